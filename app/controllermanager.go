@@ -378,7 +378,9 @@ func ControllerNames(controllerInitFuncConstructors map[string]ControllerInitFun
 
 var (
 	// ControllersDisabledByDefault is the controller disabled default when starting cloud-controller managers.
-	ControllersDisabledByDefault = sets.NewString()
+	// The endpointslice controller leaves the load balancer backends to the
+	// service controller for now, it only runs when enabled with --controllers.
+	ControllersDisabledByDefault = sets.NewString(names.EndpointSliceController)
 
 	// AllWebhooks represents the list of all webhook options configured in
 	// this package.  This is empty because no webhooks are currently
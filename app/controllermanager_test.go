@@ -43,6 +43,7 @@ func TestCloudControllerNamesDeclaration(t *testing.T) {
 		names.CloudNodeController,
 		names.ServiceLBController,
 		names.NodeRouteController,
+		names.EndpointSliceController,
 		names.CloudNodeLifecycleController,
 	)
 
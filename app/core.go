@@ -32,6 +32,7 @@ import (
 	routecontroller "github.com/inspurDTest/cloud-provider/controllers/route"
 	servicecontroller "github.com/inspurDTest/cloud-provider/controllers/service"
 	endpointslicecontroller "github.com/inspurDTest/cloud-provider/controllers/endpointslice"
+	"k8s.io/client-go/informers"
 	controllermanagerapp "k8s.io/controller-manager/app"
 	"k8s.io/controller-manager/controller"
 	"k8s.io/klog/v2"
@@ -80,15 +81,54 @@ func startCloudNodeLifecycleController(ctx context.Context, initContext Controll
 }
 
 func startServiceController(ctx context.Context, initContext ControllerInitContext, controlexContext controllermanagerapp.ControllerContext, completedConfig *config.CompletedConfig, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	maxConcurrentLBOperations := completedConfig.ComponentConfig.ServiceController.MaxConcurrentLBOperations
+	if maxConcurrentLBOperations == 0 {
+		maxConcurrentLBOperations = completedConfig.ComponentConfig.ServiceController.ConcurrentServiceSyncs
+	}
+
+	// Services and endpoint slices are watched in a single namespace when
+	// --watch-namespace is set, nodes are cluster-scoped.
+	client := completedConfig.ClientBuilder.ClientOrDie(initContext.ClientName)
+	serviceInformers := completedConfig.SharedInformers
+	watchNamespace := completedConfig.ComponentConfig.ServiceController.WatchNamespace
+	if len(watchNamespace) != 0 {
+		serviceInformers = informers.NewSharedInformerFactoryWithOptions(client, ResyncPeriod(completedConfig)(), informers.WithNamespace(watchNamespace))
+	}
+
 	// Start the service controller
 	serviceController, err := servicecontroller.New(
 		cloud,
-		completedConfig.ClientBuilder.ClientOrDie(initContext.ClientName),
-		completedConfig.SharedInformers.Core().V1().Services(),
-		completedConfig.SharedInformers.Discovery().V1().EndpointSlices(),
+		client,
+		serviceInformers.Core().V1().Services(),
+		serviceInformers.Discovery().V1().EndpointSlices(),
 		completedConfig.SharedInformers.Core().V1().Nodes(),
 		completedConfig.ComponentConfig.KubeCloudShared.ClusterName,
 		utilfeature.DefaultFeatureGate,
+		servicecontroller.WithMaxConcurrentLBOperations(int(maxConcurrentLBOperations)),
+		servicecontroller.WithConcurrentLBDeleteWorkers(int(completedConfig.ComponentConfig.ServiceController.ConcurrentLBDeleteWorkers)),
+		servicecontroller.WithMaxItemsPerNamespace(int(completedConfig.ComponentConfig.ServiceController.MaxItemsPerNamespace)),
+		servicecontroller.WithLBAPITimeout(completedConfig.ComponentConfig.ServiceController.LBAPITimeout.Duration),
+		servicecontroller.WithDefaultIdleTimeout(completedConfig.ComponentConfig.ServiceController.LBDefaultIdleTimeout.Duration),
+		servicecontroller.WithDefaultSubnetID(completedConfig.ComponentConfig.ServiceController.DefaultLBSubnetID),
+		servicecontroller.WithWatchNamespace(watchNamespace),
+		servicecontroller.WithManagedLBConfigMap(completedConfig.ComponentConfig.ServiceController.ManagedLBConfigMap),
+		servicecontroller.WithLoadBalancerClass(completedConfig.ComponentConfig.ServiceController.LBClassName),
+		servicecontroller.WithAnnotationPrefix(completedConfig.ComponentConfig.ServiceController.LBAnnotationPrefix),
+		servicecontroller.WithLBTagLabelPrefix(completedConfig.ComponentConfig.ServiceController.LBTagLabelPrefix),
+		servicecontroller.WithNodeLabelSelector(completedConfig.ComponentConfig.ServiceController.NodeLabelSelector),
+		servicecontroller.WithNodeReadinessStalenessThreshold(completedConfig.ComponentConfig.ServiceController.NodeReadinessStalenessThreshold.Duration),
+		servicecontroller.WithDeletionGracePeriod(completedConfig.ComponentConfig.ServiceController.DeletionGracePeriod.Duration),
+		servicecontroller.WithNodeCacheSyncTimeout(completedConfig.ComponentConfig.ServiceController.NodeCacheSyncTimeout.Duration),
+		servicecontroller.WithServiceCacheSnapshotPath(completedConfig.ComponentConfig.ServiceController.ServiceCacheSnapshotPath),
+		servicecontroller.WithResyncOnStartup(completedConfig.ComponentConfig.ServiceController.ResyncOnStartup),
+		servicecontroller.WithOrphanLBCleanup(completedConfig.ComponentConfig.ServiceController.EnableOrphanLBCleanup),
+		servicecontroller.WithLBStatusReconciliation(completedConfig.ComponentConfig.ServiceController.EnableLBStatusReconciliation),
+		servicecontroller.WithLBProvisioningCondition(completedConfig.ComponentConfig.ServiceController.EnableLBProvisioningCondition),
+		servicecontroller.WithLBReadinessGate(completedConfig.ComponentConfig.ServiceController.EnableLBReadinessGate),
+		servicecontroller.WithFilterNotReadyEndpoints(completedConfig.ComponentConfig.ServiceController.FilterNotReadyEndpoints),
+		servicecontroller.WithEventRateLimiter(completedConfig.ComponentConfig.ServiceController.EventRateLimiterQPS, int(completedConfig.ComponentConfig.ServiceController.EventRateLimiterBurst)),
+		servicecontroller.WithEventDedupWindow(completedConfig.ComponentConfig.ServiceController.EventDedupWindow.Duration),
+		servicecontroller.WithPreserveIngressOnEmpty(completedConfig.ComponentConfig.ServiceController.PreserveIngressOnEmpty),
 	)
 	if err != nil {
 		// This error shouldn't fail. It lives like this as a legacy.
@@ -96,8 +136,19 @@ func startServiceController(ctx context.Context, initContext ControllerInitConte
 		return nil, false, nil
 	}
 
+	if len(watchNamespace) != 0 {
+		serviceInformers.Start(ctx.Done())
+	}
 	go serviceController.Run(ctx, int(completedConfig.ComponentConfig.ServiceController.ConcurrentServiceSyncs), controlexContext.ControllerManagerMetrics)
 
+	if completedConfig.ComponentConfig.ServiceController.EnableAdminEndpoint {
+		go func() {
+			if err := serviceController.ServeAdminEndpoint(ctx, completedConfig.ComponentConfig.ServiceController.AdminEndpointBindAddress); err != nil {
+				klog.Errorf("Failed to serve the service controller admin endpoint: %v", err)
+			}
+		}()
+	}
+
 	return nil, true, nil
 }
 
@@ -142,8 +193,8 @@ func startRouteController(ctx context.Context, initContext ControllerInitContext
 	return nil, true, nil
 }
 func startEndpointSliceController(ctx context.Context, initContext ControllerInitContext, controlexContext controllermanagerapp.ControllerContext, completedConfig *config.CompletedConfig, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
-	// Start the service controller
-	_, err := endpointslicecontroller.New(
+	// Start the endpointslice controller
+	endpointSliceController, err := endpointslicecontroller.New(
 		cloud,
 		completedConfig.ClientBuilder.ClientOrDie(initContext.ClientName),
 		completedConfig.SharedInformers.Discovery().V1().EndpointSlices(),
@@ -156,7 +207,8 @@ func startEndpointSliceController(ctx context.Context, initContext ControllerIni
 		return nil, false, nil
 	}
 
-    // TODO
+	go endpointSliceController.Run(ctx, int(completedConfig.ComponentConfig.EndpointSliceController.ConcurrentEndpointSliceSyncs))
+
 	return nil, true, nil
 }
 
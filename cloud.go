@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
@@ -170,9 +171,346 @@ type LoadBalancer interface {
 	// doesn't exist even if some part of it is still laying around.
 	// Implementations must treat the *v1.Service parameter as read-only and not modify it.
 	// Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
+	//
+	// Implementations must wrap ErrLBNotFound, e.g. fmt.Errorf("...: %w", ErrLBNotFound),
+	// when the cloud definitively reports that the load balancer does not exist;
+	// the deletion is then considered done. Any other error, including a "not found"
+	// answer of an eventually consistent API, is retried with an exponential backoff.
 	EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service, lbId string) error
 }
 
+// ServiceOptions holds the per-service load balancer settings that the
+// ServiceController parsed and validated from the Service annotations.
+// A zero value for any field means the provider default applies.
+type ServiceOptions struct {
+	// ConnectionLimit is the maximum number of concurrent connections accepted
+	// on the virtual IP of the load balancer.
+	ConnectionLimit int
+	// IdleTimeout is the time after which idle connections are closed by the
+	// load balancer.
+	IdleTimeout time.Duration
+	// ClientTimeout and MemberTimeout are the timeouts of the connections
+	// between the clients and the load balancer, and between the load balancer
+	// and its members. Zero keeps the default of the cloud provider.
+	ClientTimeout time.Duration
+	MemberTimeout time.Duration
+	// PortProtocols overrides the protocol of the listener of the load
+	// balancer per service port, one of "TCP", "UDP", "HTTP" or "HTTPS".
+	PortProtocols map[int32]string
+	// PartnerClusterIDs lists the IDs of the other clusters contributing
+	// backends to the load balancer, excluding the cluster of the controller.
+	PartnerClusterIDs []string
+	// HealthCheckInterval and HealthCheckTimeout configure the health checks
+	// of the backends. Zero means the provider default.
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// SubnetID is the subnet the load balancer is placed in. Empty means the
+	// provider default. A load balancer can't move to another subnet, it is
+	// recreated when the subnet changes.
+	SubnetID string
+	// StickySession configures the session persistence of the load balancer,
+	// nil means the provider default.
+	StickySession *StickySession
+	// SessionPersistence configures the session persistence of the load
+	// balancer in the terms of the cloud API, nil means the provider default.
+	// It is never set together with StickySession.
+	SessionPersistence *SessionPersistence
+	// Algorithm is the way the load balancer distributes the connections
+	// among the backends.
+	Algorithm LoadBalancerAlgorithm
+	// PreserveClientIP enables Proxy Protocol v2 on all the listeners of the
+	// load balancer, passing the address of the clients to the backends.
+	PreserveClientIP bool
+	// CrossZoneEnabled lets each zone of the load balancer forward the
+	// connections to the backends of all the zones.
+	CrossZoneEnabled bool
+	// ZoneHints maps the addresses of the endpoints to the zones they are
+	// hinted for by topology aware routing, when cross-zone load balancing is
+	// disabled. The endpoints without hints are not listed.
+	ZoneHints map[string][]string
+	// LoggingConfig configures the access logs of the load balancer, nil
+	// when they are disabled.
+	LoggingConfig *LoggingConfig
+	// VpcID is the VPC the load balancer must belong to. Empty means any.
+	VpcID string
+	// SecurityGroupIDs are the security groups associated with the load
+	// balancer. They are updated in place, without recreating it.
+	SecurityGroupIDs []string
+	// Tags are the tags of the load balancer, e.g. for cost attribution,
+	// taken from the labels of the service.
+	Tags map[string]string
+	// ChargeType is the billing mode of the load balancer. It is only used at
+	// creation, the load balancer is recreated when it changes.
+	ChargeType LoadBalancerChargeType
+	// PrepaidPeriod is the number of months paid in advance for a prepaid
+	// load balancer, between 1 and 12.
+	PrepaidPeriod int
+	// BandwidthMbps caps the egress bandwidth of the load balancer, 0 means
+	// unlimited.
+	BandwidthMbps int
+	// AllocateEIP requests an elastic IP for the frontend of the load
+	// balancer, reported as the IP of the first ingress of its status. It is
+	// only used at creation, the load balancer is recreated when requested
+	// afterwards.
+	AllocateEIP bool
+	// EIPBandwidthMbps is the bandwidth of the elastic IP, 0 means the
+	// provider default.
+	EIPBandwidthMbps int
+	// TLSConfig configures the termination of TLS on the HTTPS listeners of
+	// the load balancer, nil when the service has no certificate or no HTTPS
+	// port.
+	TLSConfig *TLSConfig
+}
+
+// LoadBalancerChargeType is the billing mode of a load balancer.
+type LoadBalancerChargeType string
+
+const (
+	// LoadBalancerChargeTypePostpaid bills the load balancer for its usage.
+	LoadBalancerChargeTypePostpaid LoadBalancerChargeType = "postpaid"
+	// LoadBalancerChargeTypePrepaid bills the load balancer in advance for a
+	// number of months.
+	LoadBalancerChargeTypePrepaid LoadBalancerChargeType = "prepaid"
+)
+
+// LoadBalancerAlgorithm is the balancing algorithm of a load balancer.
+type LoadBalancerAlgorithm string
+
+const (
+	// LoadBalancerAlgorithmRoundRobin hands the connections to the backends in turn.
+	LoadBalancerAlgorithmRoundRobin LoadBalancerAlgorithm = "round-robin"
+	// LoadBalancerAlgorithmLeastConnections hands a connection to the backend
+	// with the fewest active connections.
+	LoadBalancerAlgorithmLeastConnections LoadBalancerAlgorithm = "least-connections"
+	// LoadBalancerAlgorithmIPHash hands the connections of a client to the
+	// backend selected by hashing its source IP.
+	LoadBalancerAlgorithmIPHash LoadBalancerAlgorithm = "ip-hash"
+)
+
+// StickySessionMode is the way a load balancer pins the clients to backends.
+type StickySessionMode string
+
+const (
+	// StickySessionModeNone disables session persistence.
+	StickySessionModeNone StickySessionMode = "none"
+	// StickySessionModeSourceIP pins the clients by source IP.
+	StickySessionModeSourceIP StickySessionMode = "source-ip"
+	// StickySessionModeCookie pins the clients with an HTTP cookie.
+	StickySessionModeCookie StickySessionMode = "cookie"
+)
+
+// StickySession is the session persistence of a load balancer.
+type StickySession struct {
+	Mode StickySessionMode
+	// CookieName is the name of the cookie of the application used to pin the
+	// clients in cookie mode. Empty means the load balancer inserts its own.
+	CookieName string
+	// CookieTTL is the lifetime of the cookie inserted by the load balancer in
+	// cookie mode. Zero means the provider default.
+	CookieTTL time.Duration
+}
+
+// SessionPersistenceType is the session persistence type of the cloud API.
+type SessionPersistenceType string
+
+const (
+	// SessionPersistenceSourceIP pins the clients by source IP.
+	SessionPersistenceSourceIP SessionPersistenceType = "SOURCE_IP"
+	// SessionPersistenceHTTPCookie pins the clients with a cookie inserted by
+	// the load balancer.
+	SessionPersistenceHTTPCookie SessionPersistenceType = "HTTP_COOKIE"
+	// SessionPersistenceAppCookie pins the clients with a cookie of the
+	// application.
+	SessionPersistenceAppCookie SessionPersistenceType = "APP_COOKIE"
+)
+
+// SessionPersistence is the session persistence of a load balancer in the
+// terms of the cloud API.
+type SessionPersistence struct {
+	Type SessionPersistenceType
+	// CookieName is the name of the cookie, always set for APP_COOKIE. Empty
+	// means the provider default for HTTP_COOKIE.
+	CookieName string
+	// CookieTimeout is the lifetime of the cookie inserted by the load
+	// balancer for HTTP_COOKIE. Zero means the provider default.
+	CookieTimeout time.Duration
+}
+
+// LoggingConfig is the access logs configuration of a load balancer.
+type LoggingConfig struct {
+	// Bucket is the object storage bucket the access logs are written to.
+	Bucket string
+}
+
+// TLSConfig is the termination of TLS by a load balancer.
+type TLSConfig struct {
+	// CertificateID is the certificate of the listeners.
+	CertificateID string
+	// CertificateChainID is the intermediate certificate chain, empty when
+	// the certificate holds the full chain.
+	CertificateChainID string
+	// Ports are the service ports terminating TLS: 443, 8443 and the ports
+	// named "https".
+	Ports []int32
+}
+
+// LoadBalancerWithOptions is an optional interface a LoadBalancer may implement
+// to receive the ServiceOptions parsed by the ServiceController. Providers that
+// only implement LoadBalancer keep working through EnsureLoadBalancer.
+type LoadBalancerWithOptions interface {
+	LoadBalancer
+	// EnsureLoadBalancerWithOptions behaves like EnsureLoadBalancer, but also
+	// receives the per-service options. Implementations must treat options as
+	// read-only; options is never nil.
+	EnsureLoadBalancerWithOptions(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, lbId string, options *ServiceOptions) (*v1.LoadBalancerStatus, error)
+}
+
+// LoadBalancerStatusGetter is an optional interface a LoadBalancer may implement
+// when the cloud can report the status of a load balancer, e.g. its VIP
+// assignments, more cheaply than ensuring it. Providers can embed
+// NoopLoadBalancerStatusGetter to opt out explicitly.
+type LoadBalancerStatusGetter interface {
+	// GetLoadBalancerStatus returns the current status of the load balancer
+	// of the service, or nil if it is unknown.
+	// Implementations must treat the *v1.Service parameter as read-only and not modify it.
+	GetLoadBalancerStatus(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, error)
+}
+
+// NoopLoadBalancerStatusGetter is a LoadBalancerStatusGetter which never knows
+// the status of a load balancer.
+type NoopLoadBalancerStatusGetter struct{}
+
+// GetLoadBalancerStatus always returns a nil status.
+func (NoopLoadBalancerStatusGetter) GetLoadBalancerStatus(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, error) {
+	return nil, nil
+}
+
+// GetLoadBalancerStatus returns the status of the load balancer of the service
+// if the balancer implements LoadBalancerStatusGetter, and nil otherwise.
+func GetLoadBalancerStatus(ctx context.Context, balancer LoadBalancer, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, error) {
+	if getter, ok := balancer.(LoadBalancerStatusGetter); ok {
+		return getter.GetLoadBalancerStatus(ctx, clusterName, service)
+	}
+	return nil, nil
+}
+
+// FederatedLoadBalancer is an optional interface a LoadBalancer may implement
+// when its load balancers accept backends from several Kubernetes clusters.
+// The ServiceController uses it for services listing partner clusters, and
+// falls back to EnsureLoadBalancer for balancers not implementing it.
+type FederatedLoadBalancer interface {
+	LoadBalancer
+	// EnsureLoadBalancerFederated behaves like EnsureLoadBalancerWithOptions,
+	// but the load balancer is shared with the clusters in partnerClusterIDs,
+	// whose backends must be kept. Implementations must treat the *v1.Service,
+	// *discoveryv1.EndpointSlice and options parameters as read-only and not
+	// modify them.
+	EnsureLoadBalancerFederated(ctx context.Context, primaryClusterName string, partnerClusterIDs []string, service *v1.Service, endpointSlices []*discoveryv1.EndpointSlice, lbId string, options *ServiceOptions) (*v1.LoadBalancerStatus, error)
+}
+
+// ZoneAwareLoadBalancer is an optional interface a LoadBalancer may implement
+// when its load balancers weight the backends by zone. The ServiceController
+// uses it instead of UpdateLoadBalancer to update the backends of the load
+// balancers.
+type ZoneAwareLoadBalancer interface {
+	LoadBalancer
+	// UpdateLoadBalancerZoneAware behaves like UpdateLoadBalancer, with the
+	// nodes grouped by their topology.kubernetes.io/zone label. The nodes
+	// without the label are grouped under the empty zone. Implementations must
+	// treat the parameters as read-only and not modify them.
+	UpdateLoadBalancerZoneAware(ctx context.Context, clusterName string, service *v1.Service, zoneNodes map[string][]*v1.Node) error
+}
+
+// LBConfigValidator is an optional interface a LoadBalancer may implement to
+// validate the configuration of a load balancer before it is ensured, sparing
+// the quota of the API calls bound to fail.
+type LBConfigValidator interface {
+	LoadBalancer
+	// ValidateLBConfig checks that the VPC vpcID exists and that the load
+	// balancer lbID belongs to it. It returns an error wrapping
+	// ErrInvalidLBConfig if not.
+	ValidateLBConfig(ctx context.Context, lbID, vpcID string) error
+}
+
+// LoadBalancerDetacher is an optional interface a LoadBalancer may implement
+// to release a load balancer from a service without deleting it, preserving
+// its virtual IP for later use. The ServiceController uses it for services
+// annotated with inspur.com/lb-delete-policy: detach.
+type LoadBalancerDetacher interface {
+	LoadBalancer
+	// DetachLoadBalancer removes the listeners and backends the service
+	// configured on the load balancer, but keeps the load balancer and its
+	// virtual IP. It must be idempotent: detaching a load balancer that is
+	// already detached returns nil. Implementations must wrap ErrLBNotFound
+	// when the load balancer does not exist, the detach is then considered
+	// done. Implementations must treat the *v1.Service parameter as read-only
+	// and not modify it.
+	DetachLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, lbId string) error
+}
+
+// LoadBalancerAdminStateSetter is an optional interface a LoadBalancer may
+// implement to take a load balancer offline without deleting it. The
+// ServiceController uses it for services annotated with
+// inspur.com/lb-admin-state-up.
+type LoadBalancerAdminStateSetter interface {
+	LoadBalancer
+	// SetLoadBalancerAdminState enables the load balancer lbID when up is
+	// true, and disables it otherwise, keeping its configuration and virtual
+	// IP. It must be idempotent.
+	SetLoadBalancerAdminState(ctx context.Context, clusterName, lbID string, up bool) error
+}
+
+// LoadBalancerReference identifies a load balancer of a cluster and the
+// service it was created for.
+type LoadBalancerReference struct {
+	ID               string
+	ServiceNamespace string
+	ServiceName      string
+}
+
+// LoadBalancerLister is an optional interface a LoadBalancer may implement to
+// list the load balancers of a cluster. The ServiceController uses it to clean
+// up the load balancers of services deleted while it was not running.
+type LoadBalancerLister interface {
+	LoadBalancer
+	// ListLoadBalancers returns the load balancers created for the services
+	// of the cluster.
+	ListLoadBalancers(ctx context.Context, clusterName string) ([]LoadBalancerReference, error)
+}
+
+// LoadBalancerUpdate is the set of nodes the load balancer of a service should
+// point to.
+type LoadBalancerUpdate struct {
+	Service *v1.Service
+	Nodes   []*v1.Node
+}
+
+// BatchableLoadBalancer is an optional interface a LoadBalancer may implement
+// when its cloud API accepts bulk updates. The ServiceController then updates
+// the hosts of all load balancers in a single call instead of one per service.
+type BatchableLoadBalancer interface {
+	LoadBalancer
+	// UpdateLoadBalancerBatch updates the hosts of several load balancers in
+	// a single call. The returned slice holds the error of each update, in the
+	// order of updates; the returned error is set when the whole call failed.
+	// Implementations must treat the *v1.Service and *v1.Node parameters as
+	// read-only and not modify them.
+	UpdateLoadBalancerBatch(ctx context.Context, clusterName string, updates []LoadBalancerUpdate) ([]error, error)
+}
+
+// EnsureLoadBalancerWithOptions bridges callers to the best method the
+// balancer supports: EnsureLoadBalancerWithOptions when it implements
+// LoadBalancerWithOptions, and EnsureLoadBalancer otherwise.
+func EnsureLoadBalancerWithOptions(ctx context.Context, balancer LoadBalancer, clusterName string, service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, lbId string, options *ServiceOptions) (*v1.LoadBalancerStatus, error) {
+	if options == nil {
+		options = &ServiceOptions{}
+	}
+	if lb, ok := balancer.(LoadBalancerWithOptions); ok {
+		return lb.EnsureLoadBalancerWithOptions(ctx, clusterName, service, nodes, endpointSlices, lbId, options)
+	}
+	return balancer.EnsureLoadBalancer(ctx, clusterName, service, nodes, endpointSlices, lbId)
+}
+
 // Instances is an abstract, pluggable interface for sets of instances.
 type Instances interface {
 	// NodeAddresses returns the addresses of the specified instance.
@@ -262,6 +600,13 @@ var (
 	InstanceNotFound     = errors.New("instance not found")
 	NotImplemented       = errors.New("unimplemented")
 	Conflict             = errors.New("conflict")
+	// ErrLBNotFound must be wrapped by LoadBalancer implementations when the
+	// load balancer does not exist.
+	ErrLBNotFound = errors.New("load balancer not found")
+	// ErrInvalidLBConfig must be wrapped by LBConfigValidator implementations
+	// when the configuration of the load balancer is invalid, e.g. its VPC
+	// doesn't exist. Other errors are retried.
+	ErrInvalidLBConfig = errors.New("invalid load balancer configuration")
 )
 
 // Zone represents the location of a particular machine.
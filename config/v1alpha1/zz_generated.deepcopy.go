@@ -32,7 +32,7 @@ func (in *CloudControllerManagerConfiguration) DeepCopyInto(out *CloudController
 	in.Generic.DeepCopyInto(&out.Generic)
 	in.KubeCloudShared.DeepCopyInto(&out.KubeCloudShared)
 	out.NodeController = in.NodeController
-	out.ServiceController = in.ServiceController
+	in.ServiceController.DeepCopyInto(&out.ServiceController)
 	out.NodeStatusUpdateFrequency = in.NodeStatusUpdateFrequency
 	in.Webhook.DeepCopyInto(&out.Webhook)
 	return
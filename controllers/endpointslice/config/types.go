@@ -16,6 +16,10 @@ limitations under the License.
 
 package config
 
+// MaxConcurrentEndpointSliceSyncs is the largest accepted
+// ConcurrentEndpointSliceSyncs.
+const MaxConcurrentEndpointSliceSyncs = 1024
+
 // EndpointSliceControllerConfiguration contains elements describing ServiceController.
 type EndpointSliceControllerConfiguration struct {
 	// ConcurrentEndpointSliceSyncs is the number of endpointSlices that are
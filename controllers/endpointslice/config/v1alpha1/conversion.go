@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+
 	"k8s.io/apimachinery/pkg/conversion"
 	endpointSliceconfig "github.com/inspurDTest/cloud-provider/controllers/endpointslice/config"
 )
@@ -28,8 +30,14 @@ import (
 // TODO: Fix the bug in conversion-gen so it automatically discovers these Convert_* functions
 // in autogenerated code as well.
 
-// Convert_v1alpha1_EndpointSliceControllerConfiguration_To_config_EndpointSliceControllerConfiguration is an autogenerated conversion function.
+// Convert_v1alpha1_EndpointSliceControllerConfiguration_To_config_EndpointSliceControllerConfiguration
+// converts the configuration, failing when ConcurrentEndpointSliceSyncs is not
+// in the range 1 to 1024. The generated conversion copies the field blindly,
+// this hand-written function replaces it in RegisterConversions.
 func Convert_v1alpha1_EndpointSliceControllerConfiguration_To_config_EndpointSliceControllerConfiguration(in *EndpointSliceControllerConfiguration, out *endpointSliceconfig.EndpointSliceControllerConfiguration, s conversion.Scope) error {
+	if in.ConcurrentEndpointSliceSyncs <= 0 || in.ConcurrentEndpointSliceSyncs > endpointSliceconfig.MaxConcurrentEndpointSliceSyncs {
+		return fmt.Errorf("concurrentEndpointSliceSyncs must be between 1 and %d, got %d", endpointSliceconfig.MaxConcurrentEndpointSliceSyncs, in.ConcurrentEndpointSliceSyncs)
+	}
 	return autoConvert_v1alpha1_EndpointSliceControllerConfiguration_To_config_EndpointSliceControllerConfiguration(in, out, s)
 }
 
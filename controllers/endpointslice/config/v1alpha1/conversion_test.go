@@ -0,0 +1,53 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	endpointsliceconfig "github.com/inspurDTest/cloud-provider/controllers/endpointslice/config"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestConvertEndpointSliceControllerConfiguration(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := RegisterConversions(scheme); err != nil {
+		t.Fatalf("RegisterConversions() failed: %v", err)
+	}
+
+	testCases := []struct {
+		syncs     int32
+		expectErr bool
+	}{
+		{syncs: -1, expectErr: true},
+		{syncs: 0, expectErr: true},
+		{syncs: 1},
+		{syncs: 1024},
+		{syncs: 1025, expectErr: true},
+	}
+	for _, tc := range testCases {
+		in := &EndpointSliceControllerConfiguration{ConcurrentEndpointSliceSyncs: tc.syncs}
+		out := &endpointsliceconfig.EndpointSliceControllerConfiguration{}
+		err := scheme.Convert(in, out, nil)
+		if tc.expectErr != (err != nil) {
+			t.Errorf("Convert() with %d concurrent syncs: expected error %v, got %v", tc.syncs, tc.expectErr, err)
+		}
+		if err == nil && out.ConcurrentEndpointSliceSyncs != tc.syncs {
+			t.Errorf("Convert() with %d concurrent syncs: got %d", tc.syncs, out.ConcurrentEndpointSliceSyncs)
+		}
+	}
+}
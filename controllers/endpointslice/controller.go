@@ -18,12 +18,18 @@ package endpointslice
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	discoverylisters "k8s.io/client-go/listers/discovery/v1"
 	"k8s.io/client-go/tools/cache"
@@ -31,6 +37,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	cloudprovider "github.com/inspurDTest/cloud-provider"
 	"k8s.io/component-base/featuregate"
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -81,7 +88,7 @@ type Controller struct {
 	lastSyncedNodesLock sync.Mutex
 }
 
-// New returns a new service controller to keep cloud provider service resources
+// New returns a new endpointslice controller to keep cloud provider service resources
 // (like load balancers) in sync with the registry.
 func New(
 	cloud cloudprovider.Interface,
@@ -90,8 +97,92 @@ func New(
 	clusterName string,
 	featureGate featuregate.FeatureGate,
 ) (*Controller, error) {
-    // TODO 暂时只定义，不做处理。数据处理在service完成
-	return nil, nil
+	broadcaster := record.NewBroadcaster()
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "endpointslice-controller"})
+
+	c := &Controller{
+		cloud:               cloud,
+		kubeClient:          kubeClient,
+		clusterName:         clusterName,
+		eventBroadcaster:    broadcaster,
+		eventRecorder:       recorder,
+		endpointSliceLister: endpointSliceInformer.Lister(),
+		endpointsliceQueue:  workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "endpointslice"),
+		lastSyncedNodes:     make(map[string][]*v1.Node),
+	}
+
+	endpointSliceInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: c.enqueueEndpointSlice,
+			UpdateFunc: func(old, cur interface{}) {
+				c.enqueueEndpointSlice(cur)
+			},
+			DeleteFunc: c.enqueueEndpointSlice,
+		},
+	)
+	c.endpointSliceListerSynced = endpointSliceInformer.Informer().HasSynced
+
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Controller) init() error {
+	if c.cloud == nil {
+		return fmt.Errorf("WARNING: no cloud provider provided, services of type LoadBalancer will fail")
+	}
+
+	balancer, ok := c.cloud.LoadBalancer()
+	if !ok {
+		return fmt.Errorf("the cloud provider does not support external load balancers")
+	}
+	c.balancer = balancer
+
+	return nil
+}
+
+// obj could be an *discoveryv1.EndpointSlice, or a DeletionFinalStateUnknown marker item.
+func (c *Controller) enqueueEndpointSlice(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("couldn't get key for object %#v: %v", obj, err))
+		return
+	}
+	c.endpointsliceQueue.Add(key)
+}
+
+// Run starts workers processing the endpointslice queue until ctx is done.
+//
+// It's an error to call Run() more than once for a given Controller object.
+func (c *Controller) Run(ctx context.Context, workers int) {
+	defer runtime.HandleCrash()
+	defer c.endpointsliceQueue.ShutDown()
+
+	// Start event processing pipeline.
+	c.eventBroadcaster.StartStructuredLogging(0)
+	c.eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: c.kubeClient.CoreV1().Events("")})
+	defer c.eventBroadcaster.Shutdown()
+
+	klog.Info("Starting endpointslice controller")
+	defer klog.Info("Shutting down endpointslice controller")
+
+	if !cache.WaitForNamedCacheSync("endpointslice", ctx.Done(), c.endpointSliceListerSynced) {
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.worker, time.Second)
+	}
+
+	<-ctx.Done()
+}
+
+// worker runs a worker thread that just dequeues items, processes them, and marks them done.
+func (c *Controller) worker(ctx context.Context) {
+	for c.processNextEpsItem(ctx) {
+	}
 }
 
 func (c *Controller) processNextNodeItem(ctx context.Context, workers int) bool {
@@ -100,14 +191,42 @@ func (c *Controller) processNextNodeItem(ctx context.Context, workers int) bool
 }
 
 func (c *Controller) processNextEpsItem(ctx context.Context) bool {
-	// TODO
+	key, quit := c.endpointsliceQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.endpointsliceQueue.Done(key)
+
+	if err := c.syncEndpointSlice(ctx, key.(string)); err != nil {
+		runtime.HandleError(fmt.Errorf("error processing endpointslice %v (retrying with exponential backoff): %v", key, err))
+		c.endpointsliceQueue.AddRateLimited(key)
+		return true
+	}
+	c.endpointsliceQueue.Forget(key)
 	return true
 }
 
+// syncEndpointSlice looks up the endpointslice of the given key. The load
+// balancer backends are reconciled by the service controller for now.
+func (c *Controller) syncEndpointSlice(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = c.endpointSliceLister.EndpointSlices(namespace).Get(name)
+	switch {
+	case apierrors.IsNotFound(err):
+		klog.V(4).Infof("EndpointSlice %s has been deleted", key)
+		return nil
+	case err != nil:
+		return err
+	}
+	klog.V(4).Infof("EndpointSlice %s is reconciled by the service controller", key)
+	return nil
+}
 
 func (c *Controller) processEpsCreateOrUpdate(ctx context.Context, service *v1.Service, key string) error {
 	// TODO(@MrHohn): Remove the cache once we get rid of the non-finalizer deletion
 
 	return nil
 }
-
@@ -0,0 +1,56 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpointslice
+
+import (
+	"context"
+	"testing"
+
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNew(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+
+	controller, err := New(&fakecloud.Cloud{}, client, informerFactory.Discovery().V1().EndpointSlices(), "test-cluster", nil)
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+	if controller == nil {
+		t.Fatalf("New() returned a nil controller")
+	}
+	if controller.balancer == nil || controller.endpointsliceQueue == nil || controller.endpointSliceLister == nil {
+		t.Errorf("New() returned a partially initialized controller: %+v", controller)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Run must return once the context is done.
+	controller.Run(ctx, 1)
+}
+
+func TestNewWithoutLoadBalancer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+
+	if _, err := New(&fakecloud.Cloud{DisableLoadBalancers: true}, client, informerFactory.Discovery().V1().EndpointSlices(), "test-cluster", nil); err == nil {
+		t.Errorf("New() expected an error for a cloud without load balancers")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// AdminTokenSecretNamespace, AdminTokenSecretName and AdminTokenSecretKey
+	// locate the bearer token protecting the admin endpoint.
+	AdminTokenSecretNamespace = "kube-system"
+	AdminTokenSecretName      = "service-controller-admin-token"
+	AdminTokenSecretKey       = "token"
+
+	// adminReconcilePath is the path of the admin endpoint triggering a full
+	// reconciliation.
+	adminReconcilePath = "/admin/reconcile"
+	// fullReconcileKey is the node queue key of a full reconciliation, which
+	// syncs the nodes of all load balancers whatever the key.
+	fullReconcileKey = "full-reconcile"
+)
+
+// TriggerFullReconcile queues all cached services and a sync of the nodes,
+// forcing the controller to reconcile every load balancer.
+func (c *Controller) TriggerFullReconcile() error {
+	services := c.cache.allServices()
+	for _, svc := range services {
+		key, err := cache.MetaNamespaceKeyFunc(svc)
+		if err != nil {
+			return fmt.Errorf("couldn't get key for service %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+		c.serviceQueue.Add(key)
+	}
+	c.nodeQueue.Add(fullReconcileKey)
+	klog.Infof("Triggered a full reconciliation of %d services", len(services))
+	return nil
+}
+
+// AdminHandler returns the handler of the admin endpoint. Requests must carry
+// the token of the admin token Secret as a bearer token.
+func (c *Controller) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminReconcilePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := c.authorizeAdminRequest(r); err != nil {
+			klog.Warningf("Rejected admin request from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := c.TriggerFullReconcile(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
+
+// authorizeAdminRequest checks the bearer token of the request against the
+// admin token Secret. The Secret is read on every request so that the token
+// can be rotated without restarting the controller.
+func (c *Controller) authorizeAdminRequest(r *http.Request) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(token) == 0 || token == r.Header.Get("Authorization") {
+		return errors.New("missing bearer token")
+	}
+	secret, err := c.kubeClient.CoreV1().Secrets(AdminTokenSecretNamespace).Get(r.Context(), AdminTokenSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the admin token: %v", err)
+	}
+	expected := secret.Data[AdminTokenSecretKey]
+	if len(expected) == 0 {
+		return fmt.Errorf("secret %s/%s has no %s", AdminTokenSecretNamespace, AdminTokenSecretName, AdminTokenSecretKey)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), expected) != 1 {
+		return errors.New("invalid bearer token")
+	}
+	return nil
+}
+
+// ServeAdminEndpoint serves the admin endpoint on the given address until the
+// context is done.
+func (c *Controller) ServeAdminEndpoint(ctx context.Context, address string) error {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           c.AdminHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	klog.Infof("Serving the service controller admin endpoint on %s", address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdminReconcile(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: AdminTokenSecretNamespace, Name: AdminTokenSecretName},
+		Data:       map[string][]byte{AdminTokenSecretKey: []byte("s3cr3t")},
+	}
+
+	testCases := []struct {
+		desc           string
+		method         string
+		authorization  string
+		expectedStatus int
+	}{
+		{desc: "valid token", method: http.MethodPost, authorization: "Bearer s3cr3t", expectedStatus: http.StatusAccepted},
+		{desc: "invalid token", method: http.MethodPost, authorization: "Bearer guess", expectedStatus: http.StatusUnauthorized},
+		{desc: "missing token", method: http.MethodPost, expectedStatus: http.StatusUnauthorized},
+		{desc: "not a bearer token", method: http.MethodPost, authorization: "s3cr3t", expectedStatus: http.StatusUnauthorized},
+		{desc: "wrong method", method: http.MethodGet, authorization: "Bearer s3cr3t", expectedStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), secret)
+			controller.cache.set("default/svc", &cachedService{state: svc})
+			// A service being processed for the first time has no state yet.
+			controller.cache.getOrCreate("default/new")
+			setReadyNodes(t, controller, 2)
+
+			req := httptest.NewRequest(tc.method, adminReconcilePath, nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			controller.AdminHandler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+			expectedServices, expectedNodes := 0, 0
+			if tc.expectedStatus == http.StatusAccepted {
+				expectedServices, expectedNodes = 1, 1
+			}
+			if got := controller.serviceQueue.Len(); got != expectedServices {
+				t.Errorf("Expected %d queued services, got %d", expectedServices, got)
+			}
+			if got := controller.nodeQueue.Len(); got != expectedNodes {
+				t.Errorf("Expected %d queued node syncs, got %d", expectedNodes, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,1048 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// DefaultAnnotationPrefix is the domain of the annotations of the services,
+// unless the controller is given another one with WithAnnotationPrefix. The
+// ServiceAnnotation* constants are the keys of the annotations under it.
+const DefaultAnnotationPrefix = "inspur.com"
+
+// AnnotationConfig names the annotations of the services under a configurable
+// domain, for deployers branding the controller under their own.
+type AnnotationConfig struct {
+	// Prefix is the domain of the annotations, e.g. "inspur.com".
+	Prefix string
+}
+
+// NewAnnotationConfig returns the AnnotationConfig of the annotations under
+// the given domain.
+func NewAnnotationConfig(prefix string) AnnotationConfig {
+	return AnnotationConfig{Prefix: prefix}
+}
+
+// Key returns the key of the annotation, given by its ServiceAnnotation*
+// constant, under the prefix of the configuration. Keys outside of
+// DefaultAnnotationPrefix are returned unchanged.
+func (a AnnotationConfig) Key(annotation string) string {
+	suffix, ok := strings.CutPrefix(annotation, DefaultAnnotationPrefix+"/")
+	if !ok || a.Prefix == DefaultAnnotationPrefix {
+		return annotation
+	}
+	return annotationKey(a.Prefix, suffix)
+}
+
+// annotationKey returns the key of the annotation with the given suffix under
+// the domain prefix.
+func annotationKey(prefix, suffix string) string {
+	return prefix + "/" + suffix
+}
+
+const (
+	// ServiceAnnotationLoadBalancerConnectionLimit is the maximum number of
+	// concurrent connections the load balancer accepts on its virtual IP.
+	ServiceAnnotationLoadBalancerConnectionLimit = DefaultAnnotationPrefix + "/lb-connection-limit"
+
+	// ServiceAnnotationLoadBalancerPriority is the priority of the service in
+	// the service queue, one of "high", "normal" or "low".
+	ServiceAnnotationLoadBalancerPriority = DefaultAnnotationPrefix + "/lb-priority"
+
+	// ServiceAnnotationLoadBalancerIdleTimeout is the idle timeout of the load
+	// balancer connections, either a duration like "90s" or a number of seconds.
+	ServiceAnnotationLoadBalancerIdleTimeout = DefaultAnnotationPrefix + "/lb-idle-timeout"
+
+	// ServiceAnnotationLoadBalancerClientTimeout and
+	// ServiceAnnotationLoadBalancerMemberTimeout are the timeouts of the
+	// connections between the clients and the load balancer, and between the
+	// load balancer and its members, in the same format as the idle timeout.
+	ServiceAnnotationLoadBalancerClientTimeout = DefaultAnnotationPrefix + "/lb-timeout-client"
+	ServiceAnnotationLoadBalancerMemberTimeout = DefaultAnnotationPrefix + "/lb-timeout-member"
+
+	// ServiceAnnotationLoadBalancerHealthCheckInterval and
+	// ServiceAnnotationLoadBalancerHealthCheckTimeout are the interval and the
+	// timeout of the health checks of the backends, either durations like "5s"
+	// or numbers of seconds. The timeout must be shorter than the interval.
+	ServiceAnnotationLoadBalancerHealthCheckInterval = DefaultAnnotationPrefix + "/lb-health-check-interval"
+	ServiceAnnotationLoadBalancerHealthCheckTimeout  = DefaultAnnotationPrefix + "/lb-health-check-timeout"
+
+	// ServiceAnnotationLoadBalancerSubnetID is the subnet the load balancer is
+	// placed in. Changing it recreates the load balancer: the controller moves
+	// the current load balancer ID to the load-balancer-old-id annotation, which
+	// deletes the load balancer before it is ensured in the new subnet.
+	ServiceAnnotationLoadBalancerSubnetID = DefaultAnnotationPrefix + "/lb-subnet-id"
+
+	// ServiceAnnotationLoadBalancerVpcID is the VPC the load balancer belongs
+	// to. When set, the load balancer is checked to belong to the VPC before
+	// it is ensured, if the cloud provider supports it.
+	ServiceAnnotationLoadBalancerVpcID = DefaultAnnotationPrefix + "/lb-vpc-id"
+
+	// ServiceAnnotationLoadBalancerSecurityGroupID is the comma-separated list
+	// of the security groups controlling the inbound traffic of the load
+	// balancer. Changing it updates the associations of the load balancer in
+	// place, the change takes effect immediately without recreating it.
+	ServiceAnnotationLoadBalancerSecurityGroupID = DefaultAnnotationPrefix + "/lb-security-group-id"
+
+	// ServiceAnnotationLoadBalancerStickySessions is the session persistence of
+	// the load balancer, one of "none", "source-ip" or "cookie". In cookie mode,
+	// ServiceAnnotationLoadBalancerStickyCookieName names the cookie of the
+	// application, and ServiceAnnotationLoadBalancerStickyCookieTTL is the
+	// lifetime in seconds of the cookie inserted by the load balancer otherwise.
+	ServiceAnnotationLoadBalancerStickySessions   = DefaultAnnotationPrefix + "/lb-sticky-sessions"
+	ServiceAnnotationLoadBalancerStickyCookieName = DefaultAnnotationPrefix + "/lb-sticky-cookie-name"
+	ServiceAnnotationLoadBalancerStickyCookieTTL  = DefaultAnnotationPrefix + "/lb-sticky-cookie-ttl"
+
+	// ServiceAnnotationLoadBalancerSessionPersistenceType is the session
+	// persistence of the load balancer in the terms of the cloud API, one of
+	// "SOURCE_IP", "HTTP_COOKIE" or "APP_COOKIE". It can't be combined with
+	// ServiceAnnotationLoadBalancerStickySessions.
+	// ServiceAnnotationLoadBalancerCookieName names the cookie, required for
+	// APP_COOKIE and optional for HTTP_COOKIE, and
+	// ServiceAnnotationLoadBalancerCookieTimeout is the lifetime of the cookie
+	// inserted by the load balancer for HTTP_COOKIE.
+	ServiceAnnotationLoadBalancerSessionPersistenceType = DefaultAnnotationPrefix + "/lb-session-type"
+	ServiceAnnotationLoadBalancerCookieName             = DefaultAnnotationPrefix + "/lb-cookie-name"
+	ServiceAnnotationLoadBalancerCookieTimeout          = DefaultAnnotationPrefix + "/lb-cookie-timeout"
+
+	// ServiceAnnotationLoadBalancerAlgorithm is the balancing algorithm of the
+	// load balancer, one of "round-robin" (the default), "least-connections" or
+	// "ip-hash".
+	ServiceAnnotationLoadBalancerAlgorithm = DefaultAnnotationPrefix + "/lb-algorithm"
+
+	// ServiceAnnotationLoadBalancerPreserveClientIP enables Proxy Protocol v2
+	// on all the listeners of the load balancer when "true", so that the
+	// applications see the address of the clients. It defaults to "false".
+	ServiceAnnotationLoadBalancerPreserveClientIP = DefaultAnnotationPrefix + "/lb-preserve-client-ip"
+
+	// ServiceAnnotationLoadBalancerCrossZoneEnabled lets each zone of the load
+	// balancer forward the connections to the backends of all the zones when
+	// "true". It defaults to "false": the connections stay in their zone,
+	// the zones of the nodes being given by their topology.kubernetes.io/zone
+	// label. The zones the endpoints are hinted for by topology aware routing,
+	// which derives them from the same label, are then passed to the load
+	// balancer too. Nodes and endpoints without zone are not constrained.
+	ServiceAnnotationLoadBalancerCrossZoneEnabled = DefaultAnnotationPrefix + "/lb-cross-zone-enabled"
+
+	// ServiceAnnotationLoadBalancerLoggingEnabled enables the access logs of
+	// the load balancer when "true", written to the object storage bucket
+	// named by ServiceAnnotationLoadBalancerLogBucket, which is then
+	// required. The bucket is ignored while the logs are disabled.
+	ServiceAnnotationLoadBalancerLoggingEnabled = DefaultAnnotationPrefix + "/lb-logging-enabled"
+	ServiceAnnotationLoadBalancerLogBucket      = DefaultAnnotationPrefix + "/lb-log-bucket"
+
+	// ServiceAnnotationLoadBalancerAdminStateUp takes the load balancer
+	// offline when "false", without deleting it: the status of the service
+	// keeps its ingress so that the traffic resumes when set back to "true",
+	// the default. It requires a cloud provider implementing
+	// LoadBalancerAdminStateSetter.
+	ServiceAnnotationLoadBalancerAdminStateUp = DefaultAnnotationPrefix + "/lb-admin-state-up"
+
+	// ServiceAnnotationLoadBalancerChargeType is the billing mode of the load
+	// balancer, "postpaid" (the default) or "prepaid" for
+	// ServiceAnnotationLoadBalancerPrepaidPeriod months, 1 by default. The
+	// billing mode is set at creation: changing it recreates the load balancer
+	// like changing its subnet.
+	ServiceAnnotationLoadBalancerChargeType    = DefaultAnnotationPrefix + "/lb-charge-type"
+	ServiceAnnotationLoadBalancerPrepaidPeriod = DefaultAnnotationPrefix + "/lb-prepaid-period"
+
+	// ServiceAnnotationLoadBalancerBandwidth caps the egress bandwidth of the
+	// load balancer, in Mbps between 1 and 10000. It is unlimited by default.
+	// Reducing the cap may drop packets during the transition, it is only
+	// applied once ServiceAnnotationLoadBalancerBandwidthReduceConfirmed is
+	// "true".
+	ServiceAnnotationLoadBalancerBandwidth                = DefaultAnnotationPrefix + "/lb-bandwidth"
+	ServiceAnnotationLoadBalancerBandwidthReduceConfirmed = DefaultAnnotationPrefix + "/lb-bandwidth-reduce-confirmed"
+	// serviceAnnotationLoadBalancerAppliedBandwidth is set by the controller
+	// to the bandwidth, 0 for unlimited, the load balancer was last ensured
+	// with, telling the reductions apart across restarts.
+	serviceAnnotationLoadBalancerAppliedBandwidth = DefaultAnnotationPrefix + "/lb-applied-bandwidth"
+
+	// ServiceAnnotationLoadBalancerEIP allocates an elastic IP for the frontend
+	// of the load balancer when "true", with a bandwidth of
+	// ServiceAnnotationLoadBalancerEIPBandwidth Mbps if set. An elastic IP is
+	// allocated at creation: requesting it later recreates the load balancer
+	// like changing its subnet.
+	ServiceAnnotationLoadBalancerEIP          = DefaultAnnotationPrefix + "/lb-eip"
+	ServiceAnnotationLoadBalancerEIPBandwidth = DefaultAnnotationPrefix + "/lb-eip-bandwidth"
+
+	// ServiceAnnotationLoadBalancerCertificateID is the certificate terminating
+	// TLS on the HTTPS ports of the service, 443, 8443 and the ports named
+	// "https", and ServiceAnnotationLoadBalancerCertificateChainID is its
+	// intermediate chain. They are ignored, with a warning, on services without
+	// an HTTPS port.
+	ServiceAnnotationLoadBalancerCertificateID      = DefaultAnnotationPrefix + "/lb-certificate-id"
+	ServiceAnnotationLoadBalancerCertificateChainID = DefaultAnnotationPrefix + "/lb-certificate-chain-id"
+
+	// ServiceAnnotationLoadBalancerClusterIDs is the comma-separated list of
+	// the clusters sharing the load balancer of the service.
+	ServiceAnnotationLoadBalancerClusterIDs = DefaultAnnotationPrefix + "/lb-cluster-ids"
+
+	// ServiceAnnotationLoadBalancerDeletePolicy controls what happens to the load
+	// balancer when the service is deleted, one of "delete" (the default) or
+	// "detach" to keep the load balancer and its virtual IP.
+	ServiceAnnotationLoadBalancerDeletePolicy = DefaultAnnotationPrefix + "/lb-delete-policy"
+	// LoadBalancerDeletePolicyDelete and LoadBalancerDeletePolicyDetach are the
+	// values of the lb-delete-policy annotation.
+	LoadBalancerDeletePolicyDelete = "delete"
+	LoadBalancerDeletePolicyDetach = "detach"
+
+	// serviceAnnotationLoadBalancerPortProtocolPrefix and
+	// serviceAnnotationLoadBalancerPortProtocolSuffix surround the port number
+	// in the annotations overriding the protocol of a listener, e.g.
+	// "inspur.com/lb-port-80-protocol: HTTP".
+	serviceAnnotationLoadBalancerPortProtocolPrefix = DefaultAnnotationPrefix + "/lb-port-"
+	serviceAnnotationLoadBalancerPortProtocolSuffix = "-protocol"
+
+	// defaultTagLabelPrefix is the default prefix of the labels of the
+	// services set as tags of their load balancer.
+	defaultTagLabelPrefix = DefaultAnnotationPrefix + "/tag-"
+	// maxTagKeyLength and maxTagValueLength bound the tags of the cloud API.
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+
+	minConnectionLimit = 1
+	maxConnectionLimit = 1000000
+
+	minPrepaidPeriod = 1
+	maxPrepaidPeriod = 12
+
+	minBandwidthMbps = 1
+	maxBandwidthMbps = 10000
+
+	minStickyCookieTTL = 0
+	maxStickyCookieTTL = 86400
+
+	minCookieTimeout = 1 * time.Second
+	maxCookieTimeout = 86400 * time.Second
+
+	// MinIdleTimeout and MaxIdleTimeout bound the idle timeout of the load
+	// balancer connections.
+	MinIdleTimeout = 5 * time.Second
+	MaxIdleTimeout = 3600 * time.Second
+
+	minConnectionTimeout = 1 * time.Second
+	maxConnectionTimeout = 3600 * time.Second
+
+	minHealthCheckInterval = 1 * time.Second
+	maxHealthCheckInterval = 60 * time.Second
+	minHealthCheckTimeout  = 1 * time.Second
+	maxHealthCheckTimeout  = 30 * time.Second
+)
+
+// lbAnnotations lists the annotations that influence the load balancer of a
+// service. Changes to any other annotation do not require a reconciliation.
+var lbAnnotations = []string{
+	ServiceAnnotationLoadBalancerID,
+	ServiceAnnotationLoadBalancerOldID,
+	ServiceAnnotationLoadBalancerConnectionLimit,
+	ServiceAnnotationLoadBalancerIdleTimeout,
+	ServiceAnnotationLoadBalancerClientTimeout,
+	ServiceAnnotationLoadBalancerMemberTimeout,
+	ServiceAnnotationLoadBalancerHealthCheckInterval,
+	ServiceAnnotationLoadBalancerHealthCheckTimeout,
+	ServiceAnnotationLoadBalancerSubnetID,
+	ServiceAnnotationLoadBalancerVpcID,
+	ServiceAnnotationLoadBalancerSecurityGroupID,
+	ServiceAnnotationLoadBalancerStickySessions,
+	ServiceAnnotationLoadBalancerStickyCookieName,
+	ServiceAnnotationLoadBalancerStickyCookieTTL,
+	ServiceAnnotationLoadBalancerSessionPersistenceType,
+	ServiceAnnotationLoadBalancerCookieName,
+	ServiceAnnotationLoadBalancerCookieTimeout,
+	ServiceAnnotationLoadBalancerAlgorithm,
+	ServiceAnnotationLoadBalancerPreserveClientIP,
+	ServiceAnnotationLoadBalancerCrossZoneEnabled,
+	ServiceAnnotationLoadBalancerLoggingEnabled,
+	ServiceAnnotationLoadBalancerLogBucket,
+	ServiceAnnotationLoadBalancerAdminStateUp,
+	ServiceAnnotationLoadBalancerChargeType,
+	ServiceAnnotationLoadBalancerPrepaidPeriod,
+	ServiceAnnotationLoadBalancerBandwidth,
+	ServiceAnnotationLoadBalancerBandwidthReduceConfirmed,
+	ServiceAnnotationLoadBalancerEIP,
+	ServiceAnnotationLoadBalancerEIPBandwidth,
+	ServiceAnnotationLoadBalancerCertificateID,
+	ServiceAnnotationLoadBalancerCertificateChainID,
+	ServiceAnnotationLoadBalancerClusterIDs,
+	ServiceAnnotationLoadBalancerPriority,
+	ServiceAnnotationLoadBalancerDeletePolicy,
+}
+
+// subnetIDPattern matches the valid subnet, VPC and certificate IDs.
+var subnetIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// invalidTagCharacters matches the characters the cloud API rejects in the
+// load balancer tags.
+var invalidTagCharacters = regexp.MustCompile(`[^A-Za-z0-9 _.:/=+@-]`)
+
+// logBucketPattern matches the valid object storage bucket names: 3 to 63
+// lower case letters, digits, dots and hyphens, starting and ending with a
+// letter or a digit. The object storage additionally rejects the names
+// formatted as IP addresses and the consecutive dots.
+var logBucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// cookieNamePattern matches the valid HTTP cookie names.
+var cookieNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// stickySessionModes lists the supported session persistence modes.
+var stickySessionModes = sets.New(cloudprovider.StickySessionModeNone, cloudprovider.StickySessionModeSourceIP, cloudprovider.StickySessionModeCookie)
+
+// sessionPersistenceTypes lists the supported lb-session-type values.
+var sessionPersistenceTypes = sets.New(cloudprovider.SessionPersistenceSourceIP, cloudprovider.SessionPersistenceHTTPCookie, cloudprovider.SessionPersistenceAppCookie)
+
+// lbAlgorithms lists the supported balancing algorithms.
+var lbAlgorithms = sets.New(cloudprovider.LoadBalancerAlgorithmRoundRobin, cloudprovider.LoadBalancerAlgorithmLeastConnections, cloudprovider.LoadBalancerAlgorithmIPHash)
+
+// lbPortProtocols lists the protocols a listener can be switched to.
+var lbPortProtocols = sets.New("TCP", "UDP", "HTTP", "HTTPS")
+
+// ServiceAnnotationLoadBalancerPortProtocol returns the annotation overriding
+// the protocol of the listener for the given service port, under
+// DefaultAnnotationPrefix.
+func ServiceAnnotationLoadBalancerPortProtocol(port int32) string {
+	return fmt.Sprintf("%s%d%s", serviceAnnotationLoadBalancerPortProtocolPrefix, port, serviceAnnotationLoadBalancerPortProtocolSuffix)
+}
+
+// isPortProtocolAnnotation reports whether the annotation overrides the
+// protocol of a listener.
+func (a AnnotationConfig) isPortProtocolAnnotation(key string) bool {
+	return strings.HasPrefix(key, a.Key(serviceAnnotationLoadBalancerPortProtocolPrefix)) &&
+		strings.HasSuffix(key, serviceAnnotationLoadBalancerPortProtocolSuffix)
+}
+
+// serviceLBAnnotations returns lbAnnotations together with the per-port
+// annotations set on any of the services.
+func (a AnnotationConfig) serviceLBAnnotations(services ...*v1.Service) []string {
+	annotations := make([]string, 0, len(lbAnnotations))
+	for _, annotation := range lbAnnotations {
+		annotations = append(annotations, a.Key(annotation))
+	}
+	keys := sets.New[string]()
+	for _, service := range services {
+		for key := range service.Annotations {
+			if a.isPortProtocolAnnotation(key) {
+				keys.Insert(key)
+			}
+		}
+	}
+	return append(annotations, sets.List(keys)...)
+}
+
+// getServiceOptions parses the load balancer annotations of the service into
+// the options handed to the cloud provider, starting from the controller-wide
+// defaults. An error is returned for the first annotation holding an invalid
+// value.
+func (a AnnotationConfig) getServiceOptions(service *v1.Service, clusterName string, defaults cloudprovider.ServiceOptions) (*cloudprovider.ServiceOptions, error) {
+	options := defaults
+	for _, parse := range a.serviceOptionParsers() {
+		if err := parse(service, &options); err != nil {
+			return nil, err
+		}
+	}
+	portProtocols, err := a.getPortProtocolsFromServiceAnnotations(service)
+	if err != nil {
+		return nil, err
+	}
+	options.PortProtocols = portProtocols
+	options.PartnerClusterIDs = a.getPartnerClusterIDsFromServiceAnnotation(service, clusterName)
+
+	return &options, nil
+}
+
+// serviceOptionParser parses some annotations of the service into options.
+type serviceOptionParser func(service *v1.Service, options *cloudprovider.ServiceOptions) error
+
+// serviceOptionParsers returns the parsers of the annotations setting the
+// options of the service, but the per-port ones, shared by getServiceOptions
+// and ValidateAnnotations.
+func (a AnnotationConfig) serviceOptionParsers() []serviceOptionParser {
+	return []serviceOptionParser{
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) error {
+			limit, err := getIntFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerConnectionLimit), minConnectionLimit, maxConnectionLimit)
+			options.ConnectionLimit = limit
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) error {
+			idleTimeout, err := getDurationFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerIdleTimeout), MinIdleTimeout, MaxIdleTimeout)
+			if idleTimeout != 0 {
+				options.IdleTimeout = idleTimeout
+			}
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) error {
+			clientTimeout, err := getDurationFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerClientTimeout), minConnectionTimeout, maxConnectionTimeout)
+			options.ClientTimeout = clientTimeout
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) error {
+			memberTimeout, err := getDurationFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerMemberTimeout), minConnectionTimeout, maxConnectionTimeout)
+			options.MemberTimeout = memberTimeout
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) error {
+			interval, timeout, err := a.getHealthCheckFromServiceAnnotations(service)
+			if interval != 0 {
+				options.HealthCheckInterval = interval
+			}
+			if timeout != 0 {
+				options.HealthCheckTimeout = timeout
+			}
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) error {
+			subnetID, err := a.getSubnetIDFromServiceAnnotation(service)
+			if len(subnetID) != 0 {
+				options.SubnetID = subnetID
+			}
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.VpcID, err = a.getVpcIDFromServiceAnnotation(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.SecurityGroupIDs, err = a.getSecurityGroupIDsFromServiceAnnotation(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) error {
+			stickySession, err := a.getStickySessionFromServiceAnnotations(service)
+			if stickySession != nil {
+				options.StickySession = stickySession
+			}
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.SessionPersistence, err = a.getSessionPersistenceFromServiceAnnotations(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.Algorithm, err = a.getAlgorithmFromServiceAnnotation(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.PreserveClientIP, err = a.getPreserveClientIPFromServiceAnnotation(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.CrossZoneEnabled, err = a.getCrossZoneEnabledFromServiceAnnotation(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.LoggingConfig, err = a.getLoggingConfigFromServiceAnnotations(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.ChargeType, options.PrepaidPeriod, err = a.getChargeTypeFromServiceAnnotations(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.BandwidthMbps, err = getIntFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerBandwidth), minBandwidthMbps, maxBandwidthMbps)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.AllocateEIP, options.EIPBandwidthMbps, err = a.getEIPFromServiceAnnotations(service)
+			return err
+		},
+		func(service *v1.Service, options *cloudprovider.ServiceOptions) (err error) {
+			options.TLSConfig, err = a.getTLSConfigFromServiceAnnotations(service)
+			return err
+		},
+	}
+}
+
+// getTagsFromServiceLabels returns the labels of the service starting with
+// prefix, without the prefix and sanitized for the cloud API, or nil if there
+// is none or prefix is empty.
+func getTagsFromServiceLabels(service *v1.Service, prefix string) map[string]string {
+	if len(prefix) == 0 {
+		return nil
+	}
+	var tags map[string]string
+	for key, value := range service.Labels {
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok || len(name) == 0 {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[sanitizeTag(name, maxTagKeyLength)] = sanitizeTag(value, maxTagValueLength)
+	}
+	return tags
+}
+
+// sanitizeTag replaces the characters the cloud API rejects in the tags with
+// underscores and truncates the tag to maxLength.
+func sanitizeTag(tag string, maxLength int) string {
+	tag = invalidTagCharacters.ReplaceAllString(tag, "_")
+	if len(tag) > maxLength {
+		tag = tag[:maxLength]
+	}
+	return tag
+}
+
+// getPartnerClusterIDsFromServiceAnnotation returns the clusters listed in the
+// lb-cluster-ids annotation other than clusterName, in order and without
+// duplicates. It returns nil if there is none.
+func (a AnnotationConfig) getPartnerClusterIDsFromServiceAnnotation(service *v1.Service, clusterName string) []string {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerClusterIDs)]
+	if !ok {
+		return nil
+	}
+	var partners []string
+	seen := sets.New(clusterName)
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if len(id) == 0 || seen.Has(id) {
+			continue
+		}
+		seen.Insert(id)
+		partners = append(partners, id)
+	}
+	return partners
+}
+
+// ValidateAnnotations checks the values of all the inspur.com annotations of
+// the service and returns an error for each invalid one: those setting the
+// options of the service in the order getServiceOptions parses them, then the
+// per-port ones by key, then the others.
+func (a AnnotationConfig) ValidateAnnotations(service *v1.Service) []error {
+	var errs []error
+	var options cloudprovider.ServiceOptions
+	for _, parse := range a.serviceOptionParsers() {
+		if err := parse(service, &options); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, key := range a.portProtocolAnnotations(service) {
+		if _, _, err := a.parsePortProtocolAnnotation(service, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := a.getAdminStateUpFromServiceAnnotation(service); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := a.getDeletePolicyFromServiceAnnotation(service); err != nil {
+		errs = append(errs, err)
+	}
+	if value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerPriority)]; ok {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "high", "normal", "low":
+		default:
+			errs = append(errs, fmt.Errorf("%s: %q is not a valid priority, expecting one of [high low normal]", a.Key(ServiceAnnotationLoadBalancerPriority), value))
+		}
+	}
+	return errs
+}
+
+// getHealthCheckFromServiceAnnotations returns the health check interval and
+// timeout of the service, 0 for those not set. When both are set, the timeout
+// must be shorter than the interval.
+func (a AnnotationConfig) getHealthCheckFromServiceAnnotations(service *v1.Service) (time.Duration, time.Duration, error) {
+	interval, err := getDurationFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerHealthCheckInterval), minHealthCheckInterval, maxHealthCheckInterval)
+	if err != nil {
+		return 0, 0, err
+	}
+	timeout, err := getDurationFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerHealthCheckTimeout), minHealthCheckTimeout, maxHealthCheckTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	if interval != 0 && timeout >= interval {
+		return 0, 0, fmt.Errorf("%s: %v must be shorter than the %s %v", a.Key(ServiceAnnotationLoadBalancerHealthCheckTimeout), timeout, a.Key(ServiceAnnotationLoadBalancerHealthCheckInterval), interval)
+	}
+	return interval, timeout, nil
+}
+
+// ValidateSubnetID checks that the subnet ID is a non-empty string of
+// alphanumeric characters and dashes.
+func ValidateSubnetID(id string) error {
+	if !subnetIDPattern.MatchString(id) {
+		return fmt.Errorf("%q is not a valid subnet ID, expecting alphanumeric characters and dashes", id)
+	}
+	return nil
+}
+
+// getSubnetIDFromServiceAnnotation returns the lb-subnet-id of the service, or
+// an empty string if it is not set.
+func (a AnnotationConfig) getSubnetIDFromServiceAnnotation(service *v1.Service) (string, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerSubnetID)]
+	if !ok {
+		return "", nil
+	}
+	if err := ValidateSubnetID(value); err != nil {
+		return "", fmt.Errorf("%s: %v", a.Key(ServiceAnnotationLoadBalancerSubnetID), err)
+	}
+	return value, nil
+}
+
+// getVpcIDFromServiceAnnotation returns the lb-vpc-id of the service, or an
+// empty string if it is not set.
+func (a AnnotationConfig) getVpcIDFromServiceAnnotation(service *v1.Service) (string, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerVpcID)]
+	if !ok {
+		return "", nil
+	}
+	if !subnetIDPattern.MatchString(value) {
+		return "", fmt.Errorf("%s: %q is not a valid VPC ID, expecting alphanumeric characters and dashes", a.Key(ServiceAnnotationLoadBalancerVpcID), value)
+	}
+	return value, nil
+}
+
+// getSecurityGroupIDsFromServiceAnnotation returns the security groups listed
+// in the lb-security-group-id annotation, in order, or nil if it is not set.
+// None of the entries may be empty.
+func (a AnnotationConfig) getSecurityGroupIDsFromServiceAnnotation(service *v1.Service) ([]string, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerSecurityGroupID)]
+	if !ok {
+		return nil, nil
+	}
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if len(id) == 0 {
+			return nil, fmt.Errorf("%s: %q holds an empty security group ID", a.Key(ServiceAnnotationLoadBalancerSecurityGroupID), value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// getStickySessionFromServiceAnnotations returns the session persistence of the
+// service, or nil if the lb-sticky-sessions annotation is not set. The cookie
+// annotations are only valid in cookie mode.
+func (a AnnotationConfig) getStickySessionFromServiceAnnotations(service *v1.Service) (*cloudprovider.StickySession, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerStickySessions)]
+	cookieName, hasCookieName := service.Annotations[a.Key(ServiceAnnotationLoadBalancerStickyCookieName)]
+	_, hasCookieTTL := service.Annotations[a.Key(ServiceAnnotationLoadBalancerStickyCookieTTL)]
+	mode := cloudprovider.StickySessionMode(strings.ToLower(strings.TrimSpace(value)))
+	if ok && !stickySessionModes.Has(mode) {
+		return nil, fmt.Errorf("%s: %q is not a valid mode, expecting one of %v", a.Key(ServiceAnnotationLoadBalancerStickySessions), value, sets.List(stickySessionModes))
+	}
+	if mode != cloudprovider.StickySessionModeCookie {
+		if hasCookieName {
+			return nil, fmt.Errorf("%s requires %s to be %q", a.Key(ServiceAnnotationLoadBalancerStickyCookieName), a.Key(ServiceAnnotationLoadBalancerStickySessions), cloudprovider.StickySessionModeCookie)
+		}
+		if hasCookieTTL {
+			return nil, fmt.Errorf("%s requires %s to be %q", a.Key(ServiceAnnotationLoadBalancerStickyCookieTTL), a.Key(ServiceAnnotationLoadBalancerStickySessions), cloudprovider.StickySessionModeCookie)
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	stickySession := &cloudprovider.StickySession{Mode: mode}
+	if mode != cloudprovider.StickySessionModeCookie {
+		return stickySession, nil
+	}
+	if hasCookieName {
+		if !cookieNamePattern.MatchString(cookieName) {
+			return nil, fmt.Errorf("%s: %q is not a valid cookie name", a.Key(ServiceAnnotationLoadBalancerStickyCookieName), cookieName)
+		}
+		stickySession.CookieName = cookieName
+	}
+	ttl, err := getIntFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerStickyCookieTTL), minStickyCookieTTL, maxStickyCookieTTL)
+	if err != nil {
+		return nil, err
+	}
+	stickySession.CookieTTL = time.Duration(ttl) * time.Second
+	return stickySession, nil
+}
+
+// getSessionPersistenceFromServiceAnnotations returns the session persistence
+// of the service in the terms of the cloud API, or nil if the lb-session-type
+// annotation is not set. APP_COOKIE requires the lb-cookie-name annotation,
+// lb-cookie-timeout is only valid for HTTP_COOKIE.
+func (a AnnotationConfig) getSessionPersistenceFromServiceAnnotations(service *v1.Service) (*cloudprovider.SessionPersistence, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType)]
+	cookieName, hasCookieName := service.Annotations[a.Key(ServiceAnnotationLoadBalancerCookieName)]
+	_, hasCookieTimeout := service.Annotations[a.Key(ServiceAnnotationLoadBalancerCookieTimeout)]
+	if !ok {
+		if hasCookieName || hasCookieTimeout {
+			return nil, fmt.Errorf("%s and %s require %s", a.Key(ServiceAnnotationLoadBalancerCookieName), a.Key(ServiceAnnotationLoadBalancerCookieTimeout), a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType))
+		}
+		return nil, nil
+	}
+	if _, sticky := service.Annotations[a.Key(ServiceAnnotationLoadBalancerStickySessions)]; sticky {
+		return nil, fmt.Errorf("%s can't be combined with %s", a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType), a.Key(ServiceAnnotationLoadBalancerStickySessions))
+	}
+	persistenceType := cloudprovider.SessionPersistenceType(strings.ToUpper(strings.TrimSpace(value)))
+	if !sessionPersistenceTypes.Has(persistenceType) {
+		return nil, fmt.Errorf("%s: %q is not a valid type, expecting one of %v", a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType), value, sets.List(sessionPersistenceTypes))
+	}
+
+	switch {
+	case persistenceType == cloudprovider.SessionPersistenceSourceIP && hasCookieName:
+		return nil, fmt.Errorf("%s is not valid for %s %q", a.Key(ServiceAnnotationLoadBalancerCookieName), a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType), persistenceType)
+	case persistenceType != cloudprovider.SessionPersistenceHTTPCookie && hasCookieTimeout:
+		return nil, fmt.Errorf("%s requires %s to be %q", a.Key(ServiceAnnotationLoadBalancerCookieTimeout), a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType), cloudprovider.SessionPersistenceHTTPCookie)
+	case persistenceType == cloudprovider.SessionPersistenceAppCookie && !hasCookieName:
+		return nil, fmt.Errorf("%s %q requires %s", a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType), persistenceType, a.Key(ServiceAnnotationLoadBalancerCookieName))
+	}
+	if hasCookieName && !cookieNamePattern.MatchString(cookieName) {
+		return nil, fmt.Errorf("%s: %q is not a valid cookie name", a.Key(ServiceAnnotationLoadBalancerCookieName), cookieName)
+	}
+	timeout, err := getDurationFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerCookieTimeout), minCookieTimeout, maxCookieTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudprovider.SessionPersistence{Type: persistenceType, CookieName: cookieName, CookieTimeout: timeout}, nil
+}
+
+// sessionPersistenceOf describes the session persistence annotations of the
+// service.
+func (a AnnotationConfig) sessionPersistenceOf(service *v1.Service) string {
+	return fmt.Sprintf("type=%q cookie=%q timeout=%q", service.Annotations[a.Key(ServiceAnnotationLoadBalancerSessionPersistenceType)], service.Annotations[a.Key(ServiceAnnotationLoadBalancerCookieName)], service.Annotations[a.Key(ServiceAnnotationLoadBalancerCookieTimeout)])
+}
+
+// getAlgorithmFromServiceAnnotation returns the lower-cased lb-algorithm of
+// the service, defaulting to round-robin.
+func (a AnnotationConfig) getAlgorithmFromServiceAnnotation(service *v1.Service) (cloudprovider.LoadBalancerAlgorithm, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerAlgorithm)]
+	if !ok {
+		return cloudprovider.LoadBalancerAlgorithmRoundRobin, nil
+	}
+	algorithm := cloudprovider.LoadBalancerAlgorithm(strings.ToLower(strings.TrimSpace(value)))
+	if !lbAlgorithms.Has(algorithm) {
+		return "", fmt.Errorf("%s: %q is not a valid algorithm, expecting one of %v", a.Key(ServiceAnnotationLoadBalancerAlgorithm), value, sets.List(lbAlgorithms))
+	}
+	return algorithm, nil
+}
+
+// getPreserveClientIPFromServiceAnnotation returns the lb-preserve-client-ip
+// of the service, defaulting to false.
+func (a AnnotationConfig) getPreserveClientIPFromServiceAnnotation(service *v1.Service) (bool, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerPreserveClientIP)]
+	if !ok {
+		return false, nil
+	}
+	preserve, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("%s: %q is not a valid boolean", a.Key(ServiceAnnotationLoadBalancerPreserveClientIP), value)
+	}
+	return preserve, nil
+}
+
+// getCrossZoneEnabledFromServiceAnnotation returns the lb-cross-zone-enabled
+// of the service, defaulting to false.
+func (a AnnotationConfig) getCrossZoneEnabledFromServiceAnnotation(service *v1.Service) (bool, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerCrossZoneEnabled)]
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("%s: %q is not a valid boolean", a.Key(ServiceAnnotationLoadBalancerCrossZoneEnabled), value)
+	}
+	return enabled, nil
+}
+
+// getAdminStateUpFromServiceAnnotation returns the lb-admin-state-up of the
+// service, defaulting to true.
+func (a AnnotationConfig) getAdminStateUpFromServiceAnnotation(service *v1.Service) (bool, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerAdminStateUp)]
+	if !ok {
+		return true, nil
+	}
+	up, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("%s: %q is not a valid boolean", a.Key(ServiceAnnotationLoadBalancerAdminStateUp), value)
+	}
+	return up, nil
+}
+
+// getLoggingConfigFromServiceAnnotations returns the access logs configuration
+// of the service, nil unless lb-logging-enabled is "true". The lb-log-bucket
+// annotation is then required.
+func (a AnnotationConfig) getLoggingConfigFromServiceAnnotations(service *v1.Service) (*cloudprovider.LoggingConfig, error) {
+	bucket, hasBucket := service.Annotations[a.Key(ServiceAnnotationLoadBalancerLogBucket)]
+	if hasBucket && (!logBucketPattern.MatchString(bucket) || strings.Contains(bucket, "..") || net.ParseIP(bucket) != nil) {
+		return nil, fmt.Errorf("%s: %q is not a valid bucket name", a.Key(ServiceAnnotationLoadBalancerLogBucket), bucket)
+	}
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerLoggingEnabled)]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %q is not a valid boolean", a.Key(ServiceAnnotationLoadBalancerLoggingEnabled), value)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	if !hasBucket {
+		return nil, fmt.Errorf("%s requires %s", a.Key(ServiceAnnotationLoadBalancerLoggingEnabled), a.Key(ServiceAnnotationLoadBalancerLogBucket))
+	}
+	return &cloudprovider.LoggingConfig{Bucket: bucket}, nil
+}
+
+// connectionTimeoutsOf describes the client and member timeout annotations of
+// the service.
+func (a AnnotationConfig) connectionTimeoutsOf(service *v1.Service) string {
+	return fmt.Sprintf("client=%q member=%q", service.Annotations[a.Key(ServiceAnnotationLoadBalancerClientTimeout)], service.Annotations[a.Key(ServiceAnnotationLoadBalancerMemberTimeout)])
+}
+
+// loggingOf describes the access logs annotations of the service.
+func (a AnnotationConfig) loggingOf(service *v1.Service) string {
+	return fmt.Sprintf("enabled=%q bucket=%q", service.Annotations[a.Key(ServiceAnnotationLoadBalancerLoggingEnabled)], service.Annotations[a.Key(ServiceAnnotationLoadBalancerLogBucket)])
+}
+
+// getChargeTypeFromServiceAnnotations returns the lower-cased lb-charge-type
+// of the service, defaulting to postpaid, and the lb-prepaid-period of a
+// prepaid load balancer, defaulting to 1 month.
+func (a AnnotationConfig) getChargeTypeFromServiceAnnotations(service *v1.Service) (cloudprovider.LoadBalancerChargeType, int, error) {
+	chargeType := cloudprovider.LoadBalancerChargeTypePostpaid
+	if value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerChargeType)]; ok {
+		chargeType = cloudprovider.LoadBalancerChargeType(strings.ToLower(strings.TrimSpace(value)))
+		if chargeType != cloudprovider.LoadBalancerChargeTypePostpaid && chargeType != cloudprovider.LoadBalancerChargeTypePrepaid {
+			return "", 0, fmt.Errorf("%s: %q is not a valid charge type, expecting %q or %q", a.Key(ServiceAnnotationLoadBalancerChargeType), value, cloudprovider.LoadBalancerChargeTypePostpaid, cloudprovider.LoadBalancerChargeTypePrepaid)
+		}
+	}
+
+	_, hasPeriod := service.Annotations[a.Key(ServiceAnnotationLoadBalancerPrepaidPeriod)]
+	if chargeType != cloudprovider.LoadBalancerChargeTypePrepaid {
+		if hasPeriod {
+			return "", 0, fmt.Errorf("%s is only valid with %s %q", a.Key(ServiceAnnotationLoadBalancerPrepaidPeriod), a.Key(ServiceAnnotationLoadBalancerChargeType), cloudprovider.LoadBalancerChargeTypePrepaid)
+		}
+		return chargeType, 0, nil
+	}
+	if !hasPeriod {
+		return chargeType, minPrepaidPeriod, nil
+	}
+	period, err := getIntFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerPrepaidPeriod), minPrepaidPeriod, maxPrepaidPeriod)
+	if err != nil {
+		return "", 0, err
+	}
+	return chargeType, period, nil
+}
+
+// getEIPFromServiceAnnotations returns whether the service requests an elastic
+// IP and its lb-eip-bandwidth, 0 when not set. The bandwidth is only valid
+// with an elastic IP.
+func (a AnnotationConfig) getEIPFromServiceAnnotations(service *v1.Service) (bool, int, error) {
+	allocate := false
+	if value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerEIP)]; ok {
+		var err error
+		allocate, err = strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return false, 0, fmt.Errorf("%s: %q is not a valid boolean", a.Key(ServiceAnnotationLoadBalancerEIP), value)
+		}
+	}
+	if _, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerEIPBandwidth)]; ok && !allocate {
+		return false, 0, fmt.Errorf("%s is only valid with %s \"true\"", a.Key(ServiceAnnotationLoadBalancerEIPBandwidth), a.Key(ServiceAnnotationLoadBalancerEIP))
+	}
+	bandwidth, err := getIntFromServiceAnnotation(service, a.Key(ServiceAnnotationLoadBalancerEIPBandwidth), minBandwidthMbps, maxBandwidthMbps)
+	if err != nil {
+		return false, 0, err
+	}
+	return allocate, bandwidth, nil
+}
+
+// getTLSConfigFromServiceAnnotations returns the TLS termination of the HTTPS
+// ports of the service, or nil if the lb-certificate-id annotation is not set
+// or the service has no HTTPS port. The chain is only valid with a
+// certificate.
+func (a AnnotationConfig) getTLSConfigFromServiceAnnotations(service *v1.Service) (*cloudprovider.TLSConfig, error) {
+	certificateID, hasCertificate := service.Annotations[a.Key(ServiceAnnotationLoadBalancerCertificateID)]
+	chainID, hasChain := service.Annotations[a.Key(ServiceAnnotationLoadBalancerCertificateChainID)]
+	if !hasCertificate {
+		if hasChain {
+			return nil, fmt.Errorf("%s is only valid with %s", a.Key(ServiceAnnotationLoadBalancerCertificateChainID), a.Key(ServiceAnnotationLoadBalancerCertificateID))
+		}
+		return nil, nil
+	}
+	if !subnetIDPattern.MatchString(certificateID) {
+		return nil, fmt.Errorf("%s: %q is not a valid certificate ID, expecting alphanumeric characters and dashes", a.Key(ServiceAnnotationLoadBalancerCertificateID), certificateID)
+	}
+	if hasChain && !subnetIDPattern.MatchString(chainID) {
+		return nil, fmt.Errorf("%s: %q is not a valid certificate ID, expecting alphanumeric characters and dashes", a.Key(ServiceAnnotationLoadBalancerCertificateChainID), chainID)
+	}
+	ports := httpsPorts(service)
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	return &cloudprovider.TLSConfig{CertificateID: certificateID, CertificateChainID: chainID, Ports: ports}, nil
+}
+
+// httpsPorts returns the ports of the service terminating TLS: 443, 8443 and
+// the ports named "https".
+func httpsPorts(service *v1.Service) []int32 {
+	var ports []int32
+	for _, port := range service.Spec.Ports {
+		if port.Port == 443 || port.Port == 8443 || strings.EqualFold(port.Name, "https") {
+			ports = append(ports, port.Port)
+		}
+	}
+	return ports
+}
+
+// getDeletePolicyFromServiceAnnotation returns the lb-delete-policy of the
+// service, defaulting to delete.
+func (a AnnotationConfig) getDeletePolicyFromServiceAnnotation(service *v1.Service) (string, error) {
+	value, ok := service.Annotations[a.Key(ServiceAnnotationLoadBalancerDeletePolicy)]
+	if !ok {
+		return LoadBalancerDeletePolicyDelete, nil
+	}
+	switch policy := strings.ToLower(strings.TrimSpace(value)); policy {
+	case LoadBalancerDeletePolicyDelete, LoadBalancerDeletePolicyDetach:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%s: %q is not a valid policy, expecting one of [%s %s]", a.Key(ServiceAnnotationLoadBalancerDeletePolicy), value, LoadBalancerDeletePolicyDelete, LoadBalancerDeletePolicyDetach)
+	}
+}
+
+// getPortProtocolsFromServiceAnnotations parses the annotations overriding the
+// protocol of the listeners into a map keyed by service port. It returns nil if
+// no such annotation is set.
+func (a AnnotationConfig) getPortProtocolsFromServiceAnnotations(service *v1.Service) (map[int32]string, error) {
+	var portProtocols map[int32]string
+	for _, key := range a.portProtocolAnnotations(service) {
+		port, protocol, err := a.parsePortProtocolAnnotation(service, key)
+		if err != nil {
+			return nil, err
+		}
+		if portProtocols == nil {
+			portProtocols = make(map[int32]string)
+		}
+		portProtocols[port] = protocol
+	}
+	return portProtocols, nil
+}
+
+// portProtocolAnnotations returns the sorted keys of the annotations of the
+// service overriding the protocol of a listener.
+func (a AnnotationConfig) portProtocolAnnotations(service *v1.Service) []string {
+	var keys []string
+	for key := range service.Annotations {
+		if a.isPortProtocolAnnotation(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parsePortProtocolAnnotation parses the annotation overriding the protocol of
+// a listener into the service port and the upper-cased protocol.
+func (a AnnotationConfig) parsePortProtocolAnnotation(service *v1.Service, key string) (int32, string, error) {
+	value := service.Annotations[key]
+	portValue := strings.TrimSuffix(strings.TrimPrefix(key, a.Key(serviceAnnotationLoadBalancerPortProtocolPrefix)), serviceAnnotationLoadBalancerPortProtocolSuffix)
+	port, err := strconv.ParseInt(portValue, 10, 32)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %q is not a valid port", key, portValue)
+	}
+	if !hasServicePort(service, int32(port)) {
+		return 0, "", fmt.Errorf("%s: %d is not a port of the service", key, port)
+	}
+	protocol := strings.ToUpper(strings.TrimSpace(value))
+	if !lbPortProtocols.Has(protocol) {
+		return 0, "", fmt.Errorf("%s: %q is not a valid protocol, expecting one of %v", key, value, sets.List(lbPortProtocols))
+	}
+	return int32(port), protocol, nil
+}
+
+func hasServicePort(service *v1.Service, port int32) bool {
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// getIntFromServiceAnnotation parses the annotation as an integer in the range
+// [min, max]. It returns 0 if the annotation is not set.
+func getIntFromServiceAnnotation(service *v1.Service, annotationKey string, min, max int) (int, error) {
+	value, ok := service.Annotations[annotationKey]
+	if !ok {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a valid integer", annotationKey, value)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("%s: %d is out of range, expecting a value between %d and %d", annotationKey, i, min, max)
+	}
+	return i, nil
+}
+
+// getDurationFromServiceAnnotation parses the annotation as a duration in the
+// range [min, max]. A plain number is read as seconds. It returns 0 if the
+// annotation is not set.
+func getDurationFromServiceAnnotation(service *v1.Service, annotationKey string, min, max time.Duration) (time.Duration, error) {
+	value, ok := service.Annotations[annotationKey]
+	if !ok {
+		return 0, nil
+	}
+	value = strings.TrimSpace(value)
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("%s: %q is not a valid duration", annotationKey, value)
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d < min || d > max {
+		return 0, fmt.Errorf("%s: %v is out of range, expecting a value between %v and %v", annotationKey, d, min, max)
+	}
+	return d, nil
+}
+
+// WatchServiceAnnotations compares the given annotations of the old and the
+// current version of a service and calls onChange only if at least one of
+// them differs.
+func WatchServiceAnnotations(ctx context.Context, oldSvc, curSvc *v1.Service, annotations []string, onChange func()) error {
+	if oldSvc == nil || curSvc == nil {
+		return fmt.Errorf("cannot compare annotations of a nil service")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, key := range annotations {
+		if oldSvc.Annotations[key] != curSvc.Annotations[key] {
+			onChange()
+			return nil
+		}
+	}
+	return nil
+}
+
+// onlyAnnotationsChanged checks if the update of the service touched nothing
+// but its annotations.
+func onlyAnnotationsChanged(oldSvc, curSvc *v1.Service) bool {
+	return !reflect.DeepEqual(oldSvc.Annotations, curSvc.Annotations) &&
+		reflect.DeepEqual(oldSvc.Spec, curSvc.Spec) &&
+		reflect.DeepEqual(oldSvc.Status, curSvc.Status) &&
+		reflect.DeepEqual(oldSvc.Labels, curSvc.Labels) &&
+		reflect.DeepEqual(oldSvc.Finalizers, curSvc.Finalizers) &&
+		reflect.DeepEqual(oldSvc.DeletionTimestamp, curSvc.DeletionTimestamp)
+}
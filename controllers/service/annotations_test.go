@@ -0,0 +1,937 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+)
+
+// defaultAnnotations names the annotations under DefaultAnnotationPrefix.
+var defaultAnnotations = NewAnnotationConfig(DefaultAnnotationPrefix)
+
+// TestLBAnnotationsComplete checks that every exported ServiceAnnotation*
+// constant of the package is listed in lbAnnotations: an annotation missing
+// from it is ignored by the service update handler.
+func TestLBAnnotationsComplete(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse the package: %v", err)
+	}
+	constants, listed := sets.New[string](), sets.New[string]()
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(node ast.Node) bool {
+			spec, ok := node.(*ast.ValueSpec)
+			if !ok {
+				return true
+			}
+			for i, name := range spec.Names {
+				if name.Obj != nil && name.Obj.Kind == ast.Con && strings.HasPrefix(name.Name, "ServiceAnnotation") {
+					constants.Insert(name.Name)
+				}
+				if name.Name == "lbAnnotations" {
+					for _, elt := range spec.Values[i].(*ast.CompositeLit).Elts {
+						if ident, ok := elt.(*ast.Ident); ok {
+							listed.Insert(ident.Name)
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+	if constants.Len() == 0 {
+		t.Fatalf("Found no ServiceAnnotation constant")
+	}
+	if missing := constants.Difference(listed); missing.Len() != 0 {
+		t.Errorf("Annotations missing from lbAnnotations: %v", sets.List(missing))
+	}
+}
+
+func TestAnnotationConfigKey(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		prefix     string
+		annotation string
+		expected   string
+	}{
+		{desc: "default prefix", prefix: DefaultAnnotationPrefix, annotation: ServiceAnnotationLoadBalancerID, expected: "inspur.com/load-balancer-id"},
+		{desc: "custom prefix", prefix: "example.com", annotation: ServiceAnnotationLoadBalancerID, expected: "example.com/load-balancer-id"},
+		{desc: "custom prefix per-port annotation", prefix: "example.com", annotation: ServiceAnnotationLoadBalancerPortProtocol(443), expected: "example.com/lb-port-443-protocol"},
+		{desc: "other domain", prefix: "example.com", annotation: "kubernetes.io/service-name", expected: "kubernetes.io/service-name"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := NewAnnotationConfig(tc.prefix).Key(tc.annotation); got != tc.expected {
+				t.Errorf("Key(%q) = %q, expected %q", tc.annotation, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsAnnotationPrefix(t *testing.T) {
+	annotations := NewAnnotationConfig("example.com")
+	svc := newLoadBalancerService("svc", "")
+	svc.Annotations = map[string]string{
+		"example.com/lb-algorithm":             "ip-hash",
+		"example.com/lb-port-80-protocol":      "HTTP",
+		ServiceAnnotationLoadBalancerBandwidth: "100",
+	}
+	options, err := annotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+	if err != nil {
+		t.Fatalf("getServiceOptions() returned unexpected error: %v", err)
+	}
+	if options.Algorithm != cloudprovider.LoadBalancerAlgorithmIPHash {
+		t.Errorf("Expected the algorithm of the example.com annotation, got %q", options.Algorithm)
+	}
+	if expected := map[int32]string{80: "HTTP"}; !reflect.DeepEqual(options.PortProtocols, expected) {
+		t.Errorf("Expected port protocols %v, got %v", expected, options.PortProtocols)
+	}
+	if options.BandwidthMbps != 0 {
+		t.Errorf("Expected the inspur.com annotation to be ignored, got a bandwidth of %d Mbps", options.BandwidthMbps)
+	}
+
+	svc.Annotations["example.com/lb-algorithm"] = "random"
+	if errs := annotations.ValidateAnnotations(svc); len(errs) != 1 || !strings.Contains(errs[0].Error(), "example.com/lb-algorithm") {
+		t.Errorf("Expected an error naming example.com/lb-algorithm, got %v", errs)
+	}
+}
+
+func TestGetServiceOptionsIdleTimeout(t *testing.T) {
+	defaults := cloudprovider.ServiceOptions{IdleTimeout: 60 * time.Second}
+	testCases := []struct {
+		desc        string
+		annotation  *string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{desc: "absent uses default", expected: 60 * time.Second},
+		{desc: "duration", annotation: stringPtr("90s"), expected: 90 * time.Second},
+		{desc: "seconds", annotation: stringPtr("120"), expected: 120 * time.Second},
+		{desc: "lower bound", annotation: stringPtr("5s"), expected: 5 * time.Second},
+		{desc: "upper bound", annotation: stringPtr("1h"), expected: time.Hour},
+		{desc: "too short", annotation: stringPtr("4s"), expectedErr: true},
+		{desc: "too long", annotation: stringPtr("3601"), expectedErr: true},
+		{desc: "invalid", annotation: stringPtr("forever"), expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if tc.annotation != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerIdleTimeout] = *tc.annotation
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, defaults)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if err == nil && options.IdleTimeout != tc.expected {
+				t.Errorf("Expected idle timeout %v, got %v", tc.expected, options.IdleTimeout)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsHealthCheck(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		interval         *string
+		timeout          *string
+		expectedInterval time.Duration
+		expectedTimeout  time.Duration
+		expectedErr      bool
+	}{
+		{desc: "absent uses provider defaults"},
+		{desc: "interval and timeout", interval: stringPtr("10s"), timeout: stringPtr("5"), expectedInterval: 10 * time.Second, expectedTimeout: 5 * time.Second},
+		{desc: "interval only", interval: stringPtr("60s"), expectedInterval: time.Minute},
+		{desc: "timeout only", timeout: stringPtr("30s"), expectedTimeout: 30 * time.Second},
+		{desc: "interval too short", interval: stringPtr("500ms"), expectedErr: true},
+		{desc: "interval too long", interval: stringPtr("61s"), expectedErr: true},
+		{desc: "timeout too long", timeout: stringPtr("31"), expectedErr: true},
+		{desc: "timeout not shorter than interval", interval: stringPtr("5s"), timeout: stringPtr("5s"), expectedErr: true},
+		{desc: "invalid", interval: stringPtr("often"), expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if tc.interval != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckInterval] = *tc.interval
+			}
+			if tc.timeout != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckTimeout] = *tc.timeout
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err != nil {
+				return
+			}
+			if options.HealthCheckInterval != tc.expectedInterval || options.HealthCheckTimeout != tc.expectedTimeout {
+				t.Errorf("Expected health check interval %v and timeout %v, got %v and %v", tc.expectedInterval, tc.expectedTimeout, options.HealthCheckInterval, options.HealthCheckTimeout)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsSubnetID(t *testing.T) {
+	defaults := cloudprovider.ServiceOptions{SubnetID: "subnet-default"}
+	testCases := []struct {
+		desc        string
+		annotation  *string
+		expected    string
+		expectedErr bool
+	}{
+		{desc: "absent uses default", expected: "subnet-default"},
+		{desc: "subnet", annotation: stringPtr("subnet-1a2b"), expected: "subnet-1a2b"},
+		{desc: "empty", annotation: stringPtr(""), expectedErr: true},
+		{desc: "invalid characters", annotation: stringPtr("subnet_1/a"), expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if tc.annotation != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerSubnetID] = *tc.annotation
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, defaults)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if err == nil && options.SubnetID != tc.expected {
+				t.Errorf("Expected subnet %q, got %q", tc.expected, options.SubnetID)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsVpcID(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotation  *string
+		expected    string
+		expectedErr bool
+	}{
+		{desc: "absent", expected: ""},
+		{desc: "vpc", annotation: stringPtr("vpc-1a2b"), expected: "vpc-1a2b"},
+		{desc: "empty", annotation: stringPtr(""), expectedErr: true},
+		{desc: "invalid characters", annotation: stringPtr("vpc_1/a"), expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if tc.annotation != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerVpcID] = *tc.annotation
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if err == nil && options.VpcID != tc.expected {
+				t.Errorf("Expected VPC %q, got %q", tc.expected, options.VpcID)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsSecurityGroupIDs(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotation  *string
+		expected    []string
+		expectedErr bool
+	}{
+		{desc: "absent", expected: nil},
+		{desc: "single", annotation: stringPtr("sg-1"), expected: []string{"sg-1"}},
+		{desc: "list with spaces", annotation: stringPtr("sg-1, sg-2"), expected: []string{"sg-1", "sg-2"}},
+		{desc: "empty", annotation: stringPtr(""), expectedErr: true},
+		{desc: "empty entry", annotation: stringPtr("sg-1,,sg-2"), expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if tc.annotation != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerSecurityGroupID] = *tc.annotation
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if err == nil && !reflect.DeepEqual(options.SecurityGroupIDs, tc.expected) {
+				t.Errorf("Expected security groups %v, got %v", tc.expected, options.SecurityGroupIDs)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsStickySession(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    *cloudprovider.StickySession
+		expectedErr bool
+	}{
+		{desc: "no annotations"},
+		{desc: "none", annotations: map[string]string{ServiceAnnotationLoadBalancerStickySessions: "none"}, expected: &cloudprovider.StickySession{Mode: cloudprovider.StickySessionModeNone}},
+		{desc: "source IP", annotations: map[string]string{ServiceAnnotationLoadBalancerStickySessions: "Source-IP"}, expected: &cloudprovider.StickySession{Mode: cloudprovider.StickySessionModeSourceIP}},
+		{desc: "inserted cookie", annotations: map[string]string{ServiceAnnotationLoadBalancerStickySessions: "cookie"}, expected: &cloudprovider.StickySession{Mode: cloudprovider.StickySessionModeCookie}},
+		{
+			desc: "application cookie",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerStickySessions:   "cookie",
+				ServiceAnnotationLoadBalancerStickyCookieName: "JSESSIONID",
+				ServiceAnnotationLoadBalancerStickyCookieTTL:  "3600",
+			},
+			expected: &cloudprovider.StickySession{Mode: cloudprovider.StickySessionModeCookie, CookieName: "JSESSIONID", CookieTTL: time.Hour},
+		},
+		{desc: "invalid mode", annotations: map[string]string{ServiceAnnotationLoadBalancerStickySessions: "round-robin"}, expectedErr: true},
+		{
+			desc:        "invalid cookie name",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerStickySessions: "cookie", ServiceAnnotationLoadBalancerStickyCookieName: "session id"},
+			expectedErr: true,
+		},
+		{
+			desc:        "cookie TTL out of range",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerStickySessions: "cookie", ServiceAnnotationLoadBalancerStickyCookieTTL: "86401"},
+			expectedErr: true,
+		},
+		{
+			desc:        "cookie name without cookie mode",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerStickySessions: "source-ip", ServiceAnnotationLoadBalancerStickyCookieName: "JSESSIONID"},
+			expectedErr: true,
+		},
+		{desc: "cookie TTL without mode", annotations: map[string]string{ServiceAnnotationLoadBalancerStickyCookieTTL: "60"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && !reflect.DeepEqual(options.StickySession, tc.expected) {
+				t.Errorf("Expected sticky session %+v, got %+v", tc.expected, options.StickySession)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsPreserveClientIP(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    bool
+		expectedErr bool
+	}{
+		{desc: "default", expected: false},
+		{desc: "enabled", annotations: map[string]string{ServiceAnnotationLoadBalancerPreserveClientIP: "true"}, expected: true},
+		{desc: "disabled", annotations: map[string]string{ServiceAnnotationLoadBalancerPreserveClientIP: "false"}, expected: false},
+		{desc: "invalid", annotations: map[string]string{ServiceAnnotationLoadBalancerPreserveClientIP: "yes please"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && options.PreserveClientIP != tc.expected {
+				t.Errorf("Expected PreserveClientIP %t, got %t", tc.expected, options.PreserveClientIP)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsCrossZoneEnabled(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    bool
+		expectedErr bool
+	}{
+		{desc: "default", expected: false},
+		{desc: "enabled", annotations: map[string]string{ServiceAnnotationLoadBalancerCrossZoneEnabled: "true"}, expected: true},
+		{desc: "disabled", annotations: map[string]string{ServiceAnnotationLoadBalancerCrossZoneEnabled: "false"}, expected: false},
+		{desc: "invalid", annotations: map[string]string{ServiceAnnotationLoadBalancerCrossZoneEnabled: "everywhere"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && options.CrossZoneEnabled != tc.expected {
+				t.Errorf("Expected CrossZoneEnabled %t, got %t", tc.expected, options.CrossZoneEnabled)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsConnectionTimeouts(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		annotations    map[string]string
+		expectedClient time.Duration
+		expectedMember time.Duration
+		expectedErr    bool
+	}{
+		{desc: "absent"},
+		{desc: "durations", annotations: map[string]string{ServiceAnnotationLoadBalancerClientTimeout: "50s", ServiceAnnotationLoadBalancerMemberTimeout: "5m"}, expectedClient: 50 * time.Second, expectedMember: 5 * time.Minute},
+		{desc: "seconds", annotations: map[string]string{ServiceAnnotationLoadBalancerMemberTimeout: "3600"}, expectedMember: time.Hour},
+		{desc: "member below 1s", annotations: map[string]string{ServiceAnnotationLoadBalancerMemberTimeout: "500ms"}, expectedErr: true},
+		{desc: "client above 3600s", annotations: map[string]string{ServiceAnnotationLoadBalancerClientTimeout: "2h"}, expectedErr: true},
+		{desc: "invalid", annotations: map[string]string{ServiceAnnotationLoadBalancerClientTimeout: "forever"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && (options.ClientTimeout != tc.expectedClient || options.MemberTimeout != tc.expectedMember) {
+				t.Errorf("Expected client timeout %v and member timeout %v, got %v and %v", tc.expectedClient, tc.expectedMember, options.ClientTimeout, options.MemberTimeout)
+			}
+		})
+	}
+}
+
+func TestGetAdminStateUpFromServiceAnnotation(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    bool
+		expectedErr bool
+	}{
+		{desc: "default", expected: true},
+		{desc: "up", annotations: map[string]string{ServiceAnnotationLoadBalancerAdminStateUp: "true"}, expected: true},
+		{desc: "down", annotations: map[string]string{ServiceAnnotationLoadBalancerAdminStateUp: "false"}, expected: false},
+		{desc: "invalid", annotations: map[string]string{ServiceAnnotationLoadBalancerAdminStateUp: "offline"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			up, err := defaultAnnotations.getAdminStateUpFromServiceAnnotation(svc)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getAdminStateUpFromServiceAnnotation() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && up != tc.expected {
+				t.Errorf("Expected admin state up %t, got %t", tc.expected, up)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsLoggingConfig(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    *cloudprovider.LoggingConfig
+		expectedErr bool
+	}{
+		{desc: "absent"},
+		{desc: "enabled", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "true", ServiceAnnotationLoadBalancerLogBucket: "lb-logs.prod"}, expected: &cloudprovider.LoggingConfig{Bucket: "lb-logs.prod"}},
+		{desc: "disabled", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "false", ServiceAnnotationLoadBalancerLogBucket: "lb-logs"}},
+		{desc: "bucket only", annotations: map[string]string{ServiceAnnotationLoadBalancerLogBucket: "lb-logs"}},
+		{desc: "enabled without bucket", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "true"}, expectedErr: true},
+		{desc: "invalid boolean", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "on", ServiceAnnotationLoadBalancerLogBucket: "lb-logs"}, expectedErr: true},
+		{desc: "upper case bucket", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "true", ServiceAnnotationLoadBalancerLogBucket: "LB-Logs"}, expectedErr: true},
+		{desc: "short bucket", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "true", ServiceAnnotationLoadBalancerLogBucket: "lb"}, expectedErr: true},
+		{desc: "consecutive dots", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "true", ServiceAnnotationLoadBalancerLogBucket: "lb..logs"}, expectedErr: true},
+		{desc: "IP address bucket", annotations: map[string]string{ServiceAnnotationLoadBalancerLoggingEnabled: "true", ServiceAnnotationLoadBalancerLogBucket: "192.168.0.1"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && !reflect.DeepEqual(options.LoggingConfig, tc.expected) {
+				t.Errorf("Expected logging config %+v, got %+v", tc.expected, options.LoggingConfig)
+			}
+		})
+	}
+}
+
+func TestGetTagsFromServiceLabels(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		prefix   string
+		labels   map[string]string
+		expected map[string]string
+	}{
+		{desc: "no labels", prefix: defaultTagLabelPrefix},
+		{desc: "unprefixed labels", prefix: defaultTagLabelPrefix, labels: map[string]string{"app": "web", "inspur.com/tag-": "empty"}},
+		{
+			desc:     "prefixed labels",
+			prefix:   defaultTagLabelPrefix,
+			labels:   map[string]string{"app": "web", "inspur.com/tag-team": "payments", "inspur.com/tag-cost-center": "cc-42"},
+			expected: map[string]string{"team": "payments", "cost-center": "cc-42"},
+		},
+		{
+			desc:     "sanitized",
+			prefix:   "tag.",
+			labels:   map[string]string{"tag.owner": "a&b", "tag.long": strings.Repeat("v", maxTagValueLength+1)},
+			expected: map[string]string{"owner": "a_b", "long": strings.Repeat("v", maxTagValueLength)},
+		},
+		{desc: "disabled", labels: map[string]string{"inspur.com/tag-team": "payments"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Labels = tc.labels
+			if got := getTagsFromServiceLabels(svc, tc.prefix); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("getTagsFromServiceLabels() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsChargeType(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		annotations    map[string]string
+		expectedType   cloudprovider.LoadBalancerChargeType
+		expectedPeriod int
+		expectedErr    bool
+	}{
+		{desc: "default", expectedType: cloudprovider.LoadBalancerChargeTypePostpaid},
+		{desc: "postpaid", annotations: map[string]string{ServiceAnnotationLoadBalancerChargeType: "Postpaid"}, expectedType: cloudprovider.LoadBalancerChargeTypePostpaid},
+		{desc: "prepaid default period", annotations: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid"}, expectedType: cloudprovider.LoadBalancerChargeTypePrepaid, expectedPeriod: 1},
+		{desc: "prepaid", annotations: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid", ServiceAnnotationLoadBalancerPrepaidPeriod: "12"}, expectedType: cloudprovider.LoadBalancerChargeTypePrepaid, expectedPeriod: 12},
+		{desc: "period out of range", annotations: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid", ServiceAnnotationLoadBalancerPrepaidPeriod: "13"}, expectedErr: true},
+		{desc: "period without prepaid", annotations: map[string]string{ServiceAnnotationLoadBalancerPrepaidPeriod: "3"}, expectedErr: true},
+		{desc: "invalid", annotations: map[string]string{ServiceAnnotationLoadBalancerChargeType: "free"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && (options.ChargeType != tc.expectedType || options.PrepaidPeriod != tc.expectedPeriod) {
+				t.Errorf("Expected charge type %q for %d months, got %q for %d months", tc.expectedType, tc.expectedPeriod, options.ChargeType, options.PrepaidPeriod)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsEIP(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		annotations       map[string]string
+		expectedAllocate  bool
+		expectedBandwidth int
+		expectedErr       bool
+	}{
+		{desc: "default"},
+		{desc: "disabled", annotations: map[string]string{ServiceAnnotationLoadBalancerEIP: "false"}},
+		{desc: "enabled", annotations: map[string]string{ServiceAnnotationLoadBalancerEIP: "true"}, expectedAllocate: true},
+		{desc: "bandwidth", annotations: map[string]string{ServiceAnnotationLoadBalancerEIP: "true", ServiceAnnotationLoadBalancerEIPBandwidth: "200"}, expectedAllocate: true, expectedBandwidth: 200},
+		{desc: "bandwidth out of range", annotations: map[string]string{ServiceAnnotationLoadBalancerEIP: "true", ServiceAnnotationLoadBalancerEIPBandwidth: "0"}, expectedErr: true},
+		{desc: "bandwidth without elastic IP", annotations: map[string]string{ServiceAnnotationLoadBalancerEIPBandwidth: "200"}, expectedErr: true},
+		{desc: "invalid", annotations: map[string]string{ServiceAnnotationLoadBalancerEIP: "maybe"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && (options.AllocateEIP != tc.expectedAllocate || options.EIPBandwidthMbps != tc.expectedBandwidth) {
+				t.Errorf("Expected elastic IP %t with %d Mbps, got %t with %d Mbps", tc.expectedAllocate, tc.expectedBandwidth, options.AllocateEIP, options.EIPBandwidthMbps)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsTLSConfig(t *testing.T) {
+	certificate := map[string]string{ServiceAnnotationLoadBalancerCertificateID: "cert-1"}
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		ports       []v1.ServicePort
+		expected    *cloudprovider.TLSConfig
+		expectedErr bool
+	}{
+		{desc: "absent", ports: []v1.ServicePort{{Port: 443}}},
+		{desc: "port 443", annotations: certificate, ports: []v1.ServicePort{{Port: 80}, {Port: 443}}, expected: &cloudprovider.TLSConfig{CertificateID: "cert-1", Ports: []int32{443}}},
+		{desc: "port 8443", annotations: certificate, ports: []v1.ServicePort{{Port: 8443}}, expected: &cloudprovider.TLSConfig{CertificateID: "cert-1", Ports: []int32{8443}}},
+		{desc: "named https", annotations: certificate, ports: []v1.ServicePort{{Name: "https", Port: 9000}, {Name: "http", Port: 8080}}, expected: &cloudprovider.TLSConfig{CertificateID: "cert-1", Ports: []int32{9000}}},
+		{
+			desc:        "chain",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerCertificateID: "cert-1", ServiceAnnotationLoadBalancerCertificateChainID: "chain-1"},
+			ports:       []v1.ServicePort{{Port: 443}},
+			expected:    &cloudprovider.TLSConfig{CertificateID: "cert-1", CertificateChainID: "chain-1", Ports: []int32{443}},
+		},
+		{desc: "no HTTPS port", annotations: certificate, ports: []v1.ServicePort{{Port: 80}, {Name: "web", Port: 8080}}},
+		{desc: "chain without certificate", annotations: map[string]string{ServiceAnnotationLoadBalancerCertificateChainID: "chain-1"}, ports: []v1.ServicePort{{Port: 443}}, expectedErr: true},
+		{desc: "invalid certificate", annotations: map[string]string{ServiceAnnotationLoadBalancerCertificateID: "cert/1"}, ports: []v1.ServicePort{{Port: 443}}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Spec.Ports = tc.ports
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && !reflect.DeepEqual(options.TLSConfig, tc.expected) {
+				t.Errorf("Expected TLS config %+v, got %+v", tc.expected, options.TLSConfig)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsSessionPersistence(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    *cloudprovider.SessionPersistence
+		expectedErr bool
+	}{
+		{desc: "absent"},
+		{desc: "source IP", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "SOURCE_IP"}, expected: &cloudprovider.SessionPersistence{Type: cloudprovider.SessionPersistenceSourceIP}},
+		{desc: "lower case", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: " http_cookie "}, expected: &cloudprovider.SessionPersistence{Type: cloudprovider.SessionPersistenceHTTPCookie}},
+		{
+			desc:        "HTTP cookie",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "HTTP_COOKIE", ServiceAnnotationLoadBalancerCookieName: "lb", ServiceAnnotationLoadBalancerCookieTimeout: "1h"},
+			expected:    &cloudprovider.SessionPersistence{Type: cloudprovider.SessionPersistenceHTTPCookie, CookieName: "lb", CookieTimeout: time.Hour},
+		},
+		{
+			desc:        "app cookie",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "APP_COOKIE", ServiceAnnotationLoadBalancerCookieName: "JSESSIONID"},
+			expected:    &cloudprovider.SessionPersistence{Type: cloudprovider.SessionPersistenceAppCookie, CookieName: "JSESSIONID"},
+		},
+		{desc: "app cookie without name", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "APP_COOKIE"}, expectedErr: true},
+		{desc: "app cookie with timeout", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "APP_COOKIE", ServiceAnnotationLoadBalancerCookieName: "JSESSIONID", ServiceAnnotationLoadBalancerCookieTimeout: "60"}, expectedErr: true},
+		{desc: "source IP with name", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "SOURCE_IP", ServiceAnnotationLoadBalancerCookieName: "lb"}, expectedErr: true},
+		{desc: "invalid type", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "COOKIE"}, expectedErr: true},
+		{desc: "invalid name", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "HTTP_COOKIE", ServiceAnnotationLoadBalancerCookieName: "a b"}, expectedErr: true},
+		{desc: "timeout out of range", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "HTTP_COOKIE", ServiceAnnotationLoadBalancerCookieTimeout: "0s"}, expectedErr: true},
+		{desc: "name without type", annotations: map[string]string{ServiceAnnotationLoadBalancerCookieName: "lb"}, expectedErr: true},
+		{desc: "with sticky sessions", annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceType: "SOURCE_IP", ServiceAnnotationLoadBalancerStickySessions: "source-ip"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && !reflect.DeepEqual(options.SessionPersistence, tc.expected) {
+				t.Errorf("Expected session persistence %+v, got %+v", tc.expected, options.SessionPersistence)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsAlgorithm(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    cloudprovider.LoadBalancerAlgorithm
+		expectedErr bool
+	}{
+		{desc: "default", expected: cloudprovider.LoadBalancerAlgorithmRoundRobin},
+		{desc: "round robin", annotations: map[string]string{ServiceAnnotationLoadBalancerAlgorithm: "round-robin"}, expected: cloudprovider.LoadBalancerAlgorithmRoundRobin},
+		{desc: "least connections", annotations: map[string]string{ServiceAnnotationLoadBalancerAlgorithm: "Least-Connections"}, expected: cloudprovider.LoadBalancerAlgorithmLeastConnections},
+		{desc: "ip hash", annotations: map[string]string{ServiceAnnotationLoadBalancerAlgorithm: " IP-HASH "}, expected: cloudprovider.LoadBalancerAlgorithmIPHash},
+		{desc: "invalid", annotations: map[string]string{ServiceAnnotationLoadBalancerAlgorithm: "random"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if errs := defaultAnnotations.ValidateAnnotations(svc); (len(errs) != 0) != tc.expectedErr {
+				t.Errorf("ValidateAnnotations() = %v, expected error: %t", errs, tc.expectedErr)
+			}
+			if err == nil && options.Algorithm != tc.expected {
+				t.Errorf("Expected algorithm %q, got %q", tc.expected, options.Algorithm)
+			}
+
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+			_ = controller.syncService(context.TODO(), "default/svc")
+			recorder := controller.eventRecorder.(*record.FakeRecorder)
+			warned := false
+			for len(recorder.Events) > 0 {
+				if event := <-recorder.Events; strings.HasPrefix(event, v1.EventTypeWarning+" "+EventReasonInvalidLoadBalancerAnnotation) {
+					warned = true
+				}
+			}
+			if warned != tc.expectedErr {
+				t.Errorf("Expected an %s warning: %t, got one: %t", EventReasonInvalidLoadBalancerAnnotation, tc.expectedErr, warned)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsPortProtocols(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    map[int32]string
+		expectedErr bool
+	}{
+		{desc: "no overrides"},
+		{
+			desc:        "single port",
+			annotations: map[string]string{"inspur.com/lb-port-80-protocol": "HTTP"},
+			expected:    map[int32]string{80: "HTTP"},
+		},
+		{
+			desc:        "case insensitive",
+			annotations: map[string]string{"inspur.com/lb-port-80-protocol": "http", "inspur.com/lb-port-443-protocol": "tcp"},
+			expected:    map[int32]string{80: "HTTP", 443: "TCP"},
+		},
+		{desc: "invalid protocol", annotations: map[string]string{"inspur.com/lb-port-80-protocol": "SCTP"}, expectedErr: true},
+		{desc: "invalid port", annotations: map[string]string{"inspur.com/lb-port-http-protocol": "HTTP"}, expectedErr: true},
+		{desc: "unknown port", annotations: map[string]string{"inspur.com/lb-port-8080-protocol": "HTTP"}, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}, {Port: 443, Protocol: v1.ProtocolTCP}}
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("getServiceOptions() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if err == nil && !reflect.DeepEqual(options.PortProtocols, tc.expected) {
+				t.Errorf("Expected port protocols %v, got %v", tc.expected, options.PortProtocols)
+			}
+		})
+	}
+}
+
+func TestGetServiceOptionsPartnerClusterIDs(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		annotation *string
+		expected   []string
+	}{
+		{desc: "no annotation"},
+		{desc: "empty annotation", annotation: stringPtr("")},
+		{desc: "single cluster", annotation: stringPtr("cluster-b"), expected: []string{"cluster-b"}},
+		{desc: "several clusters", annotation: stringPtr("cluster-b, cluster-c,,"), expected: []string{"cluster-b", "cluster-c"}},
+		{desc: "own cluster and duplicates", annotation: stringPtr(testClusterID + ",cluster-b,cluster-b"), expected: []string{"cluster-b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if tc.annotation != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerClusterIDs] = *tc.annotation
+			}
+			options, err := defaultAnnotations.getServiceOptions(svc, testClusterID, cloudprovider.ServiceOptions{})
+			if err != nil {
+				t.Fatalf("getServiceOptions() returned unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(options.PartnerClusterIDs, tc.expected) {
+				t.Errorf("Expected partner clusters %v, got %v", tc.expected, options.PartnerClusterIDs)
+			}
+		})
+	}
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		annotations  map[string]string
+		expectedErrs []string
+	}{
+		{desc: "no annotations"},
+		{
+			desc: "valid annotations",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerConnectionLimit: "1000",
+				ServiceAnnotationLoadBalancerIdleTimeout:     "90s",
+				"inspur.com/lb-port-80-protocol":             "http",
+				ServiceAnnotationLoadBalancerPriority:        "High",
+				ServiceAnnotationLoadBalancerClusterIDs:      "cluster-b",
+				ServiceAnnotationLoadBalancerDeletePolicy:    "detach",
+			},
+		},
+		{
+			desc:         "invalid connection limit",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerConnectionLimit: "0"},
+			expectedErrs: []string{ServiceAnnotationLoadBalancerConnectionLimit},
+		},
+		{
+			desc:         "invalid idle timeout",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerIdleTimeout: "forever"},
+			expectedErrs: []string{ServiceAnnotationLoadBalancerIdleTimeout},
+		},
+		{
+			desc:         "invalid port protocol",
+			annotations:  map[string]string{"inspur.com/lb-port-80-protocol": "SCTP"},
+			expectedErrs: []string{"inspur.com/lb-port-80-protocol"},
+		},
+		{
+			desc:         "invalid delete policy",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerDeletePolicy: "release"},
+			expectedErrs: []string{ServiceAnnotationLoadBalancerDeletePolicy},
+		},
+		{
+			desc:         "invalid priority",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerPriority: "urgent"},
+			expectedErrs: []string{ServiceAnnotationLoadBalancerPriority},
+		},
+		{
+			desc: "all errors are reported",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerConnectionLimit: "many",
+				ServiceAnnotationLoadBalancerIdleTimeout:     "1s",
+				"inspur.com/lb-port-8080-protocol":           "HTTP",
+				"inspur.com/lb-port-443-protocol":            "QUIC",
+				ServiceAnnotationLoadBalancerPriority:        "urgent",
+			},
+			expectedErrs: []string{
+				ServiceAnnotationLoadBalancerConnectionLimit,
+				ServiceAnnotationLoadBalancerIdleTimeout,
+				"inspur.com/lb-port-443-protocol",
+				"inspur.com/lb-port-8080-protocol",
+				ServiceAnnotationLoadBalancerPriority,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}, {Port: 443, Protocol: v1.ProtocolTCP}}
+			for key, value := range tc.annotations {
+				svc.Annotations[key] = value
+			}
+			errs := defaultAnnotations.ValidateAnnotations(svc)
+			if len(errs) != len(tc.expectedErrs) {
+				t.Fatalf("Expected %d errors, got %v", len(tc.expectedErrs), errs)
+			}
+			for i, err := range errs {
+				if !strings.HasPrefix(err.Error(), tc.expectedErrs[i]+":") {
+					t.Errorf("Expected error %d to be about %s, got %v", i, tc.expectedErrs[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceLBAnnotations(t *testing.T) {
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	curSvc := oldSvc.DeepCopy()
+	curSvc.Annotations[ServiceAnnotationLoadBalancerPortProtocol(80)] = "HTTP"
+	curSvc.Annotations["example.com/unrelated"] = "value"
+
+	called := false
+	if err := WatchServiceAnnotations(context.TODO(), oldSvc, curSvc, defaultAnnotations.serviceLBAnnotations(oldSvc, curSvc), func() { called = true }); err != nil {
+		t.Fatalf("WatchServiceAnnotations() returned unexpected error: %v", err)
+	}
+	if !called {
+		t.Errorf("Expected a change of a port protocol annotation to trigger a sync")
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
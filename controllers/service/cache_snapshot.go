@@ -0,0 +1,135 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// cacheSnapshotVersion is the version of the format of the cache snapshots.
+// Snapshots of another version are ignored.
+const cacheSnapshotVersion = 1
+
+// cacheSnapshot is the content of a cache snapshot file.
+type cacheSnapshot struct {
+	Version  int                           `json:"version"`
+	Services map[string]cacheSnapshotEntry `json:"services"`
+}
+
+// cacheSnapshotEntry is the cached state of a service, together with the
+// service and the backends of its last successful sync.
+type cacheSnapshotEntry struct {
+	State      *v1.Service `json:"state,omitempty"`
+	LastSynced *v1.Service `json:"lastSynced,omitempty"`
+	Backends   []string    `json:"backends,omitempty"`
+}
+
+// SaveCacheSnapshot writes the service cache to the file at path as JSON. The
+// file is replaced atomically, a crash never leaves a partial snapshot.
+func (c *Controller) SaveCacheSnapshot(path string) error {
+	snapshot := cacheSnapshot{Version: cacheSnapshotVersion, Services: make(map[string]cacheSnapshotEntry)}
+	c.cache.mu.RLock()
+	for key, cached := range c.cache.serviceMap {
+		if cached.state != nil {
+			snapshot.Services[key] = cacheSnapshotEntry{State: cached.state}
+		}
+	}
+	c.cache.mu.RUnlock()
+	c.lastSyncedBackendsLock.Lock()
+	for key, entry := range snapshot.Services {
+		if service, ok := c.lastSyncedServices[key]; ok {
+			entry.LastSynced = service
+			entry.Backends = sets.List(c.lastSyncedBackends[key])
+			snapshot.Services[key] = entry
+		}
+	}
+	c.lastSyncedBackendsLock.Unlock()
+
+	data, err := json.Marshal(&snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the service cache: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create the service cache snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write the service cache snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the service cache snapshot: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace the service cache snapshot: %v", err)
+	}
+	return nil
+}
+
+// LoadCacheSnapshot restores the service cache from the file at path written
+// by SaveCacheSnapshot. The services already cached are kept. Restoring the
+// last synced services lets the sync skip the load balancers that did not
+// change since the snapshot.
+func (c *Controller) LoadCacheSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the service cache snapshot: %v", err)
+	}
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to unmarshal the service cache snapshot: %v", err)
+	}
+	if snapshot.Version != cacheSnapshotVersion {
+		return fmt.Errorf("service cache snapshot version %d is not supported, expecting %d", snapshot.Version, cacheSnapshotVersion)
+	}
+
+	restored := 0
+	for key, entry := range snapshot.Services {
+		if entry.State == nil {
+			continue
+		}
+		if _, ok := c.cache.get(key); ok {
+			continue
+		}
+		c.cache.set(key, &cachedService{state: entry.State})
+		if entry.LastSynced != nil {
+			c.lastSyncedBackendsLock.Lock()
+			c.lastSyncedServices[key] = entry.LastSynced
+			c.lastSyncedBackends[key] = sets.New(entry.Backends...)
+			c.lastSyncedBackendsLock.Unlock()
+		}
+		restored++
+	}
+	klog.Infof("Restored %d services from the service cache snapshot %s", restored, path)
+	return nil
+}
+
+// saveCacheSnapshot saves the service cache to cacheSnapshotPath, logging the
+// failures: the next period tries again.
+func (c *Controller) saveCacheSnapshot() {
+	if err := c.SaveCacheSnapshot(c.cacheSnapshotPath); err != nil {
+		klog.Errorf("Failed to save the service cache snapshot: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	synced := newLoadBalancerService("synced", "lb-1")
+	pending := newLoadBalancerService("pending", "lb-2")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	controller.cache.set("default/synced", &cachedService{state: synced})
+	controller.cache.set("default/pending", &cachedService{state: pending})
+	controller.lastSyncedServices["default/synced"] = synced
+	controller.lastSyncedBackends["default/synced"] = sets.New("10.0.0.1", "10.0.0.2")
+
+	if err := controller.SaveCacheSnapshot(path); err != nil {
+		t.Fatalf("SaveCacheSnapshot() returned unexpected error: %v", err)
+	}
+
+	restored, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	current := newLoadBalancerService("pending", "lb-3")
+	restored.cache.set("default/pending", &cachedService{state: current})
+	if err := restored.LoadCacheSnapshot(path); err != nil {
+		t.Fatalf("LoadCacheSnapshot() returned unexpected error: %v", err)
+	}
+	if keys := sets.New(restored.cache.ListKeys()...); !keys.Equal(sets.New("default/synced", "default/pending")) {
+		t.Errorf("Expected both services to be cached, got %v", sets.List(keys))
+	}
+	if cached, _ := restored.cache.get("default/pending"); cached.state != current {
+		t.Errorf("Expected the service already cached to be kept")
+	}
+	if cached, _ := restored.cache.get("default/synced"); cached.state.Annotations[ServiceAnnotationLoadBalancerID] != "lb-1" {
+		t.Errorf("Expected the snapshot of default/synced to be restored, got %v", cached.state)
+	}
+	if restored.lastSyncedServices["default/synced"] == nil {
+		t.Errorf("Expected the last synced service to be restored")
+	}
+	if backends := restored.lastSyncedBackends["default/synced"]; !backends.Equal(sets.New("10.0.0.1", "10.0.0.2")) {
+		t.Errorf("Expected the last synced backends to be restored, got %v", sets.List(backends))
+	}
+	if _, ok := restored.lastSyncedServices["default/pending"]; ok {
+		t.Errorf("Expected no last synced service for a service never synced")
+	}
+}
+
+func TestLoadCacheSnapshotInvalid(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte("{"), 0o600); err != nil {
+		t.Fatalf("Failed to write the snapshot: %v", err)
+	}
+	unsupported := filepath.Join(dir, "unsupported.json")
+	if err := os.WriteFile(unsupported, []byte(`{"version": 2, "services": {}}`), 0o600); err != nil {
+		t.Fatalf("Failed to write the snapshot: %v", err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing.json"), invalid, unsupported} {
+		controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+		if err := controller.LoadCacheSnapshot(path); err == nil {
+			t.Errorf("Expected an error loading %s", filepath.Base(path))
+		}
+		if keys := controller.cache.ListKeys(); len(keys) != 0 {
+			t.Errorf("Expected an empty cache after failing to load %s, got %v", filepath.Base(path), keys)
+		}
+	}
+}
@@ -16,10 +16,109 @@ limitations under the License.
 
 package config
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // ServiceControllerConfiguration contains elements describing ServiceController.
 type ServiceControllerConfiguration struct {
 	// concurrentServiceSyncs is the number of services that are
 	// allowed to sync concurrently. Larger number = more responsive service
 	// management, but more CPU (and network) load.
 	ConcurrentServiceSyncs int32
+	// maxConcurrentLBOperations is the maximum number of cloud provider load
+	// balancer operations running at the same time. 0 means the same as
+	// concurrentServiceSyncs.
+	MaxConcurrentLBOperations int32
+	// concurrentLBDeleteWorkers is the number of load balancer deletions
+	// allowed to run concurrently. 0 means min(concurrentServiceSyncs, 5).
+	ConcurrentLBDeleteWorkers int32
+	// maxItemsPerNamespace is the number of services of a single namespace
+	// pending in the service queue above which further services of the
+	// namespace are delayed. 0 means unlimited.
+	MaxItemsPerNamespace int32
+	// lbAPITimeout bounds the duration of each cloud provider load balancer
+	// call.
+	LBAPITimeout metav1.Duration
+	// lbDefaultIdleTimeout is the idle timeout of the load balancer connections
+	// for services without the lb-idle-timeout annotation.
+	LBDefaultIdleTimeout metav1.Duration
+	// defaultLBSubnetID is the subnet of the load balancers of services
+	// without the lb-subnet-id annotation. Empty means the provider default.
+	DefaultLBSubnetID string
+	// watchNamespace is the namespace of the services managed by the
+	// controller. Empty means all namespaces.
+	WatchNamespace string
+	// managedLBConfigMap is the namespace/name of the ConfigMap listing the
+	// IDs of the load balancers the controller may ensure. Empty means all.
+	ManagedLBConfigMap string
+	// lbClassName is the LoadBalancerClass of the services managed by the
+	// controller. Empty means the services without a class.
+	LBClassName string
+	// lbAnnotationPrefix is the domain of the annotations of the services,
+	// e.g. inspur.com for inspur.com/load-balancer-id.
+	LBAnnotationPrefix string
+	// lbTagLabelPrefix is the prefix of the labels of the services that are
+	// set as tags of their load balancer, without the prefix. Empty disables
+	// the tags.
+	LBTagLabelPrefix string
+	// nodeLabelSelector restricts the nodes eligible as load balancer backends
+	// to the ones matching the label selector. Empty means all nodes.
+	NodeLabelSelector string
+	// nodeReadinessStalenessThreshold is how long a node may report a
+	// non-True readiness condition before it is excluded from the load
+	// balancers. 0 disables the threshold: nodes are excluded as soon as they
+	// are not ready.
+	NodeReadinessStalenessThreshold metav1.Duration
+	// deletionGracePeriod delays the deletion of the load balancer of a
+	// deleted service, leaving time to drain long-lived connections.
+	DeletionGracePeriod metav1.Duration
+	// nodeCacheSyncTimeout bounds the wait for the service, node and
+	// endpointslice caches to sync on startup, the controller exits when it
+	// expires. 0 waits forever.
+	NodeCacheSyncTimeout metav1.Duration
+	// serviceCacheSnapshotPath is the file the service cache is periodically
+	// saved to and restored from on startup, sparing the reconciliation of
+	// the load balancers unchanged across a restart. Empty disables the
+	// snapshots.
+	ServiceCacheSnapshotPath string
+	// preserveIngressOnEmpty keeps the ingress of the service status when the
+	// cloud provider returns a load balancer status without ingress.
+	PreserveIngressOnEmpty bool
+	// resyncOnStartup queues all the services with a load balancer once the
+	// caches are synced, reconciling the ones that diverged while the
+	// controller was down.
+	ResyncOnStartup bool
+	// enableOrphanLBCleanup deletes, on startup, the load balancers whose
+	// service no longer exists.
+	EnableOrphanLBCleanup bool
+	// enableLBStatusReconciliation periodically compares the status of the
+	// load balancers reported by the cloud with the status of the services.
+	EnableLBStatusReconciliation bool
+	// enableLBProvisioningCondition reports the calls ensuring the load
+	// balancer in the cloud.inspur.com/LoadBalancerProvisioning condition of
+	// the service status.
+	EnableLBProvisioningCondition bool
+	// enableLBReadinessGate reports the provisioning of the load balancer in
+	// the cloud.inspur.com/lb-ready condition of the service status.
+	EnableLBReadinessGate bool
+	// filterNotReadyEndpoints excludes the endpoints which are not ready from
+	// the members of the load balancers, for the clouds not health checking
+	// them.
+	FilterNotReadyEndpoints bool
+	// eventRateLimiterQPS is the number of events per second recorded for
+	// a single object, the overflow is dropped. 0 means unlimited.
+	EventRateLimiterQPS float32
+	// eventRateLimiterBurst is the number of events recorded for a single
+	// object above eventRateLimiterQPS in a burst.
+	EventRateLimiterBurst int32
+	// eventDedupWindow is how long the repeats of an event of a service,
+	// type, reason and message alike, are dropped before bumping the count
+	// of the event. 0 disables the deduplication.
+	EventDedupWindow metav1.Duration
+	// enableAdminEndpoint enables the admin endpoint used to trigger a full
+	// reconciliation of all services.
+	EnableAdminEndpoint bool
+	// adminEndpointBindAddress is the address the admin endpoint listens on.
+	AdminEndpointBindAddress string
 }
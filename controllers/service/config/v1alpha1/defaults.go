@@ -16,6 +16,13 @@ limitations under the License.
 
 package v1alpha1
 
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilpointer "k8s.io/utils/pointer"
+)
+
 // RecommendedDefaultServiceControllerConfiguration defaults a pointer to a
 // ServiceControllerConfiguration struct. This will set the recommended default
 // values, but they may be subject to change between API versions. This function
@@ -29,4 +36,34 @@ func RecommendedDefaultServiceControllerConfiguration(obj *ServiceControllerConf
 	if obj.ConcurrentServiceSyncs == 0 {
 		obj.ConcurrentServiceSyncs = 1
 	}
+	if obj.LBAPITimeout.Duration == 0 {
+		obj.LBAPITimeout = metav1.Duration{Duration: 120 * time.Second}
+	}
+	if obj.LBDefaultIdleTimeout.Duration == 0 {
+		obj.LBDefaultIdleTimeout = metav1.Duration{Duration: 60 * time.Second}
+	}
+	if obj.NodeCacheSyncTimeout.Duration == 0 {
+		obj.NodeCacheSyncTimeout = metav1.Duration{Duration: 5 * time.Minute}
+	}
+	if obj.PreserveIngressOnEmpty == nil {
+		obj.PreserveIngressOnEmpty = utilpointer.Bool(true)
+	}
+	if obj.ResyncOnStartup == nil {
+		obj.ResyncOnStartup = utilpointer.Bool(true)
+	}
+	if obj.LBAnnotationPrefix == "" {
+		obj.LBAnnotationPrefix = "inspur.com"
+	}
+	if obj.LBTagLabelPrefix == "" {
+		obj.LBTagLabelPrefix = "inspur.com/tag-"
+	}
+	if obj.EventRateLimiterBurst == 0 {
+		obj.EventRateLimiterBurst = 25
+	}
+	if obj.EventDedupWindow.Duration == 0 {
+		obj.EventDedupWindow = metav1.Duration{Duration: 60 * time.Second}
+	}
+	if obj.AdminEndpointBindAddress == "" {
+		obj.AdminEndpointBindAddress = "127.0.0.1:10270"
+	}
 }
@@ -22,6 +22,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	conversion "k8s.io/apimachinery/pkg/conversion"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	config "github.com/inspurDTest/cloud-provider/controllers/service/config"
@@ -49,10 +50,74 @@ func RegisterConversions(s *runtime.Scheme) error {
 
 func autoConvert_v1alpha1_ServiceControllerConfiguration_To_config_ServiceControllerConfiguration(in *ServiceControllerConfiguration, out *config.ServiceControllerConfiguration, s conversion.Scope) error {
 	out.ConcurrentServiceSyncs = in.ConcurrentServiceSyncs
+	out.MaxConcurrentLBOperations = in.MaxConcurrentLBOperations
+	out.ConcurrentLBDeleteWorkers = in.ConcurrentLBDeleteWorkers
+	out.MaxItemsPerNamespace = in.MaxItemsPerNamespace
+	out.LBAPITimeout = in.LBAPITimeout
+	out.LBDefaultIdleTimeout = in.LBDefaultIdleTimeout
+	out.DefaultLBSubnetID = in.DefaultLBSubnetID
+	out.WatchNamespace = in.WatchNamespace
+	out.ManagedLBConfigMap = in.ManagedLBConfigMap
+	out.LBClassName = in.LBClassName
+	out.LBAnnotationPrefix = in.LBAnnotationPrefix
+	out.LBTagLabelPrefix = in.LBTagLabelPrefix
+	out.NodeLabelSelector = in.NodeLabelSelector
+	out.NodeReadinessStalenessThreshold = in.NodeReadinessStalenessThreshold
+	out.DeletionGracePeriod = in.DeletionGracePeriod
+	out.NodeCacheSyncTimeout = in.NodeCacheSyncTimeout
+	out.ServiceCacheSnapshotPath = in.ServiceCacheSnapshotPath
+	if err := v1.Convert_Pointer_bool_To_bool(&in.PreserveIngressOnEmpty, &out.PreserveIngressOnEmpty, s); err != nil {
+		return err
+	}
+	if err := v1.Convert_Pointer_bool_To_bool(&in.ResyncOnStartup, &out.ResyncOnStartup, s); err != nil {
+		return err
+	}
+	out.EnableOrphanLBCleanup = in.EnableOrphanLBCleanup
+	out.EnableLBStatusReconciliation = in.EnableLBStatusReconciliation
+	out.EnableLBProvisioningCondition = in.EnableLBProvisioningCondition
+	out.EnableLBReadinessGate = in.EnableLBReadinessGate
+	out.FilterNotReadyEndpoints = in.FilterNotReadyEndpoints
+	out.EventRateLimiterQPS = in.EventRateLimiterQPS
+	out.EventRateLimiterBurst = in.EventRateLimiterBurst
+	out.EventDedupWindow = in.EventDedupWindow
+	out.EnableAdminEndpoint = in.EnableAdminEndpoint
+	out.AdminEndpointBindAddress = in.AdminEndpointBindAddress
 	return nil
 }
 
 func autoConvert_config_ServiceControllerConfiguration_To_v1alpha1_ServiceControllerConfiguration(in *config.ServiceControllerConfiguration, out *ServiceControllerConfiguration, s conversion.Scope) error {
 	out.ConcurrentServiceSyncs = in.ConcurrentServiceSyncs
+	out.MaxConcurrentLBOperations = in.MaxConcurrentLBOperations
+	out.ConcurrentLBDeleteWorkers = in.ConcurrentLBDeleteWorkers
+	out.MaxItemsPerNamespace = in.MaxItemsPerNamespace
+	out.LBAPITimeout = in.LBAPITimeout
+	out.LBDefaultIdleTimeout = in.LBDefaultIdleTimeout
+	out.DefaultLBSubnetID = in.DefaultLBSubnetID
+	out.WatchNamespace = in.WatchNamespace
+	out.ManagedLBConfigMap = in.ManagedLBConfigMap
+	out.LBClassName = in.LBClassName
+	out.LBAnnotationPrefix = in.LBAnnotationPrefix
+	out.LBTagLabelPrefix = in.LBTagLabelPrefix
+	out.NodeLabelSelector = in.NodeLabelSelector
+	out.NodeReadinessStalenessThreshold = in.NodeReadinessStalenessThreshold
+	out.DeletionGracePeriod = in.DeletionGracePeriod
+	out.NodeCacheSyncTimeout = in.NodeCacheSyncTimeout
+	out.ServiceCacheSnapshotPath = in.ServiceCacheSnapshotPath
+	if err := v1.Convert_bool_To_Pointer_bool(&in.PreserveIngressOnEmpty, &out.PreserveIngressOnEmpty, s); err != nil {
+		return err
+	}
+	if err := v1.Convert_bool_To_Pointer_bool(&in.ResyncOnStartup, &out.ResyncOnStartup, s); err != nil {
+		return err
+	}
+	out.EnableOrphanLBCleanup = in.EnableOrphanLBCleanup
+	out.EnableLBStatusReconciliation = in.EnableLBStatusReconciliation
+	out.EnableLBProvisioningCondition = in.EnableLBProvisioningCondition
+	out.EnableLBReadinessGate = in.EnableLBReadinessGate
+	out.FilterNotReadyEndpoints = in.FilterNotReadyEndpoints
+	out.EventRateLimiterQPS = in.EventRateLimiterQPS
+	out.EventRateLimiterBurst = in.EventRateLimiterBurst
+	out.EventDedupWindow = in.EventDedupWindow
+	out.EnableAdminEndpoint = in.EnableAdminEndpoint
+	out.AdminEndpointBindAddress = in.AdminEndpointBindAddress
 	return nil
 }
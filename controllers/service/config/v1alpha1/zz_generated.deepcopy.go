@@ -24,6 +24,22 @@ package v1alpha1
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceControllerConfiguration) DeepCopyInto(out *ServiceControllerConfiguration) {
 	*out = *in
+	out.LBAPITimeout = in.LBAPITimeout
+	out.LBDefaultIdleTimeout = in.LBDefaultIdleTimeout
+	out.NodeReadinessStalenessThreshold = in.NodeReadinessStalenessThreshold
+	out.DeletionGracePeriod = in.DeletionGracePeriod
+	out.NodeCacheSyncTimeout = in.NodeCacheSyncTimeout
+	out.EventDedupWindow = in.EventDedupWindow
+	if in.PreserveIngressOnEmpty != nil {
+		in, out := &in.PreserveIngressOnEmpty, &out.PreserveIngressOnEmpty
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ResyncOnStartup != nil {
+		in, out := &in.ResyncOnStartup, &out.ResyncOnStartup
+		*out = new(bool)
+		**out = **in
+	}
 	return
 }
 
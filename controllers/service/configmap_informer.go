@@ -0,0 +1,57 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newConfigMapInformer returns an informer watching the single ConfigMap
+// namespace/name, for the settings the controller picks up without a restart.
+func newConfigMapInformer(kubeClient clientset.Interface, namespace, name string) cache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector(metav1.ObjectNameField, name).String()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return kubeClient.CoreV1().ConfigMaps(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return kubeClient.CoreV1().ConfigMaps(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&v1.ConfigMap{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// isConfigMap reports whether obj, possibly a deletion tombstone, is the
+// ConfigMap namespace/name.
+func isConfigMap(obj interface{}, namespace, name string) bool {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	return err == nil && key == namespace+"/"+name
+}
@@ -20,20 +20,27 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cloudprovider "github.com/inspurDTest/cloud-provider"
 	"github.com/inspurDTest/cloud-provider/api"
 	endpointSliceHelper "github.com/inspurDTest/cloud-provider/endpointslice/helpers"
 	servicehelper "github.com/inspurDTest/cloud-provider/service/helpers"
+	"golang.org/x/sync/semaphore"
 	v1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -64,19 +71,49 @@ const (
 	// Interval of synchronizing node status from apiserver
 	nodeSyncPeriod = 100 * time.Second
 
+	// Interval of removing the last synced nodes of services no longer cached
+	lastSyncedNodesGCPeriod = 10 * time.Minute
+
+	// How long to wait for the caches to sync on startup by default
+	defaultCacheSyncTimeout = 5 * time.Minute
+
+	// Interval of sampling the depth of the queues
+	queueDepthSamplePeriod = 10 * time.Second
+
 	// How long to wait before retrying the processing of a service change.
 	// If this changes, the sleep in hack/jenkins/e2e.sh before downing a cluster
 	// should be changed appropriately.
 	minRetryDelay = 5 * time.Second
 	maxRetryDelay = 300 * time.Second
+	// How long to wait before retrying a failed deletion of a load balancer.
+	minDeleteRetryDelay = 10 * time.Second
+	maxDeleteRetryDelay = 300 * time.Second
+	// The default timeout of a cloud provider load balancer call.
+	defaultLBAPITimeout = 120 * time.Second
+	// The default bound of concurrent load balancer deletions.
+	maxDefaultLBDeleteWorkers = 5
+	// How long to wait before retrying a service whose key is being
+	// reconciled by another worker.
+	serviceLockRetryDelay = 1 * time.Second
+	// How long the ingress left over by a migration between an IP and a
+	// hostname is kept in the service status.
+	statusMigrationTimeout = 10 * time.Minute
+	// Bound and lifetime of the cached GetLoadBalancer results.
+	getLoadBalancerCacheSize = 1000
+	getLoadBalancerCacheTTL  = 30 * time.Second
 	// ToBeDeletedTaint is a taint used by the CLuster Autoscaler before marking a node for deletion. Defined in
 	// https://github.com/kubernetes/autoscaler/blob/e80ab518340f88f364fe3ef063f8303755125971/cluster-autoscaler/utils/deletetaint/delete.go#L36
 	ToBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
 
 	KubernetesServiceName = "kubernetes.io/service-name"
 
-	ServiceAnnotationLoadBalancerID    = "inspur.com/load-balancer-id"
-	ServiceAnnotationLoadBalancerOldID = "inspur.com/load-balancer-old-id"
+	ServiceAnnotationLoadBalancerID    = DefaultAnnotationPrefix + "/load-balancer-id"
+	ServiceAnnotationLoadBalancerOldID = DefaultAnnotationPrefix + "/load-balancer-old-id"
+
+	// endpointSliceFinalizerEnabled controls whether the load balancer cleanup
+	// finalizer is added to EndpointSlices. It is disabled for now, members are
+	// cleaned up together with the load balancer of the service.
+	endpointSliceFinalizerEnabled = false
 )
 
 type cachedService struct {
@@ -92,9 +129,11 @@ type serviceCache struct {
 // Controller keeps cloud provider service resources
 // (like load balancers) in sync with the registry.
 type Controller struct {
-	cloud       cloudprovider.Interface
-	kubeClient  clientset.Interface
-	clusterName string
+	cloud      cloudprovider.Interface
+	kubeClient clientset.Interface
+	// clusterID caches the cluster ID once the ClusterIDProvider resolved it,
+	// no load balancer is synced before.
+	clusterID atomic.Pointer[string]
 	balancer    cloudprovider.LoadBalancer
 	// TODO(#85155): Stop relying on this and remove the cache completely.
 	cache *serviceCache
@@ -111,15 +150,134 @@ type Controller struct {
 	serviceQueue       workqueue.RateLimitingInterface
 	endpointsliceQueue workqueue.RateLimitingInterface
 	nodeQueue          workqueue.RateLimitingInterface
+	// queueDepthSampling starts the sampling of the depth of the queues once.
+	queueDepthSampling sync.Once
 	// lastSyncedNodes is used when reconciling node state and keeps track of
-	// the last synced set of nodes per service key. This is accessed from the
-	// service and node controllers, hence it is protected by a lock.
-	lastSyncedNodes     map[string][]*v1.Node
+	// the hash of the last synced set of nodes per service key. This is
+	// accessed from the service and node controllers, hence it is protected by
+	// a lock.
+	lastSyncedNodes     map[string]uint64
 	lastSyncedNodesLock sync.Mutex
+	// lastSyncedBackends keeps track of the endpoint addresses handed to the
+	// cloud provider in the last successful sync per service key.
+	lastSyncedBackends map[string]sets.Set[string]
+	// lastSyncedServices keeps track of the services as they were in the last
+	// successful sync per service key, protected by lastSyncedBackendsLock.
+	lastSyncedServices     map[string]*v1.Service
+	lastSyncedBackendsLock sync.Mutex
+	// activeLBs holds the IDs of the load balancers ensured and not deleted
+	// since the controller started, reported by the lb_active_total metric.
+	activeLBs     map[string]struct{}
+	activeLBsLock sync.Mutex
+	// statusMigrations holds per service key since when the status keeps the
+	// ingress left over by a migration, dropped after statusMigrationTimeout.
+	statusMigrations     map[string]time.Time
+	statusMigrationsLock sync.Mutex
+	// managedLBs restricts the load balancers ensured by the controller, nil
+	// allows all.
+	managedLBs *managedLBAllowlist
+	// getLoadBalancerCache holds the recent GetLoadBalancer results keyed by
+	// loadBalancerCacheKey, sparing the cloud API bursts of identical reads.
+	getLoadBalancerCache *utilcache.LRUExpireCache
+	// serviceLocks serializes the reconciliation of a service between the
+	// service and node workers.
+	serviceLocks *serviceLocks
+	// deleteRetryLimiter computes the backoff for failed load balancer deletions.
+	deleteRetryLimiter workqueue.RateLimiter
+	clusterIDProvider  ClusterIDProvider
+	serviceFilter      ServiceFilter
+	circuitBreaker     CircuitBreaker
+	lifecycleHook      LBLifecycleHook
+	// cloudEventRecorder additionally records the events of the services in
+	// the cloud.
+	cloudEventRecorder CloudEventRecorder
+	// cloudEventMirror records the events in the cloud with the
+	// cloudEventRecorder, nil without one.
+	cloudEventMirror *cloudMirroringEventRecorder
+	// lbOperations bounds the number of concurrent cloud provider calls, nil
+	// means unbounded.
+	lbOperations *semaphore.Weighted
+	// maxItemsPerNamespace bounds the pending items of a namespace in the
+	// service queue, 0 means unbounded.
+	maxItemsPerNamespace int
+	// lbAPITimeout bounds the duration of each cloud provider call.
+	lbAPITimeout time.Duration
+	// lbDeletes bounds the number of concurrent load balancer deletions, it
+	// is sized by Run from concurrentLBDeletes.
+	concurrentLBDeletes int
+	lbDeletes           *semaphore.Weighted
+	// defaultServiceOptions holds the options of services not overriding them
+	// with annotations.
+	defaultServiceOptions cloudprovider.ServiceOptions
+	// nodeLabelSelector restricts the nodes eligible as load balancer
+	// backends, it is parsed from nodeLabelSelectorString.
+	nodeLabelSelectorString string
+	nodeLabelSelector       labels.Selector
+	// dynamicLabelFilter, if set, further restricts the nodes eligible as
+	// load balancer backends.
+	dynamicLabelFilter *DynamicLabelFilter
+	// dynamicExclusionLabels, if set, excludes the nodes with the label keys
+	// of its ConfigMap from the load balancer backends.
+	dynamicExclusionLabels *DynamicExclusionLabelController
+	// nodeReadinessStalenessThreshold is how long a not ready node is kept as
+	// a load balancer backend, 0 means not ready nodes are excluded right away.
+	// allNodePredicates is derived from it.
+	nodeReadinessStalenessThreshold time.Duration
+	allNodePredicates               []NodeConditionPredicate
+	// watchNamespace is the namespace of the services managed by the
+	// controller. Empty means all namespaces.
+	watchNamespace string
+	// loadBalancerClass is the LoadBalancerClass of the services managed by
+	// the controller. Empty means the services without a class.
+	loadBalancerClass string
+	// annotations names the annotations of the services under the configured
+	// domain.
+	annotations AnnotationConfig
+	// tagLabelPrefix is the prefix of the labels of the services set as tags
+	// of their load balancer, empty disables the tags.
+	tagLabelPrefix string
+	// deletionGracePeriod delays the deletion of the load balancers of the
+	// deleted services.
+	deletionGracePeriod time.Duration
+	// cacheSyncTimeout bounds the wait for the caches to sync on startup, 0
+	// waits forever.
+	cacheSyncTimeout time.Duration
+	// cacheSnapshotPath is the file the service cache is periodically saved
+	// to and restored from on startup, empty disables the snapshots.
+	cacheSnapshotPath string
+	// enableOrphanLBCleanup deletes, on startup, the load balancers whose
+	// service no longer exists.
+	enableOrphanLBCleanup bool
+	// enableLBStatusReconciliation periodically compares the status of the
+	// load balancers reported by the cloud with the status of the services.
+	enableLBStatusReconciliation bool
+	// enableLBProvisioningCondition reports the calls ensuring the load
+	// balancers in the LoadBalancerProvisioning condition of the services.
+	enableLBProvisioningCondition bool
+	// enableLBReadinessGate reports the provisioning of the load balancers
+	// in the lb-ready condition of the services.
+	enableLBReadinessGate bool
+	// filterNotReadyEndpoints excludes the endpoints which are not ready
+	// from the members of the load balancers.
+	filterNotReadyEndpoints bool
+	// eventQPS and eventBurst bound the rate of the events recorded per
+	// object, eventQPS 0 means unlimited.
+	eventQPS   float32
+	eventBurst int
+	// eventDedupWindow is how long the repeats of an event of an object are
+	// dropped, 0 disables it.
+	eventDedupWindow time.Duration
+	// preserveIngressOnEmpty keeps the ingress of the service status when the
+	// cloud provider returns a load balancer status without ingress.
+	preserveIngressOnEmpty bool
+	// resyncOnStartup queues all the services with a load balancer once the
+	// caches are synced.
+	resyncOnStartup bool
 }
 
 // New returns a new service controller to keep cloud provider service resources
-// (like load balancers) in sync with the registry.
+// (like load balancers) in sync with the registry. The cluster ID is resolved
+// with the ClusterIDProvider by the first sync, superseding clusterName.
 func New(
 	cloud cloudprovider.Interface,
 	kubeClient clientset.Interface,
@@ -128,25 +286,79 @@ func New(
 	nodeInformer coreinformers.NodeInformer,
 	clusterName string,
 	featureGate featuregate.FeatureGate,
+	opts ...Option,
 ) (*Controller, error) {
 	broadcaster := record.NewBroadcaster()
 	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "service-controller"})
 
 	registerMetrics()
 	s := &Controller{
-		cloud:               cloud,
-		kubeClient:          kubeClient,
-		clusterName:         clusterName,
-		cache:               &serviceCache{serviceMap: make(map[string]*cachedService)},
-		eventBroadcaster:    broadcaster,
-		eventRecorder:       recorder,
-		nodeLister:          nodeInformer.Lister(),
-		endpointSliceLister: endpointSliceInformer.Lister(),
-		nodeListerSynced:    nodeInformer.Informer().HasSynced,
-		serviceQueue:        workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "service"),
-		endpointsliceQueue:  workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "endpointslice"),
-		nodeQueue:           workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "node"),
-		lastSyncedNodes:     make(map[string][]*v1.Node),
+		cloud:                  cloud,
+		kubeClient:             kubeClient,
+		cache:                  &serviceCache{serviceMap: make(map[string]*cachedService)},
+		eventBroadcaster:       broadcaster,
+		eventRecorder:          recorder,
+		nodeLister:             nodeInformer.Lister(),
+		endpointSliceLister:    endpointSliceInformer.Lister(),
+		nodeListerSynced:       nodeInformer.Informer().HasSynced,
+		endpointsliceQueue:     workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "endpointslice"),
+		nodeQueue:              workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "node"),
+		lastSyncedNodes:        make(map[string]uint64),
+		lastSyncedBackends:     make(map[string]sets.Set[string]),
+		lastSyncedServices:     make(map[string]*v1.Service),
+		activeLBs:              make(map[string]struct{}),
+		statusMigrations:       make(map[string]time.Time),
+		serviceLocks:           newServiceLocks(),
+		getLoadBalancerCache:   utilcache.NewLRUExpireCache(getLoadBalancerCacheSize),
+		deleteRetryLimiter:     workqueue.NewItemExponentialFailureRateLimiter(minDeleteRetryDelay, maxDeleteRetryDelay),
+		clusterIDProvider:      &configMapClusterIDProvider{kubeClient: kubeClient},
+		serviceFilter:          allServices,
+		circuitBreaker:         noopCircuitBreaker{},
+		lifecycleHook:          NoopLBLifecycleHook{},
+		cloudEventRecorder:     NoopCloudEventRecorder{},
+		lbAPITimeout:           defaultLBAPITimeout,
+		preserveIngressOnEmpty: true,
+		resyncOnStartup:        true,
+		eventDedupWindow:       defaultEventDedupWindow,
+		cacheSyncTimeout:       defaultCacheSyncTimeout,
+		tagLabelPrefix:         defaultTagLabelPrefix,
+		annotations:            NewAnnotationConfig(DefaultAnnotationPrefix),
+	}
+	s.serviceQueue = newPriorityQueue("service", workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), s.servicePriority)
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.eventRecorder = &lbIDAnnotatingEventRecorder{recorder: s.eventRecorder, annotations: s.annotations}
+	if s.eventDedupWindow > 0 {
+		s.eventRecorder = newDeduplicatingEventRecorder(s.eventRecorder, s.eventDedupWindow)
+	}
+	if _, noop := s.cloudEventRecorder.(NoopCloudEventRecorder); !noop && s.cloudEventRecorder != nil {
+		s.cloudEventMirror = newCloudMirroringEventRecorder(s.eventRecorder, s.cloudEventRecorder, s.lbAPITimeout, s.annotations)
+		s.eventRecorder = s.cloudEventMirror
+	}
+	if s.maxItemsPerNamespace > 0 {
+		s.serviceQueue = NewNamespaceRateLimiter(s.serviceQueue, s.maxItemsPerNamespace)
+	}
+	nodeLabelSelector, err := labels.Parse(s.nodeLabelSelectorString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node label selector %q: %v", s.nodeLabelSelectorString, err)
+	}
+	s.nodeLabelSelector = nodeLabelSelector
+	if s.dynamicLabelFilter != nil {
+		// Any key triggers a sync of all the load balancers.
+		s.dynamicLabelFilter.setOnChange(func() { s.nodeQueue.Add(DynamicLabelFilterKey) })
+	}
+	if s.dynamicExclusionLabels != nil {
+		s.dynamicExclusionLabels.setOnChange(func() { s.nodeQueue.Add(DynamicExclusionLabelsKey) })
+	}
+	s.allNodePredicates = allNodePredicates
+	if s.nodeReadinessStalenessThreshold > 0 {
+		s.allNodePredicates = []NodeConditionPredicate{
+			nodeNotDeletedPredicate,
+			nodeIncludedPredicate,
+			nodeUnTaintedPredicate,
+			nodeStaleReadinessPredicate(s.nodeReadinessStalenessThreshold),
+		}
 	}
 
 	serviceInformer.Informer().AddEventHandlerWithResyncPeriod(
@@ -155,30 +367,11 @@ func New(
 				svc, ok := cur.(*v1.Service)
 				// Check cleanup here can provide a remedy when controller failed to handle
 				// changes before it exiting (e.g. crashing, restart, etc.).
-				if ok && (wantsLoadBalancer(svc) || needsCleanup(svc)) {
+				if ok && !s.ownedByOtherClass(svc) && (s.wantsLoadBalancer(svc) || needsCleanup(svc)) {
 					s.enqueueService(cur)
 				}
 			},
-			UpdateFunc: func(old, cur interface{}) {
-
-				oldSvc, ok1 := old.(*v1.Service)
-				curSvc, ok2 := cur.(*v1.Service)
-				if !(ok1 && ok2){
-					return
-				}
-				oldSvcId := oldSvc.Annotations[ServiceAnnotationLoadBalancerID]
-				oldSvcNewId := oldSvc.Annotations[ServiceAnnotationLoadBalancerOldID]
-				newSvcId := curSvc.Annotations[ServiceAnnotationLoadBalancerID]
-				newSvcNewId := curSvc.Annotations[ServiceAnnotationLoadBalancerOldID]
-
-				if len(oldSvcId) == 0 && len(oldSvcNewId) == 0 &&
-					len(newSvcId) == 0 && len(newSvcNewId) == 0 &&
-						!(wantsLoadBalancer(curSvc) || needsCleanup(curSvc)) {
-					      return
-				}
-				// 兜底所有svc玉lb的绑定关系
-				s.enqueueService(cur)
-			},
+			UpdateFunc: s.updateService,
 			// No need to handle deletion event because the deletion would be handled by
 			// the update path when the deletion timestamp is added.
 		},
@@ -207,7 +400,7 @@ func New(
 					}
 
 					// skip掉svc do not  belong lb 管理情况
-					if !(wantsLoadBalancer(svc) || needsCleanup(svc)) {
+					if !(s.wantsLoadBalancer(svc) || needsCleanup(svc)) {
 						return
 					}
 					s.enqueueService(svc)
@@ -216,7 +409,7 @@ func New(
 			UpdateFunc: func(old, cur interface{}) {
 				oldEps, ok1 := old.(*discoveryv1.EndpointSlice)
 				curEps, ok2 := cur.(*discoveryv1.EndpointSlice)
-				if ok1 && ok2 && (epsHaveServiceName(oldEps) || epsHaveServiceName(curEps)) && (s.epsNeedsUpdate(oldEps, curEps) || epsNeedsCleanup(curEps)) {
+				if ok1 && ok2 && (epsHaveServiceName(oldEps) || epsHaveServiceName(curEps)) && (s.epsNeedsUpdate(oldEps, curEps) || epsNeedsCleanup(curEps) || epsNeedsCleanup(oldEps)) {
 					klog.Info("endpoint update, enqueueService")
 					var svc *v1.Service
 					var err error
@@ -232,7 +425,7 @@ func New(
 					}
 
 					// skip掉svc do not  belong lb 管理情况
-					if !(wantsLoadBalancer(svc) || needsCleanup(svc)) {
+					if !(s.wantsLoadBalancer(svc) || needsCleanup(svc)) {
 						return
 					}
 					s.enqueueService(svc)
@@ -241,7 +434,7 @@ func New(
 			// No need to handle deletion event because the deletion would be handled by
 			// the update path when the deletion timestamp is added.
 		},
-		30 * endpointSliceSyncPeriod,
+		endpointSliceSyncPeriod,
 	)
 
 	s.endpointSliceLister = endpointSliceInformer.Lister()
@@ -263,7 +456,9 @@ func New(
 					return
 				}
 
-				if !shouldSyncUpdatedNode(oldNode, curNode) {
+				s.enqueueNodeOnStaleReadiness(curNode)
+				if !s.shouldSyncUpdatedNode(oldNode, curNode) &&
+					s.nodeLabelPredicate(oldNode) == s.nodeLabelPredicate(curNode) {
 					return
 				}
 
@@ -283,8 +478,68 @@ func New(
 	return s, nil
 }
 
+// servicePriority returns the queue priority of the service with the given
+// key from its lb-priority annotation.
+func (c *Controller) servicePriority(key interface{}) priority {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key.(string))
+	if err != nil || c.serviceLister == nil {
+		return priorityNormal
+	}
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return priorityNormal
+	}
+	return parsePriority(service.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerPriority)])
+}
+
+// updateService handles an update of a service seen by the informer, including
+// its periodic resync.
+func (c *Controller) updateService(old, cur interface{}) {
+	oldSvc, ok1 := old.(*v1.Service)
+	curSvc, ok2 := cur.(*v1.Service)
+	if !(ok1 && ok2) {
+		return
+	}
+	// The services of another LoadBalancerClass are left to their controller,
+	// including on resync.
+	if c.ownedByOtherClass(curSvc) {
+		return
+	}
+	// Annotation-only updates are reconciled only if they touch
+	// an annotation the load balancer depends on.
+	if onlyAnnotationsChanged(oldSvc, curSvc) {
+		if err := WatchServiceAnnotations(context.TODO(), oldSvc, curSvc, c.annotations.serviceLBAnnotations(oldSvc, curSvc), func() {
+			// needsUpdate records which of the annotations changed.
+			c.needsUpdate(oldSvc, curSvc)
+			c.enqueueService(cur)
+		}); err != nil {
+			klog.Errorf("Failed to compare annotations of service %s/%s: %v", curSvc.Namespace, curSvc.Name, err)
+		}
+		return
+	}
+	if c.needsUpdate(oldSvc, curSvc) || needsCleanup(curSvc) {
+		c.enqueueService(cur)
+		return
+	}
+	oldSvcId := oldSvc.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerID)]
+	oldSvcNewId := oldSvc.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerOldID)]
+	newSvcId := curSvc.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerID)]
+	newSvcNewId := curSvc.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerOldID)]
+
+	if len(oldSvcId) == 0 && len(oldSvcNewId) == 0 &&
+		len(newSvcId) == 0 && len(newSvcNewId) == 0 &&
+		!c.wantsLoadBalancer(curSvc) {
+		return
+	}
+	// 兜底所有svc玉lb的绑定关系
+	c.enqueueService(cur)
+}
+
 // obj could be an *v1.Service, or a DeletionFinalStateUnknown marker item.
 func (c *Controller) enqueueService(obj interface{}) {
+	if svc, ok := obj.(*v1.Service); ok && (!c.watchesNamespace(svc.Namespace) || !c.serviceFilter(svc)) {
+		return
+	}
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
 		runtime.HandleError(fmt.Errorf("couldn't get key for object %#v: %v", obj, err))
@@ -293,6 +548,46 @@ func (c *Controller) enqueueService(obj interface{}) {
 	c.serviceQueue.Add(key)
 }
 
+// enqueueServiceIfAbsent queues the service key unless it is already waiting
+// in the queue, sparing the queue operations when many services are retried
+// at once, e.g. by syncNodes. The service cache can't tell it: it holds the
+// services processed before, not the queued ones.
+func (c *Controller) enqueueServiceIfAbsent(key string) {
+	if checker, ok := c.serviceQueue.(queuedChecker); ok && checker.queued(key) {
+		return
+	}
+	c.serviceQueue.Add(key)
+}
+
+// resyncServices queues all the services of the lister that want a load
+// balancer or need their load balancer cleaned up. It must run once the
+// informers are synced, not to act on stale services.
+func (c *Controller) resyncServices() {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("failed to list services to resync: %v", err))
+		return
+	}
+	queued := 0
+	for _, svc := range services {
+		if !c.watchesNamespace(svc.Namespace) || !c.serviceFilter(svc) {
+			continue
+		}
+		if c.wantsLoadBalancer(svc) || needsCleanup(svc) {
+			c.enqueueService(svc)
+			queued++
+		}
+	}
+	startupResyncCount.Add(float64(queued))
+	klog.Infof("Queued %d services with a load balancer on startup", queued)
+}
+
+// watchesNamespace reports whether the services of the namespace are managed
+// by the controller.
+func (c *Controller) watchesNamespace(namespace string) bool {
+	return len(c.watchNamespace) == 0 || namespace == c.watchNamespace
+}
+
 // obj could be an *v1.Service, or a DeletionFinalStateUnknown marker item.
 func (c *Controller) enqueueNode(obj interface{}) {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
@@ -321,17 +616,45 @@ func (c *Controller) Run(ctx context.Context, workers int, controllerManagerMetr
 
 	// Start event processing pipeline.
 	c.eventBroadcaster.StartStructuredLogging(0)
-	c.eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: c.kubeClient.CoreV1().Events("")})
+	var sink record.EventSink = &v1core.EventSinkImpl{Interface: c.kubeClient.CoreV1().Events("")}
+	if c.eventQPS > 0 {
+		sink = newRateLimitedEventSink(sink, c.eventQPS, c.eventBurst)
+	}
+	c.eventBroadcaster.StartRecordingToSink(sink)
 	defer c.eventBroadcaster.Shutdown()
+	if c.cloudEventMirror != nil {
+		go c.cloudEventMirror.run(ctx.Done())
+	}
 
 	klog.Info("Starting service controller")
 	defer klog.Info("Shutting down service controller")
 	controllerManagerMetrics.ControllerStarted("service")
 	defer controllerManagerMetrics.ControllerStopped("service")
 
-	if !cache.WaitForNamedCacheSync("service", ctx.Done(), c.serviceListerSynced, c.nodeListerSynced, c.endpointSliceListerSynced) {
+	if err := c.waitForCacheSync(ctx); err != nil {
+		if ctx.Err() == nil {
+			klog.Fatalf("Failed to start service controller: %v", err)
+		}
 		return
 	}
+	if c.dynamicLabelFilter != nil {
+		go c.dynamicLabelFilter.Run(ctx.Done())
+		if !cache.WaitForNamedCacheSync("node label filter", ctx.Done(), c.dynamicLabelFilter.HasSynced) {
+			return
+		}
+	}
+	if c.dynamicExclusionLabels != nil {
+		go c.dynamicExclusionLabels.Run(ctx.Done())
+		if !cache.WaitForNamedCacheSync("node exclusion labels", ctx.Done(), c.dynamicExclusionLabels.HasSynced) {
+			return
+		}
+	}
+	if c.managedLBs != nil {
+		go c.managedLBs.run(ctx.Done())
+		if !cache.WaitForNamedCacheSync("managed load balancers", ctx.Done(), c.managedLBs.hasSynced) {
+			return
+		}
+	}
 
 	cm, err := c.kubeClient.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "icks-cluster-info", metav1.GetOptions{})
 	if err == nil || cm == nil || cm.Data["cni"] == "calico" {
@@ -339,10 +662,44 @@ func (c *Controller) Run(ctx context.Context, workers int, controllerManagerMetr
 		runtime.HandleCrash()
 	}
 
+	if c.concurrentLBDeletes <= 0 {
+		c.concurrentLBDeletes = workers
+		if c.concurrentLBDeletes > maxDefaultLBDeleteWorkers {
+			c.concurrentLBDeletes = maxDefaultLBDeleteWorkers
+		}
+	}
+	c.lbDeletes = semaphore.NewWeighted(int64(c.concurrentLBDeletes))
+
+	if len(c.cacheSnapshotPath) != 0 {
+		// Without a snapshot every service is reconciled, as on a first start.
+		if err := c.LoadCacheSnapshot(c.cacheSnapshotPath); err != nil {
+			klog.Warningf("Reconciling all services, the service cache could not be restored: %v", err)
+		}
+		go wait.Until(c.saveCacheSnapshot, serviceSyncPeriod, ctx.Done())
+	}
+	if c.enableOrphanLBCleanup {
+		if err := c.reconcileOrphanedLBs(ctx); err != nil {
+			runtime.HandleError(fmt.Errorf("failed to clean up orphaned load balancers: %v", err))
+		}
+	}
+	if c.resyncOnStartup {
+		c.resyncServices()
+	}
+
 	for i := 0; i < workers; i++ {
 		go wait.UntilWithContext(ctx, c.serviceWorker, time.Second)
 	}
 
+	go wait.Until(c.gcLastSyncedNodes, lastSyncedNodesGCPeriod, ctx.Done())
+	go wait.Until(c.cleanupStaleCache, serviceSyncPeriod, ctx.Done())
+	c.queueDepthSampling.Do(func() {
+		go wait.Until(c.sampleQueueDepths, queueDepthSamplePeriod, ctx.Done())
+	})
+
+	if c.enableLBStatusReconciliation {
+		go wait.UntilWithContext(ctx, c.reconcileStatus, serviceSyncPeriod)
+	}
+
 	// Initialize one go-routine servicing node events. This ensure we only
 	// process one node at any given moment in time
 	// TODO  wangyudong 屏蔽
@@ -372,8 +729,18 @@ func (c *Controller) processNextNodeItem(ctx context.Context, workers int) bool
 	}
 	defer c.nodeQueue.Done(key)
 
-	for serviceToRetry := range c.syncNodes(ctx, workers) {
-		c.serviceQueue.Add(serviceToRetry)
+	if err := c.resolveClusterID(ctx); err != nil {
+		runtime.HandleError(fmt.Errorf("error syncing nodes (retrying with exponential backoff): %v", err))
+		c.nodeQueue.AddRateLimited(key)
+		return true
+	}
+	for serviceToRetry, err := range c.syncNodes(ctx, workers) {
+		var re *api.RetryError
+		if errors.As(err, &re) {
+			c.serviceQueue.AddAfter(serviceToRetry, re.RetryAfter())
+		} else {
+			c.enqueueServiceIfAbsent(serviceToRetry)
+		}
 	}
 
 	c.nodeQueue.Forget(key)
@@ -387,16 +754,29 @@ func (c *Controller) processNextServiceItem(ctx context.Context) bool {
 	}
 	defer c.serviceQueue.Done(key)
 
+	// Don't block the worker while another one reconciles the same service,
+	// retry once it is done instead.
+	if !c.serviceLocks.TryLock(key.(string)) {
+		klog.V(4).Infof("Service %v is being reconciled by another worker, retrying in %s", key, serviceLockRetryDelay)
+		c.serviceQueue.AddAfter(key, serviceLockRetryDelay)
+		return true
+	}
 	err := c.syncService(ctx, key.(string))
+	c.serviceLocks.Unlock(key.(string))
 	if err == nil {
 		c.serviceQueue.Forget(key)
 		return true
 	}
 
 	var re *api.RetryError
+	var nre *nonRetryableError
 	if errors.As(err, &re) {
 		klog.Warningf("error processing service %v (retrying in %s): %v", key, re.RetryAfter(), err)
 		c.serviceQueue.AddAfter(key, re.RetryAfter())
+	} else if errors.As(err, &nre) {
+		// The service will be enqueued again once the user updates it.
+		runtime.HandleError(fmt.Errorf("error processing service %v (not retrying): %v", key, err))
+		c.serviceQueue.Forget(key)
 	} else {
 		runtime.HandleError(fmt.Errorf("error processing service %v (retrying with exponential backoff): %v", key, err))
 		c.serviceQueue.AddRateLimited(key)
@@ -405,6 +785,29 @@ func (c *Controller) processNextServiceItem(ctx context.Context) bool {
 	return true
 }
 
+// resolveClusterID resolves the cluster ID with the ClusterIDProvider unless
+// it is already cached. It is retried by the syncs until it succeeds, the load
+// balancers are never synced for another cluster ID.
+func (c *Controller) resolveClusterID(ctx context.Context) error {
+	if c.clusterID.Load() != nil {
+		return nil
+	}
+	clusterID, err := c.clusterIDProvider.ClusterID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the cluster ID: %w", err)
+	}
+	c.clusterID.CompareAndSwap(nil, &clusterID)
+	return nil
+}
+
+// clusterName returns the cluster ID cached by resolveClusterID.
+func (c *Controller) clusterName() string {
+	if clusterID := c.clusterID.Load(); clusterID != nil {
+		return *clusterID
+	}
+	return ""
+}
+
 func (c *Controller) init() error {
 	if c.cloud == nil {
 		return fmt.Errorf("WARNING: no cloud provider provided, services of type LoadBalancer will fail")
@@ -422,6 +825,7 @@ func (c *Controller) init() error {
 // processServiceCreateOrUpdate operates loadbalancers for the incoming service accordingly.
 // Returns an error if processing the service update failed.
 func (c *Controller) processServiceCreateOrUpdate(ctx context.Context, service *v1.Service, key string, endpointSlices []*discoveryv1.EndpointSlice) error {
+	startTime := time.Now()
 	// TODO(@MrHohn): Remove the cache once we get rid of the non-finalizer deletion
 	// path. Ref https://github.com/kubernetes/enhancements/issues/980.
 	cachedService := c.cache.getOrCreate(key)
@@ -430,193 +834,596 @@ func (c *Controller) processServiceCreateOrUpdate(ctx context.Context, service *
 		// in a short period, which is only possible when it doesn't
 		// contain finalizer.
 
-		lbID := getStringFromServiceAnnotation(cachedService.state, ServiceAnnotationLoadBalancerID, "")
+		lbID := getStringFromServiceAnnotation(cachedService.state, c.annotations.Key(ServiceAnnotationLoadBalancerID), "")
 		if err := c.processLoadBalancerDelete(ctx, cachedService.state, key, lbID); err != nil {
 			return err
 		}
 
-		oldLbID := getStringFromServiceAnnotation(cachedService.state, ServiceAnnotationLoadBalancerOldID, "")
+		oldLbID := getStringFromServiceAnnotation(cachedService.state, c.annotations.Key(ServiceAnnotationLoadBalancerOldID), "")
 		if err := c.processLoadBalancerDelete(ctx, cachedService.state, key, oldLbID); err != nil {
 			return err
 		}
 	}
+	if cachedService.state != nil && cachedService.state.UID == service.UID {
+		updated, err := c.recreateOnImmutableChange(cachedService.state, service)
+		if err != nil {
+			return err
+		}
+		service = updated
+	}
+	previous := cachedService.state
 	// Always cache the service, we need the info for service deletion in case
 	// when load balancer cleanup is not handled via finalizer.
 	cachedService.state = service
-	op, err := c.syncLoadBalancerIfNeeded(ctx, service, key, endpointSlices)
+
+	// syncedService is the service recorded as synced, whose bandwidth is
+	// the one the load balancer was ensured with.
+	syncedService := service
+
+	// Options are only needed to ensure the load balancer, an invalid
+	// annotation must not block the cleanup of a deleted service.
+	options := &cloudprovider.ServiceOptions{}
+	if c.wantsLoadBalancer(service) && !needsCleanup(service) {
+		var err error
+		options, err = c.annotations.getServiceOptions(service, c.clusterName(), c.defaultServiceOptions)
+		if err != nil {
+			c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonInvalidLoadBalancerAnnotation, "Error parsing load balancer annotations: %v", err)
+			return err
+		}
+		options.Tags = getTagsFromServiceLabels(service, c.tagLabelPrefix)
+		if options.TLSConfig == nil && servicehelper.HasAnnotation(service, c.annotations.Key(ServiceAnnotationLoadBalancerCertificateID)) {
+			c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonLoadBalancerCertificateIgnored, "Ignoring the %s annotation of load balancer lb-id=%s, the service has no HTTPS port", c.annotations.Key(ServiceAnnotationLoadBalancerCertificateID), c.annotations.loadBalancerID(service))
+		}
+		if last, reduced := c.unconfirmedBandwidthReduction(service, options); reduced {
+			from := "unlimited"
+			if last != 0 {
+				from = fmt.Sprintf("%d Mbps", last)
+			}
+			c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonLoadBalancerBandwidthReduction, "Reducing the bandwidth of load balancer lb-id=%s from %s to %d Mbps may drop packets during the transition, set the %s annotation to \"true\" to proceed", c.annotations.loadBalancerID(service), from, options.BandwidthMbps, c.annotations.Key(ServiceAnnotationLoadBalancerBandwidthReduceConfirmed))
+			// Sync everything else, keeping the previous bandwidth until the
+			// reduction is confirmed.
+			options.BandwidthMbps = last
+			syncedService = c.withBandwidth(service, last)
+		}
+		if c.filterNotReadyEndpoints {
+			endpointSlices = filterReadyEndpoints(endpointSlices)
+			if endpointAddresses(endpointSlices).Len() == 0 {
+				c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonNoReadyEndpoints, "Load balancer lb-id=%s has no ready endpoint, it has no member", c.annotations.loadBalancerID(service))
+			}
+		}
+		if !options.CrossZoneEnabled {
+			options.ZoneHints = endpointZoneHints(endpointSlices)
+		}
+	}
+
+	op, cloudSynced, err := c.syncLoadBalancerIfNeeded(ctx, service, key, endpointSlices, options)
 	if err != nil {
-		c.eventRecorder.Eventf(service, v1.EventTypeWarning, "SyncLoadBalancerFailed", "Error syncing load balancer: %v", err)
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer lb-id=%s: %v", c.annotations.loadBalancerID(service), err)
 		return err
 	}
+	if op == skipLoadBalancer {
+		return nil
+	}
+	if op == ensureLoadBalancer {
+		if err := c.syncAdminState(ctx, previous, service); err != nil {
+			c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error setting the admin state of load balancer lb-id=%s: %v", c.annotations.loadBalancerID(service), err)
+			return err
+		}
+	}
+	if op == ensureLoadBalancer && cloudSynced {
+		applied, err := c.recordAppliedBandwidth(syncedService, options.BandwidthMbps)
+		if err != nil {
+			return fmt.Errorf("failed to record the bandwidth of load balancer lb-id=%s: %v", c.annotations.loadBalancerID(service), err)
+		}
+		syncedService = applied
+	}
+	backendsChanged := c.updateLastSyncedBackends(key, op, syncedService, endpointSlices)
+	if cloudSynced {
+		c.eventRecorder.Eventf(service, v1.EventTypeNormal, EventReasonLoadBalancerSynced, "Synced load balancer lb-id=%s: operation=%s backendsChanged=%d duration=%s", c.annotations.loadBalancerID(service), op, backendsChanged, time.Since(startTime).Round(time.Millisecond))
+	}
 	if op == deleteLoadBalancer {
 		// Only delete the cache upon successful load balancer deletion.
 		c.cache.delete(key)
+		c.forgetLastSyncedNodes(key)
+		c.forgetStatusMigration(key)
 	}
 
 	return nil
 }
 
-type loadBalancerOperation int
+// syncAdminState sets the admin state of the load balancer of the service when
+// the lb-admin-state-up annotation is set, or was set on the previous service
+// so that removing it brings the load balancer back up. The status of the
+// service is left untouched.
+func (c *Controller) syncAdminState(ctx context.Context, previous, service *v1.Service) error {
+	key := c.annotations.Key(ServiceAnnotationLoadBalancerAdminStateUp)
+	wasSet := previous != nil && previous.UID == service.UID && servicehelper.HasAnnotation(previous, key)
+	if !servicehelper.HasAnnotation(service, key) && !wasSet {
+		return nil
+	}
+	up, err := c.annotations.getAdminStateUpFromServiceAnnotation(service)
+	if err != nil {
+		return err
+	}
+	setter, ok := c.balancer.(cloudprovider.LoadBalancerAdminStateSetter)
+	if !ok {
+		if !up {
+			c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonAdminStateUnsupported, "Ignoring the %s annotation of load balancer lb-id=%s, the cloud provider does not support disabling load balancers", key, c.annotations.loadBalancerID(service))
+		}
+		return nil
+	}
+	lbID := c.annotations.loadBalancerID(service)
+	return c.callCloud(ctx, "set-admin-state", func(ctx context.Context) error {
+		return setter.SetLoadBalancerAdminState(ctx, c.clusterName(), lbID, up)
+	})
+}
 
-const (
-	deleteLoadBalancer loadBalancerOperation = iota
-	ensureLoadBalancer
-	maxNodeNamesToLog = 20
-)
+// recreateOnImmutableChange marks the load balancer of the cached service for
+// deletion when the subnet or the charge type of the service changed, or when
+// an elastic IP is requested, which are set at creation, by moving its ID to
+// the load-balancer-old-id annotation. The load balancer is then ensured anew. It returns the service
+// to sync.
+func (c *Controller) recreateOnImmutableChange(cached, service *v1.Service) (*v1.Service, error) {
+	if !c.wantsLoadBalancer(service) || needsCleanup(service) {
+		return service, nil
+	}
+	subnetChanged := c.subnetID(cached) != c.subnetID(service)
+	oldChargeType, newChargeType := c.annotations.chargeTypeOf(cached), c.annotations.chargeTypeOf(service)
+	chargeTypeChanged := len(oldChargeType) != 0 && len(newChargeType) != 0 && oldChargeType != newChargeType
+	eipRequested := !c.annotations.allocatesEIP(cached) && c.annotations.allocatesEIP(service)
+	if !subnetChanged && !chargeTypeChanged && !eipRequested {
+		return service, nil
+	}
+	lbID := getStringFromServiceAnnotation(cached, c.annotations.Key(ServiceAnnotationLoadBalancerID), "")
+	if len(lbID) == 0 || len(getStringFromServiceAnnotation(service, c.annotations.Key(ServiceAnnotationLoadBalancerOldID), "")) != 0 {
+		return service, nil
+	}
 
-// syncLoadBalancerIfNeeded ensures that service's status is synced up with loadbalancer
-// i.e. creates loadbalancer for service if requested and deletes loadbalancer if the service
-// doesn't want a loadbalancer no more. Returns whatever error occurred.
-func (c *Controller) syncLoadBalancerIfNeeded(ctx context.Context, service *v1.Service, key string, endpointSlices []*discoveryv1.EndpointSlice) (loadBalancerOperation, error) {
-	// Note: It is safe to just call EnsureLoadBalancer.  But, on some clouds that requires a delete & create,
-	// which may involve service interruption.  Also, we would like user-friendly events.
+	updated := service.DeepCopy()
+	updated.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerOldID)] = lbID
+	if subnetChanged {
+		klog.V(2).Infof("Subnet of service %s/%s changed from %q to %q, recreating load balancer %s", service.Namespace, service.Name, c.subnetID(cached), c.subnetID(service), lbID)
+	}
+	if chargeTypeChanged {
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonLoadBalancerChargeTypeChanged, "Charge type of load balancer lb-id=%s can't be changed from %s to %s without recreating it, recreating the load balancer", lbID, oldChargeType, newChargeType)
+	}
+	if eipRequested {
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonLoadBalancerEIPRequested, "Elastic IP can't be allocated to load balancer lb-id=%s after its creation, recreating the load balancer", lbID)
+	}
+	patched, err := servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark load balancer %s for recreation: %v", lbID, err)
+	}
+	return patched, nil
+}
 
-	// Save the state so we can avoid a write if it doesn't change
-	previousStatus := service.Status.LoadBalancer.DeepCopy()
-	var newStatus *v1.LoadBalancerStatus
-	var err error
-	var op loadBalancerOperation
-
-	// service 删除操作
-	if !wantsLoadBalancer(service) || needsCleanup(service) {
-		// Delete the load balancer if service no longer wants one, or if service needs cleanup.
-		op = deleteLoadBalancer
-		newStatus = &v1.LoadBalancerStatus{}
-
-		// TODO 处理旧的Loadbalancer 使用ensureLoadBalancerDeleted
-		oldLbID := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerOldID, "")
-		if len(oldLbID) != 0 {
-			err := c.processLoadBalancerDelete(ctx, service, "", oldLbID)
-			if err != nil {
-				return op, fmt.Errorf("failed to ensure load balancer'processLoadBalancerDelete err: %w", err)
-			}
-		}
+// subnetID returns the subnet of the load balancer of the service, the
+// controller default if the lb-subnet-id annotation is not set.
+func (c *Controller) subnetID(service *v1.Service) string {
+	if id, ok := service.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerSubnetID)]; ok {
+		return id
+	}
+	return c.defaultServiceOptions.SubnetID
+}
 
-		// TODO 处理新的Loadbalancer 使用ensureLoadBalancerDeleted
-		lbID := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerID, "")
-		if len(lbID) != 0 {
-			err := c.processLoadBalancerDelete(ctx, service, "", lbID)
-			if err != nil {
-				return op, fmt.Errorf("failed to ensure load balancer'processLoadBalancerDelete err: %w", err)
-			}
-		}
+// chargeTypeOf describes the charge type and prepaid period of the load
+// balancer of the service, e.g. "prepaid for 3 months". It returns an empty
+// string if the annotations are invalid, which is reported by the sync.
+func (a AnnotationConfig) chargeTypeOf(service *v1.Service) string {
+	chargeType, period, err := a.getChargeTypeFromServiceAnnotations(service)
+	if err != nil {
+		return ""
+	}
+	if chargeType == cloudprovider.LoadBalancerChargeTypePrepaid {
+		return fmt.Sprintf("%s for %d months", chargeType, period)
+	}
+	return string(chargeType)
+}
 
-		// Always remove finalizer when load balancer is deleted, this ensures Services
-		// can be deleted after all corresponding load balancer resources are deleted.
-		if err := c.removeFinalizer(service); err != nil {
-			return op, fmt.Errorf("failed to remove load balancer cleanup finalizer: %v", err)
-		}
-		// remove new loadbalace annotation
-		if err := c.removeAnnotationLbId(service, ServiceAnnotationLoadBalancerID); err != nil {
-			return op, err
-		}
+// tlsCertificatesOf describes the certificate annotations of the service.
+func (a AnnotationConfig) tlsCertificatesOf(service *v1.Service) string {
+	return fmt.Sprintf("certificate=%q chain=%q", service.Annotations[a.Key(ServiceAnnotationLoadBalancerCertificateID)], service.Annotations[a.Key(ServiceAnnotationLoadBalancerCertificateChainID)])
+}
 
-		c.eventRecorder.Event(service, v1.EventTypeNormal, "DeletedLoadBalancer", "Deleted load balancer")
+// allocatesEIP reports whether the service requests an elastic IP. Invalid
+// annotations are reported by the sync.
+func (a AnnotationConfig) allocatesEIP(service *v1.Service) bool {
+	allocate, _, err := a.getEIPFromServiceAnnotations(service)
+	return err == nil && allocate
+}
+
+// unconfirmedBandwidthReduction reports whether the service lowers the
+// bandwidth its load balancer was last ensured with, unlimited included,
+// without the lb-bandwidth-reduce-confirmed annotation. It returns the
+// previous bandwidth, 0 for unlimited.
+func (c *Controller) unconfirmedBandwidthReduction(service *v1.Service, options *cloudprovider.ServiceOptions) (int, bool) {
+	if options.BandwidthMbps == 0 || service.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerBandwidthReduceConfirmed)] == "true" {
+		return 0, false
+	}
+	applied, ok := service.Annotations[c.annotations.Key(serviceAnnotationLoadBalancerAppliedBandwidth)]
+	if !ok {
+		return 0, false
+	}
+	last, err := strconv.Atoi(applied)
+	if err != nil || last < 0 || (last != 0 && options.BandwidthMbps >= last) {
+		return 0, false
+	}
+	return last, true
+}
+
+// recordAppliedBandwidth sets the lb-applied-bandwidth annotation of the
+// service to the bandwidth its load balancer was ensured with, and returns
+// the service with the annotation.
+func (c *Controller) recordAppliedBandwidth(service *v1.Service, bandwidthMbps int) (*v1.Service, error) {
+	key, value := c.annotations.Key(serviceAnnotationLoadBalancerAppliedBandwidth), strconv.Itoa(bandwidthMbps)
+	if service.Annotations[key] == value {
+		return service, nil
+	}
+	updated := service.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = make(map[string]string)
+	}
+	updated.Annotations[key] = value
+	if _, err := servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// withBandwidth returns a copy of the service with the lb-bandwidth annotation
+// set to bandwidthMbps, removed for 0.
+func (c *Controller) withBandwidth(service *v1.Service, bandwidthMbps int) *v1.Service {
+	updated := service.DeepCopy()
+	if bandwidthMbps == 0 {
+		delete(updated.Annotations, c.annotations.Key(ServiceAnnotationLoadBalancerBandwidth))
 	} else {
-		// service创建、更新操作
-		// Create or update the load balancer if service wants one.
-		op = ensureLoadBalancer
-		klog.V(2).Infof("Ensuring load balancer for service %s", key)
+		updated.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerBandwidth)] = strconv.Itoa(bandwidthMbps)
+	}
+	return updated
+}
 
-		// Always add a finalizer prior to creating load balancers, this ensures Services
-		// can't be deleted until all corresponding load balancer resources are also deleted.
-		if err := c.addFinalizer(service); err != nil {
-			return op, fmt.Errorf("failed to add load balancer cleanup finalizer: %v", err)
-		}
+// updateLastSyncedBackends records the service and its backends after a
+// successful sync and returns how many backends were added or removed since
+// the previous one.
+func (c *Controller) updateLastSyncedBackends(key string, op loadBalancerOperation, service *v1.Service, endpointSlices []*discoveryv1.EndpointSlice) int {
+	c.lastSyncedBackendsLock.Lock()
+	defer c.lastSyncedBackendsLock.Unlock()
 
-		for _, eps := range endpointSlices {
-			if err := c.addEndpointSliceFinalizer(eps); err != nil {
-				return op, fmt.Errorf("failed to add load balancer cleanup finalizer to eps:%+v, err: %v", eps, err)
-			}
+	previous := c.lastSyncedBackends[key]
+	current := sets.New[string]()
+	if op == deleteLoadBalancer {
+		delete(c.lastSyncedBackends, key)
+		delete(c.lastSyncedServices, key)
+	} else {
+		current = endpointAddresses(endpointSlices)
+		c.lastSyncedBackends[key] = current
+		c.lastSyncedServices[key] = service
+	}
+	return previous.Difference(current).Len() + current.Difference(previous).Len()
+}
+
+// endpointAddresses returns the addresses of all endpoints in the slices.
+func endpointAddresses(endpointSlices []*discoveryv1.EndpointSlice) sets.Set[string] {
+	addresses := sets.New[string]()
+	for _, eps := range endpointSlices {
+		for _, endpoint := range eps.Endpoints {
+			addresses.Insert(endpoint.Addresses...)
 		}
+	}
+	return addresses
+}
 
-		// 处理旧的oldLoadbalancer 使用ensureLoadBalancerDeleted
-		oldLbID := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerOldID, "")
-		if len(oldLbID) != 0 {
-			err := c.processLoadBalancerDelete(ctx, service, "", oldLbID)
-			// only remove oldlbID，newstatus is remove all status. other  newstatus is add or update status
-			newStatus = &v1.LoadBalancerStatus{}
-			if err != nil {
-				return op, fmt.Errorf("failed to delete  old load balancer,loadbalancer id: %s, err: %w", oldLbID, err)
+// filterReadyEndpoints returns copies of the slices without the endpoints
+// which are not ready, for the clouds not health checking the members of the
+// load balancers. An endpoint without Ready condition is ready.
+func filterReadyEndpoints(endpointSlices []*discoveryv1.EndpointSlice) []*discoveryv1.EndpointSlice {
+	filtered := make([]*discoveryv1.EndpointSlice, 0, len(endpointSlices))
+	for _, eps := range endpointSlices {
+		eps = eps.DeepCopy()
+		endpoints := eps.Endpoints[:0]
+		for _, endpoint := range eps.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				endpoints = append(endpoints, endpoint)
 			}
 		}
+		eps.Endpoints = endpoints
+		filtered = append(filtered, eps)
+	}
+	return filtered
+}
 
-		//  处理新的new Loadbalancer
-		lbID := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerID, "")
-		if len(lbID) != 0 {
-			newStatus, err = c.ensureLoadBalancer(ctx, service, endpointSlices, lbID)
-			if err != nil {
-				if err == cloudprovider.ImplementedElsewhere {
-					// ImplementedElsewhere indicates that the ensureLoadBalancer is a nop and the
-					// functionality is implemented by a different controller.  In this case, we
-					// return immediately without doing anything.
-					klog.V(4).Infof("LoadBalancer for service %s implemented by a different controller %s, Ignoring error", key, c.cloud.ProviderName())
-					return op, nil
-				}
-				if strings.Contains(strings.ToLower(err.Error()), "conflict") {
-					// ImplementedElsewhere indicates that the ensureLoadBalancer is a nop and the
-					// functionality is implemented by a different controller.  In this case, we
-					// return immediately without doing anything.
-					c.eventRecorder.Event(service, v1.EventTypeWarning, "conflict", strings.ToLower(err.Error()))
-					return op, nil
-				}
-				// Use %w deliberately so that a returned RetryError can be handled.
-				return op, fmt.Errorf("failed to ensure load balancer: %w", err)
+// endpointZoneHints maps the addresses of the endpoints of the slices to the
+// zones they are hinted for, nil if none is hinted.
+func endpointZoneHints(endpointSlices []*discoveryv1.EndpointSlice) map[string][]string {
+	var hints map[string][]string
+	for _, eps := range endpointSlices {
+		for _, endpoint := range eps.Endpoints {
+			if endpoint.Hints == nil || len(endpoint.Hints.ForZones) == 0 {
+				continue
+			}
+			zones := make([]string, 0, len(endpoint.Hints.ForZones))
+			for _, zone := range endpoint.Hints.ForZones {
+				zones = append(zones, zone.Name)
 			}
-			if newStatus == nil {
-				return op, fmt.Errorf("service status returned by EnsureLoadBalancer is nil")
+			if hints == nil {
+				hints = make(map[string][]string)
+			}
+			for _, address := range endpoint.Addresses {
+				hints[address] = zones
 			}
 		}
 	}
-	//c.eventRecorder.Event(service, v1.EventTypeNormal, "EnsuringLoadBalancer", "Ensuring load balancer")
+	return hints
+}
 
-	// remove  finalizer, when eps have DeletionTimestamp
-	if err := c.removeEndpointSliceFinalizerByService(service); err != nil {
-		return op, err
-	}
+type loadBalancerOperation int
+
+const (
+	deleteLoadBalancer loadBalancerOperation = iota
+	ensureLoadBalancer
+	// skipLoadBalancer leaves the load balancer of a service managed by
+	// another controller alone.
+	skipLoadBalancer
+	maxNodeNamesToLog = 20
+)
 
-	// remove old loadbalace annotation
-	if err := c.removeAnnotationLbId(service,ServiceAnnotationLoadBalancerOldID); err != nil {
-		return op, err
+func (op loadBalancerOperation) String() string {
+	switch op {
+	case deleteLoadBalancer:
+		return "delete"
+	case skipLoadBalancer:
+		return "skip"
 	}
-	klog.V(4).Infof("previousStatus  %v,newStatus %v", previousStatus, newStatus)
+	return "ensure"
+}
 
-	// TODO 处理service的status,并且一处oldLBID
-	if err := c.patchStatus(service, previousStatus, newStatus); err != nil {
-		// Only retry error that isn't not found:
-		// - Not found error mostly happens when service disappears right after
-		//   we remove the finalizer.
-		// - We can't patch status on non-exist service anyway.
-		if !apierrors.IsNotFound(err) {
-			return op, fmt.Errorf("failed to update load balancer status: %v", err)
+// syncLoadBalancerIfNeeded ensures that service's status is synced up with loadbalancer
+// i.e. creates loadbalancer for service if requested and deletes loadbalancer if the service
+// doesn't want a loadbalancer no more. Returns whether a load balancer was
+// ensured or deleted in the cloud, and whatever error occurred.
+//
+// The sync runs the state machine described in the package documentation,
+// starting from lbStateIdle until lbStateDone or the first error.
+func (c *Controller) syncLoadBalancerIfNeeded(ctx context.Context, service *v1.Service, key string, endpointSlices []*discoveryv1.EndpointSlice, options *cloudprovider.ServiceOptions) (loadBalancerOperation, bool, error) {
+	// Note: It is safe to just call EnsureLoadBalancer.  But, on some clouds that requires a delete & create,
+	// which may involve service interruption.  Also, we would like user-friendly events.
+	lbs := &lbSync{
+		service:        service,
+		key:            key,
+		endpointSlices: endpointSlices,
+		options:        options,
+		// Save the state so we can avoid a write if it doesn't change
+		previousStatus: service.Status.LoadBalancer.DeepCopy(),
+	}
+	for state := lbStateIdle; state != lbStateDone; {
+		next, err := lbStateHandlers[state](c, ctx, lbs)
+		if err != nil {
+			return lbs.op, lbs.cloudSynced, err
 		}
+		if !lbStateTransitions[state].Has(next) {
+			return lbs.op, lbs.cloudSynced, fmt.Errorf("invalid load balancer state transition from %v to %v", state, next)
+		}
+		state = next
 	}
-
-	return op, nil
+	return lbs.op, lbs.cloudSynced, nil
 }
 
-func (c *Controller) ensureLoadBalancer(ctx context.Context, service *v1.Service, endpointSlices []*discoveryv1.EndpointSlice, lbID string) (*v1.LoadBalancerStatus, error) {
+func (c *Controller) ensureLoadBalancer(ctx context.Context, service *v1.Service, endpointSlices []*discoveryv1.EndpointSlice, lbID string, options *cloudprovider.ServiceOptions) (*v1.LoadBalancerStatus, error) {
 	// - Not all cloud providers support all protocols and the next step is expected to return
 	//   an error for unsupported protocols
-	status, err := c.balancer.EnsureLoadBalancer(ctx, c.clusterName, service, nil, endpointSlices, lbID)
+	if err := c.lifecycleHook.PreEnsure(ctx, service, lbID); err != nil {
+		return nil, fmt.Errorf("pre-ensure hook failed: %w", err)
+	}
+	var status *v1.LoadBalancerStatus
+	err := c.callCloud(ctx, "ensure", func(ctx context.Context) (err error) {
+		if federated, ok := c.balancer.(cloudprovider.FederatedLoadBalancer); ok && options != nil && len(options.PartnerClusterIDs) > 0 {
+			status, err = federated.EnsureLoadBalancerFederated(ctx, c.clusterName(), options.PartnerClusterIDs, service, endpointSlices, lbID, options)
+			return err
+		}
+		status, err = cloudprovider.EnsureLoadBalancerWithOptions(ctx, c.balancer, c.clusterName(), service, nil, endpointSlices, lbID, options)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	c.setLoadBalancerActive(lbID, true)
+	c.getLoadBalancerCache.Remove(c.loadBalancerCacheKey(service))
+	if err := c.lifecycleHook.PostEnsure(ctx, service, status, lbID); err != nil {
+		return nil, fmt.Errorf("post-ensure hook failed: %w", err)
+	}
 	return status, nil
 }
 
+// managesLoadBalancer reports whether the controller may ensure the load
+// balancer lbID.
+func (c *Controller) managesLoadBalancer(lbID string) bool {
+	return c.managedLBs == nil || c.managedLBs.allows(lbID)
+}
+
+// setLoadBalancerActive records whether the load balancer lbID is managed by
+// the controller and updates the lb_active_total metric.
+func (c *Controller) setLoadBalancerActive(lbID string, active bool) {
+	c.activeLBsLock.Lock()
+	defer c.activeLBsLock.Unlock()
+	if active {
+		c.activeLBs[lbID] = struct{}{}
+	} else {
+		delete(c.activeLBs, lbID)
+	}
+	activeLoadBalancers.Set(float64(len(c.activeLBs)))
+}
+
+// reconcileStatus compares the status of the load balancer of each cached
+// service, as reported by the cloud, with the status of the service. Services
+// whose load balancer drifted are queued again.
+func (c *Controller) reconcileStatus(ctx context.Context) {
+	if err := c.resolveClusterID(ctx); err != nil {
+		klog.V(4).Infof("Skipping the load balancer status reconciliation: %v", err)
+		return
+	}
+	for _, cached := range c.cache.allServices() {
+		service, err := c.serviceLister.Services(cached.Namespace).Get(cached.Name)
+		if err != nil || !c.wantsLoadBalancer(service) || needsCleanup(service) ||
+			len(getStringFromServiceAnnotation(service, c.annotations.Key(ServiceAnnotationLoadBalancerID), "")) == 0 {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(service)
+		if err != nil {
+			continue
+		}
+
+		var status *v1.LoadBalancerStatus
+		var exists bool
+		if err := c.callCloud(ctx, "get", func(ctx context.Context) (err error) {
+			status, exists, err = c.balancer.GetLoadBalancer(ctx, c.clusterName(), service)
+			return err
+		}); err != nil {
+			klog.V(4).Infof("Failed to get load balancer of service %s: %v", key, err)
+			continue
+		}
+		switch {
+		case !exists:
+			c.eventRecorder.Event(service, v1.EventTypeWarning, EventReasonLoadBalancerStatusDrift, fmt.Sprintf("Load balancer lb-id=%s reported by the service status does not exist", c.annotations.loadBalancerID(service)))
+		case status == nil || !servicehelper.LoadBalancerStatusEqual(status, &service.Status.LoadBalancer):
+			c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonLoadBalancerStatusDrift, "Load balancer lb-id=%s status %v differs from the service status %v", c.annotations.loadBalancerID(service), status, service.Status.LoadBalancer)
+		default:
+			continue
+		}
+		c.serviceQueue.Add(key)
+	}
+}
+
+// loadBalancerUpToDate reports whether ensuring the load balancer of the
+// service can be skipped: neither the service nor its backends changed since
+// the last successful sync, and the status reported by the cloud is the one
+// of the service.
+func (c *Controller) loadBalancerUpToDate(ctx context.Context, key string, service *v1.Service, endpointSlices []*discoveryv1.EndpointSlice) bool {
+	c.lastSyncedBackendsLock.Lock()
+	lastService, lastBackends := c.lastSyncedServices[key], c.lastSyncedBackends[key]
+	c.lastSyncedBackendsLock.Unlock()
+	if lastService == nil || lastService.UID != service.UID ||
+		!reflect.DeepEqual(lastService.Spec, service.Spec) ||
+		!reflect.DeepEqual(lastService.Annotations, service.Annotations) ||
+		!lastBackends.Equal(endpointAddresses(endpointSlices)) {
+		return false
+	}
+
+	var status *v1.LoadBalancerStatus
+	err := c.callCloud(ctx, "get_status", func(ctx context.Context) (err error) {
+		status, err = cloudprovider.GetLoadBalancerStatus(ctx, c.balancer, c.clusterName(), service)
+		return err
+	})
+	if err != nil {
+		klog.V(4).Infof("Failed to get load balancer status of service %s: %v", key, err)
+		return false
+	}
+	return status != nil && servicehelper.LoadBalancerStatusEqual(status, &service.Status.LoadBalancer)
+}
+
 func (c *Controller) storeLastSyncedNodes(svc *v1.Service, nodes []*v1.Node) {
 	c.lastSyncedNodesLock.Lock()
 	defer c.lastSyncedNodesLock.Unlock()
 	key, _ := cache.MetaNamespaceKeyFunc(svc)
-	c.lastSyncedNodes[key] = nodes
+	c.lastSyncedNodes[key] = hashNodes(nodes)
 }
 
-func (c *Controller) getLastSyncedNodes(svc *v1.Service) []*v1.Node {
+// forgetLastSyncedNodes removes the nodes last synced for the service key.
+func (c *Controller) forgetLastSyncedNodes(key string) {
+	c.lastSyncedNodesLock.Lock()
+	defer c.lastSyncedNodesLock.Unlock()
+	delete(c.lastSyncedNodes, key)
+}
+
+// waitForCacheSync waits for the service, node and endpointslice caches to
+// sync, at most cacheSyncTimeout. It returns an error naming the caches not
+// synced on timeout or when ctx is done.
+func (c *Controller) waitForCacheSync(ctx context.Context) error {
+	syncCtx := ctx
+	if c.cacheSyncTimeout > 0 {
+		var cancel context.CancelFunc
+		syncCtx, cancel = context.WithTimeout(ctx, c.cacheSyncTimeout)
+		defer cancel()
+	}
+	caches := []struct {
+		name   string
+		synced cache.InformerSynced
+	}{
+		{name: "service", synced: c.serviceListerSynced},
+		{name: "node", synced: c.nodeListerSynced},
+		{name: "endpointslice", synced: c.endpointSliceListerSynced},
+	}
+	var synced []cache.InformerSynced
+	for _, informer := range caches {
+		synced = append(synced, informer.synced)
+	}
+	if cache.WaitForNamedCacheSync("service", syncCtx.Done(), synced...) {
+		return nil
+	}
+	var unsynced []string
+	for _, informer := range caches {
+		if !informer.synced() {
+			unsynced = append(unsynced, informer.name)
+		}
+	}
+	return fmt.Errorf("caches %v not synced after %v", unsynced, c.cacheSyncTimeout)
+}
+
+// cleanupStaleCache removes the cached services no longer in the lister, left
+// behind when a deletion was missed. The ones with a load balancer are queued
+// instead, so that the load balancer is deleted before the cache entry.
+func (c *Controller) cleanupStaleCache() {
+	for _, key := range c.cache.ListKeys() {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			continue
+		}
+		if _, err := c.serviceLister.Services(namespace).Get(name); !apierrors.IsNotFound(err) {
+			continue
+		}
+		if cached, ok := c.cache.get(key); ok && cached.state != nil && len(c.annotations.loadBalancerID(cached.state)) != 0 {
+			klog.V(2).Infof("Service %s is gone but still cached, queueing the deletion of its load balancer", key)
+			c.serviceQueue.Add(key)
+			continue
+		}
+		klog.V(2).Infof("Removing stale cache entry of service %s", key)
+		c.cache.delete(key)
+		c.forgetLastSyncedNodes(key)
+		c.forgetStatusMigration(key)
+		staleCacheEntriesCleanedCount.Inc()
+	}
+}
+
+// sampleQueueDepths exports the current depth of the queues.
+func (c *Controller) sampleQueueDepths() {
+	serviceQueueDepth.Set(float64(c.serviceQueue.Len()))
+	nodeQueueDepth.Set(float64(c.nodeQueue.Len()))
+	endpointSliceQueueDepth.Set(float64(c.endpointsliceQueue.Len()))
+}
+
+// gcLastSyncedNodes removes the nodes last synced for the services no longer
+// in the cache, which may be left behind when a deletion was missed.
+func (c *Controller) gcLastSyncedNodes() {
+	cached := sets.New(c.cache.ListKeys()...)
+	c.lastSyncedNodesLock.Lock()
+	defer c.lastSyncedNodesLock.Unlock()
+	for key := range c.lastSyncedNodes {
+		if !cached.Has(key) {
+			delete(c.lastSyncedNodes, key)
+		}
+	}
+}
+
+// nodeHashChanged reports whether newNodes differ from the nodes last synced
+// for the service. A service never synced is treated as synced with no nodes.
+func (c *Controller) nodeHashChanged(svc *v1.Service, newNodes []*v1.Node) bool {
 	c.lastSyncedNodesLock.Lock()
 	defer c.lastSyncedNodesLock.Unlock()
 	key, _ := cache.MetaNamespaceKeyFunc(svc)
-	return c.lastSyncedNodes[key]
+	oldHash, ok := c.lastSyncedNodes[key]
+	if !ok {
+		oldHash = hashNodes(nil)
+	}
+	return oldHash != hashNodes(newNodes)
 }
 
 // ListKeys implements the interface required by DeltaFIFO to list the keys we
@@ -641,13 +1448,16 @@ func (s *serviceCache) GetByKey(key string) (interface{}, bool, error) {
 	return nil, false, nil
 }
 
-// ListKeys implements the interface required by DeltaFIFO to list the keys we
-// already know about.
+// allServices returns the cached services, skipping the entries created by
+// getOrCreate whose service was not stored yet.
 func (s *serviceCache) allServices() []*v1.Service {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	services := make([]*v1.Service, 0, len(s.serviceMap))
 	for _, v := range s.serviceMap {
+		if v.state == nil {
+			continue
+		}
 		services = append(services, v.state)
 	}
 	return services
@@ -724,17 +1534,17 @@ func epsHaveServiceName(eps *discoveryv1.EndpointSlice) bool {
 
 // needsUpdate checks if load balancer needs to be updated due to change in attributes.
 func (c *Controller) needsUpdate(oldService *v1.Service, newService *v1.Service) bool {
-	if !wantsLoadBalancer(oldService) && !wantsLoadBalancer(newService) {
+	if !c.wantsLoadBalancer(oldService) && !c.wantsLoadBalancer(newService) {
 		return false
 	}
-	if wantsLoadBalancer(oldService) != wantsLoadBalancer(newService) {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "Type", "%v -> %v",
+	if c.wantsLoadBalancer(oldService) != c.wantsLoadBalancer(newService) {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonType, "%v -> %v",
 			oldService.Spec.Type, newService.Spec.Type)
 		return true
 	}
 
-	if wantsLoadBalancer(newService) && !reflect.DeepEqual(oldService.Spec.LoadBalancerSourceRanges, newService.Spec.LoadBalancerSourceRanges) {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "LoadBalancerSourceRanges", "%v -> %v",
+	if c.wantsLoadBalancer(newService) && !reflect.DeepEqual(oldService.Spec.LoadBalancerSourceRanges, newService.Spec.LoadBalancerSourceRanges) {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerSourceRanges, "%v -> %v",
 			oldService.Spec.LoadBalancerSourceRanges, newService.Spec.LoadBalancerSourceRanges)
 		return true
 	}
@@ -747,37 +1557,92 @@ func (c *Controller) needsUpdate(oldService *v1.Service, newService *v1.Service)
 		return true
 	}
 	if !loadBalancerIPsAreEqual(oldService, newService) {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "LoadbalancerIP", "%v -> %v",
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerIP, "%v -> %v",
 			oldService.Spec.LoadBalancerIP, newService.Spec.LoadBalancerIP)
 		return true
 	}
 	if len(oldService.Spec.ExternalIPs) != len(newService.Spec.ExternalIPs) {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "ExternalIP", "Count: %v -> %v",
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonExternalIP, "Count: %v -> %v",
 			len(oldService.Spec.ExternalIPs), len(newService.Spec.ExternalIPs))
 		return true
 	}
 	for i := range oldService.Spec.ExternalIPs {
 		if oldService.Spec.ExternalIPs[i] != newService.Spec.ExternalIPs[i] {
-			c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "ExternalIP", "Added: %v",
+			c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonExternalIP, "Added: %v",
 				newService.Spec.ExternalIPs[i])
 			return true
 		}
 	}
+	if oldAlgorithm, newAlgorithm := oldService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerAlgorithm)], newService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerAlgorithm)]; oldAlgorithm != newAlgorithm {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerAlgorithm, "%v -> %v",
+			oldAlgorithm, newAlgorithm)
+		return true
+	}
+	if oldTags, newTags := getTagsFromServiceLabels(oldService, c.tagLabelPrefix), getTagsFromServiceLabels(newService, c.tagLabelPrefix); !reflect.DeepEqual(oldTags, newTags) {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerTags, "%v -> %v",
+			oldTags, newTags)
+		return true
+	}
+	if oldBandwidth, newBandwidth := oldService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerBandwidth)], newService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerBandwidth)]; oldBandwidth != newBandwidth {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerBandwidth, "%v -> %v",
+			oldBandwidth, newBandwidth)
+		return true
+	}
+	if oldGroups, newGroups := oldService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerSecurityGroupID)], newService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerSecurityGroupID)]; oldGroups != newGroups {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonSecurityGroups, "%v -> %v",
+			oldGroups, newGroups)
+		return true
+	}
+	if oldCert, newCert := c.annotations.tlsCertificatesOf(oldService), c.annotations.tlsCertificatesOf(newService); oldCert != newCert {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerCertificate, "%v -> %v",
+			oldCert, newCert)
+		return true
+	}
+	if oldPersistence, newPersistence := c.annotations.sessionPersistenceOf(oldService), c.annotations.sessionPersistenceOf(newService); oldPersistence != newPersistence {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonSessionPersistence, "%v -> %v",
+			oldPersistence, newPersistence)
+		return true
+	}
+	if oldTimeouts, newTimeouts := c.annotations.connectionTimeoutsOf(oldService), c.annotations.connectionTimeoutsOf(newService); oldTimeouts != newTimeouts {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonConnectionTimeouts, "%v -> %v",
+			oldTimeouts, newTimeouts)
+		return true
+	}
+	if oldLogging, newLogging := c.annotations.loggingOf(oldService), c.annotations.loggingOf(newService); oldLogging != newLogging {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerLogging, "%v -> %v",
+			oldLogging, newLogging)
+		return true
+	}
+	if oldAdminState, newAdminState := oldService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerAdminStateUp)], newService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerAdminStateUp)]; oldAdminState != newAdminState {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonLoadBalancerAdminState, "%v -> %v",
+			oldAdminState, newAdminState)
+		return true
+	}
+	if oldCrossZone, newCrossZone := oldService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerCrossZoneEnabled)], newService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerCrossZoneEnabled)]; oldCrossZone != newCrossZone {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonCrossZone, "%v -> %v",
+			oldCrossZone, newCrossZone)
+		return true
+	}
+	if oldPreserve, newPreserve := oldService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerPreserveClientIP)], newService.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerPreserveClientIP)]; oldPreserve != newPreserve {
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonPreserveClientIP, "%v -> %v",
+			oldPreserve, newPreserve)
+		return true
+	}
 	if !reflect.DeepEqual(oldService.Annotations, newService.Annotations) {
 		return true
 	}
 	if oldService.UID != newService.UID {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "UID", "%v -> %v",
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonUID, "%v -> %v",
 			oldService.UID, newService.UID)
 		return true
 	}
 	if oldService.Spec.ExternalTrafficPolicy != newService.Spec.ExternalTrafficPolicy {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "ExternalTrafficPolicy", "%v -> %v",
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonExternalTrafficPolicy, "%v -> %v",
 			oldService.Spec.ExternalTrafficPolicy, newService.Spec.ExternalTrafficPolicy)
 		return true
 	}
 	if oldService.Spec.HealthCheckNodePort != newService.Spec.HealthCheckNodePort {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "HealthCheckNodePort", "%v -> %v",
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonHealthCheckNodePort, "%v -> %v",
 			oldService.Spec.HealthCheckNodePort, newService.Spec.HealthCheckNodePort)
 		return true
 	}
@@ -786,7 +1651,7 @@ func (c *Controller) needsUpdate(oldService *v1.Service, newService *v1.Service)
 	// but CAN NOT change primary/secondary clusterIP || ipFamily UNLESS they are changing from/to/ON ExternalName
 	// so not care about order, only need check the length.
 	if len(oldService.Spec.IPFamilies) != len(newService.Spec.IPFamilies) {
-		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, "IPFamilies", "Count: %v -> %v",
+		c.eventRecorder.Eventf(newService, v1.EventTypeNormal, EventReasonIPFamilies, "Count: %v -> %v",
 			len(oldService.Spec.IPFamilies), len(newService.Spec.IPFamilies))
 		return true
 	}
@@ -829,11 +1694,13 @@ func portsEqualForLB(x, y *v1.Service) bool {
 	return portSlicesEqualForLB(xPorts, yPorts)
 }
 
+// portSlicesEqualForLB compares the ports independently of their order.
 func portSlicesEqualForLB(x, y []*v1.ServicePort) bool {
 	if len(x) != len(y) {
 		return false
 	}
 
+	x, y = sortPortsForLB(x), sortPortsForLB(y)
 	for i := range x {
 		if !portEqualForLB(x[i], y[i]) {
 			return false
@@ -842,6 +1709,19 @@ func portSlicesEqualForLB(x, y []*v1.ServicePort) bool {
 	return true
 }
 
+// sortPortsForLB returns a copy of the ports sorted by protocol and port, which
+// identify a port of a service.
+func sortPortsForLB(ports []*v1.ServicePort) []*v1.ServicePort {
+	sorted := append([]*v1.ServicePort(nil), ports...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Protocol != sorted[j].Protocol {
+			return sorted[i].Protocol < sorted[j].Protocol
+		}
+		return sorted[i].Port < sorted[j].Port
+	})
+	return sorted
+}
+
 func portEqualForLB(x, y *v1.ServicePort) bool {
 	// TODO: Should we check name?  (In theory, an LB could expose it)
 	if x.Name != y.Name {
@@ -871,24 +1751,30 @@ func portEqualForLB(x, y *v1.ServicePort) bool {
 	return true
 }
 
-func nodeNames(nodes []*v1.Node) sets.String {
-	ret := sets.NewString()
+func nodeNames(nodes []*v1.Node) sets.Set[string] {
+	ret := sets.New[string]()
 	for _, node := range nodes {
 		ret.Insert(node.Name)
 	}
 	return ret
 }
 
+// logNodeSummary formats the nodes as "total=N names=[a,b,...,<K more>]",
+// listing at most maxNodeNamesToLog node names.
+func logNodeSummary(nodes []*v1.Node) string {
+	return fmt.Sprintf("total=%d names=[%s]", len(nodes), strings.Join(loggableNodeNames(nodes), ","))
+}
+
 func loggableNodeNames(nodes []*v1.Node) []string {
 	if len(nodes) > maxNodeNamesToLog {
 		skipped := len(nodes) - maxNodeNamesToLog
-		names := nodeNames(nodes[:maxNodeNamesToLog]).List()
+		names := sets.List(nodeNames(nodes[:maxNodeNamesToLog]))
 		return append(names, fmt.Sprintf("<%d more>", skipped))
 	}
-	return nodeNames(nodes).List()
+	return sets.List(nodeNames(nodes))
 }
 
-func shouldSyncUpdatedNode(oldNode, newNode *v1.Node) bool {
+func (c *Controller) shouldSyncUpdatedNode(oldNode, newNode *v1.Node) bool {
 	// Evaluate the individual node exclusion predicate before evaluating the
 	// compounded result of all predicates. We don't sync changes on the
 	// readiness condition for eTP:Local services or when
@@ -904,23 +1790,29 @@ func shouldSyncUpdatedNode(oldNode, newNode *v1.Node) bool {
 		return true
 	}
 	// For the same reason as above, also check for any change to the providerID
+	// and for the start of the deletion of the node.
 	if oldNode.Spec.ProviderID != newNode.Spec.ProviderID {
 		return true
 	}
+	if oldNode.DeletionTimestamp.IsZero() != newNode.DeletionTimestamp.IsZero() {
+		return true
+	}
 	if !utilfeature.DefaultFeatureGate.Enabled(features.StableLoadBalancerNodeSet) {
-		return respectsPredicates(oldNode, allNodePredicates...) != respectsPredicates(newNode, allNodePredicates...)
+		return respectsPredicates(oldNode, c.allNodePredicates...) != respectsPredicates(newNode, c.allNodePredicates...)
 	}
-	return false
+	// The readiness of the node doesn't matter with StableLoadBalancerNodeSet,
+	// but the labels affecting the backend selection do.
+	return !nodesSufficientlyEqual([]*v1.Node{oldNode}, []*v1.Node{newNode})
 }
 
 // syncNodes handles updating the hosts pointed to by all load
 // balancers whenever the set of nodes in the cluster changes.
-func (c *Controller) syncNodes(ctx context.Context, workers int) sets.String {
+// Returns the services that couldn't be updated, keyed to their error.
+func (c *Controller) syncNodes(ctx context.Context, workers int) map[string]error {
 	startTime := time.Now()
 	defer func() {
 		latency := time.Since(startTime).Seconds()
 		klog.V(4).Infof("It took %v seconds to finish syncNodes", latency)
-		nodeSyncLatency.Observe(latency)
 	}()
 
 	klog.V(2).Infof("Syncing backends for all LB services.")
@@ -932,99 +1824,274 @@ func (c *Controller) syncNodes(ctx context.Context, workers int) sets.String {
 	return servicesToRetry
 }
 
-// nodeSyncService syncs the nodes for one load balancer type service. The return value
-// indicates if we should retry. Hence, this functions returns false if we've updated
-// load balancers and finished doing it successfully, or didn't try to at all because
-// there's no need. This function returns true if we tried to update load balancers and
-// failed, indicating to the caller that we should try again.
-func (c *Controller) nodeSyncService(svc *v1.Service) bool {
-	const retSuccess = false
-	const retNeedRetry = true
-	if svc == nil || !wantsLoadBalancer(svc) {
-		return retSuccess
+// nodeSyncService syncs the nodes for one load balancer type service. It returns
+// nil if the load balancer was updated successfully, or didn't need an update at
+// all. A non-nil error means the caller should try again.
+func (c *Controller) nodeSyncService(ctx context.Context, svc *v1.Service) error {
+	if svc == nil || !c.wantsLoadBalancer(svc) {
+		return nil
 	}
-	newNodes, err := listWithPredicates(c.nodeLister)
+	startTime := time.Now()
+	newNodes, changed, err := c.nodesToSync(svc)
 	if err != nil {
-		runtime.HandleError(fmt.Errorf("failed to retrieve node list: %v", err))
 		nodeSyncErrorCount.Inc()
-		return retNeedRetry
+		return fmt.Errorf("failed to retrieve node list: %w", err)
+	}
+	if !changed {
+		return nil
 	}
-	newNodes = filterWithPredicates(newNodes, getNodePredicatesForService(svc)...)
-	oldNodes := filterWithPredicates(c.getLastSyncedNodes(svc), getNodePredicatesForService(svc)...)
+	// Only the syncs updating the load balancer are observed, the others
+	// would drown them.
+	defer func() {
+		nodeSyncLatency.WithLabelValues(externalTrafficPolicy(svc)).Observe(time.Since(startTime).Seconds())
+	}()
+	klog.V(4).Infof("nodeSyncService started for service %s/%s", svc.Namespace, svc.Name)
+	key, err := cache.MetaNamespaceKeyFunc(svc)
+	if err != nil {
+		return err
+	}
+	c.serviceLocks.Lock(key)
+	defer c.serviceLocks.Unlock(key)
+	if err := c.lockedUpdateLoadBalancerHosts(ctx, svc, newNodes); err != nil {
+		nodeSyncErrorCount.Inc()
+		return fmt.Errorf("failed to update load balancer hosts for service %s/%s: %w", svc.Namespace, svc.Name, err)
+	}
+	klog.V(4).Infof("nodeSyncService finished successfully for service %s/%s", svc.Namespace, svc.Name)
+	return nil
+}
+
+// nodesToSync returns the nodes the load balancer of the service should point
+// to, and whether they changed since the last sync.
+func (c *Controller) nodesToSync(svc *v1.Service) ([]*v1.Node, bool, error) {
+	// Take a single snapshot of the label predicate, the dynamic label filter
+	// may change while the nodes are listed.
+	newNodes, err := listWithPredicates(c.nodeLister, c.currentNodeLabelPredicate())
+	if err != nil {
+		return nil, false, err
+	}
+	// Keep the nodes sorted by name so that hashing them doesn't need to
+	// sort a copy.
+	newNodes = sortNodesByName(filterWithPredicates(newNodes, c.getNodePredicatesForService(svc)...))
+	changed := c.nodeHashChanged(svc, newNodes)
 	// Store last synced nodes without actually determining if we successfully
 	// synced them or not. Failed node syncs are passed off to retries in the
 	// service queue, so no need to wait. If we don't store it now, we risk
 	// re-syncing all LBs twice, one from another sync in the node sync and
 	// from the service sync
 	c.storeLastSyncedNodes(svc, newNodes)
-	if nodesSufficientlyEqual(oldNodes, newNodes) {
-		return retSuccess
-	}
-	klog.V(4).Infof("nodeSyncService started for service %s/%s", svc.Namespace, svc.Name)
-	if err := c.lockedUpdateLoadBalancerHosts(svc, newNodes); err != nil {
-		runtime.HandleError(fmt.Errorf("failed to update load balancer hosts for service %s/%s: %v", svc.Namespace, svc.Name, err))
-		nodeSyncErrorCount.Inc()
-		return retNeedRetry
-	}
-	klog.V(4).Infof("nodeSyncService finished successfully for service %s/%s", svc.Namespace, svc.Name)
-	return retSuccess
+	return newNodes, changed, nil
+}
+
+// sortNodesByName sorts the nodes by name in place and returns them.
+func sortNodesByName(nodes []*v1.Node) []*v1.Node {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+func nodesByNameSorted(nodes []*v1.Node) bool {
+	return sort.SliceIsSorted(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
 }
 
 func nodesSufficientlyEqual(oldNodes, newNodes []*v1.Node) bool {
 	if len(oldNodes) != len(newNodes) {
 		return false
 	}
-
-	// This holds the Node fields which trigger a sync when changed.
-	type protoNode struct {
-		providerID string
-	}
-	distill := func(n *v1.Node) protoNode {
-		return protoNode{
-			providerID: n.Spec.ProviderID,
+	return hashNodes(oldNodes) == hashNodes(newNodes)
+}
+
+// backendSelectionLabels lists the node labels affecting the selection of the
+// load balancer backends, hashed by hashNodes.
+var backendSelectionLabels = []string{
+	v1.LabelNodeExcludeBalancers,
+	v1.LabelTopologyZone,
+}
+
+// hashNodes returns the FNV-1a hash of the node fields which trigger a sync
+// when changed, independently of the order of the nodes.
+func hashNodes(nodes []*v1.Node) uint64 {
+	if !nodesByNameSorted(nodes) {
+		nodes = sortNodesByName(append([]*v1.Node(nil), nodes...))
+	}
+	h := fnv.New64a()
+	for _, n := range nodes {
+		// Node names can't contain NUL, so it separates the fields unambiguously.
+		h.Write([]byte(n.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(n.Spec.ProviderID))
+		h.Write([]byte{0})
+		for _, label := range backendSelectionLabels {
+			// Hash the presence of the label as well, as some predicates
+			// only look for the key.
+			if value, ok := n.Labels[label]; ok {
+				h.Write([]byte(label))
+				h.Write([]byte{'='})
+				h.Write([]byte(value))
+			}
+			h.Write([]byte{0})
 		}
 	}
-
-	mOld := map[string]protoNode{}
-	for _, n := range oldNodes {
-		mOld[n.Name] = distill(n)
-	}
-
-	mNew := map[string]protoNode{}
-	for _, n := range newNodes {
-		mNew[n.Name] = distill(n)
-	}
-
-	return reflect.DeepEqual(mOld, mNew)
+	return h.Sum64()
 }
 
 // updateLoadBalancerHosts updates all existing load balancers so that
 // they will match the latest list of nodes with input number of workers.
-// Returns the list of services that couldn't be updated.
-func (c *Controller) updateLoadBalancerHosts(ctx context.Context, services []*v1.Service, workers int) (servicesToRetry sets.String) {
+// Returns the services that couldn't be updated, keyed to their error.
+func (c *Controller) updateLoadBalancerHosts(ctx context.Context, services []*v1.Service, workers int) (servicesToRetry map[string]error) {
 	klog.V(4).Infof("Running updateLoadBalancerHosts(len(services)==%d, workers==%d)", len(services), workers)
 
+	if batcher, ok := c.balancer.(cloudprovider.BatchableLoadBalancer); ok {
+		return c.syncServiceBatch(ctx, batcher, services)
+	}
+
 	// lock for servicesToRetry
-	servicesToRetry = sets.NewString()
+	servicesToRetry = make(map[string]error)
 	lock := sync.Mutex{}
 
-	doWork := func(piece int) {
-		if shouldRetry := c.nodeSyncService(services[piece]); !shouldRetry {
+	doWork := func(ctx context.Context, piece int) {
+		err := c.nodeSyncService(ctx, services[piece])
+		if err == nil {
 			return
 		}
+		runtime.HandleError(err)
 		lock.Lock()
 		defer lock.Unlock()
 		key := fmt.Sprintf("%s/%s", services[piece].Namespace, services[piece].Name)
-		servicesToRetry.Insert(key)
+		servicesToRetry[key] = err
 	}
-	workqueue.ParallelizeUntil(ctx, workers, len(services), doWork)
+	parallelizeWithContext(ctx, workers, len(services), doWork)
 	klog.V(4).Infof("Finished updateLoadBalancerHosts")
 	return servicesToRetry
 }
 
+// parallelizeWithContext runs doWork for each of the pieces on a pool of
+// workers. Unlike workqueue.ParallelizeUntil, the context is handed to doWork
+// so that a cancellation interrupts the pieces in progress, e.g. their cloud
+// provider calls, and no piece is started once it is cancelled. The pieces
+// skipped on cancellation are not reported: the controller is shutting down.
+func parallelizeWithContext(ctx context.Context, workers, pieces int, doWork func(ctx context.Context, piece int)) {
+	if pieces == 0 {
+		return
+	}
+	if workers > pieces {
+		workers = pieces
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	toProcess := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer runtime.HandleCrash()
+			defer wg.Done()
+			for piece := range toProcess {
+				if ctx.Err() != nil {
+					continue
+				}
+				doWork(ctx, piece)
+			}
+		}()
+	}
+
+feed:
+	for piece := 0; piece < pieces; piece++ {
+		select {
+		case toProcess <- piece:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(toProcess)
+	wg.Wait()
+}
+
+// syncServiceBatch updates the load balancers of all services whose
+// nodes changed in a single call to the cloud provider. Returns the services
+// that couldn't be updated, keyed to their error.
+func (c *Controller) syncServiceBatch(ctx context.Context, batcher cloudprovider.BatchableLoadBalancer, services []*v1.Service) map[string]error {
+	servicesToRetry := make(map[string]error)
+	var updates []cloudprovider.LoadBalancerUpdate
+	var locked []string
+	defer func() {
+		for _, key := range locked {
+			c.serviceLocks.Unlock(key)
+		}
+	}()
+	for _, svc := range services {
+		if svc == nil || !c.wantsLoadBalancer(svc) {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+		// Don't wait for a service being reconciled by a service worker,
+		// which syncs its nodes too, retry it afterwards instead.
+		if !c.serviceLocks.TryLock(key) {
+			servicesToRetry[key] = fmt.Errorf("service %s is being reconciled", key)
+			continue
+		}
+		locked = append(locked, key)
+		nodes, changed, err := c.nodesToSync(svc)
+		if err != nil {
+			err = fmt.Errorf("failed to retrieve node list: %w", err)
+			runtime.HandleError(err)
+			nodeSyncErrorCount.Inc()
+			servicesToRetry[key] = err
+			continue
+		}
+		if changed {
+			updates = append(updates, cloudprovider.LoadBalancerUpdate{Service: svc, Nodes: nodes})
+		}
+	}
+	if len(updates) == 0 {
+		return servicesToRetry
+	}
+
+	startTime := time.Now()
+	defer func() {
+		latency := time.Since(startTime).Seconds()
+		klog.V(4).Infof("It took %v seconds to update load balancer hosts of %d services in a batch", latency, len(updates))
+		updateLoadBalancerHostLatency.Observe(latency)
+	}()
+	klog.V(2).Infof("Updating backends for %d load balancers in a batch", len(updates))
+
+	var errs []error
+	err := c.callCloud(ctx, "update_batch", func(ctx context.Context) (err error) {
+		errs, err = batcher.UpdateLoadBalancerBatch(ctx, c.clusterName(), updates)
+		return err
+	})
+	for i, update := range updates {
+		loadBalancerSyncCount.Inc()
+		svc := update.Service
+		updateErr := err
+		if updateErr == nil {
+			if i < len(errs) {
+				updateErr = errs[i]
+			} else {
+				// Don't take an update the cloud didn't report on for done.
+				updateErr = fmt.Errorf("no result in the batch, got %d results for %d updates", len(errs), len(updates))
+			}
+		}
+		if updateErr == nil || updateErr == cloudprovider.ImplementedElsewhere {
+			if len(update.Nodes) == 0 {
+				c.eventRecorder.Event(svc, v1.EventTypeWarning, EventReasonUnAvailableLoadBalancer, "There are no available nodes for LoadBalancer")
+			} else {
+				c.eventRecorder.Eventf(svc, v1.EventTypeNormal, EventReasonUpdatedLoadBalancer, "Updated load balancer lb-id=%s with new hosts", c.annotations.loadBalancerID(svc))
+			}
+			continue
+		}
+		c.eventRecorder.Eventf(svc, v1.EventTypeWarning, EventReasonUpdateLoadBalancerFailed, "Error updating load balancer lb-id=%s with new hosts %s, error: %v", c.annotations.loadBalancerID(svc), logNodeSummary(update.Nodes), updateErr)
+		updateErr = fmt.Errorf("failed to update load balancer hosts for service %s/%s: %w", svc.Namespace, svc.Name, updateErr)
+		runtime.HandleError(updateErr)
+		nodeSyncErrorCount.Inc()
+		servicesToRetry[fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)] = updateErr
+	}
+	return servicesToRetry
+}
+
 // Updates the load balancer of a service, assuming we hold the mutex
 // associated with the service.
-func (c *Controller) lockedUpdateLoadBalancerHosts(service *v1.Service, hosts []*v1.Node) error {
+func (c *Controller) lockedUpdateLoadBalancerHosts(ctx context.Context, service *v1.Service, hosts []*v1.Node) error {
 	startTime := time.Now()
 	loadBalancerSyncCount.Inc()
 	defer func() {
@@ -1032,16 +2099,21 @@ func (c *Controller) lockedUpdateLoadBalancerHosts(service *v1.Service, hosts []
 		klog.V(4).Infof("It took %v seconds to update load balancer hosts for service %s/%s", latency, service.Namespace, service.Name)
 		updateLoadBalancerHostLatency.Observe(latency)
 	}()
-	klog.V(2).Infof("Updating backends for load balancer %s/%s with %d nodes: %v", service.Namespace, service.Name, len(hosts), loggableNodeNames(hosts))
+	klog.V(2).Infof("Updating backends for load balancer %s/%s with nodes: %s", service.Namespace, service.Name, logNodeSummary(hosts))
 
 	// This operation doesn't normally take very long (and happens pretty often), so we only record the final event
-	err := c.balancer.UpdateLoadBalancer(context.TODO(), c.clusterName, service, hosts)
+	err := c.callCloud(ctx, "update", func(ctx context.Context) error {
+		if zoneAware, ok := c.balancer.(cloudprovider.ZoneAwareLoadBalancer); ok {
+			return zoneAware.UpdateLoadBalancerZoneAware(ctx, c.clusterName(), service, groupNodesByZone(hosts))
+		}
+		return c.balancer.UpdateLoadBalancer(ctx, c.clusterName(), service, hosts)
+	})
 	if err == nil {
 		// If there are no available nodes for LoadBalancer service, make a EventTypeWarning event for it.
 		if len(hosts) == 0 {
-			c.eventRecorder.Event(service, v1.EventTypeWarning, "UnAvailableLoadBalancer", "There are no available nodes for LoadBalancer")
+			c.eventRecorder.Event(service, v1.EventTypeWarning, EventReasonUnAvailableLoadBalancer, "There are no available nodes for LoadBalancer")
 		} else {
-			c.eventRecorder.Event(service, v1.EventTypeNormal, "UpdatedLoadBalancer", "Updated load balancer with new hosts")
+			c.eventRecorder.Eventf(service, v1.EventTypeNormal, EventReasonUpdatedLoadBalancer, "Updated load balancer lb-id=%s with new hosts", c.annotations.loadBalancerID(service))
 		}
 		return nil
 	}
@@ -1052,24 +2124,89 @@ func (c *Controller) lockedUpdateLoadBalancerHosts(service *v1.Service, hosts []
 		return nil
 	}
 	// It's only an actual error if the load balancer still exists.
-	if _, exists, err := c.balancer.GetLoadBalancer(context.TODO(), c.clusterName, service); err != nil {
-		runtime.HandleError(fmt.Errorf("failed to check if load balancer exists for service %s/%s: %v", service.Namespace, service.Name, err))
+	if exists, getErr := c.loadBalancerExists(ctx, service); getErr != nil {
+		runtime.HandleError(fmt.Errorf("failed to check if load balancer exists for service %s/%s: %v", service.Namespace, service.Name, getErr))
 	} else if !exists {
 		return nil
 	}
 
-	c.eventRecorder.Eventf(service, v1.EventTypeWarning, "UpdateLoadBalancerFailed", "Error updating load balancer with new hosts %v [node names limited, total number of nodes: %d], error: %v", loggableNodeNames(hosts), len(hosts), err)
+	c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonUpdateLoadBalancerFailed, "Error updating load balancer lb-id=%s with new hosts %s, error: %v", c.annotations.loadBalancerID(service), logNodeSummary(hosts), err)
 	return err
 }
 
+// groupNodesByZone groups the nodes by their topology.kubernetes.io/zone
+// label, the nodes without the label under the empty zone.
+func groupNodesByZone(nodes []*v1.Node) map[string][]*v1.Node {
+	zoneNodes := make(map[string][]*v1.Node)
+	for _, node := range nodes {
+		zone := node.Labels[v1.LabelTopologyZone]
+		zoneNodes[zone] = append(zoneNodes[zone], node)
+	}
+	return zoneNodes
+}
+
+// loadBalancerCacheKey identifies a service in getLoadBalancerCache.
+type loadBalancerCacheKey struct {
+	clusterName string
+	service     string
+}
+
+func (c *Controller) loadBalancerCacheKey(service *v1.Service) loadBalancerCacheKey {
+	return loadBalancerCacheKey{clusterName: c.clusterName(), service: service.Namespace + "/" + service.Name}
+}
+
+// loadBalancerExists reports whether the cloud provider has a load balancer
+// for the service. Answers are cached for getLoadBalancerCacheTTL, so that a
+// burst of failed updates does not turn into a burst of reads.
+func (c *Controller) loadBalancerExists(ctx context.Context, service *v1.Service) (bool, error) {
+	key := c.loadBalancerCacheKey(service)
+	if exists, ok := c.getLoadBalancerCache.Get(key); ok {
+		return exists.(bool), nil
+	}
+	var exists bool
+	if err := c.callCloud(ctx, "get", func(ctx context.Context) (err error) {
+		_, exists, err = c.balancer.GetLoadBalancer(ctx, c.clusterName(), service)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	c.getLoadBalancerCache.Add(key, exists, getLoadBalancerCacheTTL)
+	return exists, nil
+}
+
 func epSupportIpProtocol(eps *discoveryv1.EndpointSlice) bool {
 	// if LoadBalancerClass is set, the user does not want the default cloud-provider Load Balancer
 	return eps.AddressType == discoveryv1.AddressTypeIPv4 || eps.AddressType == discoveryv1.AddressTypeIPv6
 }
 
-func wantsLoadBalancer(service *v1.Service) bool {
-	// if LoadBalancerClass is set, the user does not want the default cloud-provider Load Balancer
-	return service.Spec.Type == v1.ServiceTypeLoadBalancer && service.Spec.LoadBalancerClass == nil
+// ownedByOtherClass reports whether the service is a LoadBalancer service of
+// another LoadBalancerClass than the one of this controller, no class being
+// the class of the default controller. Its load balancer belongs to another
+// controller and must be neither ensured nor deleted. A service that stopped
+// being a LoadBalancer has no class anymore and is cleaned up.
+func (c *Controller) ownedByOtherClass(service *v1.Service) bool {
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return false
+	}
+	class := ""
+	if service.Spec.LoadBalancerClass != nil {
+		class = *service.Spec.LoadBalancerClass
+	}
+	return class != c.loadBalancerClass
+}
+
+// wantsLoadBalancer reports whether the service wants a load balancer managed
+// by this controller: services without a LoadBalancerClass, or the services of
+// the class set with WithLoadBalancerClass.
+func (c *Controller) wantsLoadBalancer(service *v1.Service) bool {
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return false
+	}
+	if len(c.loadBalancerClass) == 0 {
+		// if LoadBalancerClass is set, the user does not want the default cloud-provider Load Balancer
+		return service.Spec.LoadBalancerClass == nil
+	}
+	return service.Spec.LoadBalancerClass != nil && *service.Spec.LoadBalancerClass == c.loadBalancerClass
 }
 
 func loadBalancerIPsAreEqual(oldService, newService *v1.Service) bool {
@@ -1089,16 +2226,9 @@ func (c *Controller) syncService(ctx context.Context, key string) error {
 	if err != nil {
 		return err
 	}
-
-	cm, err := c.kubeClient.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "icks-cluster-info", metav1.GetOptions{})
-	if err != nil {
-		return  err
-	}
-	clusterId := cm.Data["clusterId"]
-	if len(clusterId) == 0 {
-		return fmt.Errorf("icks-cluster-info's configmap could not contain clusterId")
+	if err := c.resolveClusterID(ctx); err != nil {
+		return err
 	}
-	c.clusterName = clusterId
 
 	// service holds the latest service info from apiserver
 	service, err := c.serviceLister.Services(namespace).Get(name)
@@ -1108,11 +2238,16 @@ func (c *Controller) syncService(ctx context.Context, key string) error {
 		err = c.processServiceDeletion(ctx, key)
 	case err != nil:
 		runtime.HandleError(fmt.Errorf("Unable to retrieve service %v from store: %v", key, err))
+	case c.ownedByOtherClass(service):
+		klog.V(4).Infof("Service %s belongs to the LoadBalancerClass of another controller, skipping", key)
 	default:
 		epsLablelSelector := labels.Set(map[string]string{
 			discoveryv1.LabelServiceName: service.Name,
 		}).AsSelectorPreValidated()
-		epss, err := c.endpointSliceLister.EndpointSlices(service.Namespace).List(epsLablelSelector)
+		// Do not shadow err, the result of processServiceCreateOrUpdate
+		// must reach the queue so that a RetryError is honored.
+		var epss []*discoveryv1.EndpointSlice
+		epss, err = c.endpointSliceLister.EndpointSlices(service.Namespace).List(epsLablelSelector)
 		//klog.V(1).Infof("epss is %v,err:%v", epss, err)
 		if err != nil && apierrors.IsNotFound(err) {
 			runtime.HandleError(fmt.Errorf("Unable to retrieve eps by namesapce %v, labelSelector %v from store: %v", service.Namespace, epsLablelSelector, err))
@@ -1122,6 +2257,16 @@ func (c *Controller) syncService(ctx context.Context, key string) error {
 			runtime.HandleError(fmt.Errorf("Unable to retrieve eps by namesapce %v, labelSelector %v from store: %v", service.Namespace, key, err))
 			return err
 		}
+		// Report all invalid annotations at once and leave the load balancer
+		// alone until they are fixed. Updating the annotations enqueues the
+		// service again. Cleanup must not be blocked by invalid annotations.
+		if c.wantsLoadBalancer(service) && !needsCleanup(service) {
+			if errs := c.annotations.ValidateAnnotations(service); len(errs) > 0 {
+				aggregate := utilerrors.NewAggregate(errs)
+				c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonInvalidLoadBalancerAnnotation, "Invalid load balancer annotations: %v", aggregate)
+				return &nonRetryableError{err: aggregate}
+			}
+		}
 		// It is not safe to modify an object returned from an informer.
 		// As reconcilers may modify the service object we need to copy
 		// it first.
@@ -1131,42 +2276,113 @@ func (c *Controller) syncService(ctx context.Context, key string) error {
 	return err
 }
 
+// ReconcileOnce synchronously reconciles the load balancer of the service
+// namespace/name from the service and EndpointSlice listers, which must have
+// synced, the way a service worker does. It neither starts any goroutine nor
+// touches the queues, and is meant for tests and one-shot tools; it waits for
+// a worker reconciling the same service. A service missing from the lister is
+// reported as an error, its load balancer is left alone.
+func (c *Controller) ReconcileOnce(ctx context.Context, namespace, name string) error {
+	if _, err := c.serviceLister.Services(namespace).Get(name); err != nil {
+		return fmt.Errorf("failed to get service %s/%s: %w", namespace, name, err)
+	}
+	key := namespace + "/" + name
+	c.serviceLocks.Lock(key)
+	defer c.serviceLocks.Unlock(key)
+	return c.syncService(ctx, key)
+}
+
 func (c *Controller) processServiceDeletion(ctx context.Context, key string) error {
 	cachedService, ok := c.cache.get(key)
 	if !ok {
+		c.forgetLastSyncedNodes(key)
+		c.forgetStatusMigration(key)
 		// Cache does not contains the key means:
 		// - We didn't create a Load Balancer for the deleted service at all.
 		// - We already deleted the Load Balancer that was created for the service.
 		// In both cases we have nothing left to do.
 		return nil
 	}
+	if cachedService.state != nil && c.ownedByOtherClass(cachedService.state) {
+		c.cache.delete(key)
+		c.forgetLastSyncedNodes(key)
+		c.forgetStatusMigration(key)
+		return nil
+	}
 	klog.V(2).Infof("Service %v has been deleted. Attempting to cleanup load balancer resources", key)
 	/*if err := c.processLoadBalancerDelete(ctx, cachedService.state, key); err != nil {
 		return err
 	}*/
-	lbID := getStringFromServiceAnnotation(cachedService.state, ServiceAnnotationLoadBalancerID, "")
+	lbID := getStringFromServiceAnnotation(cachedService.state, c.annotations.Key(ServiceAnnotationLoadBalancerID), "")
 	if err := c.processLoadBalancerDelete(ctx, cachedService.state, key, lbID); err != nil {
 		return err
 	}
 
-	oldLbID := getStringFromServiceAnnotation(cachedService.state, ServiceAnnotationLoadBalancerOldID, "")
+	oldLbID := getStringFromServiceAnnotation(cachedService.state, c.annotations.Key(ServiceAnnotationLoadBalancerOldID), "")
 	if err := c.processLoadBalancerDelete(ctx, cachedService.state, key, oldLbID); err != nil {
 		return err
 	}
 
 	c.cache.delete(key)
+	c.forgetLastSyncedNodes(key)
+	c.forgetStatusMigration(key)
 	return nil
 }
 
 func (c *Controller) processLoadBalancerDelete(ctx context.Context, service *v1.Service, key string, lbId string) error {
+	// Leave the load balancers provisioned outside of the cluster alone, the
+	// service is cleaned up as if they were deleted.
+	if len(lbId) != 0 && !c.managesLoadBalancer(lbId) {
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonUnmanagedLoadBalancer, "Load balancer %s is not in the managed load balancer allowlist, not deleting it", lbId)
+		return nil
+	}
+	retryKey := fmt.Sprintf("%s/%s/%s", service.Namespace, service.Name, lbId)
 
-	//c.eventRecorder.Event(service, v1.EventTypeNormal, "DeletingLoadBalancer", "Deleting load balancer")
-	if err := c.balancer.EnsureLoadBalancerDeleted(ctx, c.clusterName, service, lbId); err != nil {
-		c.eventRecorder.Eventf(service, v1.EventTypeWarning, "DeleteLoadBalancerFailed", "Error deleting load balancer: %v", err)
-		return err
+	startTime := time.Now()
+	defer func() {
+		loadBalancerDeleteLatency.Observe(time.Since(startTime).Seconds())
+	}()
+	if c.lbDeletes != nil {
+		if err := c.lbDeletes.Acquire(ctx, 1); err != nil {
+			return err
+		}
+		defer c.lbDeletes.Release(1)
+	}
+
+	//c.eventRecorder.Event(service, v1.EventTypeNormal, EventReasonDeletingLoadBalancer, "Deleting load balancer")
+	// The load balancer is never deleted unless asked to: an invalid policy,
+	// or detach with a cloud not supporting it, is retried until fixed.
+	policy, err := c.annotations.getDeletePolicyFromServiceAnnotation(service)
+	if err == nil && policy == LoadBalancerDeletePolicyDetach {
+		if detacher, ok := c.balancer.(cloudprovider.LoadBalancerDetacher); ok {
+			err = c.callCloud(ctx, "detach", func(ctx context.Context) error {
+				return detacher.DetachLoadBalancer(ctx, c.clusterName(), service, lbId)
+			})
+		} else {
+			err = errors.New("the cloud provider does not support detaching load balancers")
+		}
+	} else if err == nil {
+		err = c.callCloud(ctx, "delete", func(ctx context.Context) error {
+			return c.balancer.EnsureLoadBalancerDeleted(ctx, c.clusterName(), service, lbId)
+		})
 	}
-	//c.eventRecorder.Event(service, v1.EventTypeNormal, "DeletedLoadBalancer", "Deleted load balancer")
-	return nil
+	switch {
+	case err == nil, errors.Is(err, cloudprovider.ErrLBNotFound):
+		// The load balancer is gone, either deleted now or definitively
+		// reported as not existing by the cloud.
+		c.deleteRetryLimiter.Forget(retryKey)
+		c.setLoadBalancerActive(lbId, false)
+		c.getLoadBalancerCache.Remove(c.loadBalancerCacheKey(service))
+		return nil
+	default:
+		// Any other error, including a "not found" answer of an eventually
+		// consistent cloud API that is not ErrLBNotFound, is transient. Back
+		// off exponentially before trying again.
+		delay := c.deleteRetryLimiter.When(retryKey)
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonDeleteLoadBalancerFailed, "Error deleting load balancer lb-id=%s (retrying in %s): %v", lbId, delay, err)
+		return api.NewRetryError(fmt.Sprintf("failed to delete load balancer %q: %v", lbId, err), delay)
+	}
+	//c.eventRecorder.Event(service, v1.EventTypeNormal, EventReasonDeletedLoadBalancer, "Deleted load balancer")
 }
 
 // addFinalizer patches the service to add finalizer.
@@ -1186,8 +2402,7 @@ func (c *Controller) addFinalizer(service *v1.Service) error {
 
 // addFinalizer patches the service to add finalizer.
 func (c *Controller) addEndpointSliceFinalizer(endpointslice *discoveryv1.EndpointSlice) error {
-	return nil
-	if endpointSliceHelper.HasLBFinalizer(endpointslice) {
+	if !endpointSliceFinalizerEnabled || endpointSliceHelper.HasLBFinalizer(endpointslice) {
 		return nil
 	}
 	// Make a copy so we don't mutate the shared informer cache.
@@ -1202,12 +2417,11 @@ func (c *Controller) addEndpointSliceFinalizer(endpointslice *discoveryv1.Endpoi
 
 // removeFinalizer patches the service to remove finalizer.
 func (c *Controller) removeFinalizer(service *v1.Service) error {
+	// The caller decides whether the load balancer is being cleaned up, only
+	// patch the service if the finalizer is actually there.
 	if !servicehelper.HasLBFinalizer(service) {
 		return nil
 	}
-	if !needsCleanup(service) {
-		return nil
-	}
 	// Make a copy so we don't mutate the shared informer cache.
 	updated := service.DeepCopy()
 	updated.ObjectMeta.Finalizers = removeString(updated.ObjectMeta.Finalizers, servicehelper.LoadBalancerCleanupFinalizer)
@@ -1228,7 +2442,7 @@ func (c *Controller) removeAnnotationLbId(service *v1.Service,annotation string)
 	}
 
 	// ServiceAnnotationLoadBalancerOldID
-	if !servicehelper.HasAnnoation(service, annotation) {
+	if !servicehelper.HasAnnotation(service, annotation) {
 		return nil
 	}
 
@@ -1243,7 +2457,7 @@ func (c *Controller) removeAnnotationLbId(service *v1.Service,annotation string)
 // TODO 处理remove endpointslice的finalizer
 // removeEndpointSliceFinalizer patches the endpointslice to remove finalizer.
 func (c *Controller) removeEndpointSliceFinalizer(endpointslice *discoveryv1.EndpointSlice) error {
-	if endpointSliceHelper.HasLBFinalizer(endpointslice) {
+	if !endpointSliceHelper.HasLBFinalizer(endpointslice) {
 		return nil
 	}
 
@@ -1277,6 +2491,49 @@ func (c *Controller) removeEndpointSliceFinalizerByService(service *v1.Service)
 	return nil
 }
 
+// callCloud runs the cloud provider call fn, bounded by lbAPITimeout, unless the circuit breaker is
+// open, and records its outcome. It waits for a free slot if the number of
+// concurrent cloud provider calls is bounded.
+func (c *Controller) callCloud(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	if c.lbOperations != nil {
+		if err := c.lbOperations.Acquire(ctx, 1); err != nil {
+			return err
+		}
+		defer c.lbOperations.Release(1)
+	}
+	if !c.circuitBreaker.Allow() {
+		return api.NewRetryError("circuit breaker is open, skipping the cloud provider call", minRetryDelay)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, c.lbAPITimeout)
+	defer cancel()
+	if err := fn(callCtx); err != nil {
+		c.circuitBreaker.RecordFailure()
+		if ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+			// Only the call timed out, not the controller. This is retried
+			// with an exponential backoff like any other transient error.
+			lbAPITimeoutCount.WithLabelValues(operation).Inc()
+			return fmt.Errorf("cloud provider %s call timed out after %v: %w", operation, c.lbAPITimeout, err)
+		}
+		return err
+	}
+	c.circuitBreaker.RecordSuccess()
+	return nil
+}
+
+// nonRetryableError indicates that a service reconciliation failed because of
+// an invalid service configuration and must not be retried.
+type nonRetryableError struct {
+	err error
+}
+
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetryableError) Unwrap() error {
+	return e.err
+}
+
 // removeString returns a newly created []string that contains all items from slice that
 // are not equal to s.
 func removeString(slice []string, s string) []string {
@@ -1308,12 +2565,25 @@ func (c *Controller) patchStatus(service *v1.Service, previousStatus, newStatus
 		return nil
 	}
 
+	key := service.Namespace + "/" + service.Name
 	if previousStatus != nil && newStatus != nil {
 		klog.V(4).Infof("previousStatus  %v,newStatus %v", previousStatus, newStatus)
 		if servicehelper.LoadBalancerStatusEqual(previousStatus, newStatus) {
 			return nil
 		}
+		// While a load balancer migrates between an IP and a hostname, the
+		// old and new ingress coexist; keep the old one for a while instead of
+		// dropping it from the status, the clients moving to the new one.
+		if isMigratingStatus(previousStatus, newStatus) {
+			if remaining := c.statusMigrationRemaining(key); remaining > 0 {
+				klog.V(4).Infof("Status of service %s already contains %v, skipping patch for %s", key, newStatus.Ingress, remaining)
+				c.serviceQueue.AddAfter(key, remaining)
+				return nil
+			}
+			klog.V(2).Infof("Dropping the ingress left over by the migration of service %s", key)
+		}
 	}
+	c.forgetStatusMigration(key)
 
 	// Make a copy so we don't mutate the shared informer cache.
 	updated := service.DeepCopy()
@@ -1324,17 +2594,88 @@ func (c *Controller) patchStatus(service *v1.Service, previousStatus, newStatus
 	return err
 }
 
+// statusMigrationRemaining returns how long the status of the service with the
+// given key keeps the ingress left over by its migration, starting the
+// timeout on the first call.
+func (c *Controller) statusMigrationRemaining(key string) time.Duration {
+	c.statusMigrationsLock.Lock()
+	defer c.statusMigrationsLock.Unlock()
+	since, ok := c.statusMigrations[key]
+	if !ok {
+		since = time.Now()
+		c.statusMigrations[key] = since
+	}
+	return statusMigrationTimeout - time.Since(since)
+}
+
+// forgetStatusMigration drops the start of the migration of the status of the
+// service with the given key.
+func (c *Controller) forgetStatusMigration(key string) {
+	c.statusMigrationsLock.Lock()
+	defer c.statusMigrationsLock.Unlock()
+	delete(c.statusMigrations, key)
+}
+
+// isMigratingStatus reports whether the current status holds every ingress of
+// the desired one, ports included, and its other ingress only are addresses of
+// the other kind, i.e. IPs left over while the load balancer moves to a
+// hostname or the other way around. An empty desired status is never
+// migrating, so that clearing the status is still patched.
+func isMigratingStatus(current, desired *v1.LoadBalancerStatus) bool {
+	if current == nil || desired == nil || len(desired.Ingress) == 0 {
+		return false
+	}
+	desiredIP, desiredHostname := false, false
+	for _, entry := range desired.Ingress {
+		if !ingressSliceContains(current.Ingress, entry) {
+			return false
+		}
+		if entry.Hostname != "" {
+			desiredHostname = true
+		} else {
+			desiredIP = true
+		}
+	}
+	for _, ingress := range current.Ingress {
+		if ingressSliceContains(desired.Ingress, ingress) {
+			continue
+		}
+		// A stale ingress of the same kind as the desired ones is not a
+		// migration, the cloud dropped it.
+		if ingress.Hostname != "" && desiredHostname || ingress.Hostname == "" && desiredIP {
+			return false
+		}
+	}
+	return true
+}
+
+// ingressSliceContains reports whether the entry appears in the slice, compared
+// after normalizing the hostname and including the port status.
+func ingressSliceContains(lhs []v1.LoadBalancerIngress, entry v1.LoadBalancerIngress) bool {
+	for i := range lhs {
+		if servicehelper.IngressSliceEqual([]v1.LoadBalancerIngress{lhs[i]}, []v1.LoadBalancerIngress{entry}) {
+			return true
+		}
+	}
+	return false
+}
+
 // NodeConditionPredicate is a function that indicates whether the given node's conditions meet
 // some set of criteria defined by the function.
 type NodeConditionPredicate func(node *v1.Node) bool
 
+// The predicates are evaluated in order and stop at the first failing one, so
+// the lists below go from the cheapest to the most expensive: deletion
+// timestamp and label lookups, then taint and condition scans.
 var (
 	allNodePredicates []NodeConditionPredicate = []NodeConditionPredicate{
+		nodeNotDeletedPredicate,
 		nodeIncludedPredicate,
 		nodeUnTaintedPredicate,
 		nodeReadyPredicate,
 	}
 	etpLocalNodePredicates []NodeConditionPredicate = []NodeConditionPredicate{
+		nodeNotDeletedPredicate,
 		nodeIncludedPredicate,
 		nodeUnTaintedPredicate,
 		nodeReadyPredicate,
@@ -1351,14 +2692,34 @@ var (
 	}
 )
 
-func getNodePredicatesForService(service *v1.Service) []NodeConditionPredicate {
+func (c *Controller) getNodePredicatesForService(service *v1.Service) []NodeConditionPredicate {
 	if utilfeature.DefaultFeatureGate.Enabled(features.StableLoadBalancerNodeSet) {
 		return stableNodeSetPredicates
 	}
 	if service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal {
 		return etpLocalNodePredicates
 	}
-	return allNodePredicates
+	return c.allNodePredicates
+}
+
+// enqueueNodeOnStaleReadiness queues the node again once its readiness
+// condition turns stale, as no node update is expected when that happens.
+func (c *Controller) enqueueNodeOnStaleReadiness(node *v1.Node) {
+	if c.nodeReadinessStalenessThreshold <= 0 {
+		return
+	}
+	if remaining, ok := readinessStalenessRemaining(node, c.nodeReadinessStalenessThreshold); ok && remaining > 0 {
+		c.nodeQueue.AddAfter(node.Name, remaining)
+	}
+}
+
+// externalTrafficPolicy returns the external traffic policy of the service,
+// defaulting to Cluster when it is not set.
+func externalTrafficPolicy(service *v1.Service) string {
+	if service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal {
+		return string(v1.ServiceExternalTrafficPolicyLocal)
+	}
+	return string(v1.ServiceExternalTrafficPolicyCluster)
 }
 
 // We consider the node for load balancing only when the node is not labelled for exclusion.
@@ -1387,10 +2748,59 @@ func nodeReadyPredicate(node *v1.Node) bool {
 	return false
 }
 
+// nodeStaleReadinessPredicate is the predicate for nodes which are ready, or
+// whose readiness condition has been non-True for at most maxAge. It tolerates
+// nodes flapping to NotReady or Unknown for a short time.
+func nodeStaleReadinessPredicate(maxAge time.Duration) NodeConditionPredicate {
+	return func(node *v1.Node) bool {
+		remaining, ok := readinessStalenessRemaining(node, maxAge)
+		return ok && remaining >= 0
+	}
+}
+
+// readinessStalenessRemaining returns the time left before the non-True
+// readiness condition of the node is older than maxAge. It returns false when
+// the node has no readiness condition.
+func readinessStalenessRemaining(node *v1.Node, maxAge time.Duration) (time.Duration, bool) {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			if cond.Status == v1.ConditionTrue {
+				return maxAge, true
+			}
+			return maxAge - time.Since(cond.LastTransitionTime.Time), true
+		}
+	}
+	return 0, false
+}
+
 func nodeNotDeletedPredicate(node *v1.Node) bool {
 	return node.DeletionTimestamp.IsZero()
 }
 
+// nodeLabelPredicate is the predicate for nodes matching the node label selector
+// given to the controller.
+func (c *Controller) nodeLabelPredicate(node *v1.Node) bool {
+	return c.currentNodeLabelPredicate()(node)
+}
+
+// currentNodeLabelPredicate returns the predicate of the node label selector,
+// of the current selector of the dynamic label filter and of the current
+// dynamic exclusion labels.
+func (c *Controller) currentNodeLabelPredicate() NodeConditionPredicate {
+	predicates := []NodeConditionPredicate{func(node *v1.Node) bool {
+		return c.nodeLabelSelector.Matches(labels.Set(node.Labels))
+	}}
+	if c.dynamicLabelFilter != nil {
+		predicates = append(predicates, c.dynamicLabelFilter.Predicate())
+	}
+	if c.dynamicExclusionLabels != nil {
+		predicates = append(predicates, c.dynamicExclusionLabels.Predicate())
+	}
+	return func(node *v1.Node) bool {
+		return respectsPredicates(node, predicates...)
+	}
+}
+
 // listWithPredicate gets nodes that matches all predicate functions.
 func listWithPredicates(nodeLister corelisters.NodeLister, predicates ...NodeConditionPredicate) ([]*v1.Node, error) {
 	nodes, err := nodeLister.List(labels.Everything())
@@ -1410,6 +2820,8 @@ func filterWithPredicates(nodes []*v1.Node, predicates ...NodeConditionPredicate
 	return filtered
 }
 
+// respectsPredicates reports whether the node respects all the predicates,
+// stopping at the first one it doesn't.
 func respectsPredicates(node *v1.Node, predicates ...NodeConditionPredicate) bool {
 	for _, p := range predicates {
 		if !p(node) {
@@ -1419,6 +2831,12 @@ func respectsPredicates(node *v1.Node, predicates ...NodeConditionPredicate) boo
 	return true
 }
 
+// loadBalancerID returns the ID of the load balancer of the service, or an
+// empty string if it has none.
+func (a AnnotationConfig) loadBalancerID(service *v1.Service) string {
+	return service.Annotations[a.Key(ServiceAnnotationLoadBalancerID)]
+}
+
 // getStringFromServiceAnnotation searches a given v1.Service for a specific annotationKey and either returns the annotation's value or a specified defaultSetting
 func getStringFromServiceAnnotation(service *v1.Service, annotationKey string, defaultSetting string) string {
 	klog.V(4).Infof("getStringFromServiceAnnotation(%s/%s, %v, %v)", service.Namespace, service.Name, annotationKey, defaultSetting)
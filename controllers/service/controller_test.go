@@ -0,0 +1,3118 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	"github.com/inspurDTest/cloud-provider/api"
+	endpointSliceHelper "github.com/inspurDTest/cloud-provider/endpointslice/helpers"
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	servicehelper "github.com/inspurDTest/cloud-provider/service/helpers"
+	"golang.org/x/sync/semaphore"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/client-go/informers"
+	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	featuregatetesting "k8s.io/component-base/featuregate/testing"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/controller-manager/pkg/features"
+	_ "k8s.io/controller-manager/pkg/features/register"
+)
+
+const testClusterID = "test-cluster"
+
+// spyQueue records how keys are re-added to the wrapped queue.
+type spyQueue struct {
+	workqueue.RateLimitingInterface
+
+	lock        sync.Mutex
+	addedAfter  map[interface{}]time.Duration
+	rateLimited []interface{}
+}
+
+func newSpyQueue(queue workqueue.RateLimitingInterface) *spyQueue {
+	return &spyQueue{
+		RateLimitingInterface: queue,
+		addedAfter:            make(map[interface{}]time.Duration),
+	}
+}
+
+func (q *spyQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.lock.Lock()
+	q.addedAfter[item] = duration
+	q.lock.Unlock()
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *spyQueue) AddRateLimited(item interface{}) {
+	q.lock.Lock()
+	q.rateLimited = append(q.rateLimited, item)
+	q.lock.Unlock()
+	q.RateLimitingInterface.AddRateLimited(item)
+}
+
+func newClusterInfoConfigMap() *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "icks-cluster-info"},
+		Data:       map[string]string{"clusterId": testClusterID},
+	}
+}
+
+// getService returns the service of the default namespace with the given name
+// from the client.
+func getService(t testing.TB, client *fake.Clientset, name string) *v1.Service {
+	t.Helper()
+
+	service, err := client.CoreV1().Services("default").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get service %s: %v", name, err)
+	}
+	return service
+}
+
+func newLoadBalancerService(name, lbID string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID("uid-" + name),
+			Annotations: map[string]string{ServiceAnnotationLoadBalancerID: lbID},
+		},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}},
+		},
+	}
+}
+
+// fakeCloud is a cloud serving the given load balancer, the rest of the cloud
+// provider interface is stubbed by fakecloud.Cloud.
+type fakeCloud struct {
+	*fakecloud.Cloud
+
+	balancer cloudprovider.LoadBalancer
+}
+
+func newFakeCloud(balancer cloudprovider.LoadBalancer) *fakeCloud {
+	return &fakeCloud{Cloud: &fakecloud.Cloud{}, balancer: balancer}
+}
+
+func (c *fakeCloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return c.balancer, true
+}
+
+// newFailingLoadBalancer returns a fake load balancer whose calls all fail
+// with err, or succeed if err is nil.
+func newFailingLoadBalancer(err error) *fakecloud.FakeLoadBalancer {
+	return fakecloud.NewFakeLoadBalancer().
+		WithGetResult(&v1.LoadBalancerStatus{}, true, err).
+		WithEnsureResult(&v1.LoadBalancerStatus{}, err).
+		WithUpdateResult(err).
+		WithDeleteResult(err)
+}
+
+// callsOf returns the calls of the given method.
+func callsOf(calls []fakecloud.LoadBalancerCall, method string) []fakecloud.LoadBalancerCall {
+	var matching []fakecloud.LoadBalancerCall
+	for _, call := range calls {
+		if call.Method == method {
+			matching = append(matching, call)
+		}
+	}
+	return matching
+}
+
+// newController creates a service controller backed by a fake clientset
+// holding the given objects. Services are added to the informer cache too.
+func newController(t testing.TB, balancer cloudprovider.LoadBalancer, objects ...runtime.Object) (*Controller, *fake.Clientset) {
+	t.Helper()
+
+	objects = append(objects, newClusterInfoConfigMap())
+	client := fake.NewSimpleClientset(objects...)
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	serviceInformer := informerFactory.Core().V1().Services()
+	endpointSliceInformer := informerFactory.Discovery().V1().EndpointSlices()
+	nodeInformer := informerFactory.Core().V1().Nodes()
+
+	controller, err := New(newFakeCloud(balancer), client, serviceInformer, endpointSliceInformer, nodeInformer, testClusterID, nil)
+	if err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+	controller.eventRecorder = record.NewFakeRecorder(100)
+
+	for _, obj := range objects {
+		if svc, ok := obj.(*v1.Service); ok {
+			if err := serviceInformer.Informer().GetStore().Add(svc); err != nil {
+				t.Fatalf("Failed to add service to the informer cache: %v", err)
+			}
+		}
+	}
+	return controller, client
+}
+
+func TestProcessNextServiceItemRetryError(t *testing.T) {
+	retryAfter := 15 * time.Second
+	cloud := newFailingLoadBalancer(api.NewRetryError("load balancer is provisioning", retryAfter))
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, cloud, svc)
+
+	queue := newSpyQueue(controller.serviceQueue)
+	controller.serviceQueue = queue
+	defer queue.ShutDown()
+
+	key := "default/svc"
+	queue.Add(key)
+	if !controller.processNextServiceItem(context.TODO()) {
+		t.Fatalf("processNextServiceItem() returned false, expected the queue to keep running")
+	}
+
+	if got, ok := queue.addedAfter[key]; !ok || got != retryAfter {
+		t.Errorf("Expected key %q to be re-queued with AddAfter(%v), got %v (queued: %t)", key, retryAfter, got, ok)
+	}
+	if len(queue.rateLimited) != 0 {
+		t.Errorf("Expected no AddRateLimited calls, got %v", queue.rateLimited)
+	}
+}
+
+func TestSyncServiceInvalidAnnotations(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerConnectionLimit] = "0"
+	svc.Annotations[ServiceAnnotationLoadBalancerIdleTimeout] = "forever"
+	controller, _ := newController(t, cloud, svc)
+
+	var nre *nonRetryableError
+	if err := controller.syncService(context.TODO(), "default/svc"); !errors.As(err, &nre) {
+		t.Fatalf("Expected a non retryable error, got %v", err)
+	}
+	if cloud.CallCount("ensure") != 0 {
+		t.Errorf("Expected no ensure calls, got %d", cloud.CallCount("ensure"))
+	}
+
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	select {
+	case event := <-recorder.Events:
+		for _, annotation := range []string{ServiceAnnotationLoadBalancerConnectionLimit, ServiceAnnotationLoadBalancerIdleTimeout} {
+			if !strings.Contains(event, annotation) {
+				t.Errorf("Expected the event to mention %s, got %q", annotation, event)
+			}
+		}
+	default:
+		t.Fatalf("Expected an InvalidLoadBalancerAnnotation event, got none")
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Expected a single event, got another one: %q", event)
+	default:
+	}
+}
+
+// clusterNameCloud records the cluster names the load balancers are ensured
+// with, it is safe for concurrent use.
+type clusterNameCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	lock         sync.Mutex
+	clusterNames []string
+}
+
+func (c *clusterNameCloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, lbId string) (*v1.LoadBalancerStatus, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clusterNames = append(c.clusterNames, clusterName)
+	return &v1.LoadBalancerStatus{}, nil
+}
+
+// TestSyncServiceConcurrent syncs services concurrently, run with -race to
+// check that the cluster ID is only written by New.
+func TestSyncServiceConcurrent(t *testing.T) {
+	const services = 10
+	var objects []runtime.Object
+	for i := 0; i < services; i++ {
+		objects = append(objects, newLoadBalancerService(fmt.Sprintf("svc-%d", i), fmt.Sprintf("lb-%d", i)))
+	}
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), objects...)
+	cloud := &clusterNameCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+
+	var wg sync.WaitGroup
+	for i := 0; i < services; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := controller.syncService(context.TODO(), fmt.Sprintf("default/svc-%d", i)); err != nil {
+				t.Errorf("syncService() returned unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(cloud.clusterNames) != services {
+		t.Fatalf("Expected %d EnsureLoadBalancer calls, got %d", services, len(cloud.clusterNames))
+	}
+	for _, clusterName := range cloud.clusterNames {
+		if clusterName != testClusterID {
+			t.Errorf("Expected the load balancers to be ensured for cluster %q, got %q", testClusterID, clusterName)
+		}
+	}
+}
+
+// addCountingQueue counts the items added to the wrapped priority queue.
+type addCountingQueue struct {
+	*priorityQueue
+
+	adds int
+}
+
+func (q *addCountingQueue) Add(item interface{}) {
+	q.adds++
+	q.priorityQueue.Add(item)
+}
+
+func TestEnqueueServiceIfAbsent(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	queue := &addCountingQueue{priorityQueue: newPriorityQueue("", workqueue.DefaultControllerRateLimiter(), controller.servicePriority)}
+	controller.serviceQueue = queue
+	defer queue.ShutDown()
+
+	for i := 0; i < 50; i++ {
+		controller.enqueueServiceIfAbsent("default/svc")
+	}
+	if queue.adds != 1 || queue.Len() != 1 {
+		t.Errorf("Expected the service to be added once, got %d adds and %d queued items", queue.adds, queue.Len())
+	}
+
+	item, _ := queue.Get()
+	controller.enqueueServiceIfAbsent("default/svc")
+	if queue.adds != 2 {
+		t.Errorf("Expected a service being processed to be added again, got %d adds", queue.adds)
+	}
+	queue.Done(item)
+}
+
+func TestProcessNextServiceItemLocked(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, cloud, svc)
+
+	queue := newSpyQueue(controller.serviceQueue)
+	controller.serviceQueue = queue
+	defer queue.ShutDown()
+
+	key := "default/svc"
+	controller.serviceLocks.Lock(key)
+	queue.Add(key)
+	if !controller.processNextServiceItem(context.TODO()) {
+		t.Fatalf("processNextServiceItem() returned false, expected the queue to keep running")
+	}
+	controller.serviceLocks.Unlock(key)
+
+	if got, ok := queue.addedAfter[key]; !ok || got != serviceLockRetryDelay {
+		t.Errorf("Expected key %q to be re-queued with AddAfter(%v), got %v (queued: %t)", key, serviceLockRetryDelay, got, ok)
+	}
+	if cloud.CallCount("ensure") != 0 {
+		t.Errorf("Expected no ensure calls while the service is locked, got %d", cloud.CallCount("ensure"))
+	}
+	if !controller.serviceLocks.TryLock(key) {
+		t.Errorf("Expected the service lock to be released")
+	}
+}
+
+func TestServiceLockForgottenOnDeletion(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	key := "default/svc"
+
+	// The service doesn't exist anymore, its cached state is cleaned up.
+	controller.cache.set(key, &cachedService{state: svc})
+	queue := newSpyQueue(controller.serviceQueue)
+	controller.serviceQueue = queue
+	defer queue.ShutDown()
+	queue.Add(key)
+	if !controller.processNextServiceItem(context.TODO()) {
+		t.Fatalf("processNextServiceItem() returned false, expected the queue to keep running")
+	}
+	if _, ok := controller.cache.get(key); ok {
+		t.Fatalf("Expected the cached service to be deleted")
+	}
+	if got := controller.serviceLocks.len(); got != 0 {
+		t.Errorf("Expected the lock of the deleted service to be forgotten, got %d locks", got)
+	}
+}
+
+func TestProcessNextServiceItemInvalidSourceRanges(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/33"}
+	controller, _ := newController(t, cloud, svc)
+
+	queue := newSpyQueue(controller.serviceQueue)
+	controller.serviceQueue = queue
+	defer queue.ShutDown()
+
+	queue.Add("default/svc")
+	controller.processNextServiceItem(context.TODO())
+
+	if len(queue.rateLimited) != 0 || len(queue.addedAfter) != 0 {
+		t.Errorf("Expected the key not to be re-queued, got AddRateLimited=%v AddAfter=%v", queue.rateLimited, queue.addedAfter)
+	}
+	if len(cloud.Calls()) != 0 {
+		t.Errorf("Expected no cloud calls, got %v", cloud.Calls())
+	}
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "InvalidLoadBalancerSourceRanges") {
+			t.Errorf("Expected an InvalidLoadBalancerSourceRanges event, got %q", event)
+		}
+	default:
+		t.Errorf("Expected an InvalidLoadBalancerSourceRanges event, got none")
+	}
+}
+
+func TestWantsLoadBalancer(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		serviceType       v1.ServiceType
+		serviceClass      *string
+		loadBalancerClass string
+		expected          bool
+	}{
+		{desc: "classless service", serviceType: v1.ServiceTypeLoadBalancer, expected: true},
+		{desc: "class service without class flag", serviceType: v1.ServiceTypeLoadBalancer, serviceClass: stringPtr("inspur.com/lb")},
+		{desc: "matching class", serviceType: v1.ServiceTypeLoadBalancer, serviceClass: stringPtr("inspur.com/lb"), loadBalancerClass: "inspur.com/lb", expected: true},
+		{desc: "other class", serviceType: v1.ServiceTypeLoadBalancer, serviceClass: stringPtr("example.com/lb"), loadBalancerClass: "inspur.com/lb"},
+		{desc: "classless service with class flag", serviceType: v1.ServiceTypeLoadBalancer, loadBalancerClass: "inspur.com/lb"},
+		{desc: "cluster IP service", serviceType: v1.ServiceTypeClusterIP, serviceClass: stringPtr("inspur.com/lb"), loadBalancerClass: "inspur.com/lb"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+			controller.loadBalancerClass = tc.loadBalancerClass
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Spec.Type = tc.serviceType
+			svc.Spec.LoadBalancerClass = tc.serviceClass
+			if got := controller.wantsLoadBalancer(svc); got != tc.expected {
+				t.Errorf("wantsLoadBalancer() = %t, expected %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestOtherClassServiceLeftAlone(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		serviceClass      *string
+		loadBalancerClass string
+	}{
+		{desc: "other class", serviceClass: stringPtr("example.com/b"), loadBalancerClass: "example.com/a"},
+		{desc: "classless service with class flag", loadBalancerClass: "example.com/a"},
+		{desc: "class service without class flag", serviceClass: stringPtr("example.com/b")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Spec.LoadBalancerClass = tc.serviceClass
+			svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+			balancer := fakecloud.NewFakeLoadBalancer()
+			controller, client := newController(t, balancer, svc)
+			controller.loadBalancerClass = tc.loadBalancerClass
+			client.ClearActions()
+
+			// The resync of the service must not queue it.
+			controller.updateService(svc, svc.DeepCopy())
+			if got := controller.serviceQueue.Len(); got != 0 {
+				t.Errorf("Expected the service not to be queued, got %d items", got)
+			}
+			if err := controller.syncService(context.TODO(), "default/svc"); err != nil {
+				t.Fatalf("syncService() returned unexpected error: %v", err)
+			}
+			op, _, err := controller.syncLoadBalancerIfNeeded(context.TODO(), svc.DeepCopy(), "default/svc", nil, &cloudprovider.ServiceOptions{})
+			if err != nil || op != skipLoadBalancer {
+				t.Errorf("syncLoadBalancerIfNeeded() = %v, %v, expected the load balancer to be skipped", op, err)
+			}
+			if calls := balancer.Calls(); len(calls) != 0 {
+				t.Errorf("Expected no cloud call, got %+v", calls)
+			}
+			if patches := countPatches(client); patches != 0 {
+				t.Errorf("Expected the service and its finalizer to be left alone, got %d patches", patches)
+			}
+		})
+	}
+}
+
+func TestNeedsUpdate(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		update   func(svc *v1.Service)
+		expected bool
+	}{
+		{desc: "no change", update: func(svc *v1.Service) {}, expected: false},
+		{desc: "type", update: func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeClusterIP }, expected: true},
+		{desc: "load balancer source ranges", update: func(svc *v1.Service) { svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8"} }, expected: true},
+		{desc: "ports", update: func(svc *v1.Service) { svc.Spec.Ports[0].Port = 8080 }, expected: true},
+		{desc: "session affinity", update: func(svc *v1.Service) { svc.Spec.SessionAffinity = v1.ServiceAffinityClientIP }, expected: true},
+		{
+			desc: "session affinity config",
+			update: func(svc *v1.Service) {
+				timeout := int32(60)
+				svc.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeout}}
+			},
+			expected: true,
+		},
+		{desc: "load balancer IP", update: func(svc *v1.Service) { svc.Spec.LoadBalancerIP = "10.0.0.2" }, expected: true},
+		{desc: "external IPs count", update: func(svc *v1.Service) { svc.Spec.ExternalIPs = append(svc.Spec.ExternalIPs, "10.0.1.2") }, expected: true},
+		{desc: "external IPs value", update: func(svc *v1.Service) { svc.Spec.ExternalIPs[0] = "10.0.1.2" }, expected: true},
+		{desc: "annotations", update: func(svc *v1.Service) { svc.Annotations["example.com/team"] = "lb" }, expected: true},
+		{desc: "UID", update: func(svc *v1.Service) { svc.UID = "uid-recreated" }, expected: true},
+		{desc: "external traffic policy", update: func(svc *v1.Service) { svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal }, expected: true},
+		{desc: "health check node port", update: func(svc *v1.Service) { svc.Spec.HealthCheckNodePort = 30999 }, expected: true},
+		{desc: "IP families", update: func(svc *v1.Service) { svc.Spec.IPFamilies = append(svc.Spec.IPFamilies, v1.IPv6Protocol) }, expected: true},
+		{desc: "labels", update: func(svc *v1.Service) { svc.Labels = map[string]string{"app": "web"} }, expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+			oldSvc := newLoadBalancerService("svc", "lb-1")
+			oldSvc.Spec.ExternalIPs = []string{"10.0.1.1"}
+			oldSvc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+			newSvc := oldSvc.DeepCopy()
+			tc.update(newSvc)
+
+			if got := controller.needsUpdate(oldSvc, newSvc); got != tc.expected {
+				t.Errorf("needsUpdate() = %t, expected %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNeedsUpdatePortOrder(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	oldSvc.Spec.Ports = []v1.ServicePort{
+		{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080},
+		{Name: "https", Protocol: v1.ProtocolTCP, Port: 443, NodePort: 30443},
+		{Name: "dns", Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053},
+	}
+
+	reordered := oldSvc.DeepCopy()
+	reordered.Spec.Ports = []v1.ServicePort{oldSvc.Spec.Ports[2], oldSvc.Spec.Ports[1], oldSvc.Spec.Ports[0]}
+	if controller.needsUpdate(oldSvc, reordered) {
+		t.Errorf("Expected no update when only the order of the ports changed")
+	}
+	if oldSvc.Spec.Ports[0].Port != 80 || reordered.Spec.Ports[0].Port != 53 {
+		t.Errorf("Expected the ports of the services to be left in their order")
+	}
+
+	changed := reordered.DeepCopy()
+	changed.Spec.Ports[2].NodePort = 31080
+	if !controller.needsUpdate(oldSvc, changed) {
+		t.Errorf("Expected an update when a node port changed")
+	}
+}
+
+func TestNeedsUpdateAlgorithm(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	newSvc := oldSvc.DeepCopy()
+	newSvc.Annotations[ServiceAnnotationLoadBalancerAlgorithm] = "ip-hash"
+
+	if !controller.needsUpdate(oldSvc, newSvc) {
+		t.Fatalf("Expected an update when the algorithm changed")
+	}
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, EventReasonLoadBalancerAlgorithm) || !strings.Contains(event, "ip-hash") {
+			t.Errorf("Expected a %s event mentioning the new algorithm, got %q", EventReasonLoadBalancerAlgorithm, event)
+		}
+	default:
+		t.Errorf("Expected a %s event, got none", EventReasonLoadBalancerAlgorithm)
+	}
+}
+
+func TestNeedsUpdateTags(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	oldSvc.Labels = map[string]string{"app": "web", "inspur.com/tag-team": "payments"}
+
+	newSvc := oldSvc.DeepCopy()
+	newSvc.Labels["app"] = "api"
+	if controller.needsUpdate(oldSvc, newSvc) {
+		t.Errorf("Expected no update when an unprefixed label changed")
+	}
+	newSvc.Labels["inspur.com/tag-team"] = "billing"
+	if !controller.needsUpdate(oldSvc, newSvc) {
+		t.Errorf("Expected an update when a tag label changed")
+	}
+}
+
+func TestUpdateServiceRecordsChange(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		update func(svc *v1.Service)
+		reason string
+	}{
+		{desc: "algorithm", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerAlgorithm] = "ip-hash" }, reason: EventReasonLoadBalancerAlgorithm},
+		{desc: "preserve client IP", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerPreserveClientIP] = "true" }, reason: EventReasonPreserveClientIP},
+		{desc: "tags", update: func(svc *v1.Service) { svc.Labels = map[string]string{"inspur.com/tag-team": "billing"} }, reason: EventReasonLoadBalancerTags},
+		{desc: "bandwidth", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerBandwidth] = "200" }, reason: EventReasonLoadBalancerBandwidth},
+		{desc: "security groups", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerSecurityGroupID] = "sg-1" }, reason: EventReasonSecurityGroups},
+		{desc: "certificate", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerCertificateID] = "cert-1" }, reason: EventReasonLoadBalancerCertificate},
+		{desc: "session persistence", update: func(svc *v1.Service) {
+			svc.Annotations[ServiceAnnotationLoadBalancerSessionPersistenceType] = "source-ip"
+		}, reason: EventReasonSessionPersistence},
+		{desc: "cross zone", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerCrossZoneEnabled] = "true" }, reason: EventReasonCrossZone},
+		{desc: "logging", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerLoggingEnabled] = "true" }, reason: EventReasonLoadBalancerLogging},
+		{desc: "admin state", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerAdminStateUp] = "false" }, reason: EventReasonLoadBalancerAdminState},
+		{desc: "connection timeouts", update: func(svc *v1.Service) { svc.Annotations[ServiceAnnotationLoadBalancerClientTimeout] = "60" }, reason: EventReasonConnectionTimeouts},
+		{desc: "type", update: func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeClusterIP }, reason: EventReasonType},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+			oldSvc := newLoadBalancerService("svc", "lb-1")
+			newSvc := oldSvc.DeepCopy()
+			tc.update(newSvc)
+
+			controller.updateService(oldSvc, newSvc)
+			if controller.serviceQueue.Len() != 1 {
+				t.Errorf("Expected the service to be queued, got %d items", controller.serviceQueue.Len())
+			}
+			recorder := controller.eventRecorder.(*record.FakeRecorder)
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, tc.reason) {
+					t.Errorf("Expected a %s event, got %q", tc.reason, event)
+				}
+			default:
+				t.Errorf("Expected a %s event, got none", tc.reason)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceIgnoresUnrelatedAnnotation(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	newSvc := oldSvc.DeepCopy()
+	newSvc.Annotations["example.com/team"] = "lb"
+
+	controller.updateService(oldSvc, newSvc)
+	if controller.serviceQueue.Len() != 0 {
+		t.Errorf("Expected an unrelated annotation not to queue the service, got %d items", controller.serviceQueue.Len())
+	}
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	if len(recorder.Events) != 0 {
+		t.Errorf("Expected no event, got %q", <-recorder.Events)
+	}
+}
+
+// optionsCloud is a fake cloud recording the options of the ensure calls.
+type optionsCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	options []cloudprovider.ServiceOptions
+}
+
+func (c *optionsCloud) EnsureLoadBalancerWithOptions(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, lbId string, options *cloudprovider.ServiceOptions) (*v1.LoadBalancerStatus, error) {
+	c.options = append(c.options, *options)
+	return c.FakeLoadBalancer.EnsureLoadBalancer(ctx, clusterName, service, nodes, endpointSlices, lbId)
+}
+
+func TestPreserveClientIPToggle(t *testing.T) {
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	oldSvc.Annotations[ServiceAnnotationLoadBalancerPreserveClientIP] = "false"
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), oldSvc)
+	cloud := &optionsCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), oldSvc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	newSvc := oldSvc.DeepCopy()
+	newSvc.Annotations[ServiceAnnotationLoadBalancerPreserveClientIP] = "true"
+	if !controller.needsUpdate(oldSvc, newSvc) {
+		t.Fatalf("Expected an update when lb-preserve-client-ip changed")
+	}
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), newSvc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+
+	if len(cloud.options) != 2 {
+		t.Fatalf("Expected 2 EnsureLoadBalancer calls, got %d", len(cloud.options))
+	}
+	if cloud.options[0].PreserveClientIP || !cloud.options[1].PreserveClientIP {
+		t.Errorf("Expected PreserveClientIP to go from false to true, got %t then %t", cloud.options[0].PreserveClientIP, cloud.options[1].PreserveClientIP)
+	}
+}
+
+func TestSecurityGroupsChange(t *testing.T) {
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	oldSvc.Annotations[ServiceAnnotationLoadBalancerSecurityGroupID] = "sg-1"
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), oldSvc)
+	cloud := &optionsCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), oldSvc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	newSvc := oldSvc.DeepCopy()
+	newSvc.Annotations[ServiceAnnotationLoadBalancerSecurityGroupID] = "sg-1,sg-2"
+	if !controller.needsUpdate(oldSvc, newSvc) {
+		t.Fatalf("Expected an update when lb-security-group-id changed")
+	}
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), newSvc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+
+	if len(cloud.options) != 2 {
+		t.Fatalf("Expected 2 EnsureLoadBalancer calls, got %d", len(cloud.options))
+	}
+	if expected := []string{"sg-1", "sg-2"}; !reflect.DeepEqual(cloud.options[1].SecurityGroupIDs, expected) {
+		t.Errorf("Expected security groups %v, got %v", expected, cloud.options[1].SecurityGroupIDs)
+	}
+}
+
+func TestSessionPersistenceChange(t *testing.T) {
+	oldSvc := newLoadBalancerService("svc", "lb-1")
+	oldSvc.Annotations[ServiceAnnotationLoadBalancerSessionPersistenceType] = "HTTP_COOKIE"
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), oldSvc)
+
+	for _, annotation := range []string{ServiceAnnotationLoadBalancerSessionPersistenceType, ServiceAnnotationLoadBalancerCookieName, ServiceAnnotationLoadBalancerCookieTimeout} {
+		newSvc := oldSvc.DeepCopy()
+		newSvc.Annotations[annotation] = "changed"
+		if !controller.needsUpdate(oldSvc, newSvc) {
+			t.Errorf("Expected an update when %s changed", annotation)
+		}
+	}
+}
+
+func TestCrossZoneZoneHints(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	eps := &discoveryv1.EndpointSlice{
+		ObjectMeta:  metav1.ObjectMeta{Name: "svc-abc", Namespace: "default"},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"10.0.0.1"}, Hints: &discoveryv1.EndpointHints{ForZones: []discoveryv1.ForZone{{Name: "zone-a"}}}},
+			{Addresses: []string{"10.0.0.2"}},
+		},
+	}
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	cloud := &optionsCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", []*discoveryv1.EndpointSlice{eps}); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	crossZone := svc.DeepCopy()
+	crossZone.Annotations[ServiceAnnotationLoadBalancerCrossZoneEnabled] = "true"
+	if !controller.needsUpdate(svc, crossZone) {
+		t.Errorf("Expected an update when lb-cross-zone-enabled changed")
+	}
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), crossZone, "default/svc", []*discoveryv1.EndpointSlice{eps}); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+
+	if len(cloud.options) != 2 {
+		t.Fatalf("Expected 2 EnsureLoadBalancer calls, got %d", len(cloud.options))
+	}
+	if expected := map[string][]string{"10.0.0.1": {"zone-a"}}; cloud.options[0].CrossZoneEnabled || !reflect.DeepEqual(cloud.options[0].ZoneHints, expected) {
+		t.Errorf("Expected the zone hints %v without cross-zone load balancing, got %+v", expected, cloud.options[0])
+	}
+	if !cloud.options[1].CrossZoneEnabled || cloud.options[1].ZoneHints != nil {
+		t.Errorf("Expected no zone hint with cross-zone load balancing, got %+v", cloud.options[1])
+	}
+}
+
+func TestLoggingWithoutBucket(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerLoggingEnabled] = "true"
+	controller, _ := newController(t, cloud, svc)
+
+	var nre *nonRetryableError
+	if err := controller.syncService(context.TODO(), "default/svc"); !errors.As(err, &nre) {
+		t.Fatalf("Expected a non retryable error, got %v", err)
+	}
+	if cloud.CallCount("ensure") != 0 {
+		t.Errorf("Expected no ensure calls, got %d", cloud.CallCount("ensure"))
+	}
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, v1.EventTypeWarning+" "+EventReasonInvalidLoadBalancerAnnotation) || !strings.Contains(event, ServiceAnnotationLoadBalancerLogBucket) {
+			t.Errorf("Expected a warning mentioning %s, got %q", ServiceAnnotationLoadBalancerLogBucket, event)
+		}
+	default:
+		t.Errorf("Expected an InvalidLoadBalancerAnnotation event, got none")
+	}
+
+	withBucket := svc.DeepCopy()
+	withBucket.Annotations[ServiceAnnotationLoadBalancerLogBucket] = "lb-logs"
+	if !controller.needsUpdate(svc, withBucket) {
+		t.Errorf("Expected an update when lb-log-bucket changed")
+	}
+	disabled := withBucket.DeepCopy()
+	disabled.Annotations[ServiceAnnotationLoadBalancerLoggingEnabled] = "false"
+	if !controller.needsUpdate(withBucket, disabled) {
+		t.Errorf("Expected an update when lb-logging-enabled changed")
+	}
+}
+
+func TestReconcileOnce(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	cloud := fakecloud.NewFakeLoadBalancer()
+	controller, _ := newController(t, cloud, svc)
+
+	if err := controller.ReconcileOnce(context.TODO(), "default", "svc"); err != nil {
+		t.Fatalf("ReconcileOnce() returned unexpected error: %v", err)
+	}
+	if cloud.CallCount("ensure") != 1 {
+		t.Errorf("Expected 1 ensure call, got %d", cloud.CallCount("ensure"))
+	}
+	if controller.serviceQueue.Len() != 0 || controller.nodeQueue.Len() != 0 {
+		t.Errorf("Expected the queues to be left empty, got %d services and %d nodes", controller.serviceQueue.Len(), controller.nodeQueue.Len())
+	}
+
+	err := controller.ReconcileOnce(context.TODO(), "default", "missing")
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
+
+func TestReconcileOnceInvalidAnnotations(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerBandwidth] = "fast"
+	cloud := fakecloud.NewFakeLoadBalancer()
+	controller, _ := newController(t, cloud, svc)
+
+	err := controller.ReconcileOnce(context.TODO(), "default", "svc")
+	var nre *nonRetryableError
+	if !errors.As(err, &nre) {
+		t.Errorf("Expected a non retryable error, got %v", err)
+	}
+	if cloud.CallCount("ensure") != 0 {
+		t.Errorf("Expected no ensure call, got %d", cloud.CallCount("ensure"))
+	}
+	if got := controller.serviceLocks.len(); got != 0 {
+		t.Errorf("Expected ReconcileOnce to release the service lock, got %d locks", got)
+	}
+}
+
+type adminStateCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	states []bool
+}
+
+func (c *adminStateCloud) SetLoadBalancerAdminState(ctx context.Context, clusterName, lbID string, up bool) error {
+	c.states = append(c.states, up)
+	return nil
+}
+
+func TestAdminStateUp(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	cloud := &adminStateCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer().WithEnsureResult(&v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "192.0.2.1"}}}, nil)}
+	controller.balancer = cloud
+
+	// The default state is not set.
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	if len(cloud.states) != 0 {
+		t.Errorf("Expected no admin state call without the annotation, got %v", cloud.states)
+	}
+
+	down := svc.DeepCopy()
+	down.Annotations[ServiceAnnotationLoadBalancerAdminStateUp] = "false"
+	if !controller.needsUpdate(svc, down) {
+		t.Errorf("Expected an update when lb-admin-state-up changed")
+	}
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), down, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cloud.states, []bool{false}) {
+		t.Errorf("Expected the load balancer to be disabled, got %v", cloud.states)
+	}
+	if cloud.CallCount("ensure") != 2 {
+		t.Errorf("Expected the load balancer to be ensured, got %d ensure calls", cloud.CallCount("ensure"))
+	}
+	if cloud.CallCount("delete") != 0 {
+		t.Errorf("Expected the load balancer to be kept, got %d delete calls", cloud.CallCount("delete"))
+	}
+
+	// Removing the annotation brings the load balancer back up.
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cloud.states, []bool{false, true}) {
+		t.Errorf("Expected the load balancer to be enabled again, got %v", cloud.states)
+	}
+}
+
+func TestAdminStateUnsupported(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerAdminStateUp] = "false"
+	cloud := fakecloud.NewFakeLoadBalancer()
+	controller, _ := newController(t, cloud, svc)
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	if cloud.CallCount("ensure") != 1 {
+		t.Errorf("Expected the load balancer to be ensured, got %d ensure calls", cloud.CallCount("ensure"))
+	}
+	found := false
+	for len(recorder.Events) > 0 {
+		if strings.HasPrefix(<-recorder.Events, v1.EventTypeWarning+" "+EventReasonAdminStateUnsupported) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected an %s event", EventReasonAdminStateUnsupported)
+	}
+}
+
+func TestInvalidConnectionTimeout(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerMemberTimeout] = "0s"
+	controller, _ := newController(t, cloud, svc)
+
+	var nre *nonRetryableError
+	if err := controller.syncService(context.TODO(), "default/svc"); !errors.As(err, &nre) {
+		t.Fatalf("Expected a non retryable error, got %v", err)
+	}
+	if cloud.CallCount("ensure") != 0 {
+		t.Errorf("Expected no ensure calls, got %d", cloud.CallCount("ensure"))
+	}
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, v1.EventTypeWarning+" "+EventReasonInvalidLoadBalancerAnnotation) || !strings.Contains(event, ServiceAnnotationLoadBalancerMemberTimeout) {
+			t.Errorf("Expected a warning mentioning %s, got %q", ServiceAnnotationLoadBalancerMemberTimeout, event)
+		}
+	default:
+		t.Errorf("Expected an InvalidLoadBalancerAnnotation event, got none")
+	}
+
+	for _, annotation := range []string{ServiceAnnotationLoadBalancerClientTimeout, ServiceAnnotationLoadBalancerMemberTimeout} {
+		updated := svc.DeepCopy()
+		updated.Annotations[annotation] = "30s"
+		if !controller.needsUpdate(svc, updated) {
+			t.Errorf("Expected an update when %s changed", annotation)
+		}
+	}
+}
+
+func TestCertificateWithoutHTTPSPort(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerCertificateID] = "cert-1"
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	cloud := &optionsCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", nil); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	warned := false
+	for len(recorder.Events) > 0 {
+		if strings.HasPrefix(<-recorder.Events, v1.EventTypeWarning+" "+EventReasonLoadBalancerCertificateIgnored) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("Expected a %s event for a service without HTTPS port", EventReasonLoadBalancerCertificateIgnored)
+	}
+	if len(cloud.options) != 1 || cloud.options[0].TLSConfig != nil {
+		t.Errorf("Expected the load balancer to be ensured without TLS config, got %+v", cloud.options)
+	}
+
+	https := svc.DeepCopy()
+	https.Spec.Ports = append(https.Spec.Ports, v1.ServicePort{Name: "https", Port: 443, Protocol: v1.ProtocolTCP})
+	newCert := https.DeepCopy()
+	newCert.Annotations[ServiceAnnotationLoadBalancerCertificateID] = "cert-2"
+	if !controller.needsUpdate(https, newCert) {
+		t.Errorf("Expected an update when lb-certificate-id changed")
+	}
+}
+
+func TestFilterReadyEndpoints(t *testing.T) {
+	ready, notReady := true, false
+	eps := &discoveryv1.EndpointSlice{
+		ObjectMeta:  metav1.ObjectMeta{Name: "svc-abc", Namespace: "default"},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+			{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+			{Addresses: []string{"10.0.0.3"}},
+		},
+	}
+
+	filtered := filterReadyEndpoints([]*discoveryv1.EndpointSlice{eps})
+	if len(filtered) != 1 {
+		t.Fatalf("filterReadyEndpoints() returned %d slices, expected 1", len(filtered))
+	}
+	if got, want := endpointAddresses(filtered), sets.New("10.0.0.1", "10.0.0.3"); !got.Equal(want) {
+		t.Errorf("filterReadyEndpoints() kept %v, expected %v", sets.List(got), sets.List(want))
+	}
+	if len(eps.Endpoints) != 3 {
+		t.Errorf("filterReadyEndpoints() modified its input, %d endpoints left", len(eps.Endpoints))
+	}
+}
+
+func TestNoReadyEndpoints(t *testing.T) {
+	notReady := false
+	svc := newLoadBalancerService("svc", "lb-1")
+	eps := &discoveryv1.EndpointSlice{
+		ObjectMeta:  metav1.ObjectMeta{Name: "svc-abc", Namespace: "default"},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+		},
+	}
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	controller.filterNotReadyEndpoints = true
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+
+	if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", []*discoveryv1.EndpointSlice{eps}); err != nil {
+		t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+	}
+	warned := false
+	for len(recorder.Events) > 0 {
+		if strings.HasPrefix(<-recorder.Events, v1.EventTypeWarning+" "+EventReasonNoReadyEndpoints) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("Expected a %s event for a service without ready endpoint", EventReasonNoReadyEndpoints)
+	}
+	if backends := controller.lastSyncedBackends["default/svc"]; backends.Len() != 0 {
+		t.Errorf("Expected no backend to be synced, got %v", sets.List(backends))
+	}
+}
+
+func TestBandwidthReductionRequiresConfirmation(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerBandwidth] = "100"
+	controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	cloud := &optionsCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+
+	// sync syncs the service updated with the changes of the user.
+	sync := func(update func(svc *v1.Service)) (warned bool) {
+		svc := getService(t, client, "svc")
+		update(svc)
+		if _, err := client.CoreV1().Services("default").Update(context.TODO(), svc, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Failed to update service: %v", err)
+		}
+		if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", nil); err != nil {
+			t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+		}
+		for len(recorder.Events) > 0 {
+			if strings.HasPrefix(<-recorder.Events, v1.EventTypeWarning+" "+EventReasonLoadBalancerBandwidthReduction) {
+				warned = true
+			}
+		}
+		return warned
+	}
+
+	if sync(func(*v1.Service) {}) {
+		t.Errorf("Expected no warning for the first bandwidth")
+	}
+	if !sync(func(svc *v1.Service) {
+		svc.Annotations[ServiceAnnotationLoadBalancerBandwidth] = "50"
+		svc.Spec.Ports[0].Port = 8080
+	}) {
+		t.Errorf("Expected a warning for an unconfirmed reduction")
+	}
+	if len(cloud.options) != 2 || cloud.options[1].BandwidthMbps != 100 {
+		t.Fatalf("Expected the service to be ensured with the previous 100 Mbps, got %+v", cloud.options)
+	}
+	if port := callsOf(cloud.Calls(), "ensure")[1].Service.Spec.Ports[0].Port; port != 8080 {
+		t.Errorf("Expected the other changes to be synced, got port %d", port)
+	}
+	// The reduction is held back until confirmed, across restarts too.
+	controller.lastSyncedServices = make(map[string]*v1.Service)
+	if !sync(func(*v1.Service) {}) {
+		t.Errorf("Expected a warning for an unconfirmed reduction")
+	}
+	if cloud.options[2].BandwidthMbps != 100 {
+		t.Fatalf("Expected the service to be ensured with the previous 100 Mbps, got %d", cloud.options[2].BandwidthMbps)
+	}
+	if sync(func(svc *v1.Service) {
+		svc.Annotations[ServiceAnnotationLoadBalancerBandwidthReduceConfirmed] = "true"
+	}) {
+		t.Errorf("Expected no warning for a confirmed reduction")
+	}
+	if len(cloud.options) != 4 || cloud.options[3].BandwidthMbps != 50 {
+		t.Errorf("Expected the confirmed reduction to be ensured with 50 Mbps, got %+v", cloud.options)
+	}
+	if sync(func(svc *v1.Service) {
+		delete(svc.Annotations, ServiceAnnotationLoadBalancerBandwidthReduceConfirmed)
+		svc.Annotations[ServiceAnnotationLoadBalancerBandwidth] = "200"
+	}) {
+		t.Errorf("Expected no warning for an increase")
+	}
+	if applied := getService(t, client, "svc").Annotations[serviceAnnotationLoadBalancerAppliedBandwidth]; applied != "200" {
+		t.Errorf("Expected the applied bandwidth to be recorded, got %q", applied)
+	}
+}
+
+func newNodes(count int) []*v1.Node {
+	nodes := make([]*v1.Node, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("node-%05d", i)
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1.NodeSpec{ProviderID: "fake://" + name},
+		})
+	}
+	return nodes
+}
+
+func reversedNodes(nodes []*v1.Node) []*v1.Node {
+	reversed := make([]*v1.Node, 0, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		reversed = append(reversed, nodes[i])
+	}
+	return reversed
+}
+
+func TestNodesSufficientlyEqual(t *testing.T) {
+	nodes := newNodes(3)
+	changed := newNodes(3)
+	changed[1].Spec.ProviderID = "fake://replaced"
+	excluded := newNodes(3)
+	excluded[1].Labels = map[string]string{v1.LabelNodeExcludeBalancers: ""}
+	zoned := newNodes(3)
+	zoned[1].Labels = map[string]string{v1.LabelTopologyZone: "zone-a"}
+	rezoned := newNodes(3)
+	rezoned[1].Labels = map[string]string{v1.LabelTopologyZone: "zone-b"}
+	unrelated := newNodes(3)
+	unrelated[1].Labels = map[string]string{"example.com/unrelated": "value"}
+
+	testCases := []struct {
+		desc     string
+		oldNodes []*v1.Node
+		newNodes []*v1.Node
+		expected bool
+	}{
+		{"sorted and equal", nodes, newNodes(3), true},
+		{"unsorted and equal", reversedNodes(nodes), newNodes(3), true},
+		{"different length", nodes, newNodes(2), false},
+		{"sorted with changed provider ID", nodes, changed, false},
+		{"unsorted with changed provider ID", reversedNodes(nodes), changed, false},
+		{"exclusion label added", nodes, excluded, false},
+		{"zone label added", nodes, zoned, false},
+		{"zone label changed", zoned, rezoned, false},
+		{"unrelated label added", nodes, unrelated, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := nodesSufficientlyEqual(tc.oldNodes, tc.newNodes); got != tc.expected {
+				t.Errorf("nodesSufficientlyEqual() = %t, expected %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestShouldSyncUpdatedNodeStableNodeSet(t *testing.T) {
+	defer featuregatetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.StableLoadBalancerNodeSet, true)()
+
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	withLabels := func(labels map[string]string) *v1.Node {
+		node := newNodes(1)[0]
+		node.Labels = labels
+		return node
+	}
+	notReady := newNodes(1)[0]
+	notReady.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}
+
+	testCases := []struct {
+		desc     string
+		oldNode  *v1.Node
+		newNode  *v1.Node
+		expected bool
+	}{
+		{"unchanged", newNodes(1)[0], newNodes(1)[0], false},
+		{"readiness changed", newNodes(1)[0], notReady, false},
+		{"zone label changed", withLabels(map[string]string{v1.LabelTopologyZone: "zone-a"}), withLabels(map[string]string{v1.LabelTopologyZone: "zone-b"}), true},
+		{"unrelated label changed", newNodes(1)[0], withLabels(map[string]string{"example.com/unrelated": "value"}), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := controller.shouldSyncUpdatedNode(tc.oldNode, tc.newNode); got != tc.expected {
+				t.Errorf("shouldSyncUpdatedNode() = %t, expected %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkNodesSufficientlyEqual(b *testing.B) {
+	for _, count := range []int{1000, 2000, 5000} {
+		oldNodes, newNodes := newNodes(count), newNodes(count)
+		b.Run(fmt.Sprintf("unsorted/%d", count), func(b *testing.B) {
+			reversed := reversedNodes(newNodes)
+			for i := 0; i < b.N; i++ {
+				nodesSufficientlyEqual(oldNodes, reversed)
+			}
+		})
+		b.Run(fmt.Sprintf("sorted/%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				nodesSufficientlyEqual(oldNodes, newNodes)
+			}
+		})
+	}
+}
+
+func BenchmarkNodesToSync(b *testing.B) {
+	controller, _ := newController(b, fakecloud.NewFakeLoadBalancer())
+	setReadyNodes(b, controller, 500)
+	services := make([]*v1.Service, 1000)
+	for i := range services {
+		services[i] = newLoadBalancerService(fmt.Sprintf("svc-%d", i), "lb-1")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		controller.nodesToSync(services[i%len(services)])
+	}
+}
+
+func BenchmarkFilterWithPredicates(b *testing.B) {
+	nodes := newNodes(1000)
+	for i, node := range nodes {
+		if i%2 == 0 {
+			node.Labels = map[string]string{v1.LabelNodeExcludeBalancers: ""}
+		}
+		node.Spec.Taints = []v1.Taint{{Key: "example.com/dedicated", Effect: v1.TaintEffectNoSchedule}}
+		node.Status.Conditions = []v1.NodeCondition{
+			{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse},
+			{Type: v1.NodeDiskPressure, Status: v1.ConditionFalse},
+			{Type: v1.NodeReady, Status: v1.ConditionTrue},
+		}
+	}
+	reversed := make([]NodeConditionPredicate, 0, len(allNodePredicates))
+	for i := len(allNodePredicates) - 1; i >= 0; i-- {
+		reversed = append(reversed, allNodePredicates[i])
+	}
+
+	for name, predicates := range map[string][]NodeConditionPredicate{
+		"cheapest-first": allNodePredicates,
+		"cheapest-last":  reversed,
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				filterWithPredicates(nodes, predicates...)
+			}
+		})
+	}
+}
+
+func TestNodeSyncLatencyExternalTrafficPolicy(t *testing.T) {
+	local := newLoadBalancerService("local", "lb-1")
+	local.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	cluster := newLoadBalancerService("cluster", "lb-2")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), local, cluster)
+	setReadyNodes(t, controller, 3)
+
+	nodeSyncLatency.Reset()
+	controller.nodeSyncService(context.TODO(), local)
+	// The nodes didn't change, the load balancer is not updated.
+	controller.nodeSyncService(context.TODO(), local)
+	controller.nodeSyncService(context.TODO(), cluster)
+
+	for policy, expected := range map[string]uint64{"Local": 1, "Cluster": 1} {
+		count, err := testutil.GetHistogramMetricCount(nodeSyncLatency.WithLabelValues(policy))
+		if err != nil {
+			t.Fatalf("Failed to read nodeSyncLatency for %s: %v", policy, err)
+		}
+		if count != expected {
+			t.Errorf("Expected %d observations for externalTrafficPolicy=%s, got %d", expected, policy, count)
+		}
+	}
+}
+
+type staticClusterIDProvider string
+
+func (p staticClusterIDProvider) ClusterID(context.Context) (string, error) {
+	return string(p), nil
+}
+
+func TestSyncServiceRetriesClusterID(t *testing.T) {
+	balancer := fakecloud.NewFakeLoadBalancer()
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, client := newController(t, balancer, svc)
+	// The cluster info ConfigMap is missing.
+	if err := client.CoreV1().ConfigMaps("kube-system").Delete(context.TODO(), "icks-cluster-info", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete the cluster info ConfigMap: %v", err)
+	}
+
+	err := controller.syncService(context.TODO(), "default/svc")
+	var nre *nonRetryableError
+	if err == nil || errors.As(err, &nre) {
+		t.Fatalf("syncService() error = %v, expected a retryable error", err)
+	}
+	if calls := balancer.Calls(); len(calls) != 0 {
+		t.Fatalf("Expected no cloud call before the cluster ID is resolved, got %+v", calls)
+	}
+
+	if _, err := client.CoreV1().ConfigMaps("kube-system").Create(context.TODO(), newClusterInfoConfigMap(), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create the cluster info ConfigMap: %v", err)
+	}
+	if err := controller.syncService(context.TODO(), "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error: %v", err)
+	}
+	if got := controller.clusterName(); got != testClusterID {
+		t.Errorf("Expected the cluster ID %q, got %q", testClusterID, got)
+	}
+
+	// The cluster ID is cached once resolved.
+	if err := client.CoreV1().ConfigMaps("kube-system").Delete(context.TODO(), "icks-cluster-info", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete the cluster info ConfigMap: %v", err)
+	}
+	if err := controller.syncService(context.TODO(), "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error: %v", err)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	filter := func(svc *v1.Service) bool { return svc.Namespace == "managed" }
+
+	controller, err := New(newFakeCloud(fakecloud.NewFakeLoadBalancer()), client,
+		informerFactory.Core().V1().Services(),
+		informerFactory.Discovery().V1().EndpointSlices(),
+		informerFactory.Core().V1().Nodes(),
+		testClusterID, nil,
+		WithClusterIDProvider(staticClusterIDProvider("static")),
+		WithServiceFilter(filter),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+
+	if err := controller.resolveClusterID(context.TODO()); err != nil {
+		t.Fatalf("resolveClusterID() returned unexpected error: %v", err)
+	}
+	if got := controller.clusterName(); got != "static" {
+		t.Errorf("Expected the cluster ID to be resolved with the overridden provider, got %q", got)
+	}
+	if _, ok := controller.circuitBreaker.(noopCircuitBreaker); !ok {
+		t.Errorf("Expected the default circuit breaker, got %T", controller.circuitBreaker)
+	}
+	if _, ok := controller.lifecycleHook.(NoopLBLifecycleHook); !ok {
+		t.Errorf("Expected the default lifecycle hook, got %T", controller.lifecycleHook)
+	}
+
+	controller.enqueueService(newLoadBalancerService("svc", "lb-1"))
+	managed := newLoadBalancerService("svc", "lb-1")
+	managed.Namespace = "managed"
+	controller.enqueueService(managed)
+	if controller.serviceQueue.Len() != 1 {
+		t.Errorf("Expected only the managed service to be enqueued, got %d items", controller.serviceQueue.Len())
+	}
+}
+
+// resyncRecordingInformer records its event handlers and their resync periods.
+type resyncRecordingInformer struct {
+	cache.SharedIndexInformer
+
+	handlers      []cache.ResourceEventHandler
+	resyncPeriods []time.Duration
+}
+
+func (i *resyncRecordingInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) (cache.ResourceEventHandlerRegistration, error) {
+	i.handlers = append(i.handlers, handler)
+	i.resyncPeriods = append(i.resyncPeriods, resyncPeriod)
+	return i.SharedIndexInformer.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+}
+
+type endpointSliceInformer struct {
+	discoveryinformers.EndpointSliceInformer
+
+	informer *resyncRecordingInformer
+}
+
+func (i endpointSliceInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func TestEndpointSliceResyncPeriod(t *testing.T) {
+	client := fake.NewSimpleClientset(newClusterInfoConfigMap())
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	epsInformer := informerFactory.Discovery().V1().EndpointSlices()
+	recording := &resyncRecordingInformer{SharedIndexInformer: epsInformer.Informer()}
+
+	if _, err := New(newFakeCloud(fakecloud.NewFakeLoadBalancer()), client,
+		informerFactory.Core().V1().Services(),
+		endpointSliceInformer{EndpointSliceInformer: epsInformer, informer: recording},
+		informerFactory.Core().V1().Nodes(),
+		testClusterID, nil,
+	); err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+	if !reflect.DeepEqual(recording.resyncPeriods, []time.Duration{endpointSliceSyncPeriod}) {
+		t.Errorf("Expected the endpoint slice handler to resync every %v, got %v", endpointSliceSyncPeriod, recording.resyncPeriods)
+	}
+}
+
+func TestEndpointSliceUpdateCleanup(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	client := fake.NewSimpleClientset(svc, newClusterInfoConfigMap())
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	epsInformer := informerFactory.Discovery().V1().EndpointSlices()
+	recording := &resyncRecordingInformer{SharedIndexInformer: epsInformer.Informer()}
+	serviceInformer := informerFactory.Core().V1().Services()
+	if err := serviceInformer.Informer().GetIndexer().Add(svc); err != nil {
+		t.Fatalf("Failed to add service: %v", err)
+	}
+
+	controller, err := New(newFakeCloud(fakecloud.NewFakeLoadBalancer()), client,
+		serviceInformer,
+		endpointSliceInformer{EndpointSliceInformer: epsInformer, informer: recording},
+		informerFactory.Core().V1().Nodes(),
+		testClusterID, nil,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+
+	now := metav1.Now()
+	oldEps := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "svc-abc",
+			Namespace:         "default",
+			Labels:            map[string]string{KubernetesServiceName: "svc"},
+			Finalizers:        []string{endpointSliceHelper.LoadBalancerCleanupFinalizer},
+			DeletionTimestamp: &now,
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	// The update removing the finalizer coalesced the one observing the
+	// deletion timestamp: only the old object needs cleanup.
+	curEps := oldEps.DeepCopy()
+	curEps.Finalizers = nil
+
+	recording.handlers[0].OnUpdate(oldEps, curEps)
+	if got := controller.serviceQueue.Len(); got != 1 {
+		t.Errorf("Expected the service to be queued when the old endpoint slice needs cleanup, got %d queued", got)
+	}
+}
+
+func TestEnqueueServiceWatchNamespace(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	controller.watchNamespace = "watched"
+
+	watched := newLoadBalancerService("svc", "lb-1")
+	watched.Namespace = "watched"
+	controller.enqueueService(watched)
+	controller.enqueueService(newLoadBalancerService("svc", "lb-2"))
+
+	if got := controller.serviceQueue.Len(); got != 1 {
+		t.Fatalf("Expected only the service of the watched namespace to be queued, got %d", got)
+	}
+	if key, _ := controller.serviceQueue.Get(); key != "watched/svc" {
+		t.Errorf("Expected watched/svc to be queued, got %v", key)
+	}
+}
+
+func TestLoadBalancerSyncedEvent(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+
+	if err := controller.syncService(context.TODO(), "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error: %v", err)
+	}
+
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.Contains(event, "LoadBalancerSynced") {
+				if !strings.Contains(event, "operation=ensure") {
+					t.Errorf("Expected the summary to report the ensure operation, got %q", event)
+				}
+				return
+			}
+		default:
+			t.Fatalf("Expected a LoadBalancerSynced event, got none")
+		}
+	}
+}
+
+func TestLoadBalancerSyncedEventWithoutCloudCall(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Spec.Type = v1.ServiceTypeClusterIP
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerID)
+	cloud := fakecloud.NewFakeLoadBalancer()
+	controller, _ := newController(t, cloud, svc)
+
+	// The service never had a load balancer, there is nothing to delete.
+	if err := controller.syncService(context.TODO(), "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error: %v", err)
+	}
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.Contains(event, EventReasonLoadBalancerSynced) {
+			t.Errorf("Expected no %s event without a cloud call, got %q", EventReasonLoadBalancerSynced, event)
+		}
+	}
+	if len(cloud.Calls()) != 0 {
+		t.Errorf("Expected no cloud call, got %v", cloud.Calls())
+	}
+}
+
+func TestProcessLoadBalancerDeleteErrors(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		err           error
+		expectRetryIn time.Duration
+		expectErr     bool
+	}{
+		{desc: "deleted", err: nil},
+		{desc: "wrapped ErrLBNotFound", err: fmt.Errorf("lb-1: %w", cloudprovider.ErrLBNotFound)},
+		{desc: "untyped not found", err: errors.New("load balancer lb-1 not found"), expectErr: true, expectRetryIn: minDeleteRetryDelay},
+		{desc: "other error", err: errors.New("quota exceeded"), expectErr: true, expectRetryIn: minDeleteRetryDelay},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			controller, _ := newController(t, newFailingLoadBalancer(tc.err), svc)
+
+			err := controller.processLoadBalancerDelete(context.TODO(), svc, "default/svc", "lb-1")
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("processLoadBalancerDelete() error = %v, expected error: %t", err, tc.expectErr)
+			}
+			var re *api.RetryError
+			if tc.expectErr && (!errors.As(err, &re) || re.RetryAfter() != tc.expectRetryIn) {
+				t.Errorf("Expected a RetryError after %v, got %v", tc.expectRetryIn, err)
+			}
+		})
+	}
+}
+
+func TestLogNodeSummary(t *testing.T) {
+	testCases := []struct {
+		count    int
+		expected string
+	}{
+		{0, "total=0 names=[]"},
+		{2, "total=2 names=[node-00000,node-00001]"},
+		{maxNodeNamesToLog, fmt.Sprintf("total=%d names=[%s]", maxNodeNamesToLog, strings.Join(sets.List(nodeNames(newNodes(maxNodeNamesToLog))), ","))},
+		{maxNodeNamesToLog + 1, fmt.Sprintf("total=%d names=[%s,<1 more>]", maxNodeNamesToLog+1, strings.Join(sets.List(nodeNames(newNodes(maxNodeNamesToLog))), ","))},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d nodes", tc.count), func(t *testing.T) {
+			if got := logNodeSummary(newNodes(tc.count)); got != tc.expected {
+				t.Errorf("logNodeSummary() = %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+// batchCloud is a fake cloud supporting BatchableLoadBalancer.
+type batchCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	calls   int
+	updated []string
+	errs    map[string]error
+	// truncate is the number of updates whose error is left out of the
+	// results, from the end.
+	truncate int
+}
+
+func (c *batchCloud) UpdateLoadBalancerBatch(ctx context.Context, clusterName string, updates []cloudprovider.LoadBalancerUpdate) ([]error, error) {
+	c.calls++
+	errs := make([]error, len(updates))
+	for i, update := range updates {
+		key := update.Service.Namespace + "/" + update.Service.Name
+		c.updated = append(c.updated, key)
+		errs[i] = c.errs[key]
+	}
+	return errs[:len(errs)-c.truncate], nil
+}
+
+func TestUpdateLoadBalancerHostsBatch(t *testing.T) {
+	services := []*v1.Service{
+		newLoadBalancerService("svc-1", "lb-1"),
+		newLoadBalancerService("svc-2", "lb-2"),
+		newLoadBalancerService("svc-3", "lb-3"),
+	}
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	cloud := &batchCloud{
+		FakeLoadBalancer: fakecloud.NewFakeLoadBalancer(),
+		errs:             map[string]error{"default/svc-2": errors.New("update failed")},
+	}
+	controller.balancer = cloud
+	setReadyNodes(t, controller, 3)
+
+	retry := controller.updateLoadBalancerHosts(context.Background(), services, 2)
+	if cloud.calls != 1 {
+		t.Errorf("Expected a single batch call, got %d", cloud.calls)
+	}
+	if len(cloud.updated) != len(services) {
+		t.Errorf("Expected %d services in the batch, got %v", len(services), cloud.updated)
+	}
+	if len(retry) != 1 || retry["default/svc-2"] == nil {
+		t.Errorf("Expected only default/svc-2 to be retried, got %v", retry)
+	}
+	if cloud.CallCount("update") != 0 {
+		t.Errorf("Expected no per-service UpdateLoadBalancer calls, got %d", cloud.CallCount("update"))
+	}
+
+	// Nodes didn't change, the next sync doesn't call the cloud.
+	controller.updateLoadBalancerHosts(context.Background(), services, 2)
+	if cloud.calls != 1 {
+		t.Errorf("Expected no batch call without node changes, got %d calls", cloud.calls)
+	}
+}
+
+func TestUpdateLoadBalancerHostsBatchMissingResults(t *testing.T) {
+	services := []*v1.Service{
+		newLoadBalancerService("svc-1", "lb-1"),
+		newLoadBalancerService("svc-2", "lb-2"),
+		newLoadBalancerService("svc-3", "lb-3"),
+	}
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	cloud := &batchCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer(), truncate: 2}
+	controller.balancer = cloud
+	setReadyNodes(t, controller, 3)
+
+	retry := controller.updateLoadBalancerHosts(context.Background(), services, 2)
+	if len(retry) != 2 || retry["default/svc-2"] == nil || retry["default/svc-3"] == nil {
+		t.Errorf("Expected the services without result to be retried, got %v", retry)
+	}
+}
+
+func TestUpdateLoadBalancerHostsBatchLocked(t *testing.T) {
+	services := []*v1.Service{
+		newLoadBalancerService("svc-1", "lb-1"),
+		newLoadBalancerService("svc-2", "lb-2"),
+	}
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	cloud := &batchCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+	setReadyNodes(t, controller, 3)
+
+	// default/svc-2 is being reconciled by a service worker.
+	controller.serviceLocks.Lock("default/svc-2")
+	retry := controller.updateLoadBalancerHosts(context.Background(), services, 2)
+	controller.serviceLocks.Unlock("default/svc-2")
+	if len(retry) != 1 || retry["default/svc-2"] == nil {
+		t.Errorf("Expected only default/svc-2 to be retried, got %v", retry)
+	}
+	if len(cloud.updated) != 1 || cloud.updated[0] != "default/svc-1" {
+		t.Errorf("Expected only default/svc-1 in the batch, got %v", cloud.updated)
+	}
+	if got := controller.serviceLocks.len(); got != 0 {
+		t.Errorf("Expected the batch to release the service locks, got %d locks", got)
+	}
+}
+
+// setReadyNodes makes the node lister of the controller return count ready nodes.
+func setReadyNodes(t testing.TB, controller *Controller, count int) {
+	t.Helper()
+
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range newNodes(count) {
+		node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatalf("Failed to add node to the indexer: %v", err)
+		}
+	}
+	controller.nodeLister = corelisters.NewNodeLister(nodeIndexer)
+}
+
+func TestProcessNextNodeItemRetryError(t *testing.T) {
+	retryAfter := 20 * time.Second
+	cloudErr := api.NewRetryError("load balancer is busy", retryAfter)
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, newFailingLoadBalancer(cloudErr), svc)
+	controller.cache.set("default/svc", &cachedService{state: svc})
+	setReadyNodes(t, controller, 2)
+
+	if err := controller.nodeSyncService(context.TODO(), svc); !errors.Is(err, cloudErr) {
+		t.Fatalf("Expected nodeSyncService to return the cloud error, got %v", err)
+	}
+
+	queue := newSpyQueue(controller.serviceQueue)
+	controller.serviceQueue = queue
+	defer queue.ShutDown()
+
+	setReadyNodes(t, controller, 3)
+	controller.nodeQueue.Add("node-00002")
+	if !controller.processNextNodeItem(context.TODO(), 1) {
+		t.Fatalf("processNextNodeItem() returned false, expected the queue to keep running")
+	}
+	if got, ok := queue.addedAfter["default/svc"]; !ok || got != retryAfter {
+		t.Errorf("Expected the service to be re-queued with AddAfter(%v), got %v (queued: %t)", retryAfter, got, ok)
+	}
+}
+
+func TestNodeLabelSelector(t *testing.T) {
+	client := fake.NewSimpleClientset(newClusterInfoConfigMap())
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	newTestController := func(selector string) (*Controller, error) {
+		return New(newFakeCloud(fakecloud.NewFakeLoadBalancer()), client,
+			informerFactory.Core().V1().Services(),
+			informerFactory.Discovery().V1().EndpointSlices(),
+			informerFactory.Core().V1().Nodes(),
+			testClusterID, nil,
+			WithNodeLabelSelector(selector),
+		)
+	}
+
+	if _, err := newTestController("role in (lb"); err == nil {
+		t.Errorf("Expected an error for an invalid node label selector")
+	}
+
+	controller, err := newTestController("role=lb-eligible")
+	if err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+	controller.eventRecorder = record.NewFakeRecorder(100)
+	setReadyNodes(t, controller, 3)
+	node, err := controller.nodeLister.Get("node-00001")
+	if err != nil {
+		t.Fatalf("Failed to get node: %v", err)
+	}
+	node.Labels = map[string]string{"role": "lb-eligible"}
+
+	nodes, _, err := controller.nodesToSync(newLoadBalancerService("svc", "lb-1"))
+	if err != nil {
+		t.Fatalf("nodesToSync() failed: %v", err)
+	}
+	if names := nodeNames(nodes); !names.Equal(sets.New("node-00001")) {
+		t.Errorf("Expected only node-00001 to be selected, got %v", sets.List(names))
+	}
+}
+
+func TestDeletingNodeExcluded(t *testing.T) {
+	for _, policy := range []v1.ServiceExternalTrafficPolicyType{v1.ServiceExternalTrafficPolicyCluster, v1.ServiceExternalTrafficPolicyLocal} {
+		t.Run(string(policy), func(t *testing.T) {
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+			setReadyNodes(t, controller, 3)
+			node, err := controller.nodeLister.Get("node-00001")
+			if err != nil {
+				t.Fatalf("Failed to get node: %v", err)
+			}
+			deleting := node.DeepCopy()
+			deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			if !controller.shouldSyncUpdatedNode(node, deleting) {
+				t.Errorf("Expected a sync when the node starts being deleted")
+			}
+			node.DeletionTimestamp = deleting.DeletionTimestamp
+
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Spec.ExternalTrafficPolicy = policy
+			nodes, _, err := controller.nodesToSync(svc)
+			if err != nil {
+				t.Fatalf("nodesToSync() failed: %v", err)
+			}
+			if names := nodeNames(nodes); !names.Equal(sets.New("node-00000", "node-00002")) {
+				t.Errorf("Expected the deleting node to be excluded, got %v", sets.List(names))
+			}
+		})
+	}
+}
+
+func TestNodeStaleReadinessPredicate(t *testing.T) {
+	readyNode := func(status v1.ConditionStatus, since time.Duration) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+			Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{
+				Type:               v1.NodeReady,
+				Status:             status,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-since)),
+			}}},
+		}
+	}
+
+	testCases := []struct {
+		desc     string
+		node     *v1.Node
+		expected bool
+	}{
+		{desc: "ready for long", node: readyNode(v1.ConditionTrue, time.Hour), expected: true},
+		{desc: "unknown recently", node: readyNode(v1.ConditionUnknown, 5*time.Second), expected: true},
+		{desc: "not ready recently", node: readyNode(v1.ConditionFalse, time.Minute), expected: true},
+		{desc: "unknown for long", node: readyNode(v1.ConditionUnknown, 10*time.Minute), expected: false},
+		{desc: "not ready for long", node: readyNode(v1.ConditionFalse, 10*time.Minute), expected: false},
+		{desc: "no readiness condition", node: &v1.Node{}, expected: false},
+	}
+	predicate := nodeStaleReadinessPredicate(5 * time.Minute)
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := predicate(tc.node); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestNodeReadinessStalenessThreshold(t *testing.T) {
+	defer featuregatetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.StableLoadBalancerNodeSet, false)()
+
+	client := fake.NewSimpleClientset(newClusterInfoConfigMap())
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	newTestController := func(threshold time.Duration) *Controller {
+		controller, err := New(newFakeCloud(fakecloud.NewFakeLoadBalancer()), client,
+			informerFactory.Core().V1().Services(),
+			informerFactory.Discovery().V1().EndpointSlices(),
+			informerFactory.Core().V1().Nodes(),
+			testClusterID, nil,
+			WithNodeReadinessStalenessThreshold(threshold),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create service controller: %v", err)
+		}
+		controller.eventRecorder = record.NewFakeRecorder(100)
+		setReadyNodes(t, controller, 3)
+		node, err := controller.nodeLister.Get("node-00001")
+		if err != nil {
+			t.Fatalf("Failed to get node: %v", err)
+		}
+		node.Status.Conditions = []v1.NodeCondition{{
+			Type:               v1.NodeReady,
+			Status:             v1.ConditionUnknown,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+		}}
+		return controller
+	}
+
+	testCases := []struct {
+		desc          string
+		threshold     time.Duration
+		expectedNodes int
+	}{
+		{desc: "disabled", threshold: 0, expectedNodes: 2},
+		{desc: "within the threshold", threshold: 5 * time.Minute, expectedNodes: 3},
+		{desc: "past the threshold", threshold: 30 * time.Second, expectedNodes: 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			controller := newTestController(tc.threshold)
+			nodes, _, err := controller.nodesToSync(newLoadBalancerService("svc", "lb-1"))
+			if err != nil {
+				t.Fatalf("nodesToSync() failed: %v", err)
+			}
+			if len(nodes) != tc.expectedNodes {
+				t.Errorf("Expected %d nodes, got %v", tc.expectedNodes, sets.List(nodeNames(nodes)))
+			}
+		})
+	}
+}
+
+// countPatches returns the number of patch actions the fake clientset received.
+func countPatches(client *fake.Clientset) int {
+	patches := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" {
+			patches++
+		}
+	}
+	return patches
+}
+
+func TestFinalizerPatchCalls(t *testing.T) {
+	withFinalizer := func(svc *v1.Service) *v1.Service {
+		svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+		return svc
+	}
+	deleting := func(svc *v1.Service) *v1.Service {
+		now := metav1.Now()
+		svc.DeletionTimestamp = &now
+		return svc
+	}
+
+	testCases := []struct {
+		desc            string
+		service         *v1.Service
+		remove          bool
+		expectedPatches int
+	}{{
+		desc:            "add finalizer to service without it",
+		service:         newLoadBalancerService("svc", "lb-1"),
+		expectedPatches: 1,
+	}, {
+		desc:            "add finalizer to service already having it",
+		service:         withFinalizer(newLoadBalancerService("svc", "lb-1")),
+		expectedPatches: 0,
+	}, {
+		desc:            "remove finalizer from deleted service",
+		service:         deleting(withFinalizer(newLoadBalancerService("svc", "lb-1"))),
+		remove:          true,
+		expectedPatches: 1,
+	}, {
+		desc:            "remove finalizer from service without it",
+		service:         deleting(newLoadBalancerService("svc", "lb-1")),
+		remove:          true,
+		expectedPatches: 0,
+	}, {
+		desc:            "remove finalizer from service still wanting a load balancer",
+		service:         withFinalizer(newLoadBalancerService("svc", "lb-1")),
+		remove:          true,
+		expectedPatches: 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), tc.service)
+			client.ClearActions()
+
+			for i := 0; i < 2; i++ {
+				service, err := client.CoreV1().Services(tc.service.Namespace).Get(context.TODO(), tc.service.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Failed to get service: %v", err)
+				}
+				if tc.remove {
+					err = controller.removeFinalizer(service)
+				} else {
+					err = controller.addFinalizer(service)
+				}
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+
+			// The second call sees the patched service and must not patch again.
+			if got := countPatches(client); got != tc.expectedPatches {
+				t.Errorf("Expected %d patch calls, got %d", tc.expectedPatches, got)
+			}
+		})
+	}
+}
+
+// statusCloud is a fake cloud supporting LoadBalancerStatusGetter.
+type statusCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	status *v1.LoadBalancerStatus
+}
+
+func (c *statusCloud) GetLoadBalancerStatus(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, error) {
+	return c.status, nil
+}
+
+func TestSkipEnsureWhenLoadBalancerUpToDate(t *testing.T) {
+	status := v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}}
+	testCases := []struct {
+		desc            string
+		cloudStatus     *v1.LoadBalancerStatus
+		expectedEnsures int
+	}{{
+		desc:            "status matches",
+		cloudStatus:     status.DeepCopy(),
+		expectedEnsures: 1,
+	}, {
+		desc:            "status differs",
+		cloudStatus:     &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.2"}}},
+		expectedEnsures: 2,
+	}, {
+		desc:            "status unknown",
+		expectedEnsures: 2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Status.LoadBalancer = *status.DeepCopy()
+			controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+			cloud := &statusCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer(), status: tc.cloudStatus}
+			controller.balancer = cloud
+
+			for i := 0; i < 2; i++ {
+				if err := controller.processServiceCreateOrUpdate(context.TODO(), svc, "default/svc", nil); err != nil {
+					t.Fatalf("processServiceCreateOrUpdate() returned unexpected error: %v", err)
+				}
+				// The ensure records the applied bandwidth in the service.
+				svc = getService(t, client, "svc")
+			}
+			if got := cloud.CallCount("ensure"); got != tc.expectedEnsures {
+				t.Errorf("Expected %d EnsureLoadBalancer calls, got %d", tc.expectedEnsures, got)
+			}
+		})
+	}
+}
+
+func TestEmptyLoadBalancerIngress(t *testing.T) {
+	for _, preserve := range []bool{true, false} {
+		t.Run(fmt.Sprintf("preserve=%t", preserve), func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+			svc.Status.LoadBalancer = v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}}
+			controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+			controller.balancer = fakecloud.NewFakeLoadBalancer().WithEnsureResult(&v1.LoadBalancerStatus{}, nil)
+			controller.preserveIngressOnEmpty = preserve
+			client.ClearActions()
+
+			if _, _, err := controller.syncLoadBalancerIfNeeded(context.TODO(), svc, "default/svc", nil, &cloudprovider.ServiceOptions{}); err != nil {
+				t.Fatalf("syncLoadBalancerIfNeeded() returned unexpected error: %v", err)
+			}
+
+			recorder := controller.eventRecorder.(*record.FakeRecorder)
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, "EmptyLoadBalancerIngress") {
+					t.Errorf("Expected an EmptyLoadBalancerIngress event, got %q", event)
+				}
+			default:
+				t.Errorf("Expected an EmptyLoadBalancerIngress event, got none")
+			}
+
+			// Clearing the ingress patches the status, preserving it doesn't.
+			if got, expected := countPatches(client) > 0, !preserve; got != expected {
+				t.Errorf("Expected status patched to be %t, got %t", expected, got)
+			}
+		})
+	}
+}
+
+func TestIsMigratingStatus(t *testing.T) {
+	ip := v1.LoadBalancerIngress{IP: "10.0.0.1"}
+	hostname := v1.LoadBalancerIngress{Hostname: "lb.example.com"}
+	portErr := "Pending"
+	testCases := []struct {
+		desc     string
+		current  []v1.LoadBalancerIngress
+		desired  []v1.LoadBalancerIngress
+		expected bool
+	}{
+		{
+			desc:     "equal",
+			current:  []v1.LoadBalancerIngress{ip},
+			desired:  []v1.LoadBalancerIngress{ip},
+			expected: true,
+		},
+		{
+			desc:     "migrating from IP to hostname",
+			current:  []v1.LoadBalancerIngress{ip, hostname},
+			desired:  []v1.LoadBalancerIngress{hostname},
+			expected: true,
+		},
+		{
+			desc:     "migrating from hostname to IP",
+			current:  []v1.LoadBalancerIngress{ip, hostname},
+			desired:  []v1.LoadBalancerIngress{ip},
+			expected: true,
+		},
+		{
+			desc:     "hostname matched after normalization",
+			current:  []v1.LoadBalancerIngress{{Hostname: "LB.example.com."}},
+			desired:  []v1.LoadBalancerIngress{hostname},
+			expected: true,
+		},
+		{
+			desc:     "stale IP left in the status",
+			current:  []v1.LoadBalancerIngress{ip, {IP: "10.0.0.2"}},
+			desired:  []v1.LoadBalancerIngress{ip},
+			expected: false,
+		},
+		{
+			desc:     "port status changed",
+			current:  []v1.LoadBalancerIngress{ip, hostname},
+			desired:  []v1.LoadBalancerIngress{{Hostname: "lb.example.com", Ports: []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP}}}},
+			expected: false,
+		},
+		{
+			desc:     "port error changed",
+			current:  []v1.LoadBalancerIngress{ip, {IP: "10.0.0.2", Ports: []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP}}}},
+			desired:  []v1.LoadBalancerIngress{{IP: "10.0.0.2", Ports: []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP, Error: &portErr}}}},
+			expected: false,
+		},
+		{
+			desc:     "desired ingress missing",
+			current:  []v1.LoadBalancerIngress{ip},
+			desired:  []v1.LoadBalancerIngress{hostname},
+			expected: false,
+		},
+		{
+			desc:     "empty desired status",
+			current:  []v1.LoadBalancerIngress{ip},
+			desired:  nil,
+			expected: false,
+		},
+		{
+			desc:     "empty current status",
+			current:  nil,
+			desired:  []v1.LoadBalancerIngress{ip},
+			expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := isMigratingStatus(&v1.LoadBalancerStatus{Ingress: tc.current}, &v1.LoadBalancerStatus{Ingress: tc.desired})
+			if got != tc.expected {
+				t.Errorf("isMigratingStatus() = %t, expected %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPatchStatusSkipsMigration(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	client.ClearActions()
+
+	current := &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}, {Hostname: "lb.example.com"}}}
+	if err := controller.patchStatus(svc, current, &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{Hostname: "lb.example.com"}}}); err != nil {
+		t.Fatalf("patchStatus() returned unexpected error: %v", err)
+	}
+	if patches := countPatches(client); patches != 0 {
+		t.Errorf("Expected no patch while the status contains the desired ingress, got %d", patches)
+	}
+
+	// The cloud no longer reports the second IP, it must leave the status.
+	current = &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}}
+	if err := controller.patchStatus(svc, current, &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}}); err != nil {
+		t.Fatalf("patchStatus() returned unexpected error: %v", err)
+	}
+	if patches := countPatches(client); patches != 1 {
+		t.Errorf("Expected the stale ingress to be patched away, got %d patches", patches)
+	}
+
+	if err := controller.patchStatus(svc, current, &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.2"}}}); err != nil {
+		t.Fatalf("patchStatus() returned unexpected error: %v", err)
+	}
+	if patches := countPatches(client); patches != 2 {
+		t.Errorf("Expected the status to be patched with a new ingress, got %d patches", patches)
+	}
+}
+
+func TestPatchStatusMigrationTimeout(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	queue := newSpyQueue(controller.serviceQueue)
+	controller.serviceQueue = queue
+	defer queue.ShutDown()
+	client.ClearActions()
+
+	key := "default/svc"
+	current := &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}, {Hostname: "lb.example.com"}}}
+	desired := &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{Hostname: "lb.example.com"}}}
+	if err := controller.patchStatus(svc, current, desired); err != nil {
+		t.Fatalf("patchStatus() returned unexpected error: %v", err)
+	}
+	if patches := countPatches(client); patches != 0 {
+		t.Fatalf("Expected no patch while the load balancer migrates, got %d", patches)
+	}
+	if got, ok := queue.addedAfter[key]; !ok || got <= 0 || got > statusMigrationTimeout {
+		t.Errorf("Expected key %q to be re-queued once the migration times out, got %v (queued: %t)", key, got, ok)
+	}
+
+	// The migration timed out, the stale IP leaves the status.
+	controller.statusMigrations[key] = time.Now().Add(-statusMigrationTimeout)
+	if err := controller.patchStatus(svc, current, desired); err != nil {
+		t.Fatalf("patchStatus() returned unexpected error: %v", err)
+	}
+	if patches := countPatches(client); patches != 1 {
+		t.Errorf("Expected the stale ingress to be patched away, got %d patches", patches)
+	}
+	if _, ok := controller.statusMigrations[key]; ok {
+		t.Errorf("Expected the migration of %q to be forgotten", key)
+	}
+}
+
+// blockingCloud is a fake cloud whose calls block until their context is done.
+type blockingCloud struct {
+	*fakecloud.FakeLoadBalancer
+}
+
+func (c *blockingCloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, lbId string) (*v1.LoadBalancerStatus, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCloudCallTimeout(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	controller.balancer = &blockingCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.lbAPITimeout = 10 * time.Millisecond
+
+	before, err := testutil.GetCounterMetricValue(lbAPITimeoutCount.WithLabelValues("ensure"))
+	if err != nil {
+		t.Fatalf("Failed to read lb_api_timeout_total: %v", err)
+	}
+	_, err = controller.ensureLoadBalancer(context.TODO(), svc, nil, "lb-1", &cloudprovider.ServiceOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the call to time out, got %v", err)
+	}
+	var nre *nonRetryableError
+	if errors.As(err, &nre) {
+		t.Errorf("Expected a timeout to be retried, got a non retryable error")
+	}
+	after, err := testutil.GetCounterMetricValue(lbAPITimeoutCount.WithLabelValues("ensure"))
+	if err != nil {
+		t.Fatalf("Failed to read lb_api_timeout_total: %v", err)
+	}
+	if after != before+1 {
+		t.Errorf("Expected one ensure timeout to be counted, got %v", after-before)
+	}
+
+	// A canceled controller context is a shutdown, not a timeout.
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if _, err := controller.ensureLoadBalancer(ctx, svc, nil, "lb-1", &cloudprovider.ServiceOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the call to be canceled, got %v", err)
+	}
+	if got, _ := testutil.GetCounterMetricValue(lbAPITimeoutCount.WithLabelValues("ensure")); got != after {
+		t.Errorf("Expected a canceled call not to be counted as a timeout")
+	}
+}
+
+// recordingHook is an LBLifecycleHook recording its calls.
+type recordingHook struct {
+	calls   []string
+	preErr  error
+	postErr error
+}
+
+func (h *recordingHook) PreEnsure(ctx context.Context, service *v1.Service, lbID string) error {
+	h.calls = append(h.calls, "pre:"+lbID)
+	return h.preErr
+}
+
+func (h *recordingHook) PostEnsure(ctx context.Context, service *v1.Service, status *v1.LoadBalancerStatus, lbID string) error {
+	h.calls = append(h.calls, "post:"+lbID)
+	return h.postErr
+}
+
+func TestLBLifecycleHook(t *testing.T) {
+	hookErr := errors.New("firewall unavailable")
+	testCases := []struct {
+		desc           string
+		hook           *recordingHook
+		expectedCalls  []string
+		expectedEnsure bool
+	}{
+		{desc: "both hooks run", hook: &recordingHook{}, expectedCalls: []string{"pre:lb-1", "post:lb-1"}, expectedEnsure: true},
+		{desc: "pre-ensure failure skips ensure", hook: &recordingHook{preErr: hookErr}, expectedCalls: []string{"pre:lb-1"}},
+		{desc: "post-ensure failure", hook: &recordingHook{postErr: hookErr}, expectedCalls: []string{"pre:lb-1", "post:lb-1"}, expectedEnsure: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			cloud := fakecloud.NewFakeLoadBalancer()
+			controller, _ := newController(t, cloud, svc)
+			controller.lifecycleHook = tc.hook
+
+			_, err := controller.ensureLoadBalancer(context.TODO(), svc, nil, "lb-1", &cloudprovider.ServiceOptions{})
+			expectErr := tc.hook.preErr != nil || tc.hook.postErr != nil
+			if !errors.Is(err, hookErr) && expectErr {
+				t.Errorf("Expected the hook error, got %v", err)
+			}
+			if err != nil && !expectErr {
+				t.Errorf("ensureLoadBalancer() returned unexpected error: %v", err)
+			}
+			var nre *nonRetryableError
+			if errors.As(err, &nre) {
+				t.Errorf("Expected hook errors to be retried, got a non retryable error")
+			}
+			if !reflect.DeepEqual(tc.hook.calls, tc.expectedCalls) {
+				t.Errorf("Expected hook calls %v, got %v", tc.expectedCalls, tc.hook.calls)
+			}
+			if ensured := cloud.CallCount("ensure") != 0; ensured != tc.expectedEnsure {
+				t.Errorf("Expected the load balancer to be ensured: %t, got %d ensure calls", tc.expectedEnsure, cloud.CallCount("ensure"))
+			}
+		})
+	}
+}
+
+// federatedCloud is a fake cloud supporting FederatedLoadBalancer.
+type federatedCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	partnerClusterIDs [][]string
+	options           []cloudprovider.ServiceOptions
+}
+
+func (c *federatedCloud) EnsureLoadBalancerFederated(ctx context.Context, primaryClusterName string, partnerClusterIDs []string, service *v1.Service, endpointSlices []*discoveryv1.EndpointSlice, lbId string, options *cloudprovider.ServiceOptions) (*v1.LoadBalancerStatus, error) {
+	c.partnerClusterIDs = append(c.partnerClusterIDs, partnerClusterIDs)
+	c.options = append(c.options, *options)
+	return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}}, nil
+}
+
+func TestEnsureLoadBalancerFederated(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		partnerClusterIDs []string
+		federated         bool
+		expectedFederated int
+		expectedEnsures   int
+	}{
+		{desc: "federated", partnerClusterIDs: []string{"cluster-b"}, federated: true, expectedFederated: 1},
+		{desc: "no partner clusters", federated: true, expectedEnsures: 1},
+		{desc: "not supported by the cloud", partnerClusterIDs: []string{"cluster-b"}, expectedEnsures: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			cloud := fakecloud.NewFakeLoadBalancer()
+			controller, _ := newController(t, cloud, svc)
+			fedCloud := &federatedCloud{FakeLoadBalancer: cloud}
+			if tc.federated {
+				controller.balancer = fedCloud
+			}
+
+			options := &cloudprovider.ServiceOptions{PartnerClusterIDs: tc.partnerClusterIDs, ConnectionLimit: 100}
+			if _, err := controller.ensureLoadBalancer(context.TODO(), svc, nil, "lb-1", options); err != nil {
+				t.Fatalf("ensureLoadBalancer() returned unexpected error: %v", err)
+			}
+			if got := len(fedCloud.partnerClusterIDs); got != tc.expectedFederated {
+				t.Errorf("Expected %d federated ensures, got %d", tc.expectedFederated, got)
+			}
+			for _, got := range fedCloud.options {
+				if got.ConnectionLimit != 100 {
+					t.Errorf("Expected the federated ensure to receive the service options, got %+v", got)
+				}
+			}
+			if got := cloud.CallCount("ensure"); got != tc.expectedEnsures {
+				t.Errorf("Expected %d ensures, got %d", tc.expectedEnsures, got)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerReadyCondition(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		wantsLB        bool
+		cloudErr       error
+		condition      *metav1.Condition
+		expectedStatus metav1.ConditionStatus
+	}{
+		{desc: "provisioned", wantsLB: true, expectedStatus: metav1.ConditionTrue},
+		{desc: "provisioning failed", wantsLB: true, cloudErr: errors.New("cloud unavailable"), expectedStatus: metav1.ConditionFalse},
+		{
+			desc:      "removed with the load balancer",
+			condition: &metav1.Condition{Type: LoadBalancerReadyCondition, Status: metav1.ConditionTrue, Reason: "Provisioned"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if !tc.wantsLB {
+				svc.Spec.Type = v1.ServiceTypeClusterIP
+				svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+			}
+			if tc.condition != nil {
+				svc.Status.Conditions = []metav1.Condition{*tc.condition}
+			}
+			cloud := fakecloud.NewFakeLoadBalancer().
+				WithEnsureResult(&v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}}, tc.cloudErr)
+			controller, client := newController(t, cloud, svc)
+			controller.enableLBReadinessGate = true
+
+			controller.syncLoadBalancerIfNeeded(context.TODO(), svc.DeepCopy(), "default/svc", nil, &cloudprovider.ServiceOptions{})
+
+			updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get service: %v", err)
+			}
+			cond := meta.FindStatusCondition(updated.Status.Conditions, LoadBalancerReadyCondition)
+			switch {
+			case tc.expectedStatus == "" && cond != nil:
+				t.Errorf("Expected no %s condition, got %+v", LoadBalancerReadyCondition, cond)
+			case tc.expectedStatus != "" && cond == nil:
+				t.Errorf("Expected the %s condition to be %s, got none", LoadBalancerReadyCondition, tc.expectedStatus)
+			case tc.expectedStatus != "" && cond.Status != tc.expectedStatus:
+				t.Errorf("Expected the %s condition to be %s, got %s", LoadBalancerReadyCondition, tc.expectedStatus, cond.Status)
+			}
+		})
+	}
+}
+
+func TestReconcileStatus(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		exists      bool
+		ingressIP   string
+		expectQueue bool
+	}{
+		{desc: "in sync", exists: true, ingressIP: "10.0.0.1"},
+		{desc: "ingress drifted", exists: true, ingressIP: "10.0.0.2", expectQueue: true},
+		{desc: "load balancer gone", ingressIP: "10.0.0.1", expectQueue: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cloud := fakecloud.NewFakeLoadBalancer().WithGetResult(&v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{
+				IP:    "10.0.0.1",
+				Ports: []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP}},
+			}}}, tc.exists, nil)
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Status.LoadBalancer = v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{
+				IP:    tc.ingressIP,
+				Ports: []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP}},
+			}}}
+			controller, _ := newController(t, cloud, svc)
+			controller.cache.set("default/svc", &cachedService{state: svc})
+			// A service being processed for the first time has no state yet.
+			controller.cache.getOrCreate("default/new")
+
+			controller.reconcileStatus(context.TODO())
+
+			if got := controller.serviceQueue.Len(); (got == 1) != tc.expectQueue {
+				t.Errorf("Expected the service to be queued: %v, got %d queued keys", tc.expectQueue, got)
+			}
+			recorder := controller.eventRecorder.(*record.FakeRecorder)
+			select {
+			case event := <-recorder.Events:
+				if !tc.expectQueue {
+					t.Errorf("Expected no event, got %q", event)
+				} else if !strings.Contains(event, EventReasonLoadBalancerStatusDrift) {
+					t.Errorf("Expected a %s event, got %q", EventReasonLoadBalancerStatusDrift, event)
+				}
+			default:
+				if tc.expectQueue {
+					t.Errorf("Expected a %s event, got none", EventReasonLoadBalancerStatusDrift)
+				}
+			}
+		})
+	}
+}
+
+func TestRecreateOnSubnetChange(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		cachedSubnet  *string
+		subnet        *string
+		oldID         string
+		expectedOldID string
+	}{
+		{desc: "unchanged subnet", cachedSubnet: stringPtr("subnet-a"), subnet: stringPtr("subnet-a")},
+		{desc: "changed subnet", cachedSubnet: stringPtr("subnet-a"), subnet: stringPtr("subnet-b"), expectedOldID: "lb-1"},
+		{desc: "subnet set", subnet: stringPtr("subnet-b"), expectedOldID: "lb-1"},
+		{desc: "default subnet set explicitly", subnet: stringPtr("subnet-default")},
+		{desc: "old ID already set", cachedSubnet: stringPtr("subnet-a"), subnet: stringPtr("subnet-b"), oldID: "lb-0", expectedOldID: "lb-0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cached := newLoadBalancerService("svc", "lb-1")
+			if tc.cachedSubnet != nil {
+				cached.Annotations[ServiceAnnotationLoadBalancerSubnetID] = *tc.cachedSubnet
+			}
+			svc := cached.DeepCopy()
+			delete(svc.Annotations, ServiceAnnotationLoadBalancerSubnetID)
+			if tc.subnet != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerSubnetID] = *tc.subnet
+			}
+			if len(tc.oldID) != 0 {
+				svc.Annotations[ServiceAnnotationLoadBalancerOldID] = tc.oldID
+			}
+			controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+			controller.defaultServiceOptions.SubnetID = "subnet-default"
+
+			updated, err := controller.recreateOnImmutableChange(cached, svc)
+			if err != nil {
+				t.Fatalf("recreateOnImmutableChange() returned unexpected error: %v", err)
+			}
+			if got := updated.Annotations[ServiceAnnotationLoadBalancerOldID]; got != tc.expectedOldID {
+				t.Errorf("Expected old load balancer ID %q, got %q", tc.expectedOldID, got)
+			}
+			if patches := countPatches(client); (patches == 1) != (tc.expectedOldID != tc.oldID) {
+				t.Errorf("Expected the service to be patched: %t, got %d patches", tc.expectedOldID != tc.oldID, patches)
+			}
+		})
+	}
+}
+
+func TestRecreateOnChargeTypeChange(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		cached        map[string]string
+		current       map[string]string
+		expectedOldID string
+	}{
+		{desc: "unchanged default", cached: map[string]string{}, current: map[string]string{ServiceAnnotationLoadBalancerChargeType: "postpaid"}},
+		{desc: "unchanged default period", cached: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid"}, current: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid", ServiceAnnotationLoadBalancerPrepaidPeriod: "1"}},
+		{desc: "prepaid", cached: map[string]string{}, current: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid"}, expectedOldID: "lb-1"},
+		{desc: "period changed", cached: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid"}, current: map[string]string{ServiceAnnotationLoadBalancerChargeType: "prepaid", ServiceAnnotationLoadBalancerPrepaidPeriod: "6"}, expectedOldID: "lb-1"},
+		{desc: "invalid", cached: map[string]string{}, current: map[string]string{ServiceAnnotationLoadBalancerChargeType: "free"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cached := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.cached {
+				cached.Annotations[key] = value
+			}
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.current {
+				svc.Annotations[key] = value
+			}
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+
+			updated, err := controller.recreateOnImmutableChange(cached, svc)
+			if err != nil {
+				t.Fatalf("recreateOnImmutableChange() returned unexpected error: %v", err)
+			}
+			if got := updated.Annotations[ServiceAnnotationLoadBalancerOldID]; got != tc.expectedOldID {
+				t.Errorf("Expected old load balancer ID %q, got %q", tc.expectedOldID, got)
+			}
+			warned := false
+			recorder := controller.eventRecorder.(*record.FakeRecorder)
+			for len(recorder.Events) > 0 {
+				if strings.HasPrefix(<-recorder.Events, v1.EventTypeWarning+" "+EventReasonLoadBalancerChargeTypeChanged) {
+					warned = true
+				}
+			}
+			if expected := len(tc.expectedOldID) != 0; warned != expected {
+				t.Errorf("Expected a %s event to be %t, got %t", EventReasonLoadBalancerChargeTypeChanged, expected, warned)
+			}
+		})
+	}
+}
+
+func TestRecreateOnEIPRequested(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		cached        map[string]string
+		current       map[string]string
+		expectedOldID string
+	}{
+		{desc: "no elastic IP", cached: map[string]string{}, current: map[string]string{ServiceAnnotationLoadBalancerEIP: "false"}},
+		{desc: "kept", cached: map[string]string{ServiceAnnotationLoadBalancerEIP: "true"}, current: map[string]string{ServiceAnnotationLoadBalancerEIP: "true", ServiceAnnotationLoadBalancerEIPBandwidth: "100"}},
+		{desc: "released", cached: map[string]string{ServiceAnnotationLoadBalancerEIP: "true"}, current: map[string]string{}},
+		{desc: "requested", cached: map[string]string{}, current: map[string]string{ServiceAnnotationLoadBalancerEIP: "true"}, expectedOldID: "lb-1"},
+		{desc: "invalid", cached: map[string]string{}, current: map[string]string{ServiceAnnotationLoadBalancerEIP: "yes please"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cached := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.cached {
+				cached.Annotations[key] = value
+			}
+			svc := newLoadBalancerService("svc", "lb-1")
+			for key, value := range tc.current {
+				svc.Annotations[key] = value
+			}
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+
+			updated, err := controller.recreateOnImmutableChange(cached, svc)
+			if err != nil {
+				t.Fatalf("recreateOnImmutableChange() returned unexpected error: %v", err)
+			}
+			if got := updated.Annotations[ServiceAnnotationLoadBalancerOldID]; got != tc.expectedOldID {
+				t.Errorf("Expected old load balancer ID %q, got %q", tc.expectedOldID, got)
+			}
+			warned := false
+			recorder := controller.eventRecorder.(*record.FakeRecorder)
+			for len(recorder.Events) > 0 {
+				if strings.HasPrefix(<-recorder.Events, v1.EventTypeWarning+" "+EventReasonLoadBalancerEIPRequested) {
+					warned = true
+				}
+			}
+			if expected := len(tc.expectedOldID) != 0; warned != expected {
+				t.Errorf("Expected a %s event to be %t, got %t", EventReasonLoadBalancerEIPRequested, expected, warned)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerProvisioningCondition(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		wantsLB        bool
+		cloudErr       error
+		expectedStatus metav1.ConditionStatus
+		expectedReady  bool
+	}{
+		{desc: "ensured", wantsLB: true, expectedStatus: metav1.ConditionFalse, expectedReady: true},
+		{desc: "ensure failed", wantsLB: true, cloudErr: errors.New("cloud unavailable"), expectedStatus: metav1.ConditionUnknown, expectedReady: true},
+		{desc: "removed with the load balancer"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Status.Conditions = []metav1.Condition{{Type: LoadBalancerProvisioningCondition, Status: metav1.ConditionFalse, Reason: loadBalancerProvisioningReasonEnsured}}
+			if !tc.wantsLB {
+				svc.Spec.Type = v1.ServiceTypeClusterIP
+				svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+			}
+			controller, client := newController(t, newFailingLoadBalancer(tc.cloudErr), svc)
+			controller.enableLBProvisioningCondition = true
+			controller.enableLBReadinessGate = true
+
+			controller.syncLoadBalancerIfNeeded(context.TODO(), svc.DeepCopy(), "default/svc", nil, &cloudprovider.ServiceOptions{})
+
+			updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get service: %v", err)
+			}
+			cond := meta.FindStatusCondition(updated.Status.Conditions, LoadBalancerProvisioningCondition)
+			switch {
+			case tc.expectedStatus == "" && cond != nil:
+				t.Errorf("Expected no %s condition, got %+v", LoadBalancerProvisioningCondition, cond)
+			case tc.expectedStatus != "" && cond == nil:
+				t.Errorf("Expected the %s condition to be %s, got none", LoadBalancerProvisioningCondition, tc.expectedStatus)
+			case tc.expectedStatus != "" && cond.Status != tc.expectedStatus:
+				t.Errorf("Expected the %s condition to be %s, got %s", LoadBalancerProvisioningCondition, tc.expectedStatus, cond.Status)
+			}
+			// Setting one condition must not overwrite the others.
+			if ready := meta.FindStatusCondition(updated.Status.Conditions, LoadBalancerReadyCondition) != nil; ready != tc.expectedReady {
+				t.Errorf("Expected the %s condition to be present: %t, got %t", LoadBalancerReadyCondition, tc.expectedReady, ready)
+			}
+		})
+	}
+}
+
+func TestProcessServiceDeletionForgetsLastSyncedNodes(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	nodes := newNodes(3)
+	var keys []string
+	for i := 0; i < 100; i++ {
+		svc := newLoadBalancerService(fmt.Sprintf("svc-%d", i), fmt.Sprintf("lb-%d", i))
+		key := "default/" + svc.Name
+		controller.cache.set(key, &cachedService{state: svc})
+		controller.storeLastSyncedNodes(svc, nodes)
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if err := controller.processServiceDeletion(context.TODO(), key); err != nil {
+			t.Fatalf("processServiceDeletion(%s) returned unexpected error: %v", key, err)
+		}
+	}
+	if len(controller.lastSyncedNodes) != 0 {
+		t.Errorf("Expected no last synced nodes left, got %d", len(controller.lastSyncedNodes))
+	}
+}
+
+func TestGCLastSyncedNodes(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	cached := newLoadBalancerService("cached", "lb-1")
+	controller.cache.set("default/cached", &cachedService{state: cached})
+	controller.storeLastSyncedNodes(cached, newNodes(1))
+	controller.storeLastSyncedNodes(newLoadBalancerService("deleted", "lb-2"), newNodes(1))
+
+	controller.gcLastSyncedNodes()
+
+	if _, ok := controller.lastSyncedNodes["default/cached"]; !ok || len(controller.lastSyncedNodes) != 1 {
+		t.Errorf("Expected only the nodes of the cached service to be kept, got %v", controller.lastSyncedNodes)
+	}
+}
+
+func TestParallelizeWithContext(t *testing.T) {
+	t.Run("all pieces", func(t *testing.T) {
+		var lock sync.Mutex
+		done := sets.New[int]()
+		parallelizeWithContext(context.Background(), 4, 50, func(ctx context.Context, piece int) {
+			lock.Lock()
+			defer lock.Unlock()
+			done.Insert(piece)
+		})
+		if done.Len() != 50 {
+			t.Errorf("Expected all 50 pieces to be processed, got %d", done.Len())
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var started int32
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			parallelizeWithContext(ctx, 2, 100, func(ctx context.Context, piece int) {
+				if atomic.AddInt32(&started, 1) == 2 {
+					cancel()
+				}
+				// Stands for a cloud provider call honoring the context.
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait.ForeverTestTimeout):
+				}
+			})
+		}()
+
+		select {
+		case <-finished:
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatalf("Expected the pool to stop once the context is cancelled")
+		}
+		if got := atomic.LoadInt32(&started); got > 2 {
+			t.Errorf("Expected no piece to start after the cancellation, got %d started", got)
+		}
+	})
+}
+
+func TestWaitForCacheSync(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	alwaysSynced := func() bool { return true }
+	controller.serviceListerSynced = alwaysSynced
+	controller.endpointSliceListerSynced = alwaysSynced
+	controller.nodeListerSynced = alwaysSynced
+	if err := controller.waitForCacheSync(context.TODO()); err != nil {
+		t.Fatalf("waitForCacheSync() returned unexpected error: %v", err)
+	}
+
+	controller.nodeListerSynced = func() bool { return false }
+	controller.cacheSyncTimeout = 100 * time.Millisecond
+	err := controller.waitForCacheSync(context.TODO())
+	if err == nil || !strings.Contains(err.Error(), "[node]") {
+		t.Errorf("Expected an error naming the node cache, got %v", err)
+	}
+}
+
+func TestSampleQueueDepths(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	controller.serviceQueue.Add("default/svc-1")
+	controller.serviceQueue.Add("default/svc-2")
+	controller.nodeQueue.Add("node-1")
+
+	controller.sampleQueueDepths()
+	for _, tc := range []struct {
+		gauge    metrics.GaugeMetric
+		expected float64
+	}{
+		{gauge: serviceQueueDepth, expected: 2},
+		{gauge: nodeQueueDepth, expected: 1},
+		{gauge: endpointSliceQueueDepth, expected: 0},
+	} {
+		got, err := testutil.GetGaugeMetricValue(tc.gauge)
+		if err != nil {
+			t.Fatalf("failed to read gauge: %v", err)
+		}
+		if got != tc.expected {
+			t.Errorf("Expected queue depth %v, got %v", tc.expected, got)
+		}
+	}
+}
+
+func TestCleanupStaleCache(t *testing.T) {
+	live := newLoadBalancerService("live", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), live)
+	controller.cache.set("default/live", &cachedService{state: live})
+	controller.cache.set("default/gone", &cachedService{state: newLoadBalancerService("gone", "")})
+	controller.cache.set("default/gone-with-lb", &cachedService{state: newLoadBalancerService("gone-with-lb", "lb-2")})
+
+	before, err := testutil.GetCounterMetricValue(staleCacheEntriesCleanedCount)
+	if err != nil {
+		t.Fatalf("Failed to read lb_stale_cache_entries_cleaned_total: %v", err)
+	}
+	controller.cleanupStaleCache()
+
+	if cached := sets.New(controller.cache.ListKeys()...); !cached.Equal(sets.New("default/live", "default/gone-with-lb")) {
+		t.Errorf("Expected the stale entry without load balancer to be removed, got %v", sets.List(cached))
+	}
+	if controller.serviceQueue.Len() != 1 {
+		t.Fatalf("Expected the stale service with a load balancer to be queued, got %d items", controller.serviceQueue.Len())
+	}
+	if key, _ := controller.serviceQueue.Get(); key != "default/gone-with-lb" {
+		t.Errorf("Expected default/gone-with-lb to be queued, got %v", key)
+	}
+	after, err := testutil.GetCounterMetricValue(staleCacheEntriesCleanedCount)
+	if err != nil {
+		t.Fatalf("Failed to read lb_stale_cache_entries_cleaned_total: %v", err)
+	}
+	if after-before != 1 {
+		t.Errorf("Expected lb_stale_cache_entries_cleaned_total to grow by 1, got %v", after-before)
+	}
+}
+
+func TestResyncServices(t *testing.T) {
+	lbSvc := newLoadBalancerService("lb", "lb-1")
+	clusterIP := newLoadBalancerService("cluster-ip", "")
+	clusterIP.Spec.Type = v1.ServiceTypeClusterIP
+	formerLB := clusterIP.DeepCopy()
+	formerLB.Name = "former-lb"
+	formerLB.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), lbSvc, clusterIP, formerLB)
+
+	before, err := testutil.GetCounterMetricValue(startupResyncCount)
+	if err != nil {
+		t.Fatalf("Failed to read lb_startup_resync_total: %v", err)
+	}
+	controller.resyncServices()
+
+	queued := sets.New[string]()
+	for controller.serviceQueue.Len() > 0 {
+		key, _ := controller.serviceQueue.Get()
+		queued.Insert(key.(string))
+		controller.serviceQueue.Done(key)
+	}
+	if expected := sets.New("default/lb", "default/former-lb"); !queued.Equal(expected) {
+		t.Errorf("Expected %v to be queued, got %v", sets.List(expected), sets.List(queued))
+	}
+	after, err := testutil.GetCounterMetricValue(startupResyncCount)
+	if err != nil {
+		t.Fatalf("Failed to read lb_startup_resync_total: %v", err)
+	}
+	if after-before != 2 {
+		t.Errorf("Expected lb_startup_resync_total to grow by 2, got %v", after-before)
+	}
+}
+
+func TestActiveLoadBalancers(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+
+	expectActive := func(expected float64) {
+		t.Helper()
+		got, err := testutil.GetGaugeMetricValue(activeLoadBalancers)
+		if err != nil {
+			t.Fatalf("Failed to read lb_active_total: %v", err)
+		}
+		if got != expected {
+			t.Errorf("Expected %v active load balancers, got %v", expected, got)
+		}
+	}
+
+	for _, lbID := range []string{"lb-1", "lb-2", "lb-1"} {
+		if _, err := controller.ensureLoadBalancer(context.TODO(), svc, nil, lbID, &cloudprovider.ServiceOptions{}); err != nil {
+			t.Fatalf("ensureLoadBalancer() returned unexpected error: %v", err)
+		}
+	}
+	expectActive(2)
+
+	if err := controller.processLoadBalancerDelete(context.TODO(), svc, "default/svc", "lb-1"); err != nil {
+		t.Fatalf("processLoadBalancerDelete() returned unexpected error: %v", err)
+	}
+	expectActive(1)
+}
+
+func TestDeletionGracePeriod(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		deletedAgo    time.Duration
+		expectDeleted bool
+	}{
+		{desc: "within grace period", deletedAgo: 10 * time.Second},
+		{desc: "grace period elapsed", deletedAgo: 2 * time.Minute, expectDeleted: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+			svc.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-tc.deletedAgo)}
+			cloud := fakecloud.NewFakeLoadBalancer()
+			controller, _ := newController(t, cloud, svc)
+			controller.deletionGracePeriod = time.Minute
+
+			_, _, err := controller.syncLoadBalancerIfNeeded(context.TODO(), svc, "default/svc", nil, &cloudprovider.ServiceOptions{})
+			var re *api.RetryError
+			if tc.expectDeleted {
+				if err != nil {
+					t.Fatalf("syncLoadBalancerIfNeeded() returned unexpected error: %v", err)
+				}
+			} else if !errors.As(err, &re) {
+				t.Fatalf("Expected a RetryError, got %v", err)
+			} else if delay := re.RetryAfter(); delay <= 0 || delay > time.Minute-tc.deletedAgo {
+				t.Errorf("Expected to retry within %v, got %v", time.Minute-tc.deletedAgo, delay)
+			}
+			if deleted := cloud.CallCount("delete") != 0; deleted != tc.expectDeleted {
+				t.Errorf("Expected the load balancer to be deleted: %t, got %d delete calls", tc.expectDeleted, cloud.CallCount("delete"))
+			}
+		})
+	}
+}
+
+func TestGroupNodesByZone(t *testing.T) {
+	nodes := newNodes(4)
+	nodes[0].Labels = map[string]string{v1.LabelTopologyZone: "zone-a"}
+	nodes[1].Labels = map[string]string{v1.LabelTopologyZone: "zone-b"}
+	nodes[2].Labels = map[string]string{v1.LabelTopologyZone: "zone-a"}
+
+	expected := map[string][]*v1.Node{
+		"zone-a": {nodes[0], nodes[2]},
+		"zone-b": {nodes[1]},
+		"":       {nodes[3]},
+	}
+	if got := groupNodesByZone(nodes); !reflect.DeepEqual(got, expected) {
+		t.Errorf("groupNodesByZone() = %v, want %v", got, expected)
+	}
+}
+
+// zoneAwareCloud is a fake cloud recording the zone-aware updates.
+type zoneAwareCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	zoneNodes []map[string][]*v1.Node
+}
+
+func (c *zoneAwareCloud) UpdateLoadBalancerZoneAware(ctx context.Context, clusterName string, service *v1.Service, zoneNodes map[string][]*v1.Node) error {
+	c.zoneNodes = append(c.zoneNodes, zoneNodes)
+	return nil
+}
+
+func TestUpdateLoadBalancerHostsZoneAware(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	cloud := &zoneAwareCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer()}
+	controller.balancer = cloud
+	nodes := newNodes(2)
+	nodes[0].Labels = map[string]string{v1.LabelTopologyZone: "zone-a"}
+	nodes[1].Labels = map[string]string{v1.LabelTopologyZone: "zone-b"}
+
+	if err := controller.lockedUpdateLoadBalancerHosts(context.TODO(), svc, nodes); err != nil {
+		t.Fatalf("lockedUpdateLoadBalancerHosts() returned unexpected error: %v", err)
+	}
+	if cloud.CallCount("update") != 0 {
+		t.Errorf("Expected no UpdateLoadBalancer call, got %d", cloud.CallCount("update"))
+	}
+	expected := []map[string][]*v1.Node{{"zone-a": {nodes[0]}, "zone-b": {nodes[1]}}}
+	if !reflect.DeepEqual(cloud.zoneNodes, expected) {
+		t.Errorf("Expected zone-aware updates %v, got %v", expected, cloud.zoneNodes)
+	}
+}
+
+func TestGetLoadBalancerCache(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	balancer := fakecloud.NewFakeLoadBalancer().WithUpdateResult(errors.New("update failed"))
+	controller.balancer = balancer
+	ctx := context.Background()
+	nodes := newNodes(1)
+
+	for i := 0; i < 3; i++ {
+		if err := controller.lockedUpdateLoadBalancerHosts(ctx, svc, nodes); err == nil {
+			t.Fatalf("expected the update to fail")
+		}
+	}
+	if got := balancer.CallCount("get"); got != 1 {
+		t.Errorf("expected 1 GetLoadBalancer call while cached, got %d", got)
+	}
+
+	if _, err := controller.ensureLoadBalancer(ctx, svc, nil, "lb-1", nil); err != nil {
+		t.Fatalf("unexpected ensure error: %v", err)
+	}
+	if err := controller.lockedUpdateLoadBalancerHosts(ctx, svc, nodes); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+	if got := balancer.CallCount("get"); got != 2 {
+		t.Errorf("expected the ensure to invalidate the cache, got %d GetLoadBalancer calls", got)
+	}
+
+	if err := controller.processLoadBalancerDelete(ctx, svc, "default/svc", "lb-1"); err != nil {
+		t.Fatalf("unexpected delete error: %v", err)
+	}
+	if err := controller.lockedUpdateLoadBalancerHosts(ctx, svc, nodes); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+	if got := balancer.CallCount("get"); got != 3 {
+		t.Errorf("expected the delete to invalidate the cache, got %d GetLoadBalancer calls", got)
+	}
+}
+
+func TestFinalizerSurvivesFailedDelete(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	svc.Annotations[ServiceAnnotationLoadBalancerOldID] = "lb-0"
+	svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+	svc.Spec.Type = v1.ServiceTypeClusterIP
+	cloud := newFailingLoadBalancer(errors.New("cloud unavailable"))
+	controller, client := newController(t, cloud, svc)
+
+	getService := func() *v1.Service {
+		service, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Failed to get service: %v", err)
+		}
+		return service
+	}
+
+	var re *api.RetryError
+	if _, _, err := controller.syncLoadBalancerIfNeeded(context.TODO(), getService(), "default/svc", nil, &cloudprovider.ServiceOptions{}); !errors.As(err, &re) {
+		t.Fatalf("Expected a RetryError for the failed delete, got %v", err)
+	}
+	if !servicehelper.HasLBFinalizer(getService()) {
+		t.Fatalf("Expected the finalizer to survive the failed delete")
+	}
+
+	cloud.WithDeleteResult(nil)
+	if _, _, err := controller.syncLoadBalancerIfNeeded(context.TODO(), getService(), "default/svc", nil, &cloudprovider.ServiceOptions{}); err != nil {
+		t.Fatalf("syncLoadBalancerIfNeeded() returned unexpected error: %v", err)
+	}
+	if servicehelper.HasLBFinalizer(getService()) {
+		t.Errorf("Expected the finalizer to be removed once the load balancers are deleted")
+	}
+	if got := cloud.CallCount("delete"); got != 3 {
+		t.Errorf("Expected 3 delete calls (1 failed, 2 succeeded), got %d", got)
+	}
+}
+
+func TestProcessLoadBalancerDeleteWorkers(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-1")
+	cloud := fakecloud.NewFakeLoadBalancer()
+	controller, _ := newController(t, cloud, svc)
+	controller.lbDeletes = semaphore.NewWeighted(1)
+
+	before, err := testutil.GetHistogramMetricCount(loadBalancerDeleteLatency.ObserverMetric)
+	if err != nil {
+		t.Fatalf("Failed to read loadBalancerDeleteLatency: %v", err)
+	}
+	if err := controller.processLoadBalancerDelete(context.TODO(), svc, "default/svc", "lb-1"); err != nil {
+		t.Fatalf("processLoadBalancerDelete() returned unexpected error: %v", err)
+	}
+	after, err := testutil.GetHistogramMetricCount(loadBalancerDeleteLatency.ObserverMetric)
+	if err != nil {
+		t.Fatalf("Failed to read loadBalancerDeleteLatency: %v", err)
+	}
+	if after != before+1 {
+		t.Errorf("Expected one loadBalancerDeleteLatency observation, got %d", after-before)
+	}
+
+	// With all delete workers busy the deletion waits, and gives up with the context.
+	if !controller.lbDeletes.TryAcquire(1) {
+		t.Fatalf("Expected the delete worker to be free")
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	calls := len(cloud.Calls())
+	if err := controller.processLoadBalancerDelete(ctx, svc, "default/svc", "lb-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deletion to wait for a free worker, got %v", err)
+	}
+	if got := cloud.Calls()[calls:]; len(got) != 0 {
+		t.Errorf("Expected no cloud calls while all delete workers are busy, got %v", got)
+	}
+}
+
+// detachCloud is a fake cloud supporting LoadBalancerDetacher.
+type detachCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	detached []string
+}
+
+func (c *detachCloud) DetachLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, lbId string) error {
+	c.detached = append(c.detached, lbId)
+	return nil
+}
+
+func TestProcessLoadBalancerDeletePolicy(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		policy           *string
+		detachSupported  bool
+		expectedDeletes  int
+		expectedDetaches int
+		expectedErr      bool
+	}{
+		{desc: "no policy", detachSupported: true, expectedDeletes: 1},
+		{desc: "delete", policy: stringPtr("delete"), detachSupported: true, expectedDeletes: 1},
+		{desc: "detach", policy: stringPtr("Detach"), detachSupported: true, expectedDetaches: 1},
+		{desc: "detach not supported", policy: stringPtr("detach"), expectedErr: true},
+		{desc: "invalid policy", policy: stringPtr("release"), detachSupported: true, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if tc.policy != nil {
+				svc.Annotations[ServiceAnnotationLoadBalancerDeletePolicy] = *tc.policy
+			}
+			cloud := fakecloud.NewFakeLoadBalancer()
+			controller, _ := newController(t, cloud, svc)
+			detacher := &detachCloud{FakeLoadBalancer: cloud}
+			if tc.detachSupported {
+				controller.balancer = detacher
+			}
+
+			err := controller.processLoadBalancerDelete(context.TODO(), svc, "default/svc", "lb-1")
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("processLoadBalancerDelete() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			deletes := cloud.CallCount("delete")
+			if deletes != tc.expectedDeletes {
+				t.Errorf("Expected %d deletions, got %d", tc.expectedDeletes, deletes)
+			}
+			if len(detacher.detached) != tc.expectedDetaches {
+				t.Errorf("Expected %d detaches, got %v", tc.expectedDetaches, detacher.detached)
+			}
+		})
+	}
+}
+
+func TestRemoveEndpointSliceFinalizer(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		finalizers      []string
+		expectedPatches int
+	}{{
+		desc:            "finalizer present",
+		finalizers:      []string{endpointSliceHelper.LoadBalancerCleanupFinalizer, "example.com/other"},
+		expectedPatches: 1,
+	}, {
+		desc:            "finalizer absent",
+		finalizers:      []string{"example.com/other"},
+		expectedPatches: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			eps := &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:  "default",
+					Name:       "svc-abcde",
+					Labels:     map[string]string{discoveryv1.LabelServiceName: "svc"},
+					Finalizers: tc.finalizers,
+				},
+				AddressType: discoveryv1.AddressTypeIPv4,
+			}
+			controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), eps)
+			client.ClearActions()
+
+			if err := controller.removeEndpointSliceFinalizer(eps); err != nil {
+				t.Fatalf("removeEndpointSliceFinalizer() returned unexpected error: %v", err)
+			}
+			if got := countPatches(client); got != tc.expectedPatches {
+				t.Errorf("Expected %d patch calls, got %d", tc.expectedPatches, got)
+			}
+
+			updated, err := client.DiscoveryV1().EndpointSlices(eps.Namespace).Get(context.TODO(), eps.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get endpointslice: %v", err)
+			}
+			if endpointSliceHelper.HasLBFinalizer(updated) {
+				t.Errorf("Expected the load balancer cleanup finalizer to be removed, got %v", updated.Finalizers)
+			}
+			if len(updated.Finalizers) != 1 || updated.Finalizers[0] != "example.com/other" {
+				t.Errorf("Expected other finalizers to be kept, got %v", updated.Finalizers)
+			}
+		})
+	}
+}
@@ -16,4 +16,22 @@ limitations under the License.
 
 // Package service contains code for syncing cloud load balancers
 // with the service registry.
+//
+// The load balancer of a service is synced by a state machine:
+//
+//	           wants a load balancer
+//	  Idle ------------------------> Ensuring -----------+
+//	   |                               |                 |
+//	   | no load balancer wanted,      |                 | implemented
+//	   | or service being deleted      |                 | elsewhere, or
+//	   v                               v                 | conflict
+//	Deleting ---------------------> Cleanup ---> Done <--+
+//
+// A service of another LoadBalancerClass goes from Idle straight to Done, its
+// load balancer belongs to another controller.
+//
+// Deleting deletes the load balancers and releases the service, Ensuring
+// deletes the old load balancer and ensures the current one, and Cleanup
+// releases the endpoint slices and patches the service status. Any error
+// stops the sync in the state it occurred in.
 package service // import "github.com/inspurDTest/cloud-provider/controllers/service"
@@ -0,0 +1,170 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DynamicExclusionLabelsNamespace and DynamicExclusionLabelsName name the
+	// ConfigMap watched by a DynamicExclusionLabelController.
+	DynamicExclusionLabelsNamespace = "kube-system"
+	DynamicExclusionLabelsName      = "lb-node-exclusion-labels"
+	// DynamicExclusionLabelsKey is the key of the ConfigMap holding the
+	// newline-separated label keys excluding the nodes from the load balancer
+	// backends.
+	DynamicExclusionLabelsKey = "labels"
+)
+
+// DynamicExclusionLabelController excludes the nodes carrying any of the label
+// keys of the kube-system/lb-node-exclusion-labels ConfigMap from the load
+// balancer backends, in addition to the node.kubernetes.io/exclude-from-external-load-balancers
+// label. The predicate is rebuilt whenever the ConfigMap changes. A missing
+// ConfigMap or key excludes no other node, an invalid label key keeps the
+// previous predicate.
+type DynamicExclusionLabelController struct {
+	informer cache.SharedIndexInformer
+
+	// lock protects labels, predicate and onChange.
+	lock      sync.RWMutex
+	labels    sets.Set[string]
+	predicate NodeConditionPredicate
+	// onChange is called after the predicate is rebuilt.
+	onChange func()
+}
+
+// NewDynamicExclusionLabelController returns a DynamicExclusionLabelController
+// watching the kube-system/lb-node-exclusion-labels ConfigMap. It must be
+// handed to New with WithDynamicExclusionLabelController, which runs it.
+func NewDynamicExclusionLabelController(kubeClient clientset.Interface) *DynamicExclusionLabelController {
+	c := &DynamicExclusionLabelController{
+		informer:  newConfigMapInformer(kubeClient, DynamicExclusionLabelsNamespace, DynamicExclusionLabelsName),
+		labels:    sets.New[string](),
+		predicate: exclusionLabelsPredicate(sets.New[string]()),
+	}
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.update(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			c.update(cur)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if isConfigMap(obj, DynamicExclusionLabelsNamespace, DynamicExclusionLabelsName) {
+				c.set("")
+			}
+		},
+	})
+	return c
+}
+
+// Run watches the ConfigMap until stopCh is closed.
+func (c *DynamicExclusionLabelController) Run(stopCh <-chan struct{}) {
+	c.informer.Run(stopCh)
+}
+
+// HasSynced reports whether the ConfigMap was listed once.
+func (c *DynamicExclusionLabelController) HasSynced() bool {
+	return c.informer.HasSynced()
+}
+
+// Predicate returns the predicate of the current exclusion labels. It is safe
+// to call concurrently with the updates of the ConfigMap, the returned
+// predicate doesn't change.
+func (c *DynamicExclusionLabelController) Predicate() NodeConditionPredicate {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.predicate
+}
+
+func (c *DynamicExclusionLabelController) update(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok || !isConfigMap(cm, DynamicExclusionLabelsNamespace, DynamicExclusionLabelsName) {
+		return
+	}
+	c.set(cm.Data[DynamicExclusionLabelsKey])
+}
+
+// set rebuilds the predicate from the newline-separated label keys, unless
+// they didn't change or one is invalid.
+func (c *DynamicExclusionLabelController) set(value string) {
+	labels, err := parseExclusionLabels(value)
+	if err != nil {
+		klog.Errorf("Ignoring invalid %s of ConfigMap %s/%s: %v", DynamicExclusionLabelsKey, DynamicExclusionLabelsNamespace, DynamicExclusionLabelsName, err)
+		return
+	}
+
+	c.lock.Lock()
+	if labels.Equal(c.labels) {
+		c.lock.Unlock()
+		return
+	}
+	klog.V(2).Infof("Excluding the nodes with the labels %v from the load balancer backends", sets.List(labels))
+	c.labels = labels
+	c.predicate = exclusionLabelsPredicate(labels)
+	onChange := c.onChange
+	c.lock.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// setOnChange sets the function called after the predicate is rebuilt.
+func (c *DynamicExclusionLabelController) setOnChange(onChange func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onChange = onChange
+}
+
+// parseExclusionLabels returns the label keys of the lines of value, ignoring
+// the blank lines.
+func parseExclusionLabels(value string) (sets.Set[string], error) {
+	labels := sets.New[string]()
+	for _, line := range strings.Split(value, "\n") {
+		key := strings.TrimSpace(line)
+		if len(key) == 0 {
+			continue
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			return nil, fmt.Errorf("%q is not a valid label key: %s", key, strings.Join(errs, ", "))
+		}
+		labels.Insert(key)
+	}
+	return labels, nil
+}
+
+func exclusionLabelsPredicate(labels sets.Set[string]) NodeConditionPredicate {
+	return func(node *v1.Node) bool {
+		for key := range node.Labels {
+			if labels.Has(key) {
+				return false
+			}
+		}
+		return true
+	}
+}
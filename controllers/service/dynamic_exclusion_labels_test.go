@@ -0,0 +1,124 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newExclusionLabelsConfigMap(labels string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DynamicExclusionLabelsNamespace, Name: DynamicExclusionLabelsName},
+		Data:       map[string]string{DynamicExclusionLabelsKey: labels},
+	}
+}
+
+func TestDynamicExclusionLabelController(t *testing.T) {
+	client := fake.NewSimpleClientset(newExclusionLabelsConfigMap("example.com/maintenance\n"), newClusterInfoConfigMap())
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	controller, err := New(newFakeCloud(fakecloud.NewFakeLoadBalancer()), client,
+		informerFactory.Core().V1().Services(),
+		informerFactory.Discovery().V1().EndpointSlices(),
+		informerFactory.Core().V1().Nodes(),
+		testClusterID, nil,
+		WithDynamicExclusionLabelController(NewDynamicExclusionLabelController(client)),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+
+	// Two nodes in maintenance, one of them draining too.
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i, node := range newNodes(4) {
+		node.Labels = map[string]string{"environment": "prod"}
+		switch i {
+		case 0:
+			node.Labels["example.com/maintenance"] = ""
+		case 1:
+			node.Labels["example.com/maintenance"] = "true"
+			node.Labels["example.com/draining"] = "true"
+		case 2:
+			node.Labels["environment"] = "dev"
+		}
+		node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatalf("Failed to add node to the indexer: %v", err)
+		}
+	}
+	controller.nodeLister = corelisters.NewNodeLister(nodeIndexer)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go controller.dynamicExclusionLabels.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, controller.dynamicExclusionLabels.HasSynced) {
+		t.Fatalf("Failed to sync the node exclusion labels ConfigMap")
+	}
+
+	if got := syncedEnvironments(t, controller); got["prod"] != 1 || got["dev"] != 1 {
+		t.Errorf("Expected the 2 nodes out of maintenance, got %v", got)
+	}
+	// The initial labels queue a node sync too.
+	for controller.nodeQueue.Len() > 0 {
+		key, _ := controller.nodeQueue.Get()
+		controller.nodeQueue.Done(key)
+	}
+
+	if _, err := client.CoreV1().ConfigMaps(DynamicExclusionLabelsNamespace).Update(context.TODO(), newExclusionLabelsConfigMap("example.com/draining\n\n  environment  "), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update the ConfigMap: %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return controller.nodeQueue.Len() == 1, nil
+	}); err != nil {
+		t.Fatalf("Expected the exclusion labels change to queue a node sync: %v", err)
+	}
+	if key, _ := controller.nodeQueue.Get(); key != DynamicExclusionLabelsKey {
+		t.Errorf("Expected the node sync key %q, got %v", DynamicExclusionLabelsKey, key)
+	} else {
+		controller.nodeQueue.Done(key)
+	}
+	if got := syncedEnvironments(t, controller); len(got) != 0 {
+		t.Errorf("Expected every node to be excluded, got %v", got)
+	}
+
+	// An invalid label key keeps the previous labels.
+	controller.dynamicExclusionLabels.set("example.com/draining\nnot a label")
+	if got := syncedEnvironments(t, controller); len(got) != 0 {
+		t.Errorf("Expected the invalid label key to be ignored, got %v", got)
+	}
+
+	// Deleting the ConfigMap excludes no other node.
+	if err := client.CoreV1().ConfigMaps(DynamicExclusionLabelsNamespace).Delete(context.TODO(), DynamicExclusionLabelsName, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete the ConfigMap: %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		got := syncedEnvironments(t, controller)
+		return got["prod"] == 3 && got["dev"] == 1, nil
+	}); err != nil {
+		t.Errorf("Expected all 4 nodes after the ConfigMap deletion: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// DynamicLabelFilterKey is the key of the ConfigMap of a DynamicLabelFilter
+// holding the label selector of the nodes eligible as load balancer backends.
+const DynamicLabelFilterKey = "node-label-filter"
+
+// DynamicLabelFilter restricts the load balancer backends to the nodes
+// matching the label selector of a ConfigMap, e.g. "environment in (prod)".
+// The predicate is rebuilt whenever the ConfigMap changes. A missing
+// ConfigMap or key matches all nodes, an invalid selector keeps the previous
+// predicate.
+type DynamicLabelFilter struct {
+	namespace string
+	name      string
+	informer  cache.SharedIndexInformer
+
+	// lock protects selector, predicate and onChange.
+	lock      sync.RWMutex
+	selector  string
+	predicate NodeConditionPredicate
+	// onChange is called after the predicate is rebuilt.
+	onChange func()
+}
+
+// NewDynamicLabelFilter returns a DynamicLabelFilter watching the ConfigMap
+// namespace/name. It must be handed to New with WithDynamicLabelFilter, which
+// runs it.
+func NewDynamicLabelFilter(kubeClient clientset.Interface, namespace, name string) *DynamicLabelFilter {
+	f := &DynamicLabelFilter{
+		namespace: namespace,
+		name:      name,
+		informer:  newConfigMapInformer(kubeClient, namespace, name),
+		predicate: labelSelectorPredicate(labels.Everything()),
+	}
+	f.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			f.update(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			f.update(cur)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if isConfigMap(obj, namespace, name) {
+				f.set("")
+			}
+		},
+	})
+	return f
+}
+
+// Run watches the ConfigMap until stopCh is closed.
+func (f *DynamicLabelFilter) Run(stopCh <-chan struct{}) {
+	f.informer.Run(stopCh)
+}
+
+// HasSynced reports whether the ConfigMap was listed once.
+func (f *DynamicLabelFilter) HasSynced() bool {
+	return f.informer.HasSynced()
+}
+
+// Predicate returns the predicate of the current label selector. It is safe
+// to call concurrently with the updates of the ConfigMap, the returned
+// predicate doesn't change.
+func (f *DynamicLabelFilter) Predicate() NodeConditionPredicate {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.predicate
+}
+
+func (f *DynamicLabelFilter) update(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok || !isConfigMap(cm, f.namespace, f.name) {
+		return
+	}
+	f.set(cm.Data[DynamicLabelFilterKey])
+}
+
+// set rebuilds the predicate from the label selector, unless it didn't change
+// or is invalid.
+func (f *DynamicLabelFilter) set(selector string) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		klog.Errorf("Ignoring invalid %s %q of ConfigMap %s/%s: %v", DynamicLabelFilterKey, selector, f.namespace, f.name, err)
+		return
+	}
+
+	f.lock.Lock()
+	if selector == f.selector {
+		f.lock.Unlock()
+		return
+	}
+	klog.V(2).Infof("Restricting load balancer backends to the nodes matching %q", selector)
+	f.selector = selector
+	f.predicate = labelSelectorPredicate(parsed)
+	onChange := f.onChange
+	f.lock.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// setOnChange sets the function called after the predicate is rebuilt.
+func (f *DynamicLabelFilter) setOnChange(onChange func()) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.onChange = onChange
+}
+
+func labelSelectorPredicate(selector labels.Selector) NodeConditionPredicate {
+	return func(node *v1.Node) bool {
+		return selector.Matches(labels.Set(node.Labels))
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newNodeLabelFilterConfigMap(selector string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "lb-system", Name: "node-filter"},
+		Data:       map[string]string{DynamicLabelFilterKey: selector},
+	}
+}
+
+// newDynamicLabelFilterController returns a controller whose backends are
+// filtered by a DynamicLabelFilter, with two ready nodes per environment.
+func newDynamicLabelFilterController(t *testing.T, selector string) (*Controller, *fake.Clientset) {
+	t.Helper()
+
+	client := fake.NewSimpleClientset(newNodeLabelFilterConfigMap(selector), newClusterInfoConfigMap())
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	controller, err := New(newFakeCloud(fakecloud.NewFakeLoadBalancer()), client,
+		informerFactory.Core().V1().Services(),
+		informerFactory.Discovery().V1().EndpointSlices(),
+		informerFactory.Core().V1().Nodes(),
+		testClusterID, nil,
+		WithDynamicLabelFilter(NewDynamicLabelFilter(client, "lb-system", "node-filter")),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i, node := range newNodes(4) {
+		node.Labels = map[string]string{"environment": "prod"}
+		if i%2 == 1 {
+			node.Labels["environment"] = "dev"
+		}
+		node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatalf("Failed to add node to the indexer: %v", err)
+		}
+	}
+	controller.nodeLister = corelisters.NewNodeLister(nodeIndexer)
+	return controller, client
+}
+
+// syncedEnvironments returns the environments of the nodes the load balancer
+// of a service should point to.
+func syncedEnvironments(t *testing.T, controller *Controller) map[string]int {
+	t.Helper()
+
+	nodes, _, err := controller.nodesToSync(newLoadBalancerService("svc", "lb-1"))
+	if err != nil {
+		t.Errorf("nodesToSync() failed: %v", err)
+	}
+	environments := make(map[string]int)
+	for _, node := range nodes {
+		environments[node.Labels["environment"]]++
+	}
+	return environments
+}
+
+func TestDynamicLabelFilter(t *testing.T) {
+	controller, client := newDynamicLabelFilterController(t, "environment=prod")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go controller.dynamicLabelFilter.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, controller.dynamicLabelFilter.HasSynced) {
+		t.Fatalf("Failed to sync the node label filter ConfigMap")
+	}
+
+	if got := syncedEnvironments(t, controller); len(got) != 1 || got["prod"] != 2 {
+		t.Errorf("Expected the 2 prod nodes, got %v", got)
+	}
+	// The initial selector queues a node sync too.
+	for controller.nodeQueue.Len() > 0 {
+		key, _ := controller.nodeQueue.Get()
+		controller.nodeQueue.Done(key)
+	}
+
+	if _, err := client.CoreV1().ConfigMaps("lb-system").Update(context.TODO(), newNodeLabelFilterConfigMap("environment in (prod,dev)"), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update the ConfigMap: %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return controller.nodeQueue.Len() == 1, nil
+	}); err != nil {
+		t.Fatalf("Expected the selector change to queue a node sync: %v", err)
+	}
+	if got := syncedEnvironments(t, controller); got["prod"] != 2 || got["dev"] != 2 {
+		t.Errorf("Expected all 4 nodes, got %v", got)
+	}
+
+	// An invalid selector keeps the previous one.
+	controller.dynamicLabelFilter.set("environment in (")
+	if got := syncedEnvironments(t, controller); got["prod"] != 2 || got["dev"] != 2 {
+		t.Errorf("Expected the invalid selector to be ignored, got %v", got)
+	}
+}
+
+func TestDynamicLabelFilterConcurrentUpdates(t *testing.T) {
+	controller, _ := newDynamicLabelFilterController(t, "environment=prod")
+	controller.dynamicLabelFilter.set("environment=prod")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				controller.dynamicLabelFilter.set("environment=dev")
+			} else {
+				controller.dynamicLabelFilter.set("environment=prod")
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		// Each sync sees a single selector: two nodes of one environment.
+		if got := syncedEnvironments(t, controller); len(got) != 1 {
+			t.Fatalf("Expected the nodes of a single environment, got %v", got)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
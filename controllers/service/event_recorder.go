@@ -0,0 +1,243 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultEventDedupWindow is how long the repeats of an event of a service
+	// are dropped by default.
+	defaultEventDedupWindow = 60 * time.Second
+	// maxDedupedEvents bounds the number of distinct events tracked, the
+	// least recently used ones are forgotten first.
+	maxDedupedEvents = 4096
+	// maxPendingCloudEvents bounds the number of events waiting to be
+	// recorded in the cloud, the following ones are dropped.
+	maxPendingCloudEvents = 1024
+)
+
+// dedupKey identifies the repeats of an event.
+type dedupKey struct {
+	namespace string
+	name      string
+	eventtype string
+	reason    string
+	message   string
+}
+
+// deduplicatingEventRecorder is an EventRecorder dropping the events of an
+// object repeating an event, type, reason and message alike, recorded less
+// than window ago, e.g. the SyncLoadBalancerFailed warning of every retry of a
+// service failing the same way. The first repeat after the window is recorded
+// again, and the event correlator of the broadcaster bumps the Count of the
+// existing event instead of creating a new one.
+type deduplicatingEventRecorder struct {
+	recorder record.EventRecorder
+	window   time.Duration
+
+	// lock serializes the lookup and the insertion of the events.
+	lock   sync.Mutex
+	events *utilcache.LRUExpireCache
+}
+
+var _ record.EventRecorder = &deduplicatingEventRecorder{}
+
+func newDeduplicatingEventRecorder(recorder record.EventRecorder, window time.Duration) *deduplicatingEventRecorder {
+	return &deduplicatingEventRecorder{
+		recorder: recorder,
+		window:   window,
+		events:   utilcache.NewLRUExpireCache(maxDedupedEvents),
+	}
+}
+
+func (r *deduplicatingEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.repeated(object, eventtype, reason, message) {
+		return
+	}
+	r.recorder.Event(object, eventtype, reason, message)
+}
+
+func (r *deduplicatingEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *deduplicatingEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if r.repeated(object, eventtype, reason, message) {
+		return
+	}
+	r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+}
+
+// repeated reports whether the event repeats one of the object recorded
+// within the window.
+func (r *deduplicatingEventRecorder) repeated(object runtime.Object, eventtype, reason, message string) bool {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return false
+	}
+	key := dedupKey{namespace: accessor.GetNamespace(), name: accessor.GetName(), eventtype: eventtype, reason: reason, message: message}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.events.Get(key); ok {
+		return true
+	}
+	// The window starts with the recorded event and is not extended by the
+	// dropped repeats, a persisting problem is reported afresh every window.
+	r.events.Add(key, struct{}{}, r.window)
+	return false
+}
+
+// cloudMirroringEventRecorder is an EventRecorder additionally recording the
+// events of the services with a load balancer ID with a CloudEventRecorder,
+// in the background so that a slow cloud doesn't delay the sync. The events
+// are recorded one at a time by run, those exceeding maxPendingCloudEvents
+// are dropped.
+type cloudMirroringEventRecorder struct {
+	recorder    record.EventRecorder
+	cloud       CloudEventRecorder
+	timeout     time.Duration
+	annotations AnnotationConfig
+	events      chan cloudEvent
+}
+
+// cloudEvent is an event waiting to be recorded in the cloud.
+type cloudEvent struct {
+	namespace string
+	name      string
+	lbID      string
+	reason    string
+	message   string
+}
+
+var _ record.EventRecorder = &cloudMirroringEventRecorder{}
+
+func newCloudMirroringEventRecorder(recorder record.EventRecorder, cloud CloudEventRecorder, timeout time.Duration, annotations AnnotationConfig) *cloudMirroringEventRecorder {
+	return &cloudMirroringEventRecorder{
+		recorder:    recorder,
+		cloud:       cloud,
+		timeout:     timeout,
+		annotations: annotations,
+		events:      make(chan cloudEvent, maxPendingCloudEvents),
+	}
+}
+
+// run records the pending events in the cloud until stopCh is closed.
+func (r *cloudMirroringEventRecorder) run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event := <-r.events:
+			r.record(event)
+		}
+	}
+}
+
+func (r *cloudMirroringEventRecorder) record(event cloudEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if err := r.cloud.RecordLBEvent(ctx, event.lbID, event.reason, event.message); err != nil {
+		klog.Warningf("Failed to record event %s of service %s/%s on load balancer %s: %v", event.reason, event.namespace, event.name, event.lbID, err)
+	}
+}
+
+func (r *cloudMirroringEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.mirror(object, reason, message)
+	r.recorder.Event(object, eventtype, reason, message)
+}
+
+func (r *cloudMirroringEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *cloudMirroringEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	r.mirror(object, reason, message)
+	r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+}
+
+// mirror queues the event of a service with a load balancer ID to be recorded
+// in the cloud, or counts it as dropped when too many are pending. Failures
+// are logged only, the event is recorded in Kubernetes regardless.
+func (r *cloudMirroringEventRecorder) mirror(object runtime.Object, reason, message string) {
+	service, ok := object.(*v1.Service)
+	if !ok {
+		return
+	}
+	lbID := r.annotations.loadBalancerID(service)
+	if len(lbID) == 0 {
+		return
+	}
+	select {
+	case r.events <- cloudEvent{namespace: service.Namespace, name: service.Name, lbID: lbID, reason: reason, message: message}:
+	default:
+		eventsDroppedCount.Inc()
+		klog.V(4).Infof("Dropped event %s of service %s/%s on load balancer %s, too many events are pending", reason, service.Namespace, service.Name, lbID)
+	}
+}
+
+// lbIDAnnotatingEventRecorder is an EventRecorder adding the
+// EventAnnotationLoadBalancerID annotation, under the configured domain, to the
+// events of the services with a load balancer ID.
+type lbIDAnnotatingEventRecorder struct {
+	recorder    record.EventRecorder
+	annotations AnnotationConfig
+}
+
+var _ record.EventRecorder = &lbIDAnnotatingEventRecorder{}
+
+func (r *lbIDAnnotatingEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, "%s", message)
+}
+
+func (r *lbIDAnnotatingEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, messageFmt, args...)
+}
+
+func (r *lbIDAnnotatingEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	service, ok := object.(*v1.Service)
+	if !ok || len(r.annotations.loadBalancerID(service)) == 0 {
+		if annotations == nil {
+			r.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+			return
+		}
+		r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+		return
+	}
+	withID := make(map[string]string, len(annotations)+1)
+	for key, value := range annotations {
+		withID[key] = value
+	}
+	withID[r.annotations.Key(EventAnnotationLoadBalancerID)] = r.annotations.loadBalancerID(service)
+	r.recorder.AnnotatedEventf(object, withID, eventtype, reason, messageFmt, args...)
+}
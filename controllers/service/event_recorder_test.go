@@ -0,0 +1,159 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestDeduplicatingEventRecorder(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(100)
+	clock := testingclock.NewFakeClock(time.Now())
+	recorder := newDeduplicatingEventRecorder(fakeRecorder, time.Minute)
+	recorder.events = utilcache.NewLRUExpireCacheWithClock(maxDedupedEvents, clock)
+	svc := newLoadBalancerService("svc", "lb-1")
+	other := newLoadBalancerService("other", "lb-2")
+
+	recorder.Event(svc, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer: timeout")
+	recorder.Eventf(svc, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer: %s", "timeout")
+	recorder.Eventf(svc, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer: %s", "quota exceeded")
+	recorder.Event(svc, v1.EventTypeNormal, EventReasonEnsuringLoadBalancer, "Ensuring load balancer")
+	recorder.Event(other, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer: timeout")
+	clock.Step(time.Minute + time.Second)
+	recorder.Event(svc, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer: timeout")
+
+	expected := []string{
+		"Warning SyncLoadBalancerFailed Error syncing load balancer: timeout",
+		// The repeat is dropped, another message is recorded as is.
+		"Warning SyncLoadBalancerFailed Error syncing load balancer: quota exceeded",
+		"Normal EnsuringLoadBalancer Ensuring load balancer",
+		"Warning SyncLoadBalancerFailed Error syncing load balancer: timeout",
+		// The window expired.
+		"Warning SyncLoadBalancerFailed Error syncing load balancer: timeout",
+	}
+	var got []string
+	for len(fakeRecorder.Events) > 0 {
+		got = append(got, <-fakeRecorder.Events)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %q, got %q", expected, got)
+	}
+}
+
+// chanCloudEventRecorder sends the recorded events to a channel, blocking
+// until they are received.
+type chanCloudEventRecorder struct {
+	events chan string
+}
+
+func (r *chanCloudEventRecorder) RecordLBEvent(ctx context.Context, lbID, reason, message string) error {
+	r.events <- lbID + " " + reason + " " + message
+	return errors.New("cloud event log unavailable")
+}
+
+func TestCloudMirroringEventRecorder(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(100)
+	cloud := &chanCloudEventRecorder{events: make(chan string)}
+	recorder := newCloudMirroringEventRecorder(fakeRecorder, cloud, time.Minute, defaultAnnotations)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go recorder.run(stopCh)
+	svc := newLoadBalancerService("svc", "lb-1")
+	noID := newLoadBalancerService("no-id", "")
+
+	// The cloud recorder blocks, the Kubernetes events are recorded anyway.
+	recorder.Eventf(svc, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer: %s", "timeout")
+	recorder.Event(noID, v1.EventTypeNormal, EventReasonEnsuringLoadBalancer, "Ensuring load balancer")
+	if got := len(fakeRecorder.Events); got != 2 {
+		t.Fatalf("Expected 2 Kubernetes events, got %d", got)
+	}
+
+	select {
+	case event := <-cloud.events:
+		if expected := "lb-1 SyncLoadBalancerFailed Error syncing load balancer: timeout"; event != expected {
+			t.Errorf("Expected cloud event %q, got %q", expected, event)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("Timed out waiting for the cloud event")
+	}
+	select {
+	case event := <-cloud.events:
+		t.Errorf("Expected no cloud event for a service without load balancer ID, got %q", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCloudMirroringEventRecorderDropsOverflow(t *testing.T) {
+	registerMetrics()
+	eventsDroppedCount.Reset()
+	fakeRecorder := record.NewFakeRecorder(maxPendingCloudEvents + 1)
+	recorder := newCloudMirroringEventRecorder(fakeRecorder, NoopCloudEventRecorder{}, time.Minute, defaultAnnotations)
+	svc := newLoadBalancerService("svc", "lb-1")
+
+	// Without run, the cloud events pile up until the queue is full.
+	for i := 0; i <= maxPendingCloudEvents; i++ {
+		recorder.Eventf(svc, v1.EventTypeNormal, EventReasonEnsuringLoadBalancer, "Ensuring load balancer %d", i)
+	}
+	if got := len(fakeRecorder.Events); got != maxPendingCloudEvents+1 {
+		t.Errorf("Expected %d Kubernetes events, got %d", maxPendingCloudEvents+1, got)
+	}
+	if got := len(recorder.events); got != maxPendingCloudEvents {
+		t.Errorf("Expected %d pending cloud events, got %d", maxPendingCloudEvents, got)
+	}
+	dropped, err := testutil.GetCounterMetricValue(eventsDroppedCount)
+	if err != nil {
+		t.Fatalf("Failed to get lb_events_dropped_total: %v", err)
+	}
+	if dropped != 1 {
+		t.Errorf("Expected 1 dropped event, got %v", dropped)
+	}
+}
+
+func TestLBIDAnnotatingEventRecorder(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(100)
+	recorder := &lbIDAnnotatingEventRecorder{recorder: fakeRecorder, annotations: defaultAnnotations}
+	svc := newLoadBalancerService("svc", "lb-1")
+	noID := newLoadBalancerService("no-id", "")
+
+	recorder.Eventf(svc, v1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer lb-id=%s: %s", "lb-1", "timeout")
+	recorder.AnnotatedEventf(svc, map[string]string{"key": "value"}, v1.EventTypeNormal, EventReasonEnsuringLoadBalancer, "Ensuring load balancer")
+	recorder.Event(noID, v1.EventTypeNormal, EventReasonEnsuringLoadBalancer, "Ensuring load balancer")
+
+	expected := []string{
+		"Warning SyncLoadBalancerFailed Error syncing load balancer lb-id=lb-1: timeout map[inspur.com/lb-id:lb-1]",
+		"Normal EnsuringLoadBalancer Ensuring load balancer map[inspur.com/lb-id:lb-1 key:value]",
+		"Normal EnsuringLoadBalancer Ensuring load balancer",
+	}
+	var got []string
+	for len(fakeRecorder.Events) > 0 {
+		got = append(got, <-fakeRecorder.Events)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %q, got %q", expected, got)
+	}
+}
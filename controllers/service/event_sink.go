@@ -0,0 +1,104 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// maxEventLimiters bounds the number of objects whose event rate is
+	// tracked, the least recently used ones are forgotten first.
+	maxEventLimiters = 4096
+	// eventLimiterTTL is how long the limiter of an object without events
+	// is kept.
+	eventLimiterTTL = 10 * time.Minute
+)
+
+// rateLimitedEventSink is an EventSink dropping the events of an object once
+// they exceed qps events per second, with burst headroom. It keeps a
+// misbehaving service from flooding the API server with events.
+type rateLimitedEventSink struct {
+	sink  record.EventSink
+	qps   float32
+	burst int
+
+	// lock serializes the creation of limiters.
+	lock     sync.Mutex
+	limiters *utilcache.LRUExpireCache
+}
+
+var _ record.EventSink = &rateLimitedEventSink{}
+
+func newRateLimitedEventSink(sink record.EventSink, qps float32, burst int) *rateLimitedEventSink {
+	return &rateLimitedEventSink{
+		sink:     sink,
+		qps:      qps,
+		burst:    burst,
+		limiters: utilcache.NewLRUExpireCache(maxEventLimiters),
+	}
+}
+
+func (s *rateLimitedEventSink) Create(event *v1.Event) (*v1.Event, error) {
+	if !s.allow(event) {
+		return event, nil
+	}
+	return s.sink.Create(event)
+}
+
+func (s *rateLimitedEventSink) Update(event *v1.Event) (*v1.Event, error) {
+	if !s.allow(event) {
+		return event, nil
+	}
+	return s.sink.Update(event)
+}
+
+func (s *rateLimitedEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	if !s.allow(event) {
+		return event, nil
+	}
+	return s.sink.Patch(event, data)
+}
+
+// allow reports whether the event fits in the rate of its involved object.
+// Dropped events are counted, and reported to the broadcaster as recorded so
+// that it doesn't retry them.
+func (s *rateLimitedEventSink) allow(event *v1.Event) bool {
+	if s.limiter(event.InvolvedObject).Allow() {
+		return true
+	}
+	eventsDroppedCount.Inc()
+	return false
+}
+
+func (s *rateLimitedEventSink) limiter(object v1.ObjectReference) *rate.Limiter {
+	key := object.Kind + "/" + object.Namespace + "/" + object.Name
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if limiter, ok := s.limiters.Get(key); ok {
+		return limiter.(*rate.Limiter)
+	}
+	limiter := rate.NewLimiter(rate.Limit(s.qps), s.burst)
+	s.limiters.Add(key, limiter, eventLimiterTTL)
+	return limiter
+}
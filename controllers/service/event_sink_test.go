@@ -0,0 +1,84 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// countingEventSink counts the events reaching it.
+type countingEventSink struct {
+	created map[string]int
+}
+
+func (s *countingEventSink) Create(event *v1.Event) (*v1.Event, error) {
+	s.created[event.InvolvedObject.Name]++
+	return event, nil
+}
+
+func (s *countingEventSink) Update(event *v1.Event) (*v1.Event, error) {
+	return event, nil
+}
+
+func (s *countingEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	return event, nil
+}
+
+func TestRateLimitedEventSink(t *testing.T) {
+	registerMetrics()
+	eventsDroppedCount.Reset()
+
+	newEvent := func(name string) *v1.Event {
+		return &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: name + ".event"},
+			InvolvedObject: v1.ObjectReference{Kind: "Service", Namespace: "default", Name: name},
+			Type:           v1.EventTypeWarning,
+			Reason:         EventReasonSyncLoadBalancerFailed,
+		}
+	}
+
+	counting := &countingEventSink{created: map[string]int{}}
+	// A negligible rate makes the burst the only headroom during the test.
+	sink := newRateLimitedEventSink(counting, 0.001, 2)
+	for i := 0; i < 5; i++ {
+		event, err := sink.Create(newEvent("thrashing"))
+		if err != nil || event == nil {
+			t.Fatalf("Create() = %v, %v, expected the event and no error", event, err)
+		}
+	}
+	if _, err := sink.Create(newEvent("quiet")); err != nil {
+		t.Fatalf("Create() returned unexpected error: %v", err)
+	}
+
+	if got := counting.created["thrashing"]; got != 2 {
+		t.Errorf("Expected 2 events of the thrashing service, got %d", got)
+	}
+	if got := counting.created["quiet"]; got != 1 {
+		t.Errorf("Expected 1 event of the quiet service, got %d", got)
+	}
+	dropped, err := testutil.GetCounterMetricValue(eventsDroppedCount)
+	if err != nil {
+		t.Fatalf("Failed to get lb_events_dropped_total: %v", err)
+	}
+	if dropped != 3 {
+		t.Errorf("Expected 3 dropped events, got %v", dropped)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+// Reasons of the events the service controller records on services.
+const (
+	EventReasonEnsuringLoadBalancer            = "EnsuringLoadBalancer"
+	EventReasonSyncLoadBalancerFailed          = "SyncLoadBalancerFailed"
+	EventReasonLoadBalancerSynced              = "LoadBalancerSynced"
+	EventReasonDeletingLoadBalancer            = "DeletingLoadBalancer"
+	EventReasonDeletedLoadBalancer             = "DeletedLoadBalancer"
+	EventReasonDeleteLoadBalancerFailed        = "DeleteLoadBalancerFailed"
+	EventReasonUpdatedLoadBalancer             = "UpdatedLoadBalancer"
+	EventReasonUpdateLoadBalancerFailed        = "UpdateLoadBalancerFailed"
+	EventReasonUnAvailableLoadBalancer         = "UnAvailableLoadBalancer"
+	EventReasonEmptyLoadBalancerIngress        = "EmptyLoadBalancerIngress"
+	EventReasonInvalidLoadBalancerAnnotation   = "InvalidLoadBalancerAnnotation"
+	EventReasonInvalidLoadBalancerSourceRanges = "InvalidLoadBalancerSourceRanges"
+	EventReasonLoadBalancerStatusDrift         = "LoadBalancerStatusDrift"
+	EventReasonUnmanagedLoadBalancer           = "UnmanagedLoadBalancer"
+	EventReasonInvalidLoadBalancerConfig       = "InvalidLoadBalancerConfig"
+	EventReasonLoadBalancerChargeTypeChanged   = "LoadBalancerChargeTypeChanged"
+	EventReasonLoadBalancerBandwidthReduction  = "LoadBalancerBandwidthReduction"
+	EventReasonLoadBalancerEIPRequested        = "LoadBalancerEIPRequested"
+	EventReasonLoadBalancerCertificateIgnored  = "LoadBalancerCertificateIgnored"
+	EventReasonNoReadyEndpoints                = "NoReadyEndpoints"
+	EventReasonAdminStateUnsupported           = "AdminStateUnsupported"
+	EventReasonConflict                        = "conflict"
+)
+
+// Reasons of the events recording which attribute of a service changed and
+// requires the load balancer to be updated.
+const (
+	EventReasonType                     = "Type"
+	EventReasonLoadBalancerSourceRanges = "LoadBalancerSourceRanges"
+	EventReasonLoadBalancerIP           = "LoadbalancerIP"
+	EventReasonExternalIP               = "ExternalIP"
+	EventReasonUID                      = "UID"
+	EventReasonExternalTrafficPolicy    = "ExternalTrafficPolicy"
+	EventReasonHealthCheckNodePort      = "HealthCheckNodePort"
+	EventReasonIPFamilies               = "IPFamilies"
+	EventReasonLoadBalancerAlgorithm    = "LoadBalancerAlgorithm"
+	EventReasonPreserveClientIP         = "PreserveClientIP"
+	EventReasonLoadBalancerTags         = "LoadBalancerTags"
+	EventReasonLoadBalancerBandwidth    = "LoadBalancerBandwidth"
+	EventReasonSecurityGroups           = "SecurityGroups"
+	EventReasonLoadBalancerCertificate  = "LoadBalancerCertificate"
+	EventReasonSessionPersistence       = "SessionPersistence"
+	EventReasonCrossZone                = "CrossZone"
+	EventReasonLoadBalancerLogging      = "LoadBalancerLogging"
+	EventReasonLoadBalancerAdminState   = "LoadBalancerAdminState"
+	EventReasonConnectionTimeouts       = "ConnectionTimeouts"
+)
+
+// EventAnnotationLoadBalancerID is the annotation of the events of a service
+// holding the ID of its load balancer, correlating them with the cloud audit
+// logs.
+const EventAnnotationLoadBalancerID = DefaultAnnotationPrefix + "/lb-id"
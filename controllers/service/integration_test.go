@@ -0,0 +1,144 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	servicehelper "github.com/inspurDTest/cloud-provider/service/helpers"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestServiceLifecycle creates, updates and deletes a service through the
+// fake clientset, with running informers, and checks the load balancer calls
+// of each sync.
+func TestServiceLifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset(newClusterInfoConfigMap())
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	balancer := fakecloud.NewFakeLoadBalancer().WithEnsureResult(&v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "192.0.2.1"}}}, nil)
+	controller, err := New(newFakeCloud(balancer), client,
+		informerFactory.Core().V1().Services(),
+		informerFactory.Discovery().V1().EndpointSlices(),
+		informerFactory.Core().V1().Nodes(),
+		testClusterID, nil,
+	)
+	if err != nil {
+		t.Fatalf("Failed to create service controller: %v", err)
+	}
+	controller.eventRecorder = record.NewFakeRecorder(100)
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+
+	// waitForService waits for the informer to see the service matching cond.
+	waitForService := func(cond func(svc *v1.Service) bool) {
+		t.Helper()
+		if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+			svc, err := controller.serviceLister.Services("default").Get("svc")
+			return err == nil && cond(svc), nil
+		}); err != nil {
+			t.Fatalf("Timed out waiting for the informer to see the service: %v", err)
+		}
+	}
+	lastEnsure := func() fakecloud.LoadBalancerCall {
+		t.Helper()
+		var last fakecloud.LoadBalancerCall
+		for _, call := range balancer.Calls() {
+			if call.Method == "ensure" {
+				last = call
+			}
+		}
+		return last
+	}
+
+	// Create.
+	if _, err := client.CoreV1().Services("default").Create(ctx, newLoadBalancerService("svc", "lb-1"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	waitForService(func(*v1.Service) bool { return true })
+	if err := controller.syncService(ctx, "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error on creation: %v", err)
+	}
+	if got := balancer.CallCount("ensure"); got != 1 {
+		t.Fatalf("Expected 1 EnsureLoadBalancer call after creation, got %d", got)
+	}
+	if call := lastEnsure(); call.ClusterName != testClusterID || call.LBID != "lb-1" || call.Service.Spec.Ports[0].Port != 80 {
+		t.Errorf("Expected the load balancer lb-1 of cluster %s to be ensured for port 80, got cluster %s, load balancer %s, ports %v", testClusterID, call.ClusterName, call.LBID, call.Service.Spec.Ports)
+	}
+	svc, err := client.CoreV1().Services("default").Get(ctx, "svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	if !servicehelper.HasLBFinalizer(svc) {
+		t.Errorf("Expected the service to have the load balancer finalizer after creation")
+	}
+	if len(svc.Status.LoadBalancer.Ingress) != 1 || svc.Status.LoadBalancer.Ingress[0].IP != "192.0.2.1" {
+		t.Errorf("Expected the service status to report the load balancer ingress, got %v", svc.Status.LoadBalancer)
+	}
+
+	// Update the port.
+	svc.Spec.Ports[0].Port = 8080
+	if _, err := client.CoreV1().Services("default").Update(ctx, svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update service: %v", err)
+	}
+	waitForService(func(svc *v1.Service) bool { return svc.Spec.Ports[0].Port == 8080 })
+	if err := controller.syncService(ctx, "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error on update: %v", err)
+	}
+	if got := balancer.CallCount("ensure"); got != 2 {
+		t.Fatalf("Expected 2 EnsureLoadBalancer calls after the update, got %d", got)
+	}
+	if call := lastEnsure(); call.Service.Spec.Ports[0].Port != 8080 {
+		t.Errorf("Expected the load balancer to be ensured for port 8080, got %v", call.Service.Spec.Ports)
+	}
+
+	// Delete: the fake clientset ignores finalizers, the deletion is marked
+	// like the API server does.
+	svc, err = client.CoreV1().Services("default").Get(ctx, "svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	now := metav1.Now()
+	svc.DeletionTimestamp = &now
+	if _, err := client.CoreV1().Services("default").Update(ctx, svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to mark the service deleted: %v", err)
+	}
+	waitForService(func(svc *v1.Service) bool { return svc.DeletionTimestamp != nil })
+	if err := controller.syncService(ctx, "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error on deletion: %v", err)
+	}
+	if got := balancer.CallCount("delete"); got != 1 {
+		t.Fatalf("Expected 1 EnsureLoadBalancerDeleted call after the deletion, got %d", got)
+	}
+	svc, err = client.CoreV1().Services("default").Get(ctx, "svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	if servicehelper.HasLBFinalizer(svc) {
+		t.Errorf("Expected the load balancer finalizer to be removed after the deletion")
+	}
+}
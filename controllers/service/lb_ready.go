@@ -0,0 +1,110 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	servicehelper "github.com/inspurDTest/cloud-provider/service/helpers"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LoadBalancerReadyCondition is the service status condition reporting
+	// whether the load balancer of the service is provisioned. Services have
+	// no readiness gates, clients wait for this condition instead.
+	LoadBalancerReadyCondition = "cloud.inspur.com/lb-ready"
+
+	loadBalancerReadyReasonProvisioning = "Provisioning"
+	loadBalancerReadyReasonProvisioned  = "Provisioned"
+
+	// LoadBalancerProvisioningCondition is the service status condition
+	// reporting whether the load balancer of the service is being ensured:
+	// True while the cloud provider is called, False once it succeeded and
+	// Unknown if it failed.
+	LoadBalancerProvisioningCondition = "cloud.inspur.com/LoadBalancerProvisioning"
+
+	loadBalancerProvisioningReasonEnsuring = "Ensuring"
+	loadBalancerProvisioningReasonEnsured  = "Ensured"
+	loadBalancerProvisioningReasonFailed   = "EnsureFailed"
+)
+
+// setLoadBalancerReadyCondition patches the lb-ready condition of the service,
+// unless it already has the given status and reason. The service is updated in
+// place with the new conditions.
+func (c *Controller) setLoadBalancerReadyCondition(service *v1.Service, status metav1.ConditionStatus, reason, message string) error {
+	return c.patchCondition(service, LoadBalancerReadyCondition, status, reason, message)
+}
+
+// setLoadBalancerProvisioningCondition patches the LoadBalancerProvisioning
+// condition of the service, like setLoadBalancerReadyCondition.
+func (c *Controller) setLoadBalancerProvisioningCondition(service *v1.Service, status metav1.ConditionStatus, reason, message string) error {
+	return c.patchCondition(service, LoadBalancerProvisioningCondition, status, reason, message)
+}
+
+// patchCondition patches the condition of the given type of the service,
+// unless it already has the given status and reason. The other conditions are
+// left untouched. The service is updated in place with the new conditions.
+func (c *Controller) patchCondition(service *v1.Service, conditionType string, status metav1.ConditionStatus, reason, message string) error {
+	if cond := meta.FindStatusCondition(service.Status.Conditions, conditionType); cond != nil && cond.Status == status && cond.Reason == reason {
+		return nil
+	}
+
+	// Make a copy so we don't mutate the shared informer cache.
+	updated := service.DeepCopy()
+	meta.SetStatusCondition(&updated.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: service.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+
+	klog.V(2).Infof("Setting condition %s=%s for service %s/%s", conditionType, status, service.Namespace, service.Name)
+	if _, err := servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated); err != nil {
+		return err
+	}
+	service.Status.Conditions = updated.Status.Conditions
+	return nil
+}
+
+// removeLoadBalancerConditions patches the service to remove the conditions
+// reporting the state of its load balancer, if present. The service is
+// updated in place.
+func (c *Controller) removeLoadBalancerConditions(service *v1.Service) error {
+	conditionTypes := []string{LoadBalancerReadyCondition, LoadBalancerProvisioningCondition}
+	// Make a copy so we don't mutate the shared informer cache.
+	updated := service.DeepCopy()
+	removed := false
+	for _, conditionType := range conditionTypes {
+		if meta.FindStatusCondition(updated.Status.Conditions, conditionType) != nil {
+			meta.RemoveStatusCondition(&updated.Status.Conditions, conditionType)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	klog.V(2).Infof("Removing conditions %v from service %s/%s", conditionTypes, service.Namespace, service.Name)
+	if _, err := servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated); err != nil {
+		return err
+	}
+	service.Status.Conditions = updated.Status.Conditions
+	return nil
+}
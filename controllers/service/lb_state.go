@@ -0,0 +1,326 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	"github.com/inspurDTest/cloud-provider/api"
+	endpointSliceHelper "github.com/inspurDTest/cloud-provider/endpointslice/helpers"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// lbState is a state of the load balancer sync of a service.
+type lbState int
+
+const (
+	// lbStateIdle decides whether the load balancer is ensured or deleted.
+	lbStateIdle lbState = iota
+	// lbStateDeleting deletes the load balancers of the service and releases
+	// the service.
+	lbStateDeleting
+	// lbStateEnsuring deletes the old load balancer, if any, and ensures the
+	// current one.
+	lbStateEnsuring
+	// lbStateCleanup releases the endpoint slices, removes the old load
+	// balancer ID and patches the service status.
+	lbStateCleanup
+	// lbStateDone ends the sync.
+	lbStateDone
+)
+
+func (s lbState) String() string {
+	switch s {
+	case lbStateIdle:
+		return "Idle"
+	case lbStateDeleting:
+		return "Deleting"
+	case lbStateEnsuring:
+		return "Ensuring"
+	case lbStateCleanup:
+		return "Cleanup"
+	case lbStateDone:
+		return "Done"
+	}
+	return fmt.Sprintf("lbState(%d)", int(s))
+}
+
+// lbSync holds the progress of the load balancer sync of a service, shared by
+// the state handlers.
+type lbSync struct {
+	service        *v1.Service
+	key            string
+	endpointSlices []*discoveryv1.EndpointSlice
+	options        *cloudprovider.ServiceOptions
+
+	op             loadBalancerOperation
+	previousStatus *v1.LoadBalancerStatus
+	newStatus      *v1.LoadBalancerStatus
+	// cloudSynced reports whether a load balancer was ensured or deleted in
+	// the cloud, as opposed to skipped.
+	cloudSynced bool
+}
+
+// lbStateHandler does the work of a state and returns the next state.
+type lbStateHandler func(c *Controller, ctx context.Context, lbs *lbSync) (lbState, error)
+
+var (
+	lbStateHandlers = map[lbState]lbStateHandler{
+		lbStateIdle:     (*Controller).syncIdle,
+		lbStateDeleting: (*Controller).syncDeleting,
+		lbStateEnsuring: (*Controller).syncEnsuring,
+		lbStateCleanup:  (*Controller).syncCleanup,
+	}
+	// lbStateTransitions lists the states each state may move to.
+	lbStateTransitions = map[lbState]sets.Set[lbState]{
+		lbStateIdle:     sets.New(lbStateDeleting, lbStateEnsuring, lbStateDone),
+		lbStateDeleting: sets.New(lbStateCleanup),
+		// The sync ends right away when the load balancer is implemented
+		// elsewhere or conflicts with another one.
+		lbStateEnsuring: sets.New(lbStateCleanup, lbStateDone),
+		lbStateCleanup:  sets.New(lbStateDone),
+	}
+)
+
+func (c *Controller) syncIdle(ctx context.Context, lbs *lbSync) (lbState, error) {
+	// The load balancer of another LoadBalancerClass is neither ensured nor
+	// deleted, even if the service doesn't want one from this controller.
+	if c.ownedByOtherClass(lbs.service) {
+		lbs.op = skipLoadBalancer
+		return lbStateDone, nil
+	}
+	// Delete the load balancer if service no longer wants one, or if service needs cleanup.
+	if !c.wantsLoadBalancer(lbs.service) || needsCleanup(lbs.service) {
+		lbs.op = deleteLoadBalancer
+		return lbStateDeleting, nil
+	}
+	// Create or update the load balancer if service wants one.
+	lbs.op = ensureLoadBalancer
+	return lbStateEnsuring, nil
+}
+
+func (c *Controller) syncDeleting(ctx context.Context, lbs *lbSync) (lbState, error) {
+	service := lbs.service
+	// Leave long-lived connections time to drain before the load balancer
+	// goes away.
+	if service.DeletionTimestamp != nil {
+		if remaining := c.deletionGracePeriod - time.Since(service.DeletionTimestamp.Time); remaining > 0 {
+			return lbStateDeleting, api.NewRetryError(fmt.Sprintf("waiting %v for the connections of the deleted service to drain", remaining.Round(time.Second)), remaining)
+		}
+	}
+	lbs.newStatus = &v1.LoadBalancerStatus{}
+
+	// Delete both the old and the current load balancer before touching
+	// the finalizer. If any deletion fails the finalizer must survive, so
+	// that the service can't go away and the deletion is retried.
+	for _, annotation := range []string{c.annotations.Key(ServiceAnnotationLoadBalancerOldID), c.annotations.Key(ServiceAnnotationLoadBalancerID)} {
+		lbID := getStringFromServiceAnnotation(service, annotation, "")
+		if len(lbID) == 0 {
+			continue
+		}
+		if err := c.processLoadBalancerDelete(ctx, service, "", lbID); err != nil {
+			return lbStateDeleting, fmt.Errorf("failed to delete load balancer %s: %w", lbID, err)
+		}
+		lbs.cloudSynced = lbs.cloudSynced || c.managesLoadBalancer(lbID)
+	}
+
+	if err := c.removeLoadBalancerConditions(service); err != nil {
+		return lbStateDeleting, fmt.Errorf("failed to remove the load balancer conditions: %v", err)
+	}
+
+	// Only remove the finalizer once all load balancers are deleted, this ensures
+	// Services can be deleted after all corresponding load balancer resources are deleted.
+	if err := c.removeFinalizer(service); err != nil {
+		return lbStateDeleting, fmt.Errorf("failed to remove load balancer cleanup finalizer: %v", err)
+	}
+	// remove new loadbalace annotation
+	if err := c.removeAnnotationLbId(service, c.annotations.Key(ServiceAnnotationLoadBalancerID)); err != nil {
+		return lbStateDeleting, err
+	}
+
+	c.eventRecorder.Eventf(service, v1.EventTypeNormal, EventReasonDeletedLoadBalancer, "Deleted load balancer lb-id=%s", c.annotations.loadBalancerID(service))
+	return lbStateCleanup, nil
+}
+
+// validateLBConfig checks the load balancer of the service against its VPC,
+// if the service has an lb-vpc-id annotation and the cloud provider supports
+// it.
+func (c *Controller) validateLBConfig(ctx context.Context, service *v1.Service, options *cloudprovider.ServiceOptions) error {
+	validator, ok := c.balancer.(cloudprovider.LBConfigValidator)
+	if !ok || options == nil || len(options.VpcID) == 0 {
+		return nil
+	}
+	lbID := getStringFromServiceAnnotation(service, c.annotations.Key(ServiceAnnotationLoadBalancerID), "")
+	return c.callCloud(ctx, "validate", func(ctx context.Context) error {
+		return validator.ValidateLBConfig(ctx, lbID, options.VpcID)
+	})
+}
+
+func (c *Controller) syncEnsuring(ctx context.Context, lbs *lbSync) (lbState, error) {
+	service, key := lbs.service, lbs.key
+	klog.V(2).Infof("Ensuring load balancer for service %s", key)
+
+	// Invalid source ranges are rejected by the cloud with opaque errors,
+	// retrying is pointless until the user fixes the spec.
+	if _, err := endpointSliceHelper.GetLoadBalancerSourceRanges(service); err != nil {
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonInvalidLoadBalancerSourceRanges, "Error validating load balancer source ranges: %v", err)
+		return lbStateEnsuring, &nonRetryableError{err: err}
+	}
+
+	// Leave the load balancers provisioned outside of the cluster alone.
+	if lbID := getStringFromServiceAnnotation(service, c.annotations.Key(ServiceAnnotationLoadBalancerID), ""); len(lbID) != 0 && !c.managesLoadBalancer(lbID) {
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonUnmanagedLoadBalancer, "Load balancer %s is not in the managed load balancer allowlist", lbID)
+		return lbStateDone, nil
+	}
+
+	// A load balancer outside of its VPC can't be ensured, don't spend the API
+	// quota retrying until the user fixes the annotations.
+	if err := c.validateLBConfig(ctx, service, lbs.options); err != nil {
+		if !errors.Is(err, cloudprovider.ErrInvalidLBConfig) {
+			return lbStateEnsuring, fmt.Errorf("failed to validate load balancer configuration: %w", err)
+		}
+		c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonInvalidLoadBalancerConfig, "Error validating load balancer configuration: %v", err)
+		return lbStateDone, nil
+	}
+
+	// Always add a finalizer prior to creating load balancers, this ensures Services
+	// can't be deleted until all corresponding load balancer resources are also deleted.
+	if err := c.addFinalizer(service); err != nil {
+		return lbStateEnsuring, fmt.Errorf("failed to add load balancer cleanup finalizer: %v", err)
+	}
+
+	// Report the load balancer as not ready until it is provisioned.
+	if c.enableLBReadinessGate && meta.FindStatusCondition(service.Status.Conditions, LoadBalancerReadyCondition) == nil {
+		if err := c.setLoadBalancerReadyCondition(service, metav1.ConditionFalse, loadBalancerReadyReasonProvisioning, "Load balancer is being provisioned"); err != nil {
+			return lbStateEnsuring, fmt.Errorf("failed to set the %s condition: %v", LoadBalancerReadyCondition, err)
+		}
+	}
+
+	for _, eps := range lbs.endpointSlices {
+		if err := c.addEndpointSliceFinalizer(eps); err != nil {
+			return lbStateEnsuring, fmt.Errorf("failed to add load balancer cleanup finalizer to eps:%+v, err: %v", eps, err)
+		}
+	}
+
+	// 处理旧的oldLoadbalancer 使用ensureLoadBalancerDeleted
+	oldLbID := getStringFromServiceAnnotation(service, c.annotations.Key(ServiceAnnotationLoadBalancerOldID), "")
+	if len(oldLbID) != 0 {
+		err := c.processLoadBalancerDelete(ctx, service, "", oldLbID)
+		// only remove oldlbID，newstatus is remove all status. other  newstatus is add or update status
+		lbs.newStatus = &v1.LoadBalancerStatus{}
+		if err != nil {
+			return lbStateEnsuring, fmt.Errorf("failed to delete  old load balancer,loadbalancer id: %s, err: %w", oldLbID, err)
+		}
+		lbs.cloudSynced = c.managesLoadBalancer(oldLbID)
+	}
+
+	//  处理新的new Loadbalancer
+	lbID := getStringFromServiceAnnotation(service, c.annotations.Key(ServiceAnnotationLoadBalancerID), "")
+	if len(lbID) != 0 && len(oldLbID) == 0 && c.loadBalancerUpToDate(ctx, key, service, lbs.endpointSlices) {
+		klog.V(4).Infof("Load balancer of service %s is up to date, skipping ensure", key)
+		lbs.newStatus = lbs.previousStatus
+	} else if len(lbID) != 0 {
+		if c.enableLBProvisioningCondition {
+			if err := c.setLoadBalancerProvisioningCondition(service, metav1.ConditionTrue, loadBalancerProvisioningReasonEnsuring, "Load balancer is being ensured"); err != nil {
+				return lbStateEnsuring, fmt.Errorf("failed to set the %s condition: %v", LoadBalancerProvisioningCondition, err)
+			}
+		}
+		newStatus, err := c.ensureLoadBalancer(ctx, service, lbs.endpointSlices, lbID, lbs.options)
+		if c.enableLBProvisioningCondition {
+			condStatus, reason, message := metav1.ConditionFalse, loadBalancerProvisioningReasonEnsured, "Load balancer is ensured"
+			if err != nil {
+				condStatus, reason, message = metav1.ConditionUnknown, loadBalancerProvisioningReasonFailed, fmt.Sprintf("Error ensuring load balancer: %v", err)
+			}
+			if condErr := c.setLoadBalancerProvisioningCondition(service, condStatus, reason, message); condErr != nil {
+				klog.Errorf("Failed to set the %s condition of service %s: %v", LoadBalancerProvisioningCondition, key, condErr)
+			}
+		}
+		if err != nil {
+			if err == cloudprovider.ImplementedElsewhere {
+				// ImplementedElsewhere indicates that the ensureLoadBalancer is a nop and the
+				// functionality is implemented by a different controller.  In this case, we
+				// return immediately without doing anything.
+				klog.V(4).Infof("LoadBalancer for service %s implemented by a different controller %s, Ignoring error", key, c.cloud.ProviderName())
+				return lbStateDone, nil
+			}
+			if strings.Contains(strings.ToLower(err.Error()), "conflict") {
+				// The load balancer conflicts with another one, report it and
+				// stop without retrying.
+				c.eventRecorder.Event(service, v1.EventTypeWarning, EventReasonConflict, strings.ToLower(err.Error()))
+				return lbStateDone, nil
+			}
+			// Use %w deliberately so that a returned RetryError can be handled.
+			return lbStateEnsuring, fmt.Errorf("failed to ensure load balancer: %w", err)
+		}
+		if newStatus == nil {
+			return lbStateEnsuring, fmt.Errorf("service status returned by EnsureLoadBalancer is nil")
+		}
+		lbs.newStatus = newStatus
+		lbs.cloudSynced = true
+		if len(newStatus.Ingress) == 0 {
+			c.eventRecorder.Eventf(service, v1.EventTypeWarning, EventReasonEmptyLoadBalancerIngress, "Load balancer lb-id=%s status returned by the cloud provider has no ingress", lbID)
+			// Keep the external IPs until the cloud provider returns valid
+			// data, unless they belong to the old load balancer.
+			if c.preserveIngressOnEmpty && len(oldLbID) == 0 {
+				lbs.newStatus = lbs.previousStatus
+			}
+		}
+	}
+	if c.enableLBReadinessGate && len(lbID) != 0 && lbs.newStatus != nil && len(lbs.newStatus.Ingress) != 0 {
+		if err := c.setLoadBalancerReadyCondition(service, metav1.ConditionTrue, loadBalancerReadyReasonProvisioned, "Load balancer is provisioned"); err != nil {
+			return lbStateEnsuring, fmt.Errorf("failed to set the %s condition: %v", LoadBalancerReadyCondition, err)
+		}
+	}
+	return lbStateCleanup, nil
+}
+
+func (c *Controller) syncCleanup(ctx context.Context, lbs *lbSync) (lbState, error) {
+	service := lbs.service
+
+	// remove  finalizer, when eps have DeletionTimestamp
+	if err := c.removeEndpointSliceFinalizerByService(service); err != nil {
+		return lbStateCleanup, err
+	}
+
+	// remove old loadbalace annotation
+	if err := c.removeAnnotationLbId(service, c.annotations.Key(ServiceAnnotationLoadBalancerOldID)); err != nil {
+		return lbStateCleanup, err
+	}
+	klog.V(4).Infof("previousStatus  %v,newStatus %v", lbs.previousStatus, lbs.newStatus)
+
+	if err := c.patchStatus(service, lbs.previousStatus, lbs.newStatus); err != nil {
+		// Only retry error that isn't not found:
+		// - Not found error mostly happens when service disappears right after
+		//   we remove the finalizer.
+		// - We can't patch status on non-exist service anyway.
+		if !apierrors.IsNotFound(err) {
+			return lbStateCleanup, fmt.Errorf("failed to update load balancer status: %v", err)
+		}
+	}
+	return lbStateDone, nil
+}
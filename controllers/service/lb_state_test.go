@@ -0,0 +1,150 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLBStateTables(t *testing.T) {
+	for state := lbStateIdle; state < lbStateDone; state++ {
+		if _, ok := lbStateHandlers[state]; !ok {
+			t.Errorf("State %v has no handler", state)
+		}
+		if len(lbStateTransitions[state]) == 0 {
+			t.Errorf("State %v has no transition", state)
+		}
+	}
+	if _, ok := lbStateHandlers[lbStateDone]; ok {
+		t.Errorf("Expected %v to be final", lbStateDone)
+	}
+}
+
+func TestLBStateMachine(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		wantsLB    bool
+		cloudErr   error
+		expectedOp loadBalancerOperation
+		expected   []lbState
+	}{
+		{desc: "ensure", wantsLB: true, expectedOp: ensureLoadBalancer, expected: []lbState{lbStateEnsuring, lbStateCleanup, lbStateDone}},
+		{desc: "implemented elsewhere", wantsLB: true, cloudErr: cloudprovider.ImplementedElsewhere, expectedOp: ensureLoadBalancer, expected: []lbState{lbStateEnsuring, lbStateDone}},
+		{desc: "delete", expectedOp: deleteLoadBalancer, expected: []lbState{lbStateDeleting, lbStateCleanup, lbStateDone}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			if !tc.wantsLB {
+				svc.Spec.Type = v1.ServiceTypeClusterIP
+			}
+			controller, _ := newController(t, newFailingLoadBalancer(tc.cloudErr), svc)
+			lbs := &lbSync{service: svc, key: "default/svc", options: &cloudprovider.ServiceOptions{}, previousStatus: &v1.LoadBalancerStatus{}}
+
+			var visited []lbState
+			for state := lbStateIdle; state != lbStateDone; {
+				next, err := lbStateHandlers[state](controller, context.TODO(), lbs)
+				if err != nil {
+					t.Fatalf("State %v returned unexpected error: %v", state, err)
+				}
+				if !lbStateTransitions[state].Has(next) {
+					t.Fatalf("Unexpected transition from %v to %v", state, next)
+				}
+				visited = append(visited, next)
+				state = next
+			}
+			if len(visited) != len(tc.expected) {
+				t.Fatalf("Expected states %v, got %v", tc.expected, visited)
+			}
+			for i := range visited {
+				if visited[i] != tc.expected[i] {
+					t.Fatalf("Expected states %v, got %v", tc.expected, visited)
+				}
+			}
+			if lbs.op != tc.expectedOp {
+				t.Errorf("Expected operation %v, got %v", tc.expectedOp, lbs.op)
+			}
+		})
+	}
+}
+
+// vpcCloud is a fake cloud validating the VPC of the load balancers.
+type vpcCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	err error
+}
+
+func (c *vpcCloud) ValidateLBConfig(ctx context.Context, lbID, vpcID string) error {
+	return c.err
+}
+
+func TestSyncEnsuringValidatesLBConfig(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		vpcID           string
+		validateErr     error
+		expectedState   lbState
+		expectedErr     bool
+		expectedEnsures int
+	}{
+		{desc: "no VPC", validateErr: cloudprovider.ErrInvalidLBConfig, expectedState: lbStateCleanup, expectedEnsures: 1},
+		{desc: "valid", vpcID: "vpc-1", expectedState: lbStateCleanup, expectedEnsures: 1},
+		{desc: "invalid", vpcID: "vpc-1", validateErr: fmt.Errorf("vpc-1 doesn't exist: %w", cloudprovider.ErrInvalidLBConfig), expectedState: lbStateDone},
+		{desc: "transient error", vpcID: "vpc-1", validateErr: errors.New("timeout"), expectedState: lbStateEnsuring, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := newLoadBalancerService("svc", "lb-1")
+			controller, _ := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+			cloud := &vpcCloud{FakeLoadBalancer: fakecloud.NewFakeLoadBalancer(), err: tc.validateErr}
+			controller.balancer = cloud
+			lbs := &lbSync{service: svc, key: "default/svc", options: &cloudprovider.ServiceOptions{VpcID: tc.vpcID}, previousStatus: &v1.LoadBalancerStatus{}}
+
+			state, err := controller.syncEnsuring(context.TODO(), lbs)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("syncEnsuring() error = %v, expected error: %t", err, tc.expectedErr)
+			}
+			if state != tc.expectedState {
+				t.Errorf("Expected state %v, got %v", tc.expectedState, state)
+			}
+			if got := cloud.CallCount("ensure"); got != tc.expectedEnsures {
+				t.Errorf("Expected %d EnsureLoadBalancer calls, got %d", tc.expectedEnsures, got)
+			}
+
+			warned := false
+			recorder := controller.eventRecorder.(*record.FakeRecorder)
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, EventReasonInvalidLoadBalancerConfig) {
+					warned = true
+				}
+			}
+			if expected := tc.expectedState == lbStateDone; warned != expected {
+				t.Errorf("Expected a %s event to be %t, got %t", EventReasonInvalidLoadBalancerConfig, expected, warned)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// managedLBIDsKey is the key of the managed load balancer ConfigMap listing
+// the allowed load balancer IDs, separated by commas or whitespace.
+const managedLBIDsKey = "lb-ids"
+
+// managedLBAllowlist holds the IDs of the load balancers the controller may
+// ensure, kept up to date with a ConfigMap. It protects the load balancers
+// provisioned outside of Kubernetes from being taken over by a service
+// annotated with their ID.
+type managedLBAllowlist struct {
+	namespace string
+	name      string
+	informer  cache.SharedIndexInformer
+
+	lock sync.RWMutex
+	ids  sets.Set[string]
+}
+
+func newManagedLBAllowlist(kubeClient clientset.Interface, namespace, name string) *managedLBAllowlist {
+	a := &managedLBAllowlist{
+		namespace: namespace,
+		name:      name,
+		ids:       sets.New[string](),
+		informer:  newConfigMapInformer(kubeClient, namespace, name),
+	}
+	a.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			a.update(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			a.update(cur)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if !isConfigMap(obj, namespace, name) {
+				return
+			}
+			klog.Warningf("Managed load balancer ConfigMap %s/%s was deleted, no load balancer will be ensured", a.namespace, a.name)
+			a.set(sets.New[string]())
+		},
+	})
+	return a
+}
+
+// run watches the ConfigMap until stopCh is closed.
+func (a *managedLBAllowlist) run(stopCh <-chan struct{}) {
+	a.informer.Run(stopCh)
+}
+
+// hasSynced reports whether the ConfigMap was listed once.
+func (a *managedLBAllowlist) hasSynced() bool {
+	return a.informer.HasSynced()
+}
+
+func (a *managedLBAllowlist) update(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok || !isConfigMap(cm, a.namespace, a.name) {
+		return
+	}
+	ids := sets.New(strings.FieldsFunc(cm.Data[managedLBIDsKey], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})...)
+	klog.V(2).Infof("Managed load balancer ConfigMap %s/%s allows %d load balancers", a.namespace, a.name, ids.Len())
+	a.set(ids)
+}
+
+func (a *managedLBAllowlist) set(ids sets.Set[string]) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.ids = ids
+}
+
+// allows reports whether the load balancer lbID may be ensured.
+func (a *managedLBAllowlist) allows(lbID string) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.ids.Has(lbID)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+	servicehelper "github.com/inspurDTest/cloud-provider/service/helpers"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func newManagedLBConfigMap(ids string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "lb-system", Name: "managed-lbs"},
+		Data:       map[string]string{managedLBIDsKey: ids},
+	}
+}
+
+func TestManagedLBAllowlist(t *testing.T) {
+	client := fake.NewSimpleClientset(newManagedLBConfigMap("lb-1, lb-2\nlb-3"))
+	allowlist := newManagedLBAllowlist(client, "lb-system", "managed-lbs")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go allowlist.run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, allowlist.hasSynced) {
+		t.Fatalf("Failed to sync the managed load balancer ConfigMap")
+	}
+
+	for _, id := range []string{"lb-1", "lb-2", "lb-3"} {
+		if !allowlist.allows(id) {
+			t.Errorf("Expected %s to be allowed", id)
+		}
+	}
+	if allowlist.allows("lb-4") {
+		t.Errorf("Expected lb-4 not to be allowed")
+	}
+
+	if _, err := client.CoreV1().ConfigMaps("lb-system").Update(context.TODO(), newManagedLBConfigMap("lb-4"), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update the ConfigMap: %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return allowlist.allows("lb-4") && !allowlist.allows("lb-1"), nil
+	}); err != nil {
+		t.Errorf("Expected the allowlist to follow the ConfigMap update: %v", err)
+	}
+
+	if err := client.CoreV1().ConfigMaps("lb-system").Delete(context.TODO(), "managed-lbs", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete the ConfigMap: %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return !allowlist.allows("lb-4"), nil
+	}); err != nil {
+		t.Errorf("Expected no load balancer to be allowed once the ConfigMap is deleted: %v", err)
+	}
+}
+
+func TestSyncServiceUnmanagedLoadBalancer(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	svc := newLoadBalancerService("svc", "lb-external")
+	controller, client := newController(t, cloud, svc)
+	controller.managedLBs = newManagedLBAllowlist(client, "lb-system", "managed-lbs")
+	controller.managedLBs.update(newManagedLBConfigMap("lb-1"))
+
+	if err := controller.syncService(context.TODO(), "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error: %v", err)
+	}
+	if cloud.CallCount("ensure") != 0 {
+		t.Errorf("Expected the load balancer not to be ensured, got %d ensure calls", cloud.CallCount("ensure"))
+	}
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+	warned := false
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.HasPrefix(event, v1.EventTypeWarning+" "+EventReasonUnmanagedLoadBalancer) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("Expected an %s warning", EventReasonUnmanagedLoadBalancer)
+	}
+}
+
+func TestDeleteUnmanagedLoadBalancer(t *testing.T) {
+	svc := newLoadBalancerService("svc", "lb-external")
+	svc.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+	now := metav1.Now()
+	svc.DeletionTimestamp = &now
+	controller, client := newController(t, fakecloud.NewFakeLoadBalancer(), svc)
+	balancer := fakecloud.NewFakeLoadBalancer()
+	controller.balancer = balancer
+	controller.managedLBs = newManagedLBAllowlist(client, "lb-system", "managed-lbs")
+	controller.managedLBs.update(newManagedLBConfigMap("lb-1"))
+	recorder := controller.eventRecorder.(*record.FakeRecorder)
+
+	// Deleting the service.
+	if err := controller.syncService(context.TODO(), "default/svc"); err != nil {
+		t.Fatalf("syncService() returned unexpected error: %v", err)
+	}
+	// Deleting the service while the controller only knows its cached state.
+	controller.cache.set("default/other", &cachedService{state: newLoadBalancerService("other", "lb-external")})
+	if err := controller.processServiceDeletion(context.TODO(), "default/other"); err != nil {
+		t.Fatalf("processServiceDeletion() returned unexpected error: %v", err)
+	}
+
+	for _, call := range balancer.Calls() {
+		if call.LBID == "lb-external" {
+			t.Errorf("Expected the unmanaged load balancer to be left alone, got a %s call", call.Method)
+		}
+	}
+	warnings := 0
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.HasPrefix(event, v1.EventTypeWarning+" "+EventReasonUnmanagedLoadBalancer) {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("Expected 2 %s warnings, got %d", EventReasonUnmanagedLoadBalancer, warnings)
+	}
+	updated, err := client.CoreV1().Services("default").Get(context.TODO(), "svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	if servicehelper.HasLBFinalizer(updated) {
+		t.Errorf("Expected the finalizer to be removed")
+	}
+}
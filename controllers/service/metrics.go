@@ -37,6 +37,15 @@ func registerMetrics() {
 		legacyregistry.MustRegister(nodeSyncLatency)
 		legacyregistry.MustRegister(nodeSyncErrorCount)
 		legacyregistry.MustRegister(updateLoadBalancerHostLatency)
+		legacyregistry.MustRegister(loadBalancerDeleteLatency)
+		legacyregistry.MustRegister(eventsDroppedCount)
+		legacyregistry.MustRegister(lbAPITimeoutCount)
+		legacyregistry.MustRegister(activeLoadBalancers)
+		legacyregistry.MustRegister(startupResyncCount)
+		legacyregistry.MustRegister(staleCacheEntriesCleanedCount)
+		legacyregistry.MustRegister(serviceQueueDepth)
+		legacyregistry.MustRegister(nodeQueueDepth)
+		legacyregistry.MustRegister(endpointSliceQueueDepth)
 	})
 }
 
@@ -53,14 +62,14 @@ var (
 		Help:           "A metric counting the amount of times any load balancer has been configured and errored, as an effect of node changes on the cluster",
 		StabilityLevel: metrics.ALPHA,
 	})
-	nodeSyncLatency = metrics.NewHistogram(&metrics.HistogramOpts{
+	nodeSyncLatency = metrics.NewHistogramVec(&metrics.HistogramOpts{
 		Name:      "nodesync_latency_seconds",
 		Subsystem: subSystemName,
-		Help:      "A metric measuring the latency for nodesync which updates loadbalancer hosts on cluster node updates.",
-		// Buckets from 1s to 16384s
-		Buckets:        metrics.ExponentialBuckets(1, 2, 15),
+		Help:      "A metric measuring the latency for nodesync which updates loadbalancer hosts of a service on cluster node updates, partitioned by the externalTrafficPolicy of the service.",
+		// Buckets from 10ms to 163.84s
+		Buckets:        metrics.ExponentialBuckets(0.01, 2, 15),
 		StabilityLevel: metrics.ALPHA,
-	})
+	}, []string{"externalTrafficPolicy"})
 	updateLoadBalancerHostLatency = metrics.NewHistogram(&metrics.HistogramOpts{
 		Name:      "update_loadbalancer_host_latency_seconds",
 		Subsystem: subSystemName,
@@ -69,4 +78,60 @@ var (
 		Buckets:        metrics.ExponentialBuckets(1, 2, 15),
 		StabilityLevel: metrics.ALPHA,
 	})
+	loadBalancerDeleteLatency = metrics.NewHistogram(&metrics.HistogramOpts{
+		Name:      "lb_delete_duration_seconds",
+		Subsystem: subSystemName,
+		Help:      "A metric measuring the latency for deleting a load balancer, including the time spent waiting for a delete worker.",
+		// Buckets from 1s to 16384s
+		Buckets:        metrics.ExponentialBuckets(1, 2, 15),
+		StabilityLevel: metrics.ALPHA,
+	})
+	eventsDroppedCount = metrics.NewCounter(&metrics.CounterOpts{
+		Name:           "lb_events_dropped_total",
+		Subsystem:      subSystemName,
+		Help:           "A metric counting the events dropped because their object exceeded the event rate limit, or too many events were waiting to be recorded in the cloud.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	lbAPITimeoutCount = metrics.NewCounterVec(&metrics.CounterOpts{
+		Name:           "lb_api_timeout_total",
+		Subsystem:      subSystemName,
+		Help:           "A metric counting the cloud provider load balancer calls exceeding the load balancer API timeout, partitioned by operation.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"operation"})
+	activeLoadBalancers = metrics.NewGauge(&metrics.GaugeOpts{
+		Name:           "lb_active_total",
+		Subsystem:      subSystemName,
+		Help:           "A metric counting the load balancers managed by the controller, ensured and not deleted since it started.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	startupResyncCount = metrics.NewCounter(&metrics.CounterOpts{
+		Name:           "lb_startup_resync_total",
+		Subsystem:      subSystemName,
+		Help:           "A metric counting the services queued by the resync on startup.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	staleCacheEntriesCleanedCount = metrics.NewCounter(&metrics.CounterOpts{
+		Name:           "lb_stale_cache_entries_cleaned_total",
+		Subsystem:      subSystemName,
+		Help:           "A metric counting the cached services removed because they no longer exist.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	serviceQueueDepth = metrics.NewGauge(&metrics.GaugeOpts{
+		Name:           "lb_service_queue_depth",
+		Subsystem:      subSystemName,
+		Help:           "A metric sampling the number of services waiting in the service queue.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	nodeQueueDepth = metrics.NewGauge(&metrics.GaugeOpts{
+		Name:           "lb_node_queue_depth",
+		Subsystem:      subSystemName,
+		Help:           "A metric sampling the number of items waiting in the node queue.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	endpointSliceQueueDepth = metrics.NewGauge(&metrics.GaugeOpts{
+		Name:           "lb_endpointslice_queue_depth",
+		Subsystem:      subSystemName,
+		Help:           "A metric sampling the number of endpoint slices waiting in the endpointslice queue.",
+		StabilityLevel: metrics.ALPHA,
+	})
 )
@@ -0,0 +1,207 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// namespaceThrottleDelay is how long an item of a namespace with too many
+// pending items waits before being added again.
+const namespaceThrottleDelay = 1 * time.Second
+
+// NamespaceRateLimiter is a work queue keeping a single namespace from
+// monopolizing the workers. Items are namespace/name keys; an item added while
+// its namespace already has maxItems items pending, queued or being processed,
+// is delayed until the namespace is below the limit. Delayed and rate limited
+// items are checked against the limit once their delay is over.
+type NamespaceRateLimiter struct {
+	workqueue.RateLimitingInterface
+
+	maxItems int
+
+	lock sync.Mutex
+	// pending counts the tracked items per namespace.
+	pending map[string]int
+	// tracked holds the items counted in pending, until they are done.
+	tracked sets.Set[string]
+	// processing holds the tracked items handed out by Get.
+	processing sets.Set[string]
+	// readded holds the items added again while processing, which the
+	// wrapped queue queues again once they are done.
+	readded sets.Set[string]
+	// waiting holds the items waiting for their delay to be over.
+	waiting map[interface{}]*waitingItem
+}
+
+var _ workqueue.RateLimitingInterface = &NamespaceRateLimiter{}
+
+// NewNamespaceRateLimiter wraps queue, allowing at most maxItems pending items
+// per namespace.
+func NewNamespaceRateLimiter(queue workqueue.RateLimitingInterface, maxItems int) *NamespaceRateLimiter {
+	return &NamespaceRateLimiter{
+		RateLimitingInterface: queue,
+		maxItems:              maxItems,
+		pending:               make(map[string]int),
+		tracked:               sets.New[string](),
+		processing:            sets.New[string](),
+		readded:               sets.New[string](),
+		waiting:               make(map[interface{}]*waitingItem),
+	}
+}
+
+// Add adds the item to the queue, unless its namespace has too many pending
+// items. It is then tried again after namespaceThrottleDelay.
+func (q *NamespaceRateLimiter) Add(item interface{}) {
+	key, ok := item.(string)
+	if !ok {
+		q.RateLimitingInterface.Add(item)
+		return
+	}
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		q.RateLimitingInterface.Add(item)
+		return
+	}
+
+	q.lock.Lock()
+	if !q.tracked.Has(key) && q.pending[namespace] >= q.maxItems {
+		q.addAfterLocked(item, namespaceThrottleDelay)
+		q.lock.Unlock()
+		return
+	}
+	if !q.tracked.Has(key) {
+		q.tracked.Insert(key)
+		q.pending[namespace]++
+	} else if q.processing.Has(key) {
+		q.readded.Insert(key)
+	}
+	q.lock.Unlock()
+	q.RateLimitingInterface.Add(item)
+}
+
+// Get blocks until it can return an item to be processed.
+func (q *NamespaceRateLimiter) Get() (interface{}, bool) {
+	item, shutdown := q.RateLimitingInterface.Get()
+	if key, ok := item.(string); ok {
+		q.lock.Lock()
+		if q.tracked.Has(key) {
+			q.processing.Insert(key)
+		}
+		q.lock.Unlock()
+	}
+	return item, shutdown
+}
+
+// AddAfter adds the item to the queue after the given duration has passed,
+// subject to the limit of its namespace at that time. An item already waiting
+// is added once, at the earliest of the requested times.
+func (q *NamespaceRateLimiter) AddAfter(item interface{}, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.addAfterLocked(item, duration)
+}
+
+func (q *NamespaceRateLimiter) addAfterLocked(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+	readyAt := time.Now().Add(duration)
+	if w, ok := q.waiting[item]; ok {
+		if !readyAt.Before(w.readyAt) {
+			return
+		}
+		w.timer.Stop()
+	}
+	w := &waitingItem{readyAt: readyAt}
+	w.timer = time.AfterFunc(duration, func() {
+		q.lock.Lock()
+		// The item may have been replaced by an earlier one while the
+		// timer fired.
+		if q.waiting[item] != w {
+			q.lock.Unlock()
+			return
+		}
+		delete(q.waiting, item)
+		q.lock.Unlock()
+		q.Add(item)
+	})
+	q.waiting[item] = w
+}
+
+// AddRateLimited adds the item to the queue once the rate limiter of the
+// wrapped queue says it's ok, subject to the limit of its namespace at that
+// time.
+func (q *NamespaceRateLimiter) AddRateLimited(item interface{}) {
+	delayer, ok := q.RateLimitingInterface.(rateLimitDelayer)
+	if !ok {
+		// The wrapped queue delays the item itself.
+		q.RateLimitingInterface.AddRateLimited(item)
+		return
+	}
+	q.AddAfter(item, delayer.rateLimitDelay(item))
+}
+
+// Done marks the item as done processing, releasing its slot in the namespace
+// unless the item was added again while processing.
+func (q *NamespaceRateLimiter) Done(item interface{}) {
+	q.RateLimitingInterface.Done(item)
+	key, ok := item.(string)
+	if !ok {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.processing.Delete(key)
+	if !q.tracked.Has(key) {
+		return
+	}
+	if q.readded.Has(key) {
+		// The wrapped queue queued the item again, it keeps its slot.
+		q.readded.Delete(key)
+		return
+	}
+	q.tracked.Delete(key)
+	namespace, _, _ := cache.SplitMetaNamespaceKey(key)
+	if q.pending[namespace]--; q.pending[namespace] <= 0 {
+		delete(q.pending, namespace)
+	}
+}
+
+// queued reports whether the wrapped queue holds the item waiting to be
+// processed. Items delayed by the namespace limit are not queued.
+func (q *NamespaceRateLimiter) queued(item interface{}) bool {
+	checker, ok := q.RateLimitingInterface.(queuedChecker)
+	return ok && checker.queued(item)
+}
+
+// pendingItems returns the number of pending items of the namespace.
+func (q *NamespaceRateLimiter) pendingItems(namespace string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.pending[namespace]
+}
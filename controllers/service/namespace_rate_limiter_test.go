@@ -0,0 +1,101 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestNamespaceRateLimiter(t *testing.T) {
+	q := NewNamespaceRateLimiter(workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), 2)
+	defer q.ShutDown()
+
+	for _, key := range []string{"busy/svc-1", "busy/svc-2", "busy/svc-3", "quiet/svc-1", "busy/svc-1"} {
+		q.Add(key)
+	}
+	// busy/svc-3 is delayed, busy/svc-1 is deduplicated.
+	if got := q.Len(); got != 3 {
+		t.Fatalf("Expected 3 queued items, got %d", got)
+	}
+
+	item, _ := q.Get()
+	if item != "busy/svc-1" {
+		t.Fatalf("Expected busy/svc-1, got %v", item)
+	}
+	q.Done(item)
+	if got := q.pendingItems("busy"); got != 1 {
+		t.Errorf("Expected 1 pending item in namespace busy, got %d", got)
+	}
+
+	// The delayed item is added once the namespace has room for it.
+	deadline := time.Now().Add(5 * namespaceThrottleDelay)
+	for q.Len() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the delayed item to be queued, got %d queued items", q.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := q.pendingItems("busy"); got != 2 {
+		t.Errorf("Expected 2 pending items in namespace busy, got %d", got)
+	}
+}
+
+func TestNamespaceRateLimiterReaddedWhileProcessing(t *testing.T) {
+	q := NewNamespaceRateLimiter(workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()), 1)
+	defer q.ShutDown()
+
+	q.Add("busy/svc-1")
+	item, _ := q.Get()
+	q.Add(item)
+	q.Done(item)
+	// The item is queued again and keeps its slot.
+	if got := q.Len(); got != 1 {
+		t.Fatalf("Expected the item to be queued again, got %d queued items", got)
+	}
+	if got := q.pendingItems("busy"); got != 1 {
+		t.Errorf("Expected 1 pending item in namespace busy, got %d", got)
+	}
+	q.Add("busy/svc-2")
+	if got := q.Len(); got != 1 {
+		t.Errorf("Expected busy/svc-2 to be held by the namespace limit, got %d queued items", got)
+	}
+
+	item, _ = q.Get()
+	q.Done(item)
+	if got := q.pendingItems("busy"); got != 0 {
+		t.Errorf("Expected no pending item in namespace busy, got %d", got)
+	}
+}
+
+func TestNamespaceRateLimiterDelayedItems(t *testing.T) {
+	q := NewNamespaceRateLimiter(newPriorityQueue("", workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond), priorityFromPrefix), 1)
+	defer q.ShutDown()
+
+	q.Add("busy/svc-1")
+	q.AddAfter("busy/svc-2", time.Millisecond)
+	q.AddRateLimited("busy/svc-3")
+	time.Sleep(50 * time.Millisecond)
+	if got := q.Len(); got != 1 {
+		t.Fatalf("Expected the delayed items to be held by the namespace limit, got %d queued items", got)
+	}
+	if got := q.pendingItems("busy"); got != 1 {
+		t.Errorf("Expected 1 pending item in namespace busy, got %d", got)
+	}
+}
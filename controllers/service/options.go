@@ -0,0 +1,410 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	clusterInfoNamespace = "kube-system"
+	clusterInfoName      = "icks-cluster-info"
+	clusterInfoIDKey     = "clusterId"
+)
+
+// Option configures optional behavior of the service controller.
+type Option func(*Controller)
+
+// ClusterIDProvider returns the ID of the cluster the load balancers are
+// created for.
+type ClusterIDProvider interface {
+	ClusterID(ctx context.Context) (string, error)
+}
+
+// ServiceFilter reports whether the service controller should manage the
+// load balancer of the given service.
+type ServiceFilter func(service *v1.Service) bool
+
+// CircuitBreaker guards the calls to the cloud provider. When Allow returns
+// false the call is skipped and the service is retried later.
+type CircuitBreaker interface {
+	Allow() bool
+	RecordSuccess()
+	RecordFailure()
+}
+
+// LBLifecycleHook runs custom logic, e.g. the setup of firewall rules, around
+// the ensuring of a load balancer. Errors are retried like cloud provider
+// errors.
+type LBLifecycleHook interface {
+	// PreEnsure runs before the load balancer lbID of the service is ensured.
+	PreEnsure(ctx context.Context, service *v1.Service, lbID string) error
+	// PostEnsure runs after the load balancer lbID of the service was ensured
+	// successfully, with the status returned by the cloud provider.
+	PostEnsure(ctx context.Context, service *v1.Service, status *v1.LoadBalancerStatus, lbID string) error
+}
+
+// CloudEventRecorder records the events of the services in the event log of
+// their load balancer in the cloud, e.g. for the cloud audit systems. It is
+// called asynchronously and its errors are only logged.
+type CloudEventRecorder interface {
+	RecordLBEvent(ctx context.Context, lbID, reason, message string) error
+}
+
+// WithClusterIDProvider overrides the provider of the cluster ID, which by
+// default is read from the icks-cluster-info ConfigMap. It is called by the
+// syncs until it succeeds, its result is cached afterwards.
+func WithClusterIDProvider(provider ClusterIDProvider) Option {
+	return func(c *Controller) {
+		c.clusterIDProvider = provider
+	}
+}
+
+// WithServiceFilter restricts the services the controller manages.
+func WithServiceFilter(filter ServiceFilter) Option {
+	return func(c *Controller) {
+		c.serviceFilter = filter
+	}
+}
+
+// WithCircuitBreaker sets the circuit breaker guarding the cloud provider calls.
+func WithCircuitBreaker(breaker CircuitBreaker) Option {
+	return func(c *Controller) {
+		c.circuitBreaker = breaker
+	}
+}
+
+// WithLBLifecycleHook sets the hook run around the ensuring of load balancers.
+func WithLBLifecycleHook(hook LBLifecycleHook) Option {
+	return func(c *Controller) {
+		c.lifecycleHook = hook
+	}
+}
+
+// WithMaxConcurrentLBOperations bounds the number of cloud provider calls
+// running at the same time, independently of the number of workers.
+func WithMaxConcurrentLBOperations(n int) Option {
+	return func(c *Controller) {
+		if n > 0 {
+			c.lbOperations = semaphore.NewWeighted(int64(n))
+		}
+	}
+}
+
+// WithConcurrentLBDeleteWorkers bounds the number of load balancer deletions
+// running at the same time. When n is 0, Run uses min(workers, 5).
+func WithConcurrentLBDeleteWorkers(n int) Option {
+	return func(c *Controller) {
+		c.concurrentLBDeletes = n
+	}
+}
+
+// WithDefaultIdleTimeout sets the idle timeout of the load balancer connections
+// for services without the lb-idle-timeout annotation.
+func WithDefaultIdleTimeout(timeout time.Duration) Option {
+	return func(c *Controller) {
+		c.defaultServiceOptions.IdleTimeout = timeout
+	}
+}
+
+// WithDefaultSubnetID sets the subnet of the load balancers of services
+// without the lb-subnet-id annotation.
+func WithDefaultSubnetID(id string) Option {
+	return func(c *Controller) {
+		c.defaultServiceOptions.SubnetID = id
+	}
+}
+
+// WithNodeLabelSelector restricts the nodes eligible as load balancer backends
+// to the ones matching the label selector. The selector is parsed by New.
+func WithNodeLabelSelector(selector string) Option {
+	return func(c *Controller) {
+		c.nodeLabelSelectorString = selector
+	}
+}
+
+// WithDynamicLabelFilter further restricts the nodes eligible as load balancer
+// backends with the label selector of the filter. All the load balancers are
+// synced again when the selector changes.
+func WithDynamicLabelFilter(filter *DynamicLabelFilter) Option {
+	return func(c *Controller) {
+		c.dynamicLabelFilter = filter
+	}
+}
+
+// WithDynamicExclusionLabelController excludes the nodes with the label keys
+// listed in the ConfigMap of the controller from the load balancer backends.
+// All the load balancers are synced again when the list changes.
+func WithDynamicExclusionLabelController(controller *DynamicExclusionLabelController) Option {
+	return func(c *Controller) {
+		c.dynamicExclusionLabels = controller
+	}
+}
+
+// WithNodeReadinessStalenessThreshold sets how long a node may report a
+// non-True readiness condition before it is excluded from the load balancers.
+// 0 excludes not ready nodes right away.
+func WithNodeReadinessStalenessThreshold(threshold time.Duration) Option {
+	return func(c *Controller) {
+		c.nodeReadinessStalenessThreshold = threshold
+	}
+}
+
+// WithEventRateLimiter bounds the events recorded per object to qps events per
+// second with the given burst, dropping the overflow. A qps of 0 disables the
+// limit.
+func WithEventRateLimiter(qps float32, burst int) Option {
+	return func(c *Controller) {
+		c.eventQPS = qps
+		c.eventBurst = burst
+	}
+}
+
+// WithLBReadinessGate sets whether the provisioning of the load balancer is
+// reported in the cloud.inspur.com/lb-ready condition of the service status.
+func WithLBReadinessGate(enable bool) Option {
+	return func(c *Controller) {
+		c.enableLBReadinessGate = enable
+	}
+}
+
+// WithFilterNotReadyEndpoints sets whether the endpoints which are not ready
+// are excluded from the members of the load balancers.
+func WithFilterNotReadyEndpoints(filter bool) Option {
+	return func(c *Controller) {
+		c.filterNotReadyEndpoints = filter
+	}
+}
+
+// WithLBAPITimeout bounds the duration of each cloud provider load balancer
+// call. It defaults to 120s.
+func WithLBAPITimeout(timeout time.Duration) Option {
+	return func(c *Controller) {
+		c.lbAPITimeout = timeout
+	}
+}
+
+// WithMaxItemsPerNamespace delays the services of a namespace once it has
+// maxItems services pending in the service queue. 0 disables the limit.
+func WithMaxItemsPerNamespace(maxItems int) Option {
+	return func(c *Controller) {
+		c.maxItemsPerNamespace = maxItems
+	}
+}
+
+// WithWatchNamespace restricts the controller to the services of the given
+// namespace. The service and endpoint slice informers passed to New must be
+// restricted to it too. By default all namespaces are managed.
+func WithWatchNamespace(namespace string) Option {
+	return func(c *Controller) {
+		c.watchNamespace = namespace
+	}
+}
+
+// WithLoadBalancerClass restricts the controller to the services of the given
+// LoadBalancerClass. By default only the services without a class are managed.
+func WithLoadBalancerClass(class string) Option {
+	return func(c *Controller) {
+		c.loadBalancerClass = class
+	}
+}
+
+// WithAnnotationPrefix sets the domain of the annotations of the services,
+// "inspur.com" by default, e.g. "example.com/load-balancer-id" for the
+// load balancer ID with the "example.com" prefix.
+func WithAnnotationPrefix(prefix string) Option {
+	return func(c *Controller) {
+		c.annotations = NewAnnotationConfig(prefix)
+	}
+}
+
+// WithLBTagLabelPrefix sets the prefix of the labels of the services that are
+// set, without the prefix, as tags of their load balancer. Empty disables the
+// tags.
+func WithLBTagLabelPrefix(prefix string) Option {
+	return func(c *Controller) {
+		c.tagLabelPrefix = prefix
+	}
+}
+
+// WithLBProvisioningCondition sets whether the calls ensuring the load
+// balancers are reported in the LoadBalancerProvisioning condition of the
+// services.
+func WithLBProvisioningCondition(enable bool) Option {
+	return func(c *Controller) {
+		c.enableLBProvisioningCondition = enable
+	}
+}
+
+// WithManagedLBConfigMap restricts the load balancers the controller ensures
+// to the IDs listed by the ConfigMap, given as namespace/name. The ConfigMap is
+// watched, changes apply without a restart. An empty key allows all load
+// balancers.
+func WithManagedLBConfigMap(key string) Option {
+	return func(c *Controller) {
+		if len(key) == 0 {
+			return
+		}
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			klog.Errorf("Ignoring invalid managed load balancer ConfigMap %q: %v", key, err)
+			return
+		}
+		c.managedLBs = newManagedLBAllowlist(c.kubeClient, namespace, name)
+	}
+}
+
+// WithDeletionGracePeriod delays the deletion of the load balancer of a deleted
+// service by the grace period, counted from its deletion timestamp, to drain
+// long-lived connections.
+func WithDeletionGracePeriod(gracePeriod time.Duration) Option {
+	return func(c *Controller) {
+		c.deletionGracePeriod = gracePeriod
+	}
+}
+
+// WithNodeCacheSyncTimeout bounds the wait for the caches to sync on startup,
+// Run exits the process when it expires. 0 waits forever.
+func WithNodeCacheSyncTimeout(timeout time.Duration) Option {
+	return func(c *Controller) {
+		c.cacheSyncTimeout = timeout
+	}
+}
+
+// WithServiceCacheSnapshotPath sets the file the service cache is
+// periodically saved to and restored from on startup. Empty disables the
+// snapshots.
+func WithServiceCacheSnapshotPath(path string) Option {
+	return func(c *Controller) {
+		c.cacheSnapshotPath = path
+	}
+}
+
+// WithResyncOnStartup sets whether all the services with a load balancer are
+// queued once the caches are synced.
+func WithResyncOnStartup(resync bool) Option {
+	return func(c *Controller) {
+		c.resyncOnStartup = resync
+	}
+}
+
+// WithOrphanLBCleanup sets whether the load balancers whose service no longer
+// exists are deleted on startup. It requires a cloud provider implementing
+// LoadBalancerLister.
+func WithOrphanLBCleanup(enable bool) Option {
+	return func(c *Controller) {
+		c.enableOrphanLBCleanup = enable
+	}
+}
+
+// WithLBStatusReconciliation sets whether the status of the load balancers is
+// periodically compared with the status of their services. It doubles the
+// number of cloud provider calls.
+func WithLBStatusReconciliation(enable bool) Option {
+	return func(c *Controller) {
+		c.enableLBStatusReconciliation = enable
+	}
+}
+
+// WithEventDedupWindow sets how long the repeats of an event of a service,
+// type, reason and message alike, are dropped before bumping the count of the
+// event. 0 disables the deduplication.
+func WithEventDedupWindow(window time.Duration) Option {
+	return func(c *Controller) {
+		c.eventDedupWindow = window
+	}
+}
+
+// WithPreserveIngressOnEmpty sets whether the ingress of the service status is
+// kept when the cloud provider returns a load balancer status without ingress.
+func WithPreserveIngressOnEmpty(preserve bool) Option {
+	return func(c *Controller) {
+		c.preserveIngressOnEmpty = preserve
+	}
+}
+
+// WithCloudEventRecorder additionally records the events of the services
+// with a load balancer ID with the recorder.
+func WithCloudEventRecorder(recorder CloudEventRecorder) Option {
+	return func(c *Controller) {
+		c.cloudEventRecorder = recorder
+	}
+}
+
+// configMapClusterIDProvider reads the cluster ID from the icks-cluster-info
+// ConfigMap.
+type configMapClusterIDProvider struct {
+	kubeClient clientset.Interface
+}
+
+func (p *configMapClusterIDProvider) ClusterID(ctx context.Context) (string, error) {
+	cm, err := p.kubeClient.CoreV1().ConfigMaps(clusterInfoNamespace).Get(ctx, clusterInfoName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	clusterID := cm.Data[clusterInfoIDKey]
+	if len(clusterID) == 0 {
+		return "", fmt.Errorf("%s's configmap could not contain %s", clusterInfoName, clusterInfoIDKey)
+	}
+	return clusterID, nil
+}
+
+// allServices is the default ServiceFilter accepting every service.
+func allServices(*v1.Service) bool {
+	return true
+}
+
+// noopCircuitBreaker is the default CircuitBreaker which never opens.
+type noopCircuitBreaker struct{}
+
+func (noopCircuitBreaker) Allow() bool    { return true }
+func (noopCircuitBreaker) RecordSuccess() {}
+func (noopCircuitBreaker) RecordFailure() {}
+
+// NoopLBLifecycleHook is the default LBLifecycleHook which does nothing.
+// Hooks implementing only one of the methods can embed it.
+type NoopLBLifecycleHook struct{}
+
+func (NoopLBLifecycleHook) PreEnsure(context.Context, *v1.Service, string) error { return nil }
+func (NoopLBLifecycleHook) PostEnsure(context.Context, *v1.Service, *v1.LoadBalancerStatus, string) error {
+	return nil
+}
+
+// NoopCloudEventRecorder is the default CloudEventRecorder which does nothing.
+type NoopCloudEventRecorder struct{}
+
+func (NoopCloudEventRecorder) RecordLBEvent(context.Context, string, string, string) error {
+	return nil
+}
+
+// LogCloudEventRecorder is a CloudEventRecorder logging the events, e.g. for
+// log based audit pipelines.
+type LogCloudEventRecorder struct{}
+
+func (LogCloudEventRecorder) RecordLBEvent(ctx context.Context, lbID, reason, message string) error {
+	klog.FromContext(ctx).Info("Load balancer event", "lbID", lbID, "reason", reason, "message", message)
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// reconcileOrphanedLBs queues the deletion of the load balancers whose service
+// no longer exists, e.g. because it was deleted while the controller was not
+// running. It is a no-op if the cloud provider can't list load balancers.
+func (c *Controller) reconcileOrphanedLBs(ctx context.Context) error {
+	lister, ok := c.balancer.(cloudprovider.LoadBalancerLister)
+	if !ok {
+		klog.V(2).Info("The cloud provider does not support listing load balancers, skipping orphaned load balancer cleanup")
+		return nil
+	}
+	if err := c.resolveClusterID(ctx); err != nil {
+		return err
+	}
+	var lbs []cloudprovider.LoadBalancerReference
+	if err := c.callCloud(ctx, "list", func(ctx context.Context) (err error) {
+		lbs, err = lister.ListLoadBalancers(ctx, c.clusterName())
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to list load balancers: %w", err)
+	}
+
+	orphans := make(map[string]*v1.Service)
+	for _, lb := range lbs {
+		// The lister only holds the watched namespace, the services of the
+		// other namespaces are not known to be gone.
+		if !c.watchesNamespace(lb.ServiceNamespace) {
+			continue
+		}
+		_, err := c.serviceLister.Services(lb.ServiceNamespace).Get(lb.ServiceName)
+		if err == nil || !apierrors.IsNotFound(err) {
+			continue
+		}
+		key := lb.ServiceNamespace + "/" + lb.ServiceName
+		service, ok := orphans[key]
+		if !ok {
+			service = &v1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   lb.ServiceNamespace,
+				Name:        lb.ServiceName,
+				Annotations: map[string]string{c.annotations.Key(ServiceAnnotationLoadBalancerID): lb.ID},
+			}}
+			orphans[key] = service
+			continue
+		}
+		// processServiceDeletion deletes the current and the old load
+		// balancer of a service, further ones are left for the next start.
+		if _, ok := service.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerOldID)]; ok {
+			klog.Warningf("Service %s has more than two orphaned load balancers, not deleting %s", key, lb.ID)
+			continue
+		}
+		service.Annotations[c.annotations.Key(ServiceAnnotationLoadBalancerOldID)] = lb.ID
+	}
+
+	// The deletion of a service no longer in the lister is done from the
+	// cached service, seed the cache with a service holding the load balancer
+	// IDs and let the workers delete them.
+	for key, service := range orphans {
+		if _, ok := c.cache.get(key); ok {
+			continue
+		}
+		klog.Infof("Load balancers %v of deleted service %s are orphaned, queuing their deletion", service.Annotations, key)
+		c.cache.set(key, &cachedService{state: service})
+		c.serviceQueue.Add(key)
+	}
+	return nil
+}
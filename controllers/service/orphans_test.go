@@ -0,0 +1,108 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	fakecloud "github.com/inspurDTest/cloud-provider/fake"
+)
+
+// listingCloud is a fake cloud supporting LoadBalancerLister.
+type listingCloud struct {
+	*fakecloud.FakeLoadBalancer
+
+	lbs []cloudprovider.LoadBalancerReference
+}
+
+func (c *listingCloud) ListLoadBalancers(ctx context.Context, clusterName string) ([]cloudprovider.LoadBalancerReference, error) {
+	return c.lbs, nil
+}
+
+func TestReconcileOrphanedLBs(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	controller, _ := newController(t, cloud, newLoadBalancerService("live", "lb-1"))
+	controller.balancer = &listingCloud{FakeLoadBalancer: cloud, lbs: []cloudprovider.LoadBalancerReference{
+		{ID: "lb-1", ServiceNamespace: "default", ServiceName: "live"},
+		{ID: "lb-2", ServiceNamespace: "default", ServiceName: "gone"},
+		{ID: "lb-3", ServiceNamespace: "default", ServiceName: "gone"},
+		{ID: "lb-4", ServiceNamespace: "other", ServiceName: "gone"},
+	}}
+
+	if err := controller.reconcileOrphanedLBs(context.TODO()); err != nil {
+		t.Fatalf("reconcileOrphanedLBs() returned unexpected error: %v", err)
+	}
+	if got := controller.serviceQueue.Len(); got != 2 {
+		t.Errorf("Expected the 2 deleted services to be queued, got %d", got)
+	}
+	cached, ok := controller.cache.get("default/gone")
+	if !ok {
+		t.Fatalf("Expected default/gone to be cached")
+	}
+	if id, oldID := cached.state.Annotations[ServiceAnnotationLoadBalancerID], cached.state.Annotations[ServiceAnnotationLoadBalancerOldID]; id != "lb-2" || oldID != "lb-3" {
+		t.Errorf("Expected load balancers lb-2 and lb-3 to be deleted, got %q and %q", id, oldID)
+	}
+	if _, ok := controller.cache.get("default/live"); ok {
+		t.Errorf("Expected the existing service not to be touched")
+	}
+
+	if err := controller.syncService(context.TODO(), "default/gone"); err != nil {
+		t.Fatalf("syncService() returned unexpected error: %v", err)
+	}
+	var deleted []string
+	for _, call := range cloud.Calls() {
+		if call.Method == "delete" {
+			deleted = append(deleted, call.LBID)
+		}
+	}
+	if !reflect.DeepEqual(deleted, []string{"lb-2", "lb-3"}) {
+		t.Errorf("Expected both orphaned load balancers to be deleted, got deletes of %v", deleted)
+	}
+	if _, ok := controller.cache.get("default/gone"); ok {
+		t.Errorf("Expected default/gone to be removed from the cache once deleted")
+	}
+}
+
+func TestReconcileOrphanedLBsWatchNamespace(t *testing.T) {
+	cloud := fakecloud.NewFakeLoadBalancer()
+	controller, _ := newController(t, cloud)
+	controller.watchNamespace = "default"
+	controller.balancer = &listingCloud{FakeLoadBalancer: cloud, lbs: []cloudprovider.LoadBalancerReference{
+		{ID: "lb-1", ServiceNamespace: "default", ServiceName: "gone"},
+		{ID: "lb-2", ServiceNamespace: "other", ServiceName: "unknown"},
+	}}
+
+	if err := controller.reconcileOrphanedLBs(context.TODO()); err != nil {
+		t.Fatalf("reconcileOrphanedLBs() returned unexpected error: %v", err)
+	}
+	if _, ok := controller.cache.get("other/unknown"); ok || controller.serviceQueue.Len() != 1 {
+		t.Errorf("Expected only the load balancer of the watched namespace to be deleted, got %d queued", controller.serviceQueue.Len())
+	}
+}
+
+func TestReconcileOrphanedLBsUnsupported(t *testing.T) {
+	controller, _ := newController(t, fakecloud.NewFakeLoadBalancer())
+	if err := controller.reconcileOrphanedLBs(context.TODO()); err != nil {
+		t.Fatalf("reconcileOrphanedLBs() returned unexpected error: %v", err)
+	}
+	if got := controller.serviceQueue.Len(); got != 0 {
+		t.Errorf("Expected nothing to be queued, got %d", got)
+	}
+}
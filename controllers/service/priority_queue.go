@@ -0,0 +1,331 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+type priority int
+
+const (
+	priorityHigh priority = iota
+	priorityNormal
+	priorityLow
+	numPriorities
+)
+
+// parsePriority returns the priority for the value of the lb-priority
+// annotation, unknown values are treated as normal.
+func parsePriority(value string) priority {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "high":
+		return priorityHigh
+	case "low":
+		return priorityLow
+	default:
+		return priorityNormal
+	}
+}
+
+// priorityQueue is a rate limiting work queue keeping its items in one
+// client-go work queue per priority, named after the priority so that each
+// of them reports the usual work queue metrics. Items are handed out in
+// cycles: a cycle serves the items that were queued when it started, high
+// priority first, then normal, then low. Items added during a cycle wait for
+// the next one, so a steady stream of high priority items delays lower
+// priority items by at most one cycle.
+//
+// Like the client-go work queue, an item is never processed concurrently and
+// an item added while being processed is queued again once it is done, even
+// if its priority changed in the meantime.
+type priorityQueue struct {
+	rateLimiter workqueue.RateLimiter
+	priorityOf  func(item interface{}) priority
+
+	cond         *sync.Cond
+	queues       [numPriorities]*workqueue.Type
+	budget       [numPriorities]int
+	dirty        map[interface{}]struct{}
+	processing   map[interface{}]priority
+	waiting      map[interface{}]*waitingItem
+	shuttingDown bool
+	drain        bool
+}
+
+// waitingItem is an item added with a delay, it is queued when its timer
+// fires unless it was replaced by an earlier one.
+type waitingItem struct {
+	readyAt time.Time
+	timer   *time.Timer
+}
+
+var _ workqueue.RateLimitingInterface = &priorityQueue{}
+
+// queuedChecker is implemented by the work queues able to tell whether an item
+// is already waiting to be processed.
+type queuedChecker interface {
+	// queued reports whether item is waiting to be processed, adding it
+	// again is then a no-op.
+	queued(item interface{}) bool
+}
+
+// rateLimitDelayer is implemented by the rate limiting work queues able to
+// tell how long an item has to wait before being added again.
+type rateLimitDelayer interface {
+	// rateLimitDelay returns the delay of item given by the rate limiter,
+	// counting it as a requeue.
+	rateLimitDelay(item interface{}) time.Duration
+}
+
+// priorityQueueNames holds the suffixes of the names of the queues of each
+// priority, the normal priority queue keeps the name of the priority queue.
+var priorityQueueNames = [numPriorities]string{
+	priorityHigh:   "_high",
+	priorityNormal: "",
+	priorityLow:    "_low",
+}
+
+// newPriorityQueue returns a priority queue whose queues report their metrics
+// under name, an empty name disables the metrics.
+func newPriorityQueue(name string, rateLimiter workqueue.RateLimiter, priorityOf func(item interface{}) priority) *priorityQueue {
+	q := &priorityQueue{
+		rateLimiter: rateLimiter,
+		priorityOf:  priorityOf,
+		cond:        sync.NewCond(&sync.Mutex{}),
+		dirty:       make(map[interface{}]struct{}),
+		processing:  make(map[interface{}]priority),
+		waiting:     make(map[interface{}]*waitingItem),
+	}
+	for p := range q.queues {
+		queueName := ""
+		if len(name) != 0 {
+			queueName = name + priorityQueueNames[p]
+		}
+		q.queues[p] = workqueue.NewWithConfig(workqueue.QueueConfig{Name: queueName})
+	}
+	return q
+}
+
+// Add marks item as needing processing.
+func (q *priorityQueue) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.add(item)
+}
+
+func (q *priorityQueue) add(item interface{}) {
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		return
+	}
+	q.push(item)
+	q.cond.Signal()
+}
+
+func (q *priorityQueue) push(item interface{}) {
+	p := q.priorityOf(item)
+	if p < priorityHigh || p >= numPriorities {
+		p = priorityNormal
+	}
+	q.queues[p].Add(item)
+}
+
+// queued reports whether item is waiting to be processed, including an item
+// added while being processed.
+func (q *priorityQueue) queued(item interface{}) bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	_, ok := q.dirty[item]
+	return ok
+}
+
+// Len returns the number of items waiting to be processed.
+func (q *priorityQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.len()
+}
+
+func (q *priorityQueue) len() int {
+	n := 0
+	for _, queue := range q.queues {
+		n += queue.Len()
+	}
+	return n
+}
+
+// Get blocks until it can return the next item to be processed. If shutdown
+// is true the caller should end its goroutine.
+func (q *priorityQueue) Get() (item interface{}, shutdown bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for q.len() == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if q.len() == 0 {
+		return nil, true
+	}
+
+	// Items are only taken out of the queues here under the lock, so the
+	// queue of p is not empty and Get does not block.
+	p := q.next()
+	item, _ = q.queues[p].Get()
+	q.budget[p]--
+
+	q.processing[item] = p
+	delete(q.dirty, item)
+	return item, false
+}
+
+// next returns the queue to serve the next item from, starting a new cycle
+// if the current one is exhausted. It must be called with a non-empty queue.
+func (q *priorityQueue) next() priority {
+	for p := priorityHigh; p < numPriorities; p++ {
+		if q.budget[p] > 0 && q.queues[p].Len() > 0 {
+			return p
+		}
+	}
+	for p := priorityHigh; p < numPriorities; p++ {
+		q.budget[p] = q.queues[p].Len()
+	}
+	for p := priorityHigh; p < numPriorities; p++ {
+		if q.budget[p] > 0 {
+			return p
+		}
+	}
+	return priorityNormal
+}
+
+// Done marks item as done processing, queueing it again if it was added
+// while being processed.
+func (q *priorityQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if p, ok := q.processing[item]; ok {
+		q.queues[p].Done(item)
+		delete(q.processing, item)
+	}
+	if _, ok := q.dirty[item]; ok {
+		q.push(item)
+		q.cond.Signal()
+	} else if len(q.processing) == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+// ShutDown makes the queue ignore new items and wakes up all workers.
+func (q *priorityQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = false
+	q.shutDown()
+}
+
+// ShutDownWithDrain is like ShutDown but waits for the items being processed
+// to be done.
+func (q *priorityQueue) ShutDownWithDrain() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = true
+	q.shutDown()
+	for len(q.processing) != 0 && q.drain {
+		q.cond.Wait()
+	}
+}
+
+func (q *priorityQueue) shutDown() {
+	q.shuttingDown = true
+	for item, w := range q.waiting {
+		w.timer.Stop()
+		delete(q.waiting, item)
+	}
+	for _, queue := range q.queues {
+		queue.ShutDown()
+	}
+	q.cond.Broadcast()
+}
+
+// ShuttingDown returns whether the queue is shutting down.
+func (q *priorityQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
+
+// AddAfter adds item to the queue after the given duration has passed. An item
+// already waiting is queued once, at the earliest of the requested times.
+func (q *priorityQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if duration <= 0 {
+		q.add(item)
+		return
+	}
+	readyAt := time.Now().Add(duration)
+	if w, ok := q.waiting[item]; ok {
+		if !readyAt.Before(w.readyAt) {
+			return
+		}
+		w.timer.Stop()
+	}
+	w := &waitingItem{readyAt: readyAt}
+	w.timer = time.AfterFunc(duration, func() {
+		q.cond.L.Lock()
+		defer q.cond.L.Unlock()
+		// The item may have been replaced by an earlier one while the
+		// timer fired.
+		if q.waiting[item] != w {
+			return
+		}
+		delete(q.waiting, item)
+		q.add(item)
+	})
+	q.waiting[item] = w
+}
+
+// AddRateLimited adds item to the queue after the rate limiter says it's ok.
+func (q *priorityQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimitDelay(item))
+}
+
+func (q *priorityQueue) rateLimitDelay(item interface{}) time.Duration {
+	return q.rateLimiter.When(item)
+}
+
+// Forget indicates that an item is finished being retried.
+func (q *priorityQueue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues returns how many times the item was requeued.
+func (q *priorityQueue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
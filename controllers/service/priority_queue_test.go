@@ -0,0 +1,220 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// priorityFromPrefix derives the priority of test items from their prefix.
+func priorityFromPrefix(item interface{}) priority {
+	return parsePriority(strings.SplitN(item.(string), "-", 2)[0])
+}
+
+func getAll(t *testing.T, q *priorityQueue, n int) []string {
+	t.Helper()
+	var items []string
+	for i := 0; i < n; i++ {
+		item, shutdown := q.Get()
+		if shutdown {
+			t.Fatalf("Unexpected shutdown after %d items", i)
+		}
+		items = append(items, item.(string))
+		q.Done(item)
+	}
+	return items
+}
+
+func TestPriorityQueueOrder(t *testing.T) {
+	q := newPriorityQueue("", workqueue.DefaultControllerRateLimiter(), priorityFromPrefix)
+	defer q.ShutDown()
+
+	for _, item := range []string{"low-1", "normal-1", "high-1", "low-2", "high-2"} {
+		q.Add(item)
+	}
+	expected := []string{"high-1", "high-2", "normal-1", "low-1", "low-2"}
+	if got := getAll(t, q, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected items in order %v, got %v", expected, got)
+	}
+}
+
+func TestPriorityQueueBoundedStarvation(t *testing.T) {
+	q := newPriorityQueue("", workqueue.DefaultControllerRateLimiter(), priorityFromPrefix)
+	defer q.ShutDown()
+
+	q.Add("high-1")
+	q.Add("low-1")
+	if got := getAll(t, q, 1); got[0] != "high-1" {
+		t.Fatalf("Expected high-1 first, got %v", got)
+	}
+	// High priority items added during the cycle must not starve low-1.
+	q.Add("high-2")
+	q.Add("high-3")
+	expected := []string{"low-1", "high-2", "high-3"}
+	if got := getAll(t, q, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected items in order %v, got %v", expected, got)
+	}
+}
+
+func TestPriorityQueueDeduplicates(t *testing.T) {
+	q := newPriorityQueue("", workqueue.DefaultControllerRateLimiter(), priorityFromPrefix)
+	defer q.ShutDown()
+
+	q.Add("normal-1")
+	q.Add("normal-1")
+	if q.Len() != 1 {
+		t.Fatalf("Expected 1 queued item, got %d", q.Len())
+	}
+	item, _ := q.Get()
+	// Added while processing, queued again once done.
+	q.Add(item)
+	if q.Len() != 0 {
+		t.Errorf("Expected an item being processed not to be queued, got %d items", q.Len())
+	}
+	q.Done(item)
+	if q.Len() != 1 {
+		t.Errorf("Expected the item to be queued again after Done, got %d items", q.Len())
+	}
+}
+
+func TestPriorityQueueQueued(t *testing.T) {
+	q := newPriorityQueue("", workqueue.DefaultControllerRateLimiter(), priorityFromPrefix)
+	defer q.ShutDown()
+
+	q.Add("normal-1")
+	if !q.queued("normal-1") || q.queued("normal-2") {
+		t.Fatalf("Expected only normal-1 to be queued")
+	}
+	item, _ := q.Get()
+	if q.queued(item) {
+		t.Errorf("Expected an item being processed not to be queued")
+	}
+	q.Add(item)
+	if !q.queued(item) {
+		t.Errorf("Expected an item added while being processed to be queued")
+	}
+	q.Done(item)
+}
+
+func TestPriorityQueueShutDown(t *testing.T) {
+	q := newPriorityQueue("", workqueue.DefaultControllerRateLimiter(), priorityFromPrefix)
+	q.ShutDown()
+	q.Add("high-1")
+	if _, shutdown := q.Get(); !shutdown {
+		t.Errorf("Expected Get to report shutdown")
+	}
+}
+
+func TestPriorityQueueAddAfterDeduplicates(t *testing.T) {
+	q := newPriorityQueue("", workqueue.DefaultControllerRateLimiter(), priorityFromPrefix)
+	defer q.ShutDown()
+
+	q.AddAfter("normal-1", time.Hour)
+	q.AddAfter("normal-1", time.Hour)
+	q.AddAfter("normal-1", 10*time.Millisecond)
+	if len(q.waiting) != 1 {
+		t.Fatalf("Expected 1 waiting item, got %d", len(q.waiting))
+	}
+	if err := wait.PollUntilContextTimeout(context.TODO(), time.Millisecond, time.Second, true, func(context.Context) (bool, error) {
+		return q.Len() == 1, nil
+	}); err != nil {
+		t.Fatalf("Expected the item to be queued at the earliest time, got %d items", q.Len())
+	}
+	if len(q.waiting) != 0 {
+		t.Errorf("Expected no waiting item once queued, got %d", len(q.waiting))
+	}
+}
+
+// countingMetricsProvider counts the items added to the named work queues.
+type countingMetricsProvider struct {
+	sync.Mutex
+	adds map[string]int
+}
+
+type countingMetric struct {
+	provider *countingMetricsProvider
+	name     string
+}
+
+func (m countingMetric) Inc() {
+	m.provider.Lock()
+	defer m.provider.Unlock()
+	m.provider.adds[m.name]++
+}
+
+type noopMetric struct{}
+
+func (noopMetric) Inc()            {}
+func (noopMetric) Dec()            {}
+func (noopMetric) Set(float64)     {}
+func (noopMetric) Observe(float64) {}
+
+func (p *countingMetricsProvider) NewDepthMetric(string) workqueue.GaugeMetric { return noopMetric{} }
+func (p *countingMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return countingMetric{provider: p, name: name}
+}
+func (p *countingMetricsProvider) NewLatencyMetric(string) workqueue.HistogramMetric {
+	return noopMetric{}
+}
+func (p *countingMetricsProvider) NewWorkDurationMetric(string) workqueue.HistogramMetric {
+	return noopMetric{}
+}
+func (p *countingMetricsProvider) NewUnfinishedWorkSecondsMetric(string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+func (p *countingMetricsProvider) NewLongestRunningProcessorSecondsMetric(string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+func (p *countingMetricsProvider) NewRetriesMetric(string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+// queueMetrics is registered once as the work queue metrics provider, which
+// client-go does not allow to replace.
+var (
+	queueMetrics             = &countingMetricsProvider{adds: make(map[string]int)}
+	registerQueueMetricsOnce sync.Once
+)
+
+func TestPriorityQueueMetrics(t *testing.T) {
+	registerQueueMetricsOnce.Do(func() { workqueue.SetProvider(queueMetrics) })
+	queueMetrics.Lock()
+	queueMetrics.adds = make(map[string]int)
+	queueMetrics.Unlock()
+
+	q := newPriorityQueue("priority_test", workqueue.DefaultControllerRateLimiter(), priorityFromPrefix)
+	defer q.ShutDown()
+	for _, item := range []string{"high-1", "normal-1", "normal-2", "low-1"} {
+		q.Add(item)
+	}
+	getAll(t, q, 4)
+
+	queueMetrics.Lock()
+	defer queueMetrics.Unlock()
+	expected := map[string]int{"priority_test_high": 1, "priority_test": 2, "priority_test_low": 1}
+	if !reflect.DeepEqual(queueMetrics.adds, expected) {
+		t.Errorf("Expected adds %v, got %v", expected, queueMetrics.adds)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"sync"
+)
+
+// serviceLocks holds a mutex per service key, serializing the reconciliation
+// of a service between the service and node workers. The mutex of a key is
+// dropped once no goroutine holds or waits for it, so the mutexes of the
+// deleted services don't pile up and two goroutines never hold different
+// mutexes of the same key.
+type serviceLocks struct {
+	lock  sync.Mutex
+	locks map[string]*refCountedMutex
+}
+
+// refCountedMutex is a mutex with the number of goroutines holding or waiting
+// for it.
+type refCountedMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newServiceLocks() *serviceLocks {
+	return &serviceLocks{locks: make(map[string]*refCountedMutex)}
+}
+
+// acquire returns the mutex of the key, counting the caller as a reference
+// until release.
+func (l *serviceLocks) acquire(key string) *refCountedMutex {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m, ok := l.locks[key]
+	if !ok {
+		m = &refCountedMutex{}
+		l.locks[key] = m
+	}
+	m.refs++
+	return m
+}
+
+// release drops the reference of the caller to the mutex of the key, and the
+// mutex with the last reference.
+func (l *serviceLocks) release(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m := l.locks[key]
+	m.refs--
+	if m.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+// Lock locks the mutex of the key, blocking until it is available.
+func (l *serviceLocks) Lock(key string) {
+	l.acquire(key).Lock()
+}
+
+// TryLock locks the mutex of the key if it is available and reports whether
+// it did.
+func (l *serviceLocks) TryLock(key string) bool {
+	if l.acquire(key).TryLock() {
+		return true
+	}
+	l.release(key)
+	return false
+}
+
+// Unlock unlocks the mutex of the key, which must be held by the caller.
+func (l *serviceLocks) Unlock(key string) {
+	l.lock.Lock()
+	m := l.locks[key]
+	l.lock.Unlock()
+	m.Unlock()
+	l.release(key)
+}
+
+// len returns the number of keys whose mutex is held or waited for.
+func (l *serviceLocks) len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.locks)
+}
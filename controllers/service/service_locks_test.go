@@ -0,0 +1,60 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceLocksWaiter(t *testing.T) {
+	locks := newServiceLocks()
+	key := "default/svc"
+
+	locks.Lock(key)
+	locked := make(chan struct{})
+	go func() {
+		locks.Lock(key)
+		close(locked)
+	}()
+	// Wait for the goroutine to reference the mutex of the key.
+	for {
+		locks.lock.Lock()
+		refs := locks.locks[key].refs
+		locks.lock.Unlock()
+		if refs == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The mutex waited for is kept on unlock, the waiter gets it and nobody
+	// else does.
+	locks.Unlock(key)
+	<-locked
+	if locks.TryLock(key) {
+		t.Fatalf("Expected the lock of the waiting goroutine to be held")
+	}
+	if got := locks.len(); got != 1 {
+		t.Fatalf("Expected the held lock to be kept, got %d locks", got)
+	}
+
+	locks.Unlock(key)
+	if got := locks.len(); got != 0 {
+		t.Errorf("Expected the released lock to be dropped, got %d locks", got)
+	}
+}
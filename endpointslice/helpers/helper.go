@@ -136,10 +136,10 @@ func EPSHasLBFinalizer(service *v1.Service) bool {
 
 
 
-// PatchEndpointSlice patches the given endpointSlice's Status or ObjectMeta based on the original and
-// updated ones. Change to spec will be ignored.
+// PatchEndpointSlice patches the given endpointSlice's ObjectMeta based on the original and
+// updated ones. Change to endpoints and ports will be ignored.
 func PatchEndpointSlice(c discoveryv1client.DiscoveryV1Interface, oldEps, newEps *discoveryv1.EndpointSlice) (*discoveryv1.EndpointSlice, error) {
-	// Reset spec to make sure only patch for Status or ObjectMeta.
+	// Reset spec to make sure only patch for ObjectMeta, see epsGetPatchBytes.
 	patchBytes, err := epsGetPatchBytes(oldEps, newEps)
 	if err != nil {
 		return nil, err
@@ -167,7 +167,15 @@ func getPatchBytes(oldSvc, newSvc *v1.Service) ([]byte, error) {
 
 }
 
+// epsGetPatchBytes returns the patch of the ObjectMeta of the endpointSlice.
+// The endpoints and the ports are reset on copies of the given ones, so that
+// the patch never touches them.
 func epsGetPatchBytes(oldEps, newEps *discoveryv1.EndpointSlice) ([]byte, error) {
+	oldCopy, newCopy := *oldEps, *newEps
+	oldEps, newEps = &oldCopy, &newCopy
+	oldEps.Endpoints, newEps.Endpoints = nil, nil
+	oldEps.Ports, newEps.Ports = nil, nil
+
 	oldData, err := json.Marshal(oldEps)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Marshal oldData for svc %s/%s: %v", oldEps.Namespace, oldEps.Name, err)
@@ -0,0 +1,53 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helpers
+
+import (
+	"testing"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_epsGetPatchBytes(t *testing.T) {
+	port := int32(80)
+	origin := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-patch-bytes",
+			Finalizers: []string{"foo"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+		Ports:       []discoveryv1.EndpointPort{{Port: &port}},
+	}
+	updated := origin.DeepCopy()
+	updated.Finalizers = append(updated.Finalizers, "bar")
+	updated.Endpoints = []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.2"}}}
+	updated.Ports = nil
+
+	b, err := epsGetPatchBytes(origin, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"metadata":{"$setElementOrder/finalizers":["foo","bar"],"finalizers":["bar"]}}`
+	if string(b) != expected {
+		t.Errorf("epsGetPatchBytes(%+v, %+v) = %s ; want %s", origin, updated, string(b), expected)
+	}
+	if len(origin.Endpoints) != 1 || len(origin.Ports) != 1 || updated.Endpoints[0].Addresses[0] != "10.0.0.2" {
+		t.Errorf("epsGetPatchBytes() modified its arguments")
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/types"
 	cloudprovider "github.com/inspurDTest/cloud-provider"
 )
@@ -191,7 +192,7 @@ func (f *Cloud) Routes() (cloudprovider.Routes, bool) {
 // GetLoadBalancer is a stub implementation of LoadBalancer.GetLoadBalancer.
 func (f *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
 	status := &v1.LoadBalancerStatus{}
-	status.Ingress = []v1.LoadBalancerIngress{{IP: f.ExternalIP.String()}}
+	status.Ingress = []v1.LoadBalancerIngress{{IP: f.ExternalIP.String(), Ports: portStatuses(service)}}
 
 	return status, f.Exists, f.Err
 }
@@ -204,7 +205,7 @@ func (f *Cloud) GetLoadBalancerName(ctx context.Context, clusterName string, ser
 
 // EnsureLoadBalancer is a test-spy implementation of LoadBalancer.EnsureLoadBalancer.
 // It adds an entry "create" into the internal method call record.
-func (f *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+func (f *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, lbId string) (*v1.LoadBalancerStatus, error) {
 	f.addCall("create")
 	f.markEnsureCall(service, nodes)
 	if f.Balancers == nil {
@@ -223,11 +224,21 @@ func (f *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 	f.Balancers[name] = Balancer{name, region, spec.LoadBalancerIP, spec.Ports, nodes}
 
 	status := &v1.LoadBalancerStatus{}
-	status.Ingress = []v1.LoadBalancerIngress{{IP: f.ExternalIP.String()}}
+	status.Ingress = []v1.LoadBalancerIngress{{IP: f.ExternalIP.String(), Ports: portStatuses(service)}}
 
 	return status, f.Err
 }
 
+// portStatuses returns the status of the load balancer ports of the service,
+// reported without error.
+func portStatuses(service *v1.Service) []v1.PortStatus {
+	var ports []v1.PortStatus
+	for _, port := range service.Spec.Ports {
+		ports = append(ports, v1.PortStatus{Port: port.Port, Protocol: port.Protocol})
+	}
+	return ports
+}
+
 func (f *Cloud) markUpdateCall(service *v1.Service, nodes []*v1.Node) {
 	f.updateCallLock.Lock()
 	defer f.updateCallLock.Unlock()
@@ -258,7 +269,7 @@ func (f *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, serv
 
 // EnsureLoadBalancerDeleted is a test-spy implementation of LoadBalancer.EnsureLoadBalancerDeleted.
 // It adds an entry "delete" into the internal method call record.
-func (f *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+func (f *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service, lbId string) error {
 	f.addCall("delete")
 	return f.Err
 }
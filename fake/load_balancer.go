@@ -0,0 +1,164 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"sync"
+
+	cloudprovider "github.com/inspurDTest/cloud-provider"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+var _ cloudprovider.LoadBalancer = (*FakeLoadBalancer)(nil)
+
+// LoadBalancerCall is a call recorded by FakeLoadBalancer.
+type LoadBalancerCall struct {
+	// Method is one of "get", "ensure", "update" or "delete".
+	Method      string
+	ClusterName string
+	Service     *v1.Service
+	// Nodes are the nodes of the ensure and update calls.
+	Nodes []*v1.Node
+	// LBID is the load balancer ID of the ensure and delete calls.
+	LBID string
+}
+
+// FakeLoadBalancer is a test-double implementation of LoadBalancer recording
+// its calls and returning the results configured with its With* methods, by
+// default a load balancer with no ingress that exists. It is safe for
+// concurrent use.
+type FakeLoadBalancer struct {
+	lock  sync.Mutex
+	calls []LoadBalancerCall
+
+	getStatus    *v1.LoadBalancerStatus
+	exists       bool
+	getErr       error
+	ensureStatus *v1.LoadBalancerStatus
+	ensureErr    error
+	updateErr    error
+	deleteErr    error
+}
+
+// NewFakeLoadBalancer returns a FakeLoadBalancer whose calls all succeed.
+func NewFakeLoadBalancer() *FakeLoadBalancer {
+	return &FakeLoadBalancer{
+		getStatus:    &v1.LoadBalancerStatus{},
+		exists:       true,
+		ensureStatus: &v1.LoadBalancerStatus{},
+	}
+}
+
+// WithGetResult sets the result of GetLoadBalancer.
+func (f *FakeLoadBalancer) WithGetResult(status *v1.LoadBalancerStatus, exists bool, err error) *FakeLoadBalancer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.getStatus, f.exists, f.getErr = status, exists, err
+	return f
+}
+
+// WithEnsureResult sets the result of EnsureLoadBalancer.
+func (f *FakeLoadBalancer) WithEnsureResult(status *v1.LoadBalancerStatus, err error) *FakeLoadBalancer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.ensureStatus, f.ensureErr = status, err
+	return f
+}
+
+// WithUpdateResult sets the result of UpdateLoadBalancer.
+func (f *FakeLoadBalancer) WithUpdateResult(err error) *FakeLoadBalancer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.updateErr = err
+	return f
+}
+
+// WithDeleteResult sets the result of EnsureLoadBalancerDeleted.
+func (f *FakeLoadBalancer) WithDeleteResult(err error) *FakeLoadBalancer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.deleteErr = err
+	return f
+}
+
+// Calls returns a copy of the calls recorded so far, in order.
+func (f *FakeLoadBalancer) Calls() []LoadBalancerCall {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]LoadBalancerCall(nil), f.calls...)
+}
+
+// CallCount returns the number of calls of the method recorded so far.
+func (f *FakeLoadBalancer) CallCount(method string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	count := 0
+	for _, call := range f.calls {
+		if call.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *FakeLoadBalancer) record(call LoadBalancerCall) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+// GetLoadBalancer records a "get" call and returns the configured result.
+func (f *FakeLoadBalancer) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	f.record(LoadBalancerCall{Method: "get", ClusterName: clusterName, Service: service})
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.getStatus.DeepCopy(), f.exists, f.getErr
+}
+
+// GetLoadBalancerName returns the default name of the load balancer.
+func (f *FakeLoadBalancer) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	return cloudprovider.DefaultLoadBalancerName(service)
+}
+
+// EnsureLoadBalancer records an "ensure" call and returns the configured result.
+func (f *FakeLoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node, endpointSlices []*discoveryv1.EndpointSlice, lbId string) (*v1.LoadBalancerStatus, error) {
+	f.record(LoadBalancerCall{Method: "ensure", ClusterName: clusterName, Service: service, Nodes: nodes, LBID: lbId})
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.ensureErr != nil {
+		return nil, f.ensureErr
+	}
+	return f.ensureStatus.DeepCopy(), nil
+}
+
+// UpdateLoadBalancer records an "update" call and returns the configured result.
+func (f *FakeLoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	f.record(LoadBalancerCall{Method: "update", ClusterName: clusterName, Service: service, Nodes: nodes})
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.updateErr
+}
+
+// EnsureLoadBalancerDeleted records a "delete" call and returns the configured result.
+func (f *FakeLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service, lbId string) error {
+	f.record(LoadBalancerCall{Method: "delete", ClusterName: clusterName, Service: service, LBID: lbId})
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.deleteErr
+}
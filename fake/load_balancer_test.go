@@ -0,0 +1,70 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestFakeLoadBalancer(t *testing.T) {
+	status := &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}}
+	deleteErr := errors.New("delete failed")
+	lb := NewFakeLoadBalancer().WithEnsureResult(status, nil).WithDeleteResult(deleteErr)
+	svc := &v1.Service{}
+	ctx := context.Background()
+
+	got, err := lb.EnsureLoadBalancer(ctx, "cluster", svc, nil, nil, "lb-1")
+	if err != nil || !reflect.DeepEqual(got, status) {
+		t.Errorf("EnsureLoadBalancer() = %v, %v, want %v, nil", got, err, status)
+	}
+	if err := lb.UpdateLoadBalancer(ctx, "cluster", svc, nil); err != nil {
+		t.Errorf("UpdateLoadBalancer() = %v, want nil", err)
+	}
+	if err := lb.EnsureLoadBalancerDeleted(ctx, "cluster", svc, "lb-1"); err != deleteErr {
+		t.Errorf("EnsureLoadBalancerDeleted() = %v, want %v", err, deleteErr)
+	}
+
+	var methods []string
+	for _, call := range lb.Calls() {
+		methods = append(methods, call.Method)
+	}
+	if expected := []string{"ensure", "update", "delete"}; !reflect.DeepEqual(methods, expected) {
+		t.Errorf("expected calls %v, got %v", expected, methods)
+	}
+}
+
+func TestFakeLoadBalancerConcurrentCalls(t *testing.T) {
+	lb := NewFakeLoadBalancer()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lb.UpdateLoadBalancer(context.Background(), "cluster", &v1.Service{}, nil)
+		}()
+	}
+	wg.Wait()
+	if got := lb.CallCount("update"); got != 10 {
+		t.Errorf("expected 10 update calls, got %d", got)
+	}
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package options
 
 import (
+	"fmt"
+
 	"github.com/spf13/pflag"
 	 endpointSliceConfig "github.com/inspurDTest/cloud-provider/controllers/endpointslice/config"
 )
@@ -32,7 +34,7 @@ func (o *EndpointSliceOptions) AddFlags(fs *pflag.FlagSet) {
 		return
 	}
 
-	fs.Int32Var(&o.ConcurrentEndpointSliceSyncs, "concurrent-service-syncs", o.ConcurrentEndpointSliceSyncs, "The number of services that are allowed to sync concurrently. Larger number = more responsive service management, but more CPU (and network) load")
+	fs.Int32Var(&o.ConcurrentEndpointSliceSyncs, "concurrent-endpointslice-syncs", o.ConcurrentEndpointSliceSyncs, "The number of endpointslices that are allowed to sync concurrently. Larger number = more responsive endpointslice management, but more CPU (and network) load")
 }
 
 // ApplyTo fills up EndpointSlice config with options.
@@ -53,5 +55,9 @@ func (o *EndpointSliceOptions) Validate() []error {
 	}
 
 	errs := []error{}
+	// The flags are not converted, the conversion doesn't validate them.
+	if n := o.ConcurrentEndpointSliceSyncs; n <= 0 || n > endpointSliceConfig.MaxConcurrentEndpointSliceSyncs {
+		errs = append(errs, fmt.Errorf("--concurrent-endpointslice-syncs must be between 1 and %d, got %d", endpointSliceConfig.MaxConcurrentEndpointSliceSyncs, n))
+	}
 	return errs
 }
@@ -153,6 +153,7 @@ func (o *CloudControllerManagerOptions) Flags(allControllers []string, disabledB
 	o.KubeCloudShared.AddFlags(fss.FlagSet("generic"))
 	o.NodeController.AddFlags(fss.FlagSet(names.CloudNodeController))
 	o.ServiceController.AddFlags(fss.FlagSet(names.ServiceLBController))
+	o.EndpointSliceController.AddFlags(fss.FlagSet(names.EndpointSliceController))
 	if o.Webhook != nil {
 		o.Webhook.AddFlags(fss.FlagSet("webhook"), allWebhooks, disabledByDefaultWebhooks)
 	}
@@ -199,6 +200,9 @@ func (o *CloudControllerManagerOptions) ApplyTo(c *config.Config, allControllers
 	if err = o.ServiceController.ApplyTo(&c.ComponentConfig.ServiceController); err != nil {
 		return err
 	}
+	if err = o.EndpointSliceController.ApplyTo(&c.ComponentConfig.EndpointSliceController); err != nil {
+		return err
+	}
 	if o.Webhook != nil {
 		if err = o.Webhook.ApplyTo(&c.ComponentConfig.Webhook); err != nil {
 			return err
@@ -258,6 +262,7 @@ func (o *CloudControllerManagerOptions) Validate(allControllers []string, disabl
 	errors = append(errors, o.Generic.Validate(allControllers, disabledByDefaultControllers, controllerAliases)...)
 	errors = append(errors, o.KubeCloudShared.Validate()...)
 	errors = append(errors, o.ServiceController.Validate()...)
+	errors = append(errors, o.EndpointSliceController.Validate()...)
 	errors = append(errors, o.SecureServing.Validate()...)
 	errors = append(errors, o.Authentication.Validate()...)
 	errors = append(errors, o.Authorization.Validate()...)
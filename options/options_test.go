@@ -30,6 +30,9 @@ import (
 	apiserveroptions "k8s.io/apiserver/pkg/server/options"
 	appconfig "github.com/inspurDTest/cloud-provider/app/config"
 	cpconfig "github.com/inspurDTest/cloud-provider/config"
+	ccmconfigscheme "github.com/inspurDTest/cloud-provider/config/install"
+	ccmconfigv1alpha1 "github.com/inspurDTest/cloud-provider/config/v1alpha1"
+	endpointsliceconfig "github.com/inspurDTest/cloud-provider/controllers/endpointslice/config"
 	nodeconfig "github.com/inspurDTest/cloud-provider/controllers/node/config"
 	serviceconfig "github.com/inspurDTest/cloud-provider/controllers/service/config"
 	componentbaseconfig "k8s.io/component-base/config"
@@ -102,7 +105,22 @@ func TestDefaultFlags(t *testing.T) {
 		},
 		ServiceController: &ServiceControllerOptions{
 			ServiceControllerConfiguration: &serviceconfig.ServiceControllerConfiguration{
-				ConcurrentServiceSyncs: 1,
+				ConcurrentServiceSyncs:   1,
+				LBAPITimeout:             metav1.Duration{Duration: 120 * time.Second},
+				LBDefaultIdleTimeout:     metav1.Duration{Duration: 60 * time.Second},
+				NodeCacheSyncTimeout:     metav1.Duration{Duration: 5 * time.Minute},
+				PreserveIngressOnEmpty:   true,
+				ResyncOnStartup:          true,
+				LBAnnotationPrefix:       "inspur.com",
+				LBTagLabelPrefix:         "inspur.com/tag-",
+				EventRateLimiterBurst:    25,
+				EventDedupWindow:         metav1.Duration{Duration: 60 * time.Second},
+				AdminEndpointBindAddress: "127.0.0.1:10270",
+			},
+		},
+		EndpointSliceController: &EndpointSliceOptions{
+			EndpointSliceControllerConfiguration: &endpointsliceconfig.EndpointSliceControllerConfiguration{
+				ConcurrentEndpointSliceSyncs: 1,
 			},
 		},
 		Webhook: &WebhookOptions{},
@@ -197,6 +215,35 @@ func TestAddFlags(t *testing.T) {
 		"--secure-port=10001",
 		"--use-service-account-credentials=false",
 		"--concurrent-node-syncs=5",
+		"--concurrent-endpointslice-syncs=4",
+		"--max-concurrent-lb-operations=3",
+		"--concurrent-lb-delete-workers=2",
+		"--lb-default-idle-timeout=90s",
+		"--default-lb-subnet-id=subnet-1",
+		"--watch-namespace=lb-system",
+		"--managed-lb-configmap=lb-system/managed-lbs",
+		"--lb-class-name=inspur.com/lb",
+		"--lb-annotation-prefix=example.com",
+		"--lb-tag-label-prefix=example.com/cost-",
+		"--node-label-selector=role=lb-eligible",
+		"--node-readiness-staleness-threshold=5m",
+		"--tolerate-deletion-timestamp-grace-period=30s",
+		"--lb-api-timeout=30s",
+		"--max-items-per-namespace=50",
+		"--enable-orphan-lb-cleanup=true",
+		"--enable-lb-status-reconciliation=true",
+		"--enable-lb-provisioning-condition=true",
+		"--enable-lb-readiness-gate=true",
+		"--filter-notready-endpoints=true",
+		"--event-rate-limiter-qps=0.5",
+		"--event-rate-limiter-burst=5",
+		"--node-cache-sync-timeout=10m",
+		"--service-cache-snapshot-path=/var/lib/service-controller/cache.json",
+		"--event-dedup-window=2m",
+		"--preserve-ingress-on-empty=false",
+		"--resync-on-startup=false",
+		"--enable-admin-endpoint=true",
+		"--admin-endpoint-bind-address=127.0.0.1:9999",
 		"--webhooks=foo,bar,-baz",
 	}
 	err = fs.Parse(args)
@@ -259,7 +306,38 @@ func TestAddFlags(t *testing.T) {
 		},
 		ServiceController: &ServiceControllerOptions{
 			ServiceControllerConfiguration: &serviceconfig.ServiceControllerConfiguration{
-				ConcurrentServiceSyncs: 1,
+				ConcurrentServiceSyncs:          1,
+				MaxConcurrentLBOperations:       3,
+				ConcurrentLBDeleteWorkers:       2,
+				MaxItemsPerNamespace:            50,
+				LBAPITimeout:                    metav1.Duration{Duration: 30 * time.Second},
+				LBDefaultIdleTimeout:            metav1.Duration{Duration: 90 * time.Second},
+				DefaultLBSubnetID:               "subnet-1",
+				WatchNamespace:                  "lb-system",
+				ManagedLBConfigMap:              "lb-system/managed-lbs",
+				LBClassName:                     "inspur.com/lb",
+				LBAnnotationPrefix:              "example.com",
+				LBTagLabelPrefix:                "example.com/cost-",
+				NodeLabelSelector:               "role=lb-eligible",
+				NodeReadinessStalenessThreshold: metav1.Duration{Duration: 5 * time.Minute},
+				DeletionGracePeriod:             metav1.Duration{Duration: 30 * time.Second},
+				NodeCacheSyncTimeout:            metav1.Duration{Duration: 10 * time.Minute},
+				ServiceCacheSnapshotPath:        "/var/lib/service-controller/cache.json",
+				EnableOrphanLBCleanup:           true,
+				EnableLBStatusReconciliation:    true,
+				EnableLBProvisioningCondition:   true,
+				EnableLBReadinessGate:           true,
+				FilterNotReadyEndpoints:         true,
+				EventRateLimiterQPS:             0.5,
+				EventRateLimiterBurst:           5,
+				EventDedupWindow:                metav1.Duration{Duration: 2 * time.Minute},
+				EnableAdminEndpoint:             true,
+				AdminEndpointBindAddress:        "127.0.0.1:9999",
+			},
+		},
+		EndpointSliceController: &EndpointSliceOptions{
+			EndpointSliceControllerConfiguration: &endpointsliceconfig.EndpointSliceControllerConfiguration{
+				ConcurrentEndpointSliceSyncs: 4,
 			},
 		},
 		Webhook: &WebhookOptions{
@@ -415,9 +493,20 @@ func TestCreateConfig(t *testing.T) {
 				},
 			},
 			ServiceController: serviceconfig.ServiceControllerConfiguration{
-				ConcurrentServiceSyncs: 1,
+				ConcurrentServiceSyncs:   1,
+				LBAPITimeout:             metav1.Duration{Duration: 120 * time.Second},
+				LBDefaultIdleTimeout:     metav1.Duration{Duration: 60 * time.Second},
+				NodeCacheSyncTimeout:     metav1.Duration{Duration: 5 * time.Minute},
+				PreserveIngressOnEmpty:   true,
+				ResyncOnStartup:          true,
+				LBAnnotationPrefix:       "inspur.com",
+				LBTagLabelPrefix:         "inspur.com/tag-",
+				EventRateLimiterBurst:    25,
+				EventDedupWindow:         metav1.Duration{Duration: 60 * time.Second},
+				AdminEndpointBindAddress: "127.0.0.1:10270",
 			},
 			NodeController:            nodeconfig.NodeControllerConfiguration{ConcurrentNodeSyncs: 1},
+			EndpointSliceController:   endpointsliceconfig.EndpointSliceControllerConfiguration{ConcurrentEndpointSliceSyncs: 1},
 			NodeStatusUpdateFrequency: metav1.Duration{Duration: 10 * time.Minute},
 			Webhook: cpconfig.WebhookConfiguration{
 				Webhooks: []string{"foo", "bar", "-baz"},
@@ -483,3 +572,48 @@ func TestCloudControllerManagerAliases(t *testing.T) {
 		t.Errorf("controller aliases not resolved correctly, expected %+v, got %+v", expectedControllers, cfg.Controllers)
 	}
 }
+
+func TestConfigConcurrentEndpointSliceSyncs(t *testing.T) {
+	testCases := []struct {
+		syncs     string
+		expectErr bool
+	}{
+		{syncs: "0", expectErr: true},
+		{syncs: "4"},
+		{syncs: "1025", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.syncs, func(t *testing.T) {
+			s, err := NewCloudControllerManagerOptions()
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			fs := pflag.NewFlagSet("endpointslicetest", pflag.ContinueOnError)
+			for _, f := range s.Flags([]string{""}, []string{""}, nil, []string{""}, []string{""}).FlagSets {
+				fs.AddFlagSet(f)
+			}
+			if err := fs.Parse([]string{"--cloud-provider=aws", "--master=192.168.4.20", "--concurrent-endpointslice-syncs=" + tc.syncs}); err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+
+			c, err := s.Config([]string{"foo"}, []string{}, nil, []string{}, []string{})
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("Config() with %s concurrent endpointslice syncs: expected error %v, got %v", tc.syncs, tc.expectErr, err)
+			}
+			if err == nil && fmt.Sprint(c.ComponentConfig.EndpointSliceController.ConcurrentEndpointSliceSyncs) != tc.syncs {
+				t.Errorf("Expected %s concurrent endpointslice syncs, got %d", tc.syncs, c.ComponentConfig.EndpointSliceController.ConcurrentEndpointSliceSyncs)
+			}
+		})
+	}
+}
+
+func TestConvertConcurrentEndpointSliceSyncs(t *testing.T) {
+	versioned := &ccmconfigv1alpha1.CloudControllerManagerConfiguration{}
+	ccmconfigscheme.Scheme.Default(versioned)
+	versioned.EndpointSliceController.ConcurrentEndpointSliceSyncs = 1025
+
+	internal := &cpconfig.CloudControllerManagerConfiguration{}
+	if err := ccmconfigscheme.Scheme.Convert(versioned, internal, nil); err == nil {
+		t.Errorf("Expected the conversion of 1025 concurrent endpointslice syncs to fail")
+	}
+}
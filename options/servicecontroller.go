@@ -17,7 +17,14 @@ limitations under the License.
 package options
 
 import (
+	"fmt"
+	"net"
+	"strings"
+
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	servicecontroller "github.com/inspurDTest/cloud-provider/controllers/service"
 	serviceconfig "github.com/inspurDTest/cloud-provider/controllers/service/config"
 )
 
@@ -33,6 +40,34 @@ func (o *ServiceControllerOptions) AddFlags(fs *pflag.FlagSet) {
 	}
 
 	fs.Int32Var(&o.ConcurrentServiceSyncs, "concurrent-service-syncs", o.ConcurrentServiceSyncs, "The number of services that are allowed to sync concurrently. Larger number = more responsive service management, but more CPU (and network) load")
+	fs.Int32Var(&o.MaxConcurrentLBOperations, "max-concurrent-lb-operations", o.MaxConcurrentLBOperations, "The maximum number of load balancer operations sent to the cloud provider at the same time. 0 means the same as --concurrent-service-syncs")
+	fs.Int32Var(&o.ConcurrentLBDeleteWorkers, "concurrent-lb-delete-workers", o.ConcurrentLBDeleteWorkers, "The number of load balancer deletions that are allowed to run concurrently, separately from --concurrent-service-syncs. 0 means min(--concurrent-service-syncs, 5)")
+	fs.Int32Var(&o.MaxItemsPerNamespace, "max-items-per-namespace", o.MaxItemsPerNamespace, "The number of services of a single namespace pending in the service queue above which further services of the namespace are delayed. 0 means unlimited")
+	fs.DurationVar(&o.LBAPITimeout.Duration, "lb-api-timeout", o.LBAPITimeout.Duration, "The timeout of each cloud provider load balancer call. Timed out calls are retried")
+	fs.DurationVar(&o.LBDefaultIdleTimeout.Duration, "lb-default-idle-timeout", o.LBDefaultIdleTimeout.Duration, "The idle timeout of load balancer connections for services without the inspur.com/lb-idle-timeout annotation. Must be between 5s and 3600s")
+	fs.StringVar(&o.DefaultLBSubnetID, "default-lb-subnet-id", o.DefaultLBSubnetID, "The subnet of the load balancers of services without the inspur.com/lb-subnet-id annotation. Empty means the cloud provider default")
+	fs.StringVar(&o.WatchNamespace, "watch-namespace", o.WatchNamespace, "Watch and manage only the services of this namespace, for namespace-scoped RBAC. Empty means all namespaces")
+	fs.StringVar(&o.ManagedLBConfigMap, "managed-lb-configmap", o.ManagedLBConfigMap, "The namespace/name of a ConfigMap listing, in its lb-ids key, the IDs of the load balancers the controller may ensure, separated by commas or whitespace. Changes are picked up without a restart. Empty means all load balancers")
+	fs.StringVar(&o.LBClassName, "lb-class-name", o.LBClassName, "Manage only the services with this spec.loadBalancerClass, allowing several controllers to manage different classes. Empty means only the services without a class")
+	fs.StringVar(&o.LBAnnotationPrefix, "lb-annotation-prefix", o.LBAnnotationPrefix, "The domain of the annotations of the services, e.g. inspur.com for inspur.com/load-balancer-id, for deployers branding the controller under their own domain")
+	fs.StringVar(&o.LBTagLabelPrefix, "lb-tag-label-prefix", o.LBTagLabelPrefix, "The prefix of the labels of the services that are set, without the prefix, as tags of their load balancer, e.g. for cost attribution")
+	fs.StringVar(&o.NodeLabelSelector, "node-label-selector", o.NodeLabelSelector, "A label selector restricting the nodes eligible as load balancer backends, e.g. role=lb-eligible. Empty means all nodes")
+	fs.DurationVar(&o.NodeReadinessStalenessThreshold.Duration, "node-readiness-staleness-threshold", o.NodeReadinessStalenessThreshold.Duration, "How long a node may report a non-True Ready condition before it is excluded from load balancers. 0 means nodes are excluded as soon as they are not ready")
+	fs.DurationVar(&o.DeletionGracePeriod.Duration, "tolerate-deletion-timestamp-grace-period", o.DeletionGracePeriod.Duration, "How long to keep the load balancer of a deleted service before deleting it, to drain long-lived connections. 0 deletes it right away")
+	fs.BoolVar(&o.ResyncOnStartup, "resync-on-startup", o.ResyncOnStartup, "Once the caches are synced, queue all the services with a load balancer, reconciling the ones that diverged while the controller was down")
+	fs.BoolVar(&o.EnableOrphanLBCleanup, "enable-orphan-lb-cleanup", o.EnableOrphanLBCleanup, "On startup, delete the load balancers whose service no longer exists, e.g. because it was deleted while the controller was down. Requires a cloud provider able to list load balancers")
+	fs.BoolVar(&o.EnableLBStatusReconciliation, "enable-lb-status-reconciliation", o.EnableLBStatusReconciliation, "Periodically compare the status of the load balancers reported by the cloud provider with the status of their services, queuing the services that drifted. This doubles the cloud provider calls")
+	fs.BoolVar(&o.EnableLBProvisioningCondition, "enable-lb-provisioning-condition", o.EnableLBProvisioningCondition, "Report the calls ensuring the load balancer in the cloud.inspur.com/LoadBalancerProvisioning condition of the service status")
+	fs.BoolVar(&o.EnableLBReadinessGate, "enable-lb-readiness-gate", o.EnableLBReadinessGate, "Report the provisioning of the load balancer in the cloud.inspur.com/lb-ready condition of the service status")
+	fs.BoolVar(&o.FilterNotReadyEndpoints, "filter-notready-endpoints", o.FilterNotReadyEndpoints, "Exclude the endpoints which are not ready from the members of the load balancers, for the clouds not health checking them")
+	fs.Float32Var(&o.EventRateLimiterQPS, "event-rate-limiter-qps", o.EventRateLimiterQPS, "The number of events per second recorded for a single object, further events are dropped. 0 means unlimited")
+	fs.Int32Var(&o.EventRateLimiterBurst, "event-rate-limiter-burst", o.EventRateLimiterBurst, "The number of events recorded for a single object in a burst above --event-rate-limiter-qps")
+	fs.DurationVar(&o.NodeCacheSyncTimeout.Duration, "node-cache-sync-timeout", o.NodeCacheSyncTimeout.Duration, "How long to wait on startup for the service, node and endpointslice caches to sync before exiting with an error. 0 waits forever")
+	fs.StringVar(&o.ServiceCacheSnapshotPath, "service-cache-snapshot-path", o.ServiceCacheSnapshotPath, "The file the service cache is periodically saved to and restored from on startup, sparing the reconciliation of the load balancers unchanged across a restart. Empty disables the snapshots")
+	fs.DurationVar(&o.EventDedupWindow.Duration, "event-dedup-window", o.EventDedupWindow.Duration, "How long the repeats of an event of a service, type, reason and message alike, are dropped before bumping the count of the event. 0 disables the deduplication")
+	fs.BoolVar(&o.EnableAdminEndpoint, "enable-admin-endpoint", o.EnableAdminEndpoint, "Serve the /admin/reconcile endpoint forcing a full reconciliation of all services. Requests must carry the token of the kube-system/service-controller-admin-token Secret as a bearer token")
+	fs.StringVar(&o.AdminEndpointBindAddress, "admin-endpoint-bind-address", o.AdminEndpointBindAddress, "The address the admin endpoint listens on when --enable-admin-endpoint is set")
+	fs.BoolVar(&o.PreserveIngressOnEmpty, "preserve-ingress-on-empty", o.PreserveIngressOnEmpty, "Keep the ingress of the service status when the cloud provider returns a load balancer status without ingress")
 }
 
 // ApplyTo fills up ServiceController config with options.
@@ -42,6 +77,34 @@ func (o *ServiceControllerOptions) ApplyTo(cfg *serviceconfig.ServiceControllerC
 	}
 
 	cfg.ConcurrentServiceSyncs = o.ConcurrentServiceSyncs
+	cfg.MaxConcurrentLBOperations = o.MaxConcurrentLBOperations
+	cfg.ConcurrentLBDeleteWorkers = o.ConcurrentLBDeleteWorkers
+	cfg.MaxItemsPerNamespace = o.MaxItemsPerNamespace
+	cfg.LBAPITimeout = o.LBAPITimeout
+	cfg.LBDefaultIdleTimeout = o.LBDefaultIdleTimeout
+	cfg.DefaultLBSubnetID = o.DefaultLBSubnetID
+	cfg.WatchNamespace = o.WatchNamespace
+	cfg.ManagedLBConfigMap = o.ManagedLBConfigMap
+	cfg.LBClassName = o.LBClassName
+	cfg.LBAnnotationPrefix = o.LBAnnotationPrefix
+	cfg.LBTagLabelPrefix = o.LBTagLabelPrefix
+	cfg.NodeLabelSelector = o.NodeLabelSelector
+	cfg.NodeReadinessStalenessThreshold = o.NodeReadinessStalenessThreshold
+	cfg.DeletionGracePeriod = o.DeletionGracePeriod
+	cfg.NodeCacheSyncTimeout = o.NodeCacheSyncTimeout
+	cfg.ServiceCacheSnapshotPath = o.ServiceCacheSnapshotPath
+	cfg.PreserveIngressOnEmpty = o.PreserveIngressOnEmpty
+	cfg.ResyncOnStartup = o.ResyncOnStartup
+	cfg.EnableOrphanLBCleanup = o.EnableOrphanLBCleanup
+	cfg.EnableLBStatusReconciliation = o.EnableLBStatusReconciliation
+	cfg.EnableLBProvisioningCondition = o.EnableLBProvisioningCondition
+	cfg.EnableLBReadinessGate = o.EnableLBReadinessGate
+	cfg.FilterNotReadyEndpoints = o.FilterNotReadyEndpoints
+	cfg.EventRateLimiterQPS = o.EventRateLimiterQPS
+	cfg.EventRateLimiterBurst = o.EventRateLimiterBurst
+	cfg.EventDedupWindow = o.EventDedupWindow
+	cfg.EnableAdminEndpoint = o.EnableAdminEndpoint
+	cfg.AdminEndpointBindAddress = o.AdminEndpointBindAddress
 
 	return nil
 }
@@ -53,5 +116,83 @@ func (o *ServiceControllerOptions) Validate() []error {
 	}
 
 	errs := []error{}
+	if o.MaxConcurrentLBOperations < 0 {
+		errs = append(errs, fmt.Errorf("--max-concurrent-lb-operations must not be negative, got %d", o.MaxConcurrentLBOperations))
+	}
+	if o.ConcurrentLBDeleteWorkers < 0 {
+		errs = append(errs, fmt.Errorf("--concurrent-lb-delete-workers must not be negative, got %d", o.ConcurrentLBDeleteWorkers))
+	}
+	if o.MaxItemsPerNamespace < 0 {
+		errs = append(errs, fmt.Errorf("--max-items-per-namespace must not be negative, got %d", o.MaxItemsPerNamespace))
+	}
+	if o.LBAPITimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("--lb-api-timeout must be positive, got %v", o.LBAPITimeout.Duration))
+	}
+	if d := o.LBDefaultIdleTimeout.Duration; d < servicecontroller.MinIdleTimeout || d > servicecontroller.MaxIdleTimeout {
+		errs = append(errs, fmt.Errorf("--lb-default-idle-timeout must be between %v and %v, got %v", servicecontroller.MinIdleTimeout, servicecontroller.MaxIdleTimeout, d))
+	}
+	if len(o.DefaultLBSubnetID) != 0 {
+		if err := servicecontroller.ValidateSubnetID(o.DefaultLBSubnetID); err != nil {
+			errs = append(errs, fmt.Errorf("--default-lb-subnet-id is invalid: %v", err))
+		}
+	}
+	if len(o.WatchNamespace) != 0 {
+		for _, msg := range validation.IsDNS1123Label(o.WatchNamespace) {
+			errs = append(errs, fmt.Errorf("--watch-namespace is invalid: %s", msg))
+		}
+	}
+	if len(o.ManagedLBConfigMap) != 0 {
+		namespace, name, ok := strings.Cut(o.ManagedLBConfigMap, "/")
+		if !ok {
+			errs = append(errs, fmt.Errorf("--managed-lb-configmap must be of the form namespace/name, got %q", o.ManagedLBConfigMap))
+		} else {
+			for _, msg := range validation.IsDNS1123Label(namespace) {
+				errs = append(errs, fmt.Errorf("--managed-lb-configmap namespace is invalid: %s", msg))
+			}
+			for _, msg := range validation.IsDNS1123Subdomain(name) {
+				errs = append(errs, fmt.Errorf("--managed-lb-configmap name is invalid: %s", msg))
+			}
+		}
+	}
+	if len(o.LBClassName) != 0 {
+		for _, msg := range validation.IsQualifiedName(o.LBClassName) {
+			errs = append(errs, fmt.Errorf("--lb-class-name is invalid: %s", msg))
+		}
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(o.LBAnnotationPrefix) {
+		errs = append(errs, fmt.Errorf("--lb-annotation-prefix is invalid: %s", msg))
+	}
+	if len(o.LBTagLabelPrefix) != 0 {
+		// The prefix must start a valid label key.
+		for _, msg := range validation.IsQualifiedName(o.LBTagLabelPrefix + "x") {
+			errs = append(errs, fmt.Errorf("--lb-tag-label-prefix is invalid: %s", msg))
+		}
+	}
+	if _, err := labels.Parse(o.NodeLabelSelector); err != nil {
+		errs = append(errs, fmt.Errorf("--node-label-selector is invalid: %v", err))
+	}
+	if o.NodeReadinessStalenessThreshold.Duration < 0 {
+		errs = append(errs, fmt.Errorf("--node-readiness-staleness-threshold must not be negative, got %v", o.NodeReadinessStalenessThreshold.Duration))
+	}
+	if o.DeletionGracePeriod.Duration < 0 {
+		errs = append(errs, fmt.Errorf("--tolerate-deletion-timestamp-grace-period must not be negative, got %v", o.DeletionGracePeriod.Duration))
+	}
+	if o.EventRateLimiterQPS < 0 {
+		errs = append(errs, fmt.Errorf("--event-rate-limiter-qps must not be negative, got %v", o.EventRateLimiterQPS))
+	}
+	if o.EventRateLimiterQPS > 0 && o.EventRateLimiterBurst < 1 {
+		errs = append(errs, fmt.Errorf("--event-rate-limiter-burst must be at least 1 when --event-rate-limiter-qps is set, got %d", o.EventRateLimiterBurst))
+	}
+	if o.NodeCacheSyncTimeout.Duration < 0 {
+		errs = append(errs, fmt.Errorf("--node-cache-sync-timeout must not be negative, got %v", o.NodeCacheSyncTimeout.Duration))
+	}
+	if o.EventDedupWindow.Duration < 0 {
+		errs = append(errs, fmt.Errorf("--event-dedup-window must not be negative, got %v", o.EventDedupWindow.Duration))
+	}
+	if o.EnableAdminEndpoint {
+		if _, _, err := net.SplitHostPort(o.AdminEndpointBindAddress); err != nil {
+			errs = append(errs, fmt.Errorf("--admin-endpoint-bind-address is invalid: %v", err))
+		}
+	}
 	return errs
 }
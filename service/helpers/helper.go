@@ -121,14 +121,21 @@ func HasLBFinalizer(service *v1.Service) bool {
 	}
 	return false
 }
-// HasAnnoation checks if service have annotation
-func HasAnnoation(svc *v1.Service, key string) bool {
-	if svc.Annotations != nil && len(svc.Annotations[key]) == 0 {
+
+// HasAnnotation checks if service has the annotation key, whatever its value.
+func HasAnnotation(svc *v1.Service, key string) bool {
+	if svc.Annotations == nil {
 		return false
 	}
-	return true
+	_, ok := svc.Annotations[key]
+	return ok
 }
 
+// HasAnnoation is the former, misspelled, name of HasAnnotation.
+//
+// Deprecated: use HasAnnotation.
+var HasAnnoation = HasAnnotation
+
 
 // HasLBFinalizer checks if service contains LoadBalancerCleanupFinalizer.
 func EPSHasLBFinalizer(service *v1.Service) bool {
@@ -142,7 +149,7 @@ func EPSHasLBFinalizer(service *v1.Service) bool {
 
 // LoadBalancerStatusEqual checks if load balancer status are equal
 func LoadBalancerStatusEqual(l, r *v1.LoadBalancerStatus) bool {
-	return ingressSliceEqual(l.Ingress, r.Ingress)
+	return IngressSliceEqual(l.Ingress, r.Ingress)
 }
 
 // PatchService patches the given service's Status or ObjectMeta based on the original and
@@ -179,7 +186,9 @@ func getPatchBytes(oldSvc, newSvc *v1.Service) ([]byte, error) {
 
 }
 
-func ingressSliceEqual(lhs, rhs []v1.LoadBalancerIngress) bool {
+// IngressSliceEqual checks if two load balancer ingress slices are equal,
+// including the status of their ports.
+func IngressSliceEqual(lhs, rhs []v1.LoadBalancerIngress) bool {
 	if len(lhs) != len(rhs) {
 		return false
 	}
@@ -191,12 +200,40 @@ func ingressSliceEqual(lhs, rhs []v1.LoadBalancerIngress) bool {
 	return true
 }
 
+// NormalizeIngress returns a copy of the ingress with its hostname lower-cased
+// and stripped of the trailing dot, so that the same FQDN reported in different
+// forms compares equal.
+func NormalizeIngress(ingress *v1.LoadBalancerIngress) *v1.LoadBalancerIngress {
+	normalized := *ingress
+	normalized.Hostname = strings.TrimSuffix(strings.ToLower(ingress.Hostname), ".")
+	return &normalized
+}
+
 func ingressEqual(lhs, rhs *v1.LoadBalancerIngress) bool {
+	lhs, rhs = NormalizeIngress(lhs), NormalizeIngress(rhs)
 	if lhs.IP != rhs.IP {
 		return false
 	}
 	if lhs.Hostname != rhs.Hostname {
 		return false
 	}
+	return portStatusSliceEqual(lhs.Ports, rhs.Ports)
+}
+
+func portStatusSliceEqual(lhs, rhs []v1.PortStatus) bool {
+	if len(lhs) != len(rhs) {
+		return false
+	}
+	for i := range lhs {
+		if lhs[i].Port != rhs[i].Port || lhs[i].Protocol != rhs[i].Protocol {
+			return false
+		}
+		if (lhs[i].Error == nil) != (rhs[i].Error == nil) {
+			return false
+		}
+		if lhs[i].Error != nil && *lhs[i].Error != *rhs[i].Error {
+			return false
+		}
+	}
 	return true
 }
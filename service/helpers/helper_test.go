@@ -273,6 +273,58 @@ func TestHasLBFinalizer(t *testing.T) {
 	}
 }
 
+func TestHasAnnotation(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		svc           *v1.Service
+		hasAnnotation bool
+	}{
+		{
+			desc:          "service with nil annotations",
+			svc:           &v1.Service{},
+			hasAnnotation: false,
+		},
+		{
+			desc: "service without the annotation",
+			svc: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"unrelated": "value"},
+				},
+			},
+			hasAnnotation: false,
+		},
+		{
+			desc: "service with the annotation",
+			svc: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"key": "value"},
+				},
+			},
+			hasAnnotation: true,
+		},
+		{
+			desc: "service with the annotation set to an empty value",
+			svc: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"key": ""},
+				},
+			},
+			hasAnnotation: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if hasAnnotation := HasAnnotation(tc.svc, "key"); hasAnnotation != tc.hasAnnotation {
+				t.Errorf("HasAnnotation() = %t, want %t", hasAnnotation, tc.hasAnnotation)
+			}
+			if hasAnnotation := HasAnnoation(tc.svc, "key"); hasAnnotation != tc.hasAnnotation {
+				t.Errorf("HasAnnoation() = %t, want %t", hasAnnotation, tc.hasAnnotation)
+			}
+		})
+	}
+}
+
 func TestPatchService(t *testing.T) {
 	svcOrigin := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -347,3 +399,61 @@ func Test_getPatchBytes(t *testing.T) {
 func addAnnotations(svc *v1.Service) {
 	svc.Annotations["foo"] = "bar"
 }
+
+func TestNormalizeIngress(t *testing.T) {
+	testCases := []struct {
+		hostname string
+		expected string
+	}{
+		{"", ""},
+		{"lb.example.com", "lb.example.com"},
+		{"lb.example.com.", "lb.example.com"},
+		{"LB.Example.COM.", "lb.example.com"},
+	}
+	for _, tc := range testCases {
+		ingress := &v1.LoadBalancerIngress{IP: "10.0.0.1", Hostname: tc.hostname}
+		normalized := NormalizeIngress(ingress)
+		if normalized.Hostname != tc.expected || normalized.IP != ingress.IP {
+			t.Errorf("NormalizeIngress(%q) = %+v, expected hostname %q", tc.hostname, normalized, tc.expected)
+		}
+		if ingress.Hostname != tc.hostname {
+			t.Errorf("NormalizeIngress(%q) modified its argument", tc.hostname)
+		}
+	}
+}
+
+func TestIngressSliceEqual(t *testing.T) {
+	errMsg := "port is unavailable"
+	otherErrMsg := "listener failed"
+	ingress := func(ports ...v1.PortStatus) []v1.LoadBalancerIngress {
+		return []v1.LoadBalancerIngress{{IP: "10.0.0.1", Ports: ports}}
+	}
+
+	testCases := []struct {
+		desc     string
+		lhs, rhs []v1.LoadBalancerIngress
+		expected bool
+	}{
+		{"both empty", nil, nil, true},
+		{"same IP", ingress(), ingress(), true},
+		{"different IP", ingress(), []v1.LoadBalancerIngress{{IP: "10.0.0.2"}}, false},
+		{"different hostname", ingress(), []v1.LoadBalancerIngress{{IP: "10.0.0.1", Hostname: "lb.example.com"}}, false},
+		{"hostname trailing dot", []v1.LoadBalancerIngress{{Hostname: "lb.example.com."}}, []v1.LoadBalancerIngress{{Hostname: "lb.example.com"}}, true},
+		{"hostname mixed case", []v1.LoadBalancerIngress{{Hostname: "LB.Example.com"}}, []v1.LoadBalancerIngress{{Hostname: "lb.example.com."}}, true},
+		{"different FQDN", []v1.LoadBalancerIngress{{Hostname: "lb.example.com."}}, []v1.LoadBalancerIngress{{Hostname: "lb.example.org"}}, false},
+		{"same ports", ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP}), ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP}), true},
+		{"port added", ingress(), ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP}), false},
+		{"different port", ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP}), ingress(v1.PortStatus{Port: 443, Protocol: v1.ProtocolTCP}), false},
+		{"different protocol", ingress(v1.PortStatus{Port: 53, Protocol: v1.ProtocolTCP}), ingress(v1.PortStatus{Port: 53, Protocol: v1.ProtocolUDP}), false},
+		{"error set", ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP}), ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP, Error: &errMsg}), false},
+		{"different error", ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP, Error: &otherErrMsg}), ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP, Error: &errMsg}), false},
+		{"same error", ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP, Error: &errMsg}), ingress(v1.PortStatus{Port: 80, Protocol: v1.ProtocolTCP, Error: &errMsg}), true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := IngressSliceEqual(tc.lhs, tc.rhs); got != tc.expected {
+				t.Errorf("IngressSliceEqual() = %t, expected %t", got, tc.expected)
+			}
+		})
+	}
+}